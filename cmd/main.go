@@ -7,11 +7,14 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/UnendingLoop/WarehouseControl/internal/engine"
 	"github.com/UnendingLoop/WarehouseControl/internal/mwauthlog"
+	"github.com/UnendingLoop/WarehouseControl/internal/oidc"
+	"github.com/UnendingLoop/WarehouseControl/internal/policy"
 	"github.com/UnendingLoop/WarehouseControl/internal/repository"
 	"github.com/UnendingLoop/WarehouseControl/internal/service"
 	"github.com/UnendingLoop/WarehouseControl/internal/transport"
@@ -40,13 +43,73 @@ func main() {
 	// repo
 	repo := repository.NewPostgresImageRepo(dbConn)
 	// jwt
-	jwtMngr := mwauthlog.NewJWTManager([]byte(appConfig.GetString("SECRET")), time.Hour, "WarehouseControl app")
+	accessTTL := appConfig.GetDuration("ACCESS_TOKEN_TTL")
+	if accessTTL <= 0 {
+		accessTTL = service.DefaultAccessTokenTTL
+	}
+	jwtKeys, err := loadJWTKeys(appConfig.GetString("JWT_KEYS_DIR"), appConfig.GetString("JWT_SIGNING_KID"))
+	if err != nil {
+		log.Fatalf("Failed to load JWT keys: %s\nExiting app...", err)
+	}
+	jwtMngr := mwauthlog.NewJWTManager(jwtKeys, accessTTL, "WarehouseControl app")
+	// политика доступа: перечитывается при изменении файла без рестарта
+	pc, err := policy.NewPolicyChecker(appConfig.GetString("POLICY_FILE"))
+	if err != nil {
+		log.Fatalf("Failed to load policy: %s\nExiting app...", err)
+	}
+	reloadInterval := appConfig.GetDuration("POLICY_RELOAD_INTERVAL")
+	if reloadInterval <= 0 {
+		reloadInterval = 30 * time.Second
+	}
+	go pc.Watch(ctx, reloadInterval)
+	// вход через внешний IdP - включается заданием OIDC_ISSUER_URL
+	var idp service.OIDCProvider
+	groupRoles, err := oidc.ParseGroupRoles(appConfig.GetString("OIDC_GROUP_ROLES"))
+	if err != nil {
+		log.Fatalf("Failed to parse OIDC_GROUP_ROLES: %s\nExiting app...", err)
+	}
+	if issuer := appConfig.GetString("OIDC_ISSUER_URL"); issuer != "" {
+		idp = oidc.NewClient(oidc.Config{
+			IssuerURL:     issuer,
+			ClientID:      appConfig.GetString("OIDC_CLIENT_ID"),
+			ClientSecret:  appConfig.GetString("OIDC_CLIENT_SECRET"),
+			RedirectURL:   appConfig.GetString("OIDC_REDIRECT_URL"),
+			GroupsClaim:   appConfig.GetString("OIDC_GROUPS_CLAIM"),
+			UsernameClaim: appConfig.GetString("OIDC_USERNAME_CLAIM"),
+		})
+	}
 	// service
-	svc := service.NewWHBService(repo, jwtMngr)
+	svc := service.NewWHBService(repo, jwtMngr, pc, idp, service.Config{
+		SignupMode:        appConfig.GetString("SIGNUP_MODE"),
+		SignupDefaultRole: appConfig.GetString("SIGNUP_DEFAULT_ROLE"),
+		AccessTokenTTL:    accessTTL,
+		RefreshTokenTTL:   appConfig.GetDuration("REFRESH_TOKEN_TTL"),
+		OIDCGroupRoles:    groupRoles,
+
+		LoginWindow:          appConfig.GetDuration("LOGIN_WINDOW"),
+		LoginMaxUserFailures: appConfig.GetInt("LOGIN_MAX_USER_FAILURES"),
+		LoginMaxIPFailures:   appConfig.GetInt("LOGIN_MAX_IP_FAILURES"),
+
+		TOTPRequiredRoles: splitList(appConfig.GetString("TOTP_REQUIRED_ROLES")),
+		TOTPIssuer:        appConfig.GetString("TOTP_ISSUER"),
+
+		DefaultWarehouseID: appConfig.GetInt("DEFAULT_WAREHOUSE_ID"),
+
+		IdempotencyTTL: appConfig.GetDuration("IDEMPOTENCY_TTL"),
+
+		ItemsRetentionDays: appConfig.GetInt("ITEMS_RETENTION_DAYS"),
+		ItemsPurgeDryRun:   appConfig.GetBool("ITEMS_PURGE_DRY_RUN"),
+	})
+	// плановая очистка корзины - только если задан срок хранения удаленных товаров
+	purgeInterval := appConfig.GetDuration("ITEMS_PURGE_INTERVAL")
+	if purgeInterval <= 0 {
+		purgeInterval = service.DefaultItemsPurgeInterval
+	}
+	go svc.RunItemsPurge(ctx, purgeInterval)
 	// handlers
 	handlers := transport.NewWHCHandlers(svc)
 	// конфиг сервера
-	srv, _ := engine.NewServerEngine(appConfig, handlers, "PROD")
+	srv, _ := engine.NewServerEngine(appConfig, handlers, jwtMngr, svc, svc, svc)
 
 	// запуск сервера
 	go func() {
@@ -68,6 +131,26 @@ func main() {
 	shutdown(dbConn, srv)
 }
 
+// loadJWTKeys читает ключи подписи из каталога; без каталога ключ генерируется на время жизни процесса
+func loadJWTKeys(dir, signingKID string) (*mwauthlog.KeySet, error) {
+	if dir == "" {
+		log.Println("JWT_KEYS_DIR is not set: using ephemeral signing key, access tokens won't survive restart")
+		return mwauthlog.GenerateKeySet()
+	}
+	return mwauthlog.LoadKeySet(dir, signingKID)
+}
+
+// splitList разбирает список вида "admin, auditor"; пустые элементы пропускаются
+func splitList(raw string) []string {
+	var res []string
+	for _, v := range strings.Split(raw, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
 func shutdown(dbConn *dbpg.DB, srv *http.Server) {
 	log.Println("Interrupt received! Starting shutdown sequence...")
 
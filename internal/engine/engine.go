@@ -4,6 +4,7 @@ package engine
 import (
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/UnendingLoop/WarehouseControl/internal/mwauthlog"
 	"github.com/UnendingLoop/WarehouseControl/internal/transport"
@@ -11,32 +12,53 @@ import (
 	"github.com/wb-go/wbf/ginext"
 )
 
-func NewServerEngine(c *config.Config, h *transport.WHCHandlers, mode string) (*http.Server, *ginext.Engine) {
+func NewServerEngine(c *config.Config, h *transport.WHCHandlers, tokens mwauthlog.TokenParser, sessions mwauthlog.SessionChecker, events mwauthlog.AuthEventRecorder, idem mwauthlog.IdempotencyStore) (*http.Server, *ginext.Engine) {
 	engine := ginext.New(c.GetString("GIN_MODE"))
+	// X-Forwarded-For учитывается только от своих прокси: иначе клиент подменяет IP и обходит блокировку логина по адресу
+	if err := engine.SetTrustedProxies(trustedProxies(c.GetString("TRUSTED_PROXIES"))); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
 	engine.Use(mwauthlog.RequestID()) // вставка уникального UID в каждый реквест
 	engine.GET("/ping", h.SimplePinger)
-	engine.Static("/ui", "./internal/web") // UI админа/юзера - функциональность и контент зависит от роли
+	engine.Static("/ui", "./internal/web")          // UI админа/юзера - функциональность и контент зависит от роли
+	engine.GET("/.well-known/jwks.json", h.GetJWKS) // публичные ключи проверки JWT
 
 	auth := engine.Group("/auth")
-	auth.POST("/signup", h.SignUpUser) // регистрация пользователя
-	auth.POST("/login", h.LoginUser)   // авторизация
-
-	var items *ginext.RouterGroup
-	switch mode {
-	case "PROD":
-		items = engine.Group("/items", mwauthlog.RequireAuth([]byte(c.GetString("SECRET"))))
-	case "TEST":
-		items = engine.Group("/items", mwauthlog.RequireAuthTest([]byte(c.GetString("SECRET"))))
-	default:
-		log.Fatalf("Incorrect mode %q provided to configure routers. Must be 'PROD' or 'TEST'.", mode)
-	}
+	auth.POST("/signup", h.SignUpUser)      // регистрация пользователя - поведение зависит от SIGNUP_MODE
+	auth.POST("/login", h.LoginUser)        // авторизация
+	auth.POST("/refresh", h.RefreshSession) // обмен refresh-токена на новую пару токенов
+	auth.POST("/logout", h.Logout)          // выход с отзывом текущих токенов
+
+	auth.POST("/login/totp", h.LoginTOTP)              // второй шаг входа: код второго фактора
+	auth.POST("/login/totp/enroll", h.LoginTOTPEnroll) // настройка обязательного второго фактора посреди входа
+
+	auth.GET("/oidc/login", h.OIDCLogin)       // перенаправление на страницу логина внешнего IdP
+	auth.GET("/oidc/callback", h.OIDCCallback) // возврат из IdP: обмен кода и выдача cookie сессии
+
+	requireAuth := mwauthlog.RequireAuth(tokens, sessions)
+
+	// все защищенные маршруты: отказы 401/403 попадают в журнал аутентификации,
+	// изменяющие запросы cookie-сессий требуют CSRF-токен
+	protected := engine.Group("", mwauthlog.AuditDenials(events), requireAuth, mwauthlog.RequireCSRF())
 
-	items.POST("", h.CreateItem)                    // создание Item
-	items.PATCH("/:id", h.UpdateItem)               // обновление Item по ID
-	items.GET("/:id", h.GetItemByID)                // получение Item по ID
-	items.GET("/:id/history", h.GetItemHistoryByID) // получение History товара по его ID
-	items.DELETE("/:id", h.DeleteItem)              // удаление Item по ID
-	items.GET("", h.GetItemsList)                   // получение всех Item
+	// повторы неидемпотентных запросов с Idempotency-Key получают первый ответ и ничего не пишут заново
+	idempotent := mwauthlog.Idempotency(idem)
+
+	items := protected.Group("/items")
+
+	items.POST("", idempotent, h.CreateItem)                 // создание Item
+	items.PATCH("/:id", h.UpdateItem)                        // обновление Item по ID
+	items.PATCH("/:id/stock", idempotent, h.AdjustItemStock) // изменение остатка на delta
+	items.GET("/:id", h.GetItemByID)                         // получение Item по ID
+	items.GET("/:id/history", h.GetItemHistoryByID)          // получение History товара по его ID
+	items.GET("/:id/history/diff", h.GetItemHistoryDiff)     // отличия между версиями товара
+	items.DELETE("/:id", h.DeleteItem)                       // удаление Item по ID
+	items.POST("/:id/restore", h.RestoreItem)                // восстановление удаленного Item
+	items.POST("/:id/revert", h.RevertItem)                  // откат Item к версии из истории
+	items.GET("/trash", h.GetDeletedItems)                   // корзина: удаленные Item
+	items.DELETE("/:id/purge", h.PurgeItem)                  // окончательное удаление Item из корзины
+	items.POST("/purge", h.PurgeDeletedItems)                // очистка корзины по сроку хранения
+	items.GET("", h.GetItemsList)                            // получение всех Item
 
 	items.GET("/history", h.GetItemsHistoryList) // получение History всех товаров - JSON
 
@@ -44,8 +66,80 @@ func NewServerEngine(c *config.Config, h *transport.WHCHandlers, mode string) (*
 	items.GET("/:id/history/csv", h.ExportItemIDHistoryCSV) // CSV: получение History товара по его ID
 	items.GET("/history/csv", h.ExportItemsHistoryCSV)      // CSV: получение History всех товаров
 
+	items.GET("/:id/locations", h.GetItemLocations)       // ячейки, в которых лежит товар
+	items.POST("/:id/putaway", idempotent, h.PutawayItem) // размещение товара из приемки в ячейку
+	items.POST("/:id/move", idempotent, h.MoveItem)       // перенос товара между ячейками склада
+
+	users := protected.Group("/users")
+	users.GET("", h.GetUsersList)                       // получение списка пользователей
+	users.PATCH("/:id/role", h.ChangeUserRole)          // смена основной роли пользователя
+	users.POST("/:id/disable", h.DisableUser)           // отключение учетной записи
+	users.POST("/:id/enable", h.EnableUser)             // повторное включение учетной записи
+	users.POST("/:id/password", h.ResetUserPassword)    // принудительная смена пароля
+	users.DELETE("/:id", h.DeleteUser)                  // удаление пользователя
+	users.GET("/:id/history", h.GetUserHistory)         // получение History пользователя
+	users.DELETE("/:id/sessions", h.RevokeUserSessions) // отзыв всех сессий пользователя
+	users.GET("/:id/roles", h.GetUserRoles)             // получение выданных пользователю ролей
+	users.POST("/:id/roles", h.GrantUserRole)           // выдача пользователю дополнительной роли
+	users.DELETE("/:id/roles/:role", h.RevokeUserRole)  // отзыв выданной роли
+	users.DELETE("/:id/totp", h.ResetUserTOTP)          // сброс второго фактора пользователя
+
+	users.GET("/:id/warehouses", h.GetUserWarehouses)                    // склады, которыми ограничен пользователь
+	users.POST("/:id/warehouses", h.GrantUserWarehouse)                  // ограничение пользователя складом
+	users.DELETE("/:id/warehouses/:warehouse_id", h.RevokeUserWarehouse) // снятие ограничения складом
+
+	warehouses := protected.Group("/warehouses")
+	warehouses.POST("", h.CreateWarehouse) // создание склада
+	warehouses.GET("", h.GetWarehouses)    // получение списка складов
+
+	warehouses.POST("/:id/locations", h.CreateLocation) // создание места хранения на складе
+	warehouses.GET("/:id/locations", h.GetLocations)    // получение мест хранения склада
+
+	protected.GET("/locations/:id/stock", h.GetLocationStock) // содержимое места хранения и вложенных ячеек
+
+	movements := protected.Group("/movements")
+	movements.POST("", idempotent, h.CreateMovement) // проведение движения остатка
+	movements.GET("", h.GetMovements)                // журнал движений - JSON
+	movements.GET("/csv", h.ExportMovementsCSV)      // CSV: журнал движений
+
+	totp := protected.Group("/auth/totp")
+	totp.POST("/enroll", h.EnrollTOTP)   // новый секрет второго фактора для текущего пользователя
+	totp.POST("/confirm", h.ConfirmTOTP) // включение второго фактора первым кодом
+	totp.DELETE("", h.DisableTOTP)       // выключение второго фактора по действующему коду
+
+	pol := protected.Group("/policy")
+	pol.GET("", h.GetPolicy)            // текущая матрица прав
+	pol.POST("/reload", h.ReloadPolicy) // перечитать файл политики немедленно
+
+	invites := protected.Group("/invites")
+	invites.POST("", h.CreateInvite)       // выпуск одноразового инвайта на регистрацию
+	invites.GET("", h.GetInvitesList)      // получение списка инвайтов
+	invites.DELETE("/:id", h.RevokeInvite) // отзыв неиспользованного инвайта
+
+	apiKeys := protected.Group("/api-keys")
+	apiKeys.POST("", h.CreateAPIKey)       // выпуск API-ключа сервисного аккаунта
+	apiKeys.GET("", h.GetAPIKeysList)      // получение списка API-ключей
+	apiKeys.DELETE("/:id", h.RevokeAPIKey) // отзыв API-ключа
+
+	protected.GET("/login-attempts", h.GetLoginAttempts) // журнал попыток входа по паролю
+
+	authEvents := protected.Group("/auth-events")
+	authEvents.GET("", h.GetAuthEvents)           // журнал аутентификации - JSON
+	authEvents.GET("/csv", h.ExportAuthEventsCSV) // CSV: журнал аутентификации
+
 	return &http.Server{
 		Addr:    ":" + c.GetString("APP_PORT"),
 		Handler: engine,
 	}, engine
 }
+
+// trustedProxies разбирает список адресов/подсетей прокси через запятую; пустой список - IP берется из соединения
+func trustedProxies(raw string) []string {
+	var res []string
+	for _, v := range strings.Split(raw, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
@@ -0,0 +1,16 @@
+-- ===== USER ROLES =====
+-- основная роль пользователя хранится в users.role, здесь - дополнительные роли, выданные админом
+CREATE TABLE user_roles (
+    user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role TEXT NOT NULL CHECK (
+        role IN (
+            'admin',
+            'manager',
+            'viewer',
+            'auditor'
+        )
+    ),
+    granted_by TEXT,
+    granted_at TIMESTAMP NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, role)
+);
@@ -0,0 +1,15 @@
+DROP TRIGGER IF EXISTS user_roles_audit_trigger ON user_roles;
+
+DROP FUNCTION IF EXISTS log_user_role_changes ();
+
+DROP TRIGGER IF EXISTS users_audit_trigger ON users;
+
+DROP FUNCTION IF EXISTS log_user_changes ();
+
+DROP TABLE IF EXISTS users_history;
+
+ALTER TABLE users DROP COLUMN IF EXISTS disabled_at;
+
+ALTER TABLE users DROP COLUMN IF EXISTS updated_by;
+
+ALTER TABLE users DROP COLUMN IF EXISTS updated_at;
\ No newline at end of file
@@ -0,0 +1,112 @@
+-- ===== USERS: статус и автор изменений =====
+ALTER TABLE users ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT now();
+
+ALTER TABLE users ADD COLUMN updated_by TEXT;
+
+ALTER TABLE users ADD COLUMN disabled_at TIMESTAMP NULL;
+
+-- ===== USERS HISTORY =====
+CREATE TABLE users_history (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL,
+    version INT NOT NULL,
+    action TEXT NOT NULL CHECK (
+        action IN (
+            'INSERT',
+            'UPDATE',
+            'DISABLE',
+            'ENABLE',
+            'PASSWORD RESET',
+            'ROLE GRANT',
+            'ROLE REVOKE',
+            'COMPLETE DELETE'
+        )
+    ),
+    changed_at TIMESTAMP NOT NULL DEFAULT now(),
+    changed_by TEXT,
+    old_data JSONB,
+    new_data JSONB
+);
+
+CREATE INDEX idx_users_history_user_id ON users_history (user_id);
+
+CREATE INDEX idx_users_history_changed_at ON users_history (changed_at);
+
+-- ===== TRIGGER FUNCTION: USERS =====
+-- хэш пароля в историю не пишем; автора удаления передаем через настройку транзакции whc.changed_by
+CREATE OR REPLACE FUNCTION log_user_changes()
+RETURNS TRIGGER AS $$
+DECLARE
+    next_version INT;
+    action_type TEXT;
+BEGIN
+    SELECT COALESCE(MAX(version), 0) + 1
+    INTO next_version
+    FROM users_history
+    WHERE user_id = COALESCE(NEW.id, OLD.id);
+
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO users_history(user_id, version, action, old_data, new_data, changed_by)
+        VALUES (NEW.id, next_version, 'INSERT', NULL, to_jsonb(NEW) - 'pass_hash', NEW.updated_by);
+        RETURN NEW;
+
+    ELSIF TG_OP = 'UPDATE' THEN
+        IF OLD.disabled_at IS NULL AND NEW.disabled_at IS NOT NULL THEN
+        action_type := 'DISABLE';
+        ELSIF OLD.disabled_at IS NOT NULL AND NEW.disabled_at IS NULL THEN
+        action_type := 'ENABLE';
+        ELSIF OLD.pass_hash <> NEW.pass_hash THEN
+        action_type := 'PASSWORD RESET';
+        ELSE
+        action_type := 'UPDATE';
+        END IF;
+        INSERT INTO users_history(user_id, version, action, old_data, new_data, changed_by)
+        VALUES (NEW.id, next_version, action_type, to_jsonb(OLD) - 'pass_hash', to_jsonb(NEW) - 'pass_hash', NEW.updated_by);
+        RETURN NEW;
+
+    ELSIF TG_OP = 'DELETE' THEN
+        INSERT INTO users_history(user_id, version, action, old_data, new_data, changed_by)
+        VALUES (OLD.id, next_version, 'COMPLETE DELETE', to_jsonb(OLD) - 'pass_hash', NULL,
+            COALESCE(NULLIF(current_setting('whc.changed_by', true), ''), OLD.updated_by));
+        RETURN OLD;
+    END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_audit_trigger
+AFTER INSERT OR UPDATE OR DELETE ON users
+FOR EACH ROW EXECUTE FUNCTION log_user_changes();
+
+-- ===== TRIGGER FUNCTION: USER ROLES =====
+-- каскадное удаление ролей вместе с пользователем не логируем - это уже покрыто COMPLETE DELETE
+CREATE OR REPLACE FUNCTION log_user_role_changes()
+RETURNS TRIGGER AS $$
+DECLARE
+    next_version INT;
+BEGIN
+    IF TG_OP = 'DELETE' AND NOT EXISTS (SELECT 1 FROM users WHERE id = OLD.user_id) THEN
+        RETURN OLD;
+    END IF;
+
+    SELECT COALESCE(MAX(version), 0) + 1
+    INTO next_version
+    FROM users_history
+    WHERE user_id = COALESCE(NEW.user_id, OLD.user_id);
+
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO users_history(user_id, version, action, old_data, new_data, changed_by)
+        VALUES (OLD.user_id, next_version, 'ROLE REVOKE', to_jsonb(OLD), NULL,
+            NULLIF(current_setting('whc.changed_by', true), ''));
+        RETURN OLD;
+    END IF;
+
+    INSERT INTO users_history(user_id, version, action, old_data, new_data, changed_by)
+    VALUES (NEW.user_id, next_version, 'ROLE GRANT',
+        CASE WHEN TG_OP = 'UPDATE' THEN to_jsonb(OLD) END, to_jsonb(NEW), NEW.granted_by);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER user_roles_audit_trigger
+AFTER INSERT OR UPDATE OR DELETE ON user_roles
+FOR EACH ROW EXECUTE FUNCTION log_user_role_changes();
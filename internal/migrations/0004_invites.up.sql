@@ -0,0 +1,19 @@
+-- ===== INVITES =====
+-- одноразовые приглашения на регистрацию: сам токен не хранится, только его sha256
+CREATE TABLE invites (
+    id SERIAL PRIMARY KEY,
+    token_hash TEXT UNIQUE NOT NULL,
+    role TEXT NOT NULL CHECK (
+        role IN (
+            'admin',
+            'manager',
+            'viewer',
+            'auditor'
+        )
+    ),
+    expires_at TIMESTAMP NOT NULL,
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    used_at TIMESTAMP NULL,
+    used_by TEXT NULL
+);
@@ -0,0 +1,61 @@
+CREATE OR REPLACE FUNCTION log_user_changes()
+RETURNS TRIGGER AS $$
+DECLARE
+    next_version INT;
+    action_type TEXT;
+BEGIN
+    SELECT COALESCE(MAX(version), 0) + 1
+    INTO next_version
+    FROM users_history
+    WHERE user_id = COALESCE(NEW.id, OLD.id);
+
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO users_history(user_id, version, action, old_data, new_data, changed_by)
+        VALUES (NEW.id, next_version, 'INSERT', NULL, to_jsonb(NEW) - 'pass_hash', NEW.updated_by);
+        RETURN NEW;
+
+    ELSIF TG_OP = 'UPDATE' THEN
+        IF OLD.disabled_at IS NULL AND NEW.disabled_at IS NOT NULL THEN
+        action_type := 'DISABLE';
+        ELSIF OLD.disabled_at IS NOT NULL AND NEW.disabled_at IS NULL THEN
+        action_type := 'ENABLE';
+        ELSIF OLD.pass_hash <> NEW.pass_hash THEN
+        action_type := 'PASSWORD RESET';
+        ELSE
+        action_type := 'UPDATE';
+        END IF;
+        INSERT INTO users_history(user_id, version, action, old_data, new_data, changed_by)
+        VALUES (NEW.id, next_version, action_type, to_jsonb(OLD) - 'pass_hash', to_jsonb(NEW) - 'pass_hash', NEW.updated_by);
+        RETURN NEW;
+
+    ELSIF TG_OP = 'DELETE' THEN
+        INSERT INTO users_history(user_id, version, action, old_data, new_data, changed_by)
+        VALUES (OLD.id, next_version, 'COMPLETE DELETE', to_jsonb(OLD) - 'pass_hash', NULL,
+            COALESCE(NULLIF(current_setting('whc.changed_by', true), ''), OLD.updated_by));
+        RETURN OLD;
+    END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+DELETE FROM users_history WHERE action = 'SESSIONS REVOKE';
+
+ALTER TABLE users_history DROP CONSTRAINT users_history_action_check;
+
+ALTER TABLE users_history ADD CONSTRAINT users_history_action_check CHECK (
+    action IN (
+        'INSERT',
+        'UPDATE',
+        'DISABLE',
+        'ENABLE',
+        'PASSWORD RESET',
+        'ROLE GRANT',
+        'ROLE REVOKE',
+        'COMPLETE DELETE'
+    )
+);
+
+ALTER TABLE users DROP COLUMN IF EXISTS tokens_valid_after;
+
+DROP TABLE IF EXISTS revoked_tokens;
+
+DROP TABLE IF EXISTS refresh_tokens;
@@ -0,0 +1,85 @@
+-- ===== REFRESH TOKENS =====
+-- хранится только sha256 токена; при ротации старый токен гасится и ссылается на выданный взамен
+CREATE TABLE refresh_tokens (
+    id SERIAL PRIMARY KEY,
+    token_hash TEXT UNIQUE NOT NULL,
+    user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    revoked_at TIMESTAMP NULL,
+    replaced_by INT NULL REFERENCES refresh_tokens (id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+
+-- ===== REVOKED ACCESS TOKENS =====
+-- отозванные access-токены по jti; запись нужна только до истечения самого токена
+CREATE TABLE revoked_tokens (
+    jti TEXT PRIMARY KEY,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
+
+-- ===== USERS: массовый отзыв сессий =====
+-- все токены, выпущенные раньше этой отметки, считаются отозванными
+ALTER TABLE users ADD COLUMN tokens_valid_after TIMESTAMP NULL;
+
+ALTER TABLE users_history DROP CONSTRAINT users_history_action_check;
+
+ALTER TABLE users_history ADD CONSTRAINT users_history_action_check CHECK (
+    action IN (
+        'INSERT',
+        'UPDATE',
+        'DISABLE',
+        'ENABLE',
+        'PASSWORD RESET',
+        'SESSIONS REVOKE',
+        'ROLE GRANT',
+        'ROLE REVOKE',
+        'COMPLETE DELETE'
+    )
+);
+
+CREATE OR REPLACE FUNCTION log_user_changes()
+RETURNS TRIGGER AS $$
+DECLARE
+    next_version INT;
+    action_type TEXT;
+BEGIN
+    SELECT COALESCE(MAX(version), 0) + 1
+    INTO next_version
+    FROM users_history
+    WHERE user_id = COALESCE(NEW.id, OLD.id);
+
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO users_history(user_id, version, action, old_data, new_data, changed_by)
+        VALUES (NEW.id, next_version, 'INSERT', NULL, to_jsonb(NEW) - 'pass_hash', NEW.updated_by);
+        RETURN NEW;
+
+    ELSIF TG_OP = 'UPDATE' THEN
+        IF OLD.disabled_at IS NULL AND NEW.disabled_at IS NOT NULL THEN
+        action_type := 'DISABLE';
+        ELSIF OLD.disabled_at IS NOT NULL AND NEW.disabled_at IS NULL THEN
+        action_type := 'ENABLE';
+        ELSIF OLD.pass_hash <> NEW.pass_hash THEN
+        action_type := 'PASSWORD RESET';
+        ELSIF OLD.tokens_valid_after IS DISTINCT FROM NEW.tokens_valid_after THEN
+        action_type := 'SESSIONS REVOKE';
+        ELSE
+        action_type := 'UPDATE';
+        END IF;
+        INSERT INTO users_history(user_id, version, action, old_data, new_data, changed_by)
+        VALUES (NEW.id, next_version, action_type, to_jsonb(OLD) - 'pass_hash', to_jsonb(NEW) - 'pass_hash', NEW.updated_by);
+        RETURN NEW;
+
+    ELSIF TG_OP = 'DELETE' THEN
+        INSERT INTO users_history(user_id, version, action, old_data, new_data, changed_by)
+        VALUES (OLD.id, next_version, 'COMPLETE DELETE', to_jsonb(OLD) - 'pass_hash', NULL,
+            COALESCE(NULLIF(current_setting('whc.changed_by', true), ''), OLD.updated_by));
+        RETURN OLD;
+    END IF;
+END;
+$$ LANGUAGE plpgsql;
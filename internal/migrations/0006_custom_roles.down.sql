@@ -0,0 +1,26 @@
+ALTER TABLE invites ADD CONSTRAINT invites_role_check CHECK (
+    role IN (
+        'admin',
+        'manager',
+        'viewer',
+        'auditor'
+    )
+);
+
+ALTER TABLE user_roles ADD CONSTRAINT user_roles_role_check CHECK (
+    role IN (
+        'admin',
+        'manager',
+        'viewer',
+        'auditor'
+    )
+);
+
+ALTER TABLE users ADD CONSTRAINT users_role_check CHECK (
+    role IN (
+        'admin',
+        'manager',
+        'viewer',
+        'auditor'
+    )
+);
@@ -0,0 +1,7 @@
+-- ===== КАСТОМНЫЕ РОЛИ =====
+-- набор ролей теперь задается файлом политики, корректность роли проверяет приложение
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check;
+
+ALTER TABLE user_roles DROP CONSTRAINT IF EXISTS user_roles_role_check;
+
+ALTER TABLE invites DROP CONSTRAINT IF EXISTS invites_role_check;
@@ -0,0 +1,16 @@
+-- ===== API KEYS =====
+-- ключи сервисных аккаунтов (ERP-синхронизация, сканеры): сам ключ не хранится, только его sha256
+CREATE TABLE api_keys (
+    id SERIAL PRIMARY KEY,
+    key_hash TEXT UNIQUE NOT NULL,
+    key_prefix TEXT NOT NULL,
+    name TEXT NOT NULL,
+    role TEXT NOT NULL,
+    expires_at TIMESTAMP NULL,
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    last_used_at TIMESTAMP NULL,
+    revoked_at TIMESTAMP NULL
+);
+
+CREATE INDEX idx_api_keys_name ON api_keys (name);
@@ -0,0 +1,3 @@
+-- ===== USERS: вход через внешний IdP =====
+-- пользователи, созданные при первом OIDC-логине, связаны с IdP по subject; локального пароля у них нет (pass_hash = '')
+ALTER TABLE users ADD COLUMN oidc_subject TEXT UNIQUE NULL;
@@ -0,0 +1,14 @@
+-- ===== LOGIN ATTEMPTS =====
+-- журнал попыток входа по паролю: по нему считаются неудачи в скользящем окне (защита от перебора)
+CREATE TABLE login_attempts (
+    id SERIAL PRIMARY KEY,
+    username TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    success BOOLEAN NOT NULL,
+    reason TEXT NULL,
+    attempted_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_login_attempts_username ON login_attempts (username, attempted_at);
+
+CREATE INDEX idx_login_attempts_ip ON login_attempts (ip, attempted_at);
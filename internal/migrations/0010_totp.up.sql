@@ -0,0 +1,36 @@
+-- ===== USER TOTP =====
+-- второй фактор хранится отдельно от users: last_step меняется на каждый вход и не должен засорять users_history.
+-- Секрет нужен в открытом виде для проверки кодов, поэтому таблица никогда не отдается через API
+CREATE TABLE user_totp (
+    user_id INT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    secret TEXT NOT NULL,
+    confirmed_at TIMESTAMP NULL,
+    last_step BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+-- ===== RECOVERY CODES =====
+-- одноразовые коды на случай потери телефона; хранится только sha256
+CREATE TABLE recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    UNIQUE (user_id, code_hash)
+);
+
+-- ===== LOGIN CHALLENGES =====
+-- пароль проверен, ждем второй фактор; токен одноразовый и короткоживущий
+CREATE TABLE login_challenges (
+    id SERIAL PRIMARY KEY,
+    token_hash TEXT UNIQUE NOT NULL,
+    user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    used_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_login_challenges_expires_at ON login_challenges (expires_at);
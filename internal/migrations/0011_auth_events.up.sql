@@ -0,0 +1,24 @@
+-- ===== AUTH EVENTS =====
+-- журнал аутентификации для админов и аудиторов: входы, выходы, регистрации и отказы 401/403.
+-- В отличие от login_attempts (счетчик для защиты от перебора) сюда пишется все, что важно для расследований
+CREATE TABLE auth_events (
+    id BIGSERIAL PRIMARY KEY,
+    event_type TEXT NOT NULL,
+    user_id INT NULL,
+    username TEXT NULL,
+    role TEXT NULL,
+    status INT NULL,
+    detail TEXT NULL,
+    method TEXT NULL,
+    path TEXT NULL,
+    request_id TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    user_agent TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_auth_events_created_at ON auth_events (created_at);
+
+CREATE INDEX idx_auth_events_type ON auth_events (event_type, created_at);
+
+CREATE INDEX idx_auth_events_username ON auth_events (username, created_at);
@@ -0,0 +1,65 @@
+CREATE OR REPLACE FUNCTION log_item_changes()
+RETURNS TRIGGER AS $$
+DECLARE
+    next_version INT;
+    action_type TEXT;
+BEGIN
+    SELECT COALESCE(MAX(version), 0) + 1
+    INTO next_version
+    FROM items_history
+    WHERE item_id = COALESCE(NEW.id, OLD.id);
+
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO items_history(item_id, version, action, old_data, new_data, changed_by)
+        VALUES (NEW.id, next_version, 'INSERT', NULL, to_jsonb(NEW), NEW.updated_by);
+        RETURN NEW;
+
+    ELSIF TG_OP = 'UPDATE' THEN
+        IF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        action_type := 'SOFT DELETE';
+        ELSE
+        action_type := 'UPDATE';
+        END IF;
+        INSERT INTO items_history(item_id, version, action, old_data, new_data, changed_by)
+        VALUES (NEW.id, next_version, action_type, to_jsonb(OLD), to_jsonb(NEW), NEW.updated_by);
+        RETURN NEW;
+
+    ELSIF TG_OP = 'DELETE' THEN
+        INSERT INTO items_history(item_id, version, action, old_data, new_data, changed_by)
+        VALUES (OLD.id, next_version, 'COMPLETE DELETE', to_jsonb(OLD), NULL, OLD.updated_by);
+        RETURN OLD;
+    END IF;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP INDEX IF EXISTS idx_items_history_warehouse_id;
+
+ALTER TABLE items_history DROP COLUMN IF EXISTS warehouse_id;
+
+DROP TRIGGER IF EXISTS user_warehouses_audit_trigger ON user_warehouses;
+
+DROP FUNCTION IF EXISTS log_user_warehouse_changes ();
+
+DELETE FROM users_history WHERE action IN ('WAREHOUSE GRANT', 'WAREHOUSE REVOKE');
+
+ALTER TABLE users_history DROP CONSTRAINT users_history_action_check;
+
+ALTER TABLE users_history ADD CONSTRAINT users_history_action_check CHECK (
+    action IN (
+        'INSERT',
+        'UPDATE',
+        'DISABLE',
+        'ENABLE',
+        'PASSWORD RESET',
+        'SESSIONS REVOKE',
+        'ROLE GRANT',
+        'ROLE REVOKE',
+        'COMPLETE DELETE'
+    )
+);
+
+DROP TABLE IF EXISTS user_warehouses;
+
+DROP TABLE IF EXISTS item_stock;
+
+DROP TABLE IF EXISTS warehouses;
@@ -0,0 +1,132 @@
+-- ===== WAREHOUSES =====
+CREATE TABLE warehouses (
+    id SERIAL PRIMARY KEY,
+    code TEXT UNIQUE NOT NULL,
+    name TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    updated_by TEXT
+);
+
+-- склад по умолчанию: сюда переносятся существующие остатки и приходуются товары без явного склада
+INSERT INTO warehouses (code, name, updated_by) VALUES ('MAIN', 'Main warehouse', 'migration');
+
+-- ===== ITEM STOCK =====
+-- остаток товара на каждом складе; items.available_amount - сумма по складам, ее пересчитывает приложение
+CREATE TABLE item_stock (
+    item_id INT NOT NULL REFERENCES items (id) ON DELETE CASCADE,
+    warehouse_id INT NOT NULL REFERENCES warehouses (id),
+    amount INT NOT NULL DEFAULT 0 CHECK (amount >= 0),
+    updated_at TIMESTAMP NOT NULL DEFAULT now(),
+    updated_by TEXT,
+    PRIMARY KEY (item_id, warehouse_id)
+);
+
+CREATE INDEX idx_item_stock_warehouse_id ON item_stock (warehouse_id);
+
+INSERT INTO item_stock (item_id, warehouse_id, amount, updated_by)
+SELECT id, (SELECT id FROM warehouses WHERE code = 'MAIN'), available_amount, 'migration'
+FROM items
+WHERE available_amount > 0;
+
+-- ===== USER WAREHOUSES =====
+-- склады, которыми ограничен пользователь; нет записей - доступны все склады
+CREATE TABLE user_warehouses (
+    user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    warehouse_id INT NOT NULL REFERENCES warehouses (id) ON DELETE CASCADE,
+    granted_by TEXT,
+    granted_at TIMESTAMP NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, warehouse_id)
+);
+
+ALTER TABLE users_history DROP CONSTRAINT users_history_action_check;
+
+ALTER TABLE users_history ADD CONSTRAINT users_history_action_check CHECK (
+    action IN (
+        'INSERT',
+        'UPDATE',
+        'DISABLE',
+        'ENABLE',
+        'PASSWORD RESET',
+        'SESSIONS REVOKE',
+        'ROLE GRANT',
+        'ROLE REVOKE',
+        'WAREHOUSE GRANT',
+        'WAREHOUSE REVOKE',
+        'COMPLETE DELETE'
+    )
+);
+
+-- ===== TRIGGER FUNCTION: USER WAREHOUSES =====
+-- каскадное удаление вместе с пользователем не логируем - это уже покрыто COMPLETE DELETE
+CREATE OR REPLACE FUNCTION log_user_warehouse_changes()
+RETURNS TRIGGER AS $$
+DECLARE
+    next_version INT;
+BEGIN
+    IF TG_OP = 'DELETE' AND NOT EXISTS (SELECT 1 FROM users WHERE id = OLD.user_id) THEN
+        RETURN OLD;
+    END IF;
+
+    SELECT COALESCE(MAX(version), 0) + 1
+    INTO next_version
+    FROM users_history
+    WHERE user_id = COALESCE(NEW.user_id, OLD.user_id);
+
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO users_history(user_id, version, action, old_data, new_data, changed_by)
+        VALUES (OLD.user_id, next_version, 'WAREHOUSE REVOKE', to_jsonb(OLD), NULL,
+            NULLIF(current_setting('whc.changed_by', true), ''));
+        RETURN OLD;
+    END IF;
+
+    INSERT INTO users_history(user_id, version, action, old_data, new_data, changed_by)
+    VALUES (NEW.user_id, next_version, 'WAREHOUSE GRANT',
+        CASE WHEN TG_OP = 'UPDATE' THEN to_jsonb(OLD) END, to_jsonb(NEW), NEW.granted_by);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER user_warehouses_audit_trigger
+AFTER INSERT OR UPDATE OR DELETE ON user_warehouses
+FOR EACH ROW EXECUTE FUNCTION log_user_warehouse_changes();
+
+-- ===== ITEMS HISTORY: склад изменения остатка =====
+-- склад передается в триггер через настройку транзакции whc.warehouse_id; у правок карточки товара он пустой
+ALTER TABLE items_history ADD COLUMN warehouse_id INT NULL;
+
+CREATE INDEX idx_items_history_warehouse_id ON items_history (warehouse_id);
+
+CREATE OR REPLACE FUNCTION log_item_changes()
+RETURNS TRIGGER AS $$
+DECLARE
+    next_version INT;
+    action_type TEXT;
+    wh_id INT := NULLIF(current_setting('whc.warehouse_id', true), '')::INT;
+BEGIN
+    SELECT COALESCE(MAX(version), 0) + 1
+    INTO next_version
+    FROM items_history
+    WHERE item_id = COALESCE(NEW.id, OLD.id);
+
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO items_history(item_id, version, action, old_data, new_data, changed_by, warehouse_id)
+        VALUES (NEW.id, next_version, 'INSERT', NULL, to_jsonb(NEW), NEW.updated_by, wh_id);
+        RETURN NEW;
+
+    ELSIF TG_OP = 'UPDATE' THEN
+        IF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        action_type := 'SOFT DELETE';
+        ELSE
+        action_type := 'UPDATE';
+        END IF;
+        INSERT INTO items_history(item_id, version, action, old_data, new_data, changed_by, warehouse_id)
+        VALUES (NEW.id, next_version, action_type, to_jsonb(OLD), to_jsonb(NEW), NEW.updated_by, wh_id);
+        RETURN NEW;
+
+    ELSIF TG_OP = 'DELETE' THEN
+        INSERT INTO items_history(item_id, version, action, old_data, new_data, changed_by, warehouse_id)
+        VALUES (OLD.id, next_version, 'COMPLETE DELETE', to_jsonb(OLD), NULL, OLD.updated_by, wh_id);
+        RETURN OLD;
+    END IF;
+END;
+$$ LANGUAGE plpgsql;
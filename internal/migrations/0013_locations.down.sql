@@ -0,0 +1,20 @@
+DELETE FROM items_history WHERE action IN ('PUTAWAY', 'MOVE');
+
+ALTER TABLE items_history DROP CONSTRAINT items_history_action_check;
+
+ALTER TABLE items_history ADD CONSTRAINT items_history_action_check CHECK (
+    action IN (
+        'INSERT',
+        'UPDATE',
+        'SOFT DELETE',
+        'COMPLETE DELETE'
+    )
+);
+
+DROP TABLE IF EXISTS location_stock;
+
+DROP TRIGGER IF EXISTS warehouses_receiving_trigger ON warehouses;
+
+DROP FUNCTION IF EXISTS create_receiving_location ();
+
+DROP TABLE IF EXISTS locations;
@@ -0,0 +1,72 @@
+-- ===== LOCATIONS =====
+-- иерархия мест хранения внутри склада: зона -> ряд -> стеллаж -> ячейка; остатки лежат только в ячейках (BIN)
+CREATE TABLE locations (
+    id SERIAL PRIMARY KEY,
+    warehouse_id INT NOT NULL REFERENCES warehouses (id) ON DELETE CASCADE,
+    parent_id INT NULL REFERENCES locations (id),
+    kind TEXT NOT NULL CHECK (
+        kind IN (
+            'ZONE',
+            'AISLE',
+            'SHELF',
+            'BIN'
+        )
+    ),
+    code TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    updated_by TEXT,
+    UNIQUE (warehouse_id, code)
+);
+
+CREATE INDEX idx_locations_parent_id ON locations (parent_id);
+
+-- у каждого склада есть ячейка приемки RECEIVING: сюда приходуется остаток без явного места,
+-- а размещение (putaway) переносит его в ячейки хранения
+INSERT INTO locations (warehouse_id, kind, code, updated_by)
+SELECT id, 'BIN', 'RECEIVING', 'migration'
+FROM warehouses;
+
+CREATE OR REPLACE FUNCTION create_receiving_location()
+RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO locations (warehouse_id, kind, code, updated_by)
+    VALUES (NEW.id, 'BIN', 'RECEIVING', NEW.updated_by);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER warehouses_receiving_trigger
+AFTER INSERT ON warehouses
+FOR EACH ROW EXECUTE FUNCTION create_receiving_location();
+
+-- ===== LOCATION STOCK =====
+-- остаток товара в ячейке; item_stock - сумма по ячейкам склада, items.available_amount - по всем складам
+CREATE TABLE location_stock (
+    item_id INT NOT NULL REFERENCES items (id) ON DELETE CASCADE,
+    location_id INT NOT NULL REFERENCES locations (id),
+    amount INT NOT NULL DEFAULT 0 CHECK (amount >= 0),
+    updated_at TIMESTAMP NOT NULL DEFAULT now(),
+    updated_by TEXT,
+    PRIMARY KEY (item_id, location_id)
+);
+
+CREATE INDEX idx_location_stock_location_id ON location_stock (location_id);
+
+INSERT INTO location_stock (item_id, location_id, amount, updated_by)
+SELECT s.item_id, l.id, s.amount, 'migration'
+FROM item_stock s
+JOIN locations l ON l.warehouse_id = s.warehouse_id AND l.code = 'RECEIVING';
+
+-- ===== ITEMS HISTORY: размещение и перемещение =====
+ALTER TABLE items_history DROP CONSTRAINT items_history_action_check;
+
+ALTER TABLE items_history ADD CONSTRAINT items_history_action_check CHECK (
+    action IN (
+        'INSERT',
+        'UPDATE',
+        'SOFT DELETE',
+        'COMPLETE DELETE',
+        'PUTAWAY',
+        'MOVE'
+    )
+);
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS stock_movements_append_only_trigger ON stock_movements;
+
+DROP FUNCTION IF EXISTS reject_stock_movement_changes ();
+
+DROP TABLE IF EXISTS stock_movements;
@@ -0,0 +1,52 @@
+-- ===== STOCK MOVEMENTS =====
+-- журнал движений остатков: только добавление. Остаток в ячейке - сумма движений по ней,
+-- location_stock, item_stock и items.available_amount - материализованные балансы журнала.
+-- Внешнего ключа на items нет, как и у items_history: журнал переживает удаление товара
+CREATE TABLE stock_movements (
+    id BIGSERIAL PRIMARY KEY,
+    item_id INT NOT NULL,
+    movement_type TEXT NOT NULL CHECK (
+        movement_type IN (
+            'receipt',
+            'issue',
+            'transfer',
+            'adjustment',
+            'write_off',
+            'return'
+        )
+    ),
+    warehouse_id INT NOT NULL REFERENCES warehouses (id),
+    location_id INT NOT NULL REFERENCES locations (id),
+    quantity INT NOT NULL CHECK (quantity <> 0),
+    reason_code TEXT NOT NULL,
+    reference TEXT,
+    comment TEXT,
+    related_id BIGINT NULL REFERENCES stock_movements (id), -- парная запись перемещения
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    created_by TEXT
+);
+
+CREATE INDEX idx_stock_movements_item_id ON stock_movements (item_id);
+
+CREATE INDEX idx_stock_movements_warehouse_id ON stock_movements (warehouse_id);
+
+CREATE INDEX idx_stock_movements_created_at ON stock_movements (created_at);
+
+-- начальные остатки: журнал должен сходиться с уже лежащим в ячейках
+INSERT INTO stock_movements (item_id, movement_type, warehouse_id, location_id, quantity, reason_code, created_by)
+SELECT s.item_id, 'adjustment', l.warehouse_id, s.location_id, s.amount, 'OPENING_BALANCE', 'migration'
+FROM location_stock s
+JOIN locations l ON l.id = s.location_id
+WHERE s.amount > 0
+ORDER BY s.item_id, s.location_id;
+
+CREATE OR REPLACE FUNCTION reject_stock_movement_changes()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'stock_movements is append-only: use a compensating movement instead';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER stock_movements_append_only_trigger
+BEFORE UPDATE OR DELETE ON stock_movements
+FOR EACH ROW EXECUTE FUNCTION reject_stock_movement_changes();
@@ -0,0 +1,16 @@
+-- ===== IDEMPOTENCY KEYS =====
+-- первый ответ на запрос с Idempotency-Key: повтор с тем же ключом получает его же и ничего не пишет заново.
+-- Ключи разных клиентов не пересекаются; запись нужна только до expires_at
+CREATE TABLE idempotency_keys (
+    owner TEXT NOT NULL, -- пользователь или сервисный аккаунт
+    key TEXT NOT NULL,
+    request_hash TEXT NOT NULL, -- sha256 метода, пути и тела запроса
+    status_code INT NULL, -- NULL - первый запрос еще обрабатывается
+    content_type TEXT NULL,
+    body BYTEA NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (owner, key)
+);
+
+CREATE INDEX idx_idempotency_keys_expires_at ON idempotency_keys (expires_at);
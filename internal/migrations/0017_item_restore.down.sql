@@ -0,0 +1,52 @@
+DROP INDEX IF EXISTS idx_items_deleted_at;
+
+UPDATE items_history SET action = 'UPDATE' WHERE action = 'RESTORE';
+
+ALTER TABLE items_history DROP CONSTRAINT items_history_action_check;
+
+ALTER TABLE items_history ADD CONSTRAINT items_history_action_check CHECK (
+    action IN (
+        'INSERT',
+        'UPDATE',
+        'SOFT DELETE',
+        'COMPLETE DELETE',
+        'PUTAWAY',
+        'MOVE'
+    )
+);
+
+CREATE OR REPLACE FUNCTION log_item_changes()
+RETURNS TRIGGER AS $$
+DECLARE
+    next_version INT;
+    action_type TEXT;
+    wh_id INT := NULLIF(current_setting('whc.warehouse_id', true), '')::INT;
+    delta INT := NULLIF(current_setting('whc.stock_delta', true), '')::INT;
+BEGIN
+    SELECT COALESCE(MAX(version), 0) + 1
+    INTO next_version
+    FROM items_history
+    WHERE item_id = COALESCE(NEW.id, OLD.id);
+
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO items_history(item_id, version, action, old_data, new_data, changed_by, warehouse_id)
+        VALUES (NEW.id, next_version, 'INSERT', NULL, to_jsonb(NEW), NEW.updated_by, wh_id);
+        RETURN NEW;
+
+    ELSIF TG_OP = 'UPDATE' THEN
+        IF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        action_type := 'SOFT DELETE';
+        ELSE
+        action_type := 'UPDATE';
+        END IF;
+        INSERT INTO items_history(item_id, version, action, old_data, new_data, changed_by, warehouse_id, stock_delta)
+        VALUES (NEW.id, next_version, action_type, to_jsonb(OLD), to_jsonb(NEW), NEW.updated_by, wh_id, delta);
+        RETURN NEW;
+
+    ELSIF TG_OP = 'DELETE' THEN
+        INSERT INTO items_history(item_id, version, action, old_data, new_data, changed_by, warehouse_id)
+        VALUES (OLD.id, next_version, 'COMPLETE DELETE', to_jsonb(OLD), NULL, OLD.updated_by, wh_id);
+        RETURN OLD;
+    END IF;
+END;
+$$ LANGUAGE plpgsql;
@@ -4,8 +4,16 @@ import "errors"
 
 var (
 	// 404
-	ErrUserNotFound = errors.New("requested username not found")
-	ErrItemNotFound = errors.New("requested item id not found")
+	ErrUserNotFound        = errors.New("requested username not found")
+	ErrItemNotFound        = errors.New("requested item id not found")
+	ErrRoleNotGranted      = errors.New("requested role is not granted to user")
+	ErrInviteNotFound      = errors.New("requested invite not found or already used")
+	ErrAPIKeyNotFound      = errors.New("requested api key not found or already revoked")
+	ErrOIDCDisabled        = errors.New("oidc login is not configured")
+	ErrWarehouseNotFound   = errors.New("requested warehouse not found")
+	ErrWarehouseNotGranted = errors.New("requested warehouse is not granted to user")
+	ErrLocationNotFound    = errors.New("requested location not found")
+	ErrVersionNotFound     = errors.New("requested item version not found")
 
 	// 400
 	ErrInvalidToken       = errors.New("invalid auth-token provided")
@@ -18,22 +26,88 @@ var (
 	ErrInvalidLimit        = errors.New("invalid limit value provided: value must be > 0 and < 1000")
 	ErrInvalidRequestParam = errors.New("invalid request parameter provided")
 
-	ErrIncorrectItemID   = errors.New("incorrect item id provided")
-	ErrIncorrectUserName = errors.New("incorrect username provided")
-	ErrIncorrectUserRole = errors.New("incorrect user role is provided")
-	ErrEmptyItemInfo     = errors.New("incomplete data provided to create item")
-	ErrEmptyTitle        = errors.New("invalid item title provided")
-	ErrInvalidPrice      = errors.New("invalid item price provided")
-	ErrEmptyUser         = errors.New("empty user-info provided")
-	ErrInvalidAvail      = errors.New("invalid item available amount provided")
-	ErrNoFieldsToUpdate  = errors.New("nothing to update in item")
+	ErrIncorrectItemID      = errors.New("incorrect item id provided")
+	ErrIncorrectUserID      = errors.New("incorrect user id provided")
+	ErrIncorrectUserName    = errors.New("incorrect username provided")
+	ErrIncorrectUserRole    = errors.New("incorrect user role is provided")
+	ErrEmptyItemInfo        = errors.New("incomplete data provided to create item")
+	ErrEmptyTitle           = errors.New("invalid item title provided")
+	ErrInvalidPrice         = errors.New("invalid item price provided")
+	ErrEmptyUser            = errors.New("empty user-info provided")
+	ErrInvalidAvail         = errors.New("invalid item available amount provided")
+	ErrNoFieldsToUpdate     = errors.New("nothing to update in item")
+	ErrEmptyPassword        = errors.New("empty password provided")
+	ErrInvalidInviteTTL     = errors.New("invalid invite expiry provided: value must be > 0")
+	ErrInvalidAPIKeyTTL     = errors.New("invalid api key expiry provided: value must be >= 0")
+	ErrEmptyAPIKeyName      = errors.New("empty service account name provided")
+	ErrEmptyOTPCode         = errors.New("empty one-time code provided")
+	ErrInvalidEventType     = errors.New("invalid auth event type provided")
+	ErrInvalidCSRFToken     = errors.New("missing or invalid csrf token")
+	ErrInvalidIdempotency   = errors.New("invalid idempotency key provided: use up to 255 characters")
+	ErrIncorrectWarehouseID = errors.New("incorrect warehouse id provided")
+	ErrEmptyWarehouseInfo   = errors.New("warehouse code and name must not be empty")
+	ErrIncorrectLocationID  = errors.New("incorrect location id provided")
+	ErrEmptyLocationInfo    = errors.New("location code must not be empty")
+	ErrInvalidLocationKind  = errors.New("invalid location kind provided: must be ZONE, AISLE, SHELF or BIN")
+	ErrInvalidLocationTree  = errors.New("parent location must be in the same warehouse and one level up")
+	ErrInvalidMoveAmount    = errors.New("invalid amount to move provided: value must be > 0")
+	ErrInvalidStockMove     = errors.New("stock can only be moved between different bins of one warehouse")
+	ErrInvalidMovementType  = errors.New("invalid movement type provided")
+	ErrInvalidMovementQty   = errors.New("invalid movement quantity provided: sign must match movement type")
+	ErrInvalidReasonCode    = errors.New("invalid reason code provided: use 1-32 latin letters, digits or underscores")
+	ErrInvalidTransfer      = errors.New("transfer needs a destination bin different from the source")
+	ErrLocationNotBin       = errors.New("stock can only be kept in BIN locations of the movement warehouse")
+	ErrInvalidStockDelta    = errors.New("invalid stock delta provided: value must not be 0")
+	ErrInvalidIfMatch       = errors.New("invalid If-Match header provided: expected item version ETag")
+	ErrInvalidRetention     = errors.New("invalid retention provided: older_than_days must be > 0")
+	ErrInvalidVersionRange  = errors.New("invalid versions provided: from and to must be > 0 and from < to")
+	ErrInvalidRevertVersion = errors.New("invalid version provided: value must be > 0")
+	ErrAsOfWithWarehouse    = errors.New("as_of cannot be combined with warehouse_id: item history keeps only total stock")
+	ErrInvalidHistoryAction = errors.New("invalid history action provided")
+
+	// 401
+	ErrInvalidRefreshToken = errors.New("refresh token is invalid, expired or revoked")
+	ErrSessionRevoked      = errors.New("session has been revoked")
+	ErrInvalidAPIKey       = errors.New("api key is invalid, expired or revoked")
+	ErrInvalidOIDCState    = errors.New("oidc login state is invalid or expired, start login again")
+	ErrOIDCLoginFailed     = errors.New("identity provider login failed")
+	ErrInvalidChallenge    = errors.New("login challenge is invalid or expired, log in again")
+	ErrInvalidOTP          = errors.New("one-time code is incorrect")
 
 	// 403
-	ErrAccessDenied = errors.New("lack permissions to complete operation")
+	ErrAccessDenied          = errors.New("lack permissions to complete operation")
+	ErrUserDisabled          = errors.New("user account is disabled")
+	ErrSignupDisabled        = errors.New("self-signup is disabled")
+	ErrInviteRequired        = errors.New("signup requires an invite token")
+	ErrInvalidInvite         = errors.New("invite token is invalid, expired or already used")
+	ErrNoOIDCRole            = errors.New("no warehouse role is mapped to identity provider groups")
+	ErrTOTPRequired          = errors.New("two-factor authentication is required for this role")
+	ErrWarehouseAccessDenied = errors.New("user has no access to requested warehouse")
+
+	// 429
+	ErrTooManyLoginAttempts = errors.New("too many failed login attempts, try again later")
 
 	// 500
 	ErrCommon500 = errors.New("something went wrong. Try again later")
 
 	// 409
-	ErrUserAlreadyExists = errors.New("user with such username already exists")
+	ErrUserAlreadyExists      = errors.New("user with such username already exists")
+	ErrTOTPAlreadyActive      = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnrolled        = errors.New("two-factor authentication is not set up, start enrollment first")
+	ErrWarehouseAlreadyExists = errors.New("warehouse with such code already exists")
+	ErrLocationAlreadyExists  = errors.New("location with such code already exists in warehouse")
+	ErrInsufficientStock      = errors.New("not enough stock in source location")
+	ErrStockPlacedInBins      = errors.New("available amount is less than stock already placed in bins")
+	ErrNegativeStock          = errors.New("stock adjustment would make the amount negative")
+	ErrIdempotencyKeyReused   = errors.New("idempotency key was already used with a different request")
+	ErrIdempotencyInProgress  = errors.New("request with this idempotency key is still being processed")
+	ErrItemNotDeleted         = errors.New("item is not deleted, nothing to restore")
+	ErrSnapshotSchemaMismatch = errors.New("item version snapshot does not match current item schema, revert is not possible")
+
+	// 412
+	ErrVersionMismatch = errors.New("item was changed by someone else: version does not match If-Match")
+
+	// 422
+	ErrInvalidPolicy      = errors.New("policy file is invalid, previous policy is kept")
+	ErrVersionNotSnapshot = errors.New("requested item version is a stock movement (PUTAWAY or MOVE) and holds no item state")
 )
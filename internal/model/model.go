@@ -10,13 +10,217 @@ import (
 //================ Пользователь и роли ========================
 
 type User struct {
-	ID        int        `json:"id,omitempty" db:"id"`
-	UserName  string     `json:"username" binding:"required" db:"username"`
-	Role      string     `json:"role" binding:"required" db:"role"`
-	PassHash  string     `json:"password" binding:"required" db:"pass_hash"` // используется как хранилище открытого пароля при регистрации, и как хранилище Hash после
-	CreatedAt *time.Time `json:"created_at,omitempty" db:"created_at"`
+	ID         int        `json:"id,omitempty" db:"id"`
+	UserName   string     `json:"username" binding:"required" db:"username"`
+	Role       string     `json:"role" binding:"required" db:"role"`
+	PassHash   string     `json:"password" binding:"required" db:"pass_hash"` // используется как хранилище открытого пароля при регистрации, и как хранилище Hash после
+	CreatedAt  *time.Time `json:"created_at,omitempty" db:"created_at"`
+	DisabledAt *time.Time `json:"-" db:"disabled_at"`
+	UpdatedBy  string     `json:"-" db:"updated_by"`
+
+	Permissions []string `json:"-" db:"-"` // разрешения роли текущей сессии по политике - для UI
+}
+
+type UserHistory struct {
+	ID        int              `json:"id" db:"id"`
+	UserID    int              `json:"user_id" db:"user_id"`
+	Version   int              `json:"version" db:"version"`
+	Action    string           `json:"action" db:"action"`
+	ChangedAt time.Time        `json:"changed_at" db:"changed_at"`
+	ChangedBy string           `json:"changed_by" db:"changed_by"`
+	OldData   *json.RawMessage `json:"old" db:"old_data"`
+	NewData   *json.RawMessage `json:"new" db:"new_data"`
+}
+
+// LoginAttempt - попытка входа по паролю; неудачные попытки считаются для защиты от перебора
+type LoginAttempt struct {
+	ID          int       `json:"id" db:"id"`
+	Username    string    `json:"username" db:"username"`
+	IP          string    `json:"ip" db:"ip"`
+	Success     bool      `json:"success" db:"success"`
+	Reason      *string   `json:"reason,omitempty" db:"reason"` // причина отказа, только для неудачных
+	AttemptedAt time.Time `json:"attempted_at" db:"attempted_at"`
+}
+
+// LoginFailures - число неудачных попыток входа в скользящем окне
+type LoginFailures struct {
+	ByUser int // с момента последнего успешного входа этого пользователя
+	ByIP   int
+}
+
+// LoginAttemptFilter - фильтры выборки попыток входа для админа
+type LoginAttemptFilter struct {
+	Username *string `form:"username"`
+	IP       *string `form:"ip"`
+	Success  *bool   `form:"success"`
+}
+
+const (
+	LoginFailUnknownUser = "unknown user"
+	LoginFailBadPassword = "bad password"
+	LoginFailDisabled    = "disabled"
+	LoginFailLocked      = "locked" // попытка во время блокировки - в счетчик неудач не входит
+	LoginFailBadOTP      = "bad otp"
+
+	LoginFailRoleNotGranted = "role not granted" // только в журнал аутентификации, в счетчик неудач не входит
+)
+
+// AuthEvent - запись журнала аутентификации: входы, выходы, регистрации и отказы в доступе (401/403)
+type AuthEvent struct {
+	ID        int64     `json:"id" db:"id"`
+	Type      string    `json:"type" db:"event_type"`
+	UserID    int       `json:"user_id,omitempty" db:"user_id"`
+	Username  string    `json:"username,omitempty" db:"username"`
+	Role      string    `json:"role,omitempty" db:"role"`     // роль сессии либо запрошенная при логине
+	Status    int       `json:"status,omitempty" db:"status"` // HTTP-код отказа
+	Detail    string    `json:"detail,omitempty" db:"detail"` // причина неудачи или способ входа
+	Method    string    `json:"method,omitempty" db:"method"`
+	Path      string    `json:"path,omitempty" db:"path"`
+	RequestID string    `json:"request_id" db:"request_id"`
+	IP        string    `json:"ip" db:"ip"`
+	UserAgent string    `json:"user_agent" db:"user_agent"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// AuthEventFilter - фильтры выборки журнала аутентификации
+type AuthEventFilter struct {
+	Type      *string `form:"type"`
+	Username  *string `form:"username"`
+	IP        *string `form:"ip"`
+	RequestID *string `form:"request_id"`
+}
+
+// типы событий журнала аутентификации
+const (
+	AuthEventLogin        = "login"
+	AuthEventLoginFailed  = "login_failed"
+	AuthEventLogout       = "logout"
+	AuthEventSignup       = "signup"
+	AuthEventSignupFailed = "signup_failed"
+	AuthEventDenied       = "denied" // запрос отклонен с 401/403
+)
+
+var AuthEventTypesMap = map[string]struct{}{
+	AuthEventLogin:        {},
+	AuthEventLoginFailed:  {},
+	AuthEventLogout:       {},
+	AuthEventSignup:       {},
+	AuthEventSignupFailed: {},
+	AuthEventDenied:       {},
+}
+
+// AuthTokens - пара токенов сессии: короткоживущий access (JWT) и ротируемый refresh
+type AuthTokens struct {
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
+
+// SessionState - серверное состояние сессии, проверяемое на каждый запрос
+type SessionState struct {
+	Active  bool // пользователь не отключен
+	HasRole bool // роль сессии все еще принадлежит пользователю
+	Revoked bool // токен отозван по jti либо все сессии пользователя отозваны
+}
+
+// Invite - одноразовое приглашение на регистрацию с заранее заданной ролью
+type Invite struct {
+	ID        int        `json:"id" db:"id"`
+	Token     string     `json:"token,omitempty" db:"-"` // открытый токен отдается только при создании
+	Role      string     `json:"role" db:"role"`
+	ExpiresAt time.Time  `json:"expires_at" db:"expires_at"`
+	CreatedBy string     `json:"created_by" db:"created_by"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty" db:"used_at"`
+	UsedBy    *string    `json:"used_by,omitempty" db:"used_by"`
+}
+
+// APIKey - ключ сервисного аккаунта для машинных клиентов с фиксированной ролью
+type APIKey struct {
+	ID         int        `json:"id" db:"id"`
+	Key        string     `json:"key,omitempty" db:"-"` // открытый ключ отдается только при создании
+	Prefix     string     `json:"prefix" db:"key_prefix"`
+	Name       string     `json:"name" db:"name"` // имя сервисного аккаунта - попадает в changed_by
+	Role       string     `json:"role" db:"role"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	CreatedBy  string     `json:"created_by" db:"created_by"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty" db:"revoked_at"`
 }
 
+// JWKS - набор публичных ключей проверки JWT (RFC 7517), отдается на /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK - публичный ключ RSA (n, e) или Ed25519 (crv, x)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// OIDCIdentity - пользователь, подтвержденный ID-токеном внешнего IdP
+type OIDCIdentity struct {
+	Subject  string
+	Username string
+	Groups   []string
+}
+
+// OIDCFlow - одноразовые значения одной попытки OIDC-логина, хранятся в cookie браузера до callback
+type OIDCFlow struct {
+	State    string
+	Nonce    string
+	Verifier string // PKCE code_verifier
+}
+
+// TOTPEnrollment - новый секрет второго фактора: URI показывается QR-кодом, секрет - для ручного ввода
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// TOTPState - второй фактор пользователя; до подтверждения первым кодом он не действует
+type TOTPState struct {
+	UserID      int        `db:"user_id"`
+	Secret      string     `db:"secret"`
+	ConfirmedAt *time.Time `db:"confirmed_at"`
+	LastStep    int64      `db:"last_step"` // последний принятый интервал - защита от повторного предъявления кода
+}
+
+// LoginChallenge - промежуточное состояние входа: пароль проверен, ждем код второго фактора
+type LoginChallenge struct {
+	ID                 int       `json:"-" db:"id"`
+	Token              string    `json:"token" db:"-"` // открытый токен отдается клиенту только один раз
+	UserID             int       `json:"-" db:"user_id"`
+	Username           string    `json:"-" db:"username"`
+	Role               string    `json:"-" db:"role"` // роль, выбранная при вводе пароля
+	ExpiresAt          time.Time `json:"expires_at" db:"expires_at"`
+	Attempts           int       `json:"-" db:"attempts"`
+	EnrollmentRequired bool      `json:"enrollment_required" db:"-"` // второй фактор обязателен для роли, но еще не настроен
+}
+
+// GroupRole - сопоставление группы IdP роли склада
+type GroupRole struct {
+	Group string
+	Role  string
+}
+
+// APIKeyPrefix - префикс открытого API-ключа, по нему ключ отличается от JWT в заголовке Authorization
+const APIKeyPrefix = "whc_"
+
+// APIKeyActorPrefix - префикс имени сервисного аккаунта в changed_by/updated_by: по нему изменения по ключу
+// не путаются с изменениями пользователя с тем же именем. Имена пользователей с этим префиксом запрещены
+const APIKeyActorPrefix = "apikey:"
+
 const (
 	RoleAdmin   = "admin"
 	RoleManager = "manager"
@@ -24,7 +228,62 @@ const (
 	RoleAuditor = "auditor"
 )
 
-var RolesMap = map[string]struct{}{RoleAdmin: {}, RoleManager: {}, RoleViewer: {}, RoleAuditor: {}}
+// разрешения, из которых в файле политики собираются роли
+const (
+	PermItemsRead        = "items.read"
+	PermItemsCreate      = "items.create"
+	PermItemsUpdate      = "items.update"
+	PermItemsDelete      = "items.delete"
+	PermItemsSeeDeleted  = "items.see_deleted"
+	PermItemsRestore     = "items.restore"
+	PermItemsPurge       = "items.purge"
+	PermItemsRevert      = "items.revert"
+	PermHistoryRead      = "history.read"
+	PermHistoryExport    = "history.export"
+	PermUsersManage      = "users.manage"
+	PermPolicyManage     = "policy.manage"
+	PermAuditRead        = "audit.read"
+	PermWarehousesManage = "warehouses.manage"
+
+	PermAll = "*" // все разрешения
+)
+
+var PermissionsMap = map[string]struct{}{
+	PermItemsRead:        {},
+	PermItemsCreate:      {},
+	PermItemsUpdate:      {},
+	PermItemsDelete:      {},
+	PermItemsSeeDeleted:  {},
+	PermItemsRestore:     {},
+	PermItemsPurge:       {},
+	PermItemsRevert:      {},
+	PermHistoryRead:      {},
+	PermHistoryExport:    {},
+	PermUsersManage:      {},
+	PermPolicyManage:     {},
+	PermAuditRead:        {},
+	PermWarehousesManage: {},
+}
+
+// режимы самостоятельной регистрации
+const (
+	SignupDisabled = "disabled" // регистрация закрыта полностью
+	SignupOpen     = "open"     // регистрация открыта с дефолтной ролью, инвайт (если передан) задает роль
+	SignupInvite   = "invite"   // регистрация только по инвайту, роль берется из инвайта
+)
+
+const (
+	UsersOrderByUserName  = "username"
+	UsersOrderByRole      = "role"
+	UsersOrderByCreatedAt = "created_at"
+)
+
+var OrderByUsersMap = map[string]string{
+	ItemsOrderByID:        "id",
+	UsersOrderByUserName:  "username",
+	UsersOrderByRole:      "role",
+	UsersOrderByCreatedAt: "created_at",
+}
 
 // =============== Товар ========================
 
@@ -39,6 +298,11 @@ type Item struct {
 	UpdatedAt       time.Time  `json:"updated_at" db:"updated_at"`
 	UpdatedBy       string     `json:"-" db:"updated_by"`
 	DeletedAt       *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	DeletedBy       *string    `json:"deleted_by,omitempty" db:"-"` // кто удалил - заполняется только в корзине
+	Version         int        `json:"version" db:"version"`        // последняя версия из items_history, отдается как ETag
+
+	WarehouseID *int              `json:"warehouse_id,omitempty" db:"-"` // склад прихода начального остатка; не задан - склад по умолчанию
+	Stock       []*WarehouseStock `json:"stock,omitempty" db:"-"`        // остатки по складам, заполняются только для карточки товара
 }
 type ItemUpdate struct {
 	ID              int     `json:"id" db:"id"`
@@ -48,8 +312,40 @@ type ItemUpdate struct {
 	Visible         *bool   `json:"visible" db:"visible"`
 	AvailableAmount *int    `json:"available_amount" db:"available_amount"`
 	UpdatedBy       string  `json:"-" db:"updated_by"`
+
+	WarehouseID *int `json:"warehouse_id,omitempty" db:"-"` // склад, остаток на котором задает available_amount
+	Version     *int `json:"-" db:"-"`                      // ожидаемая версия товара из If-Match; не задана - без проверки
+	RevertOf    *int `json:"-" db:"-"`                      // версия, к которой откатывается товар; задается только сервисом
+}
+
+// ItemRevert - запрос отката товара к состоянию после версии Version
+type ItemRevert struct {
+	Version int `json:"version"`
+}
+
+// ItemFilter - фильтры списка товаров; при фильтре по складу available_amount - остаток на этом складе
+type ItemFilter struct {
+	WarehouseID *int       `form:"warehouse_id"`
+	AsOf        *time.Time `form:"as_of"` // состояние товаров на момент по последним снимкам History; со складом не сочетается
 }
 
+// PurgeRequest - параметры очистки корзины; без OlderThanDays берется срок хранения из настроек
+type PurgeRequest struct {
+	DryRun        bool `form:"dry_run"`
+	OlderThanDays *int `form:"older_than_days"`
+}
+
+// PurgeResult - товары, удаленные окончательно (или только кандидаты на удаление при DryRun)
+type PurgeResult struct {
+	DryRun        bool      `json:"dry_run"`
+	DeletedBefore time.Time `json:"deleted_before"`
+	Count         int       `json:"count"`
+	Items         []*Item   `json:"items"`
+}
+
+// PurgeJobActor - автор записей COMPLETE DELETE, сделанных плановой очисткой
+const PurgeJobActor = "purge-job"
+
 const (
 	ItemsOrderByID           = "id"
 	ItemsOrderByTitle        = "title"
@@ -58,12 +354,169 @@ const (
 	ItemsOrderByVisibility   = "visibility"
 )
 
-var OrderByItemsMap = map[string]struct{}{
-	ItemsOrderByID:           {},
-	ItemsOrderByTitle:        {},
-	ItemsOrderByPrice:        {},
-	ItemsOrderByAvailability: {},
-	ItemsOrderByVisibility:   {},
+var OrderByItemsMap = map[string]string{
+	ItemsOrderByID:           "id",
+	ItemsOrderByTitle:        "title",
+	ItemsOrderByPrice:        "price",
+	ItemsOrderByAvailability: "available_amount",
+	ItemsOrderByVisibility:   "visible",
+}
+
+// =============== Склады ========================
+
+type Warehouse struct {
+	ID        int       `json:"id" db:"id"`
+	Code      string    `json:"code" binding:"required" db:"code"`
+	Name      string    `json:"name" binding:"required" db:"name"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedBy string    `json:"-" db:"updated_by"`
+}
+
+// WarehouseStock - остаток товара на одном складе
+type WarehouseStock struct {
+	WarehouseID   int       `json:"warehouse_id" db:"warehouse_id"`
+	WarehouseCode string    `json:"warehouse_code" db:"code"`
+	Amount        int       `json:"amount" db:"amount"`
+	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Location - место хранения на складе; остатки товара лежат только в ячейках (BIN)
+type Location struct {
+	ID          int       `json:"id" db:"id"`
+	WarehouseID int       `json:"warehouse_id" db:"warehouse_id"`
+	ParentID    *int      `json:"parent_id,omitempty" db:"parent_id"`
+	Kind        string    `json:"kind" binding:"required" db:"kind"`
+	Code        string    `json:"code" binding:"required" db:"code"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedBy   string    `json:"-" db:"updated_by"`
+}
+
+const (
+	LocationZone  = "ZONE"
+	LocationAisle = "AISLE"
+	LocationShelf = "SHELF"
+	LocationBin   = "BIN"
+
+	// ReceivingLocationCode - ячейка приемки, создается для каждого склада автоматически
+	ReceivingLocationCode = "RECEIVING"
+)
+
+// LocationLevels - уровень вложенности вида места хранения: родитель всегда уровнем выше
+var LocationLevels = map[string]int{
+	LocationZone:  1,
+	LocationAisle: 2,
+	LocationShelf: 3,
+	LocationBin:   4,
+}
+
+// LocationStock - остаток товара в ячейке
+type LocationStock struct {
+	LocationID   int       `json:"location_id" db:"location_id"`
+	LocationCode string    `json:"location_code" db:"code"`
+	WarehouseID  int       `json:"warehouse_id" db:"warehouse_id"`
+	ItemID       int       `json:"item_id" db:"item_id"`
+	ItemTitle    string    `json:"item_title" db:"title"`
+	Amount       int       `json:"amount" db:"amount"`
+	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// StockMove - перенос остатка товара между ячейками одного склада; без FromLocationID - размещение из приемки
+type StockMove struct {
+	ItemID         int    `json:"-"`
+	FromLocationID *int   `json:"from_location_id,omitempty"`
+	ToLocationID   int    `json:"to_location_id" binding:"required"`
+	Amount         int    `json:"amount" binding:"required"`
+	WarehouseID    int    `json:"-"`
+	Action         string `json:"-"` // PUTAWAY или MOVE - попадает в историю товара
+	UpdatedBy      string `json:"-"`
+}
+
+const (
+	HistoryActionPutaway = "PUTAWAY"
+	HistoryActionMove    = "MOVE"
+)
+
+// =============== Движения остатков ========================
+
+// Movement - запись журнала движений. В запросе на перемещение (transfer) quantity - сколько переносится,
+// а журнал получает две записи: списание в источнике и приход в получателе
+type Movement struct {
+	ID           int64     `json:"id" db:"id"`
+	ItemID       int       `json:"item_id" binding:"required" db:"item_id"`
+	Type         string    `json:"type" binding:"required" db:"movement_type"`
+	WarehouseID  *int      `json:"warehouse_id" db:"warehouse_id"` // не задан - склад по умолчанию
+	LocationID   *int      `json:"location_id" db:"location_id"`   // не задана - ячейка приемки склада
+	Quantity     int       `json:"quantity" binding:"required" db:"quantity"`
+	ReasonCode   string    `json:"reason_code" binding:"required" db:"reason_code"`
+	Reference    *string   `json:"reference,omitempty" db:"reference"` // номер накладной, заказа и т.п.
+	Comment      *string   `json:"comment,omitempty" db:"comment"`
+	RelatedID    *int64    `json:"related_id,omitempty" db:"related_id"` // парная запись перемещения
+	BalanceAfter int       `json:"balance_after" db:"balance_after"`     // общий остаток товара после движения
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+	CreatedBy    string    `json:"created_by" db:"created_by"`
+
+	ToWarehouseID *int `json:"to_warehouse_id,omitempty" db:"-"` // только для transfer
+	ToLocationID  *int `json:"to_location_id,omitempty" db:"-"`  // только для transfer
+}
+
+// MovementFilter - фильтры журнала движений
+type MovementFilter struct {
+	ItemID      *int    `form:"item_id"`
+	WarehouseID *int    `form:"warehouse_id"`
+	Type        *string `form:"type"`
+	ReasonCode  *string `form:"reason_code"`
+	Reference   *string `form:"reference"`
+}
+
+const (
+	MovementReceipt    = "receipt"
+	MovementIssue      = "issue"
+	MovementTransfer   = "transfer"
+	MovementAdjustment = "adjustment"
+	MovementWriteOff   = "write_off"
+	MovementReturn     = "return"
+)
+
+// MovementTypesMap - знак количества, допустимый для типа движения: 0 - любой ненулевой
+var MovementTypesMap = map[string]int{
+	MovementReceipt:    1,
+	MovementIssue:      -1,
+	MovementTransfer:   1,
+	MovementAdjustment: 0,
+	MovementWriteOff:   -1,
+	MovementReturn:     1,
+}
+
+// системные коды причин для движений, которые приложение пишет само
+const (
+	ReasonInitial    = "INITIAL"     // начальный остаток при создании товара
+	ReasonManualEdit = "MANUAL_EDIT" // остаток задан через PATCH /items/:id
+	ReasonStockDelta = "STOCK_DELTA" // остаток изменен на delta через PATCH /items/:id/stock
+)
+
+// StockAdjustment - относительное изменение остатка товара: delta применяется к текущему значению в БД,
+// поэтому одновременные списания не затирают друг друга. Без ячейки - ячейка приемки склада
+type StockAdjustment struct {
+	ItemID      int     `json:"-"`
+	Delta       int     `json:"delta" binding:"required"`
+	WarehouseID *int    `json:"warehouse_id,omitempty"`
+	LocationID  *int    `json:"location_id,omitempty"`
+	ReasonCode  string  `json:"reason_code,omitempty"` // по умолчанию STOCK_DELTA
+	Reference   *string `json:"reference,omitempty"`
+	Comment     *string `json:"comment,omitempty"`
+	UpdatedBy   string  `json:"-"`
+}
+
+// IdempotencyRecord - ответ на запрос с Idempotency-Key, сохраненный для повторов
+type IdempotencyRecord struct {
+	Owner       string // пользователь или сервисный аккаунт - у каждого клиента свои ключи
+	Key         string
+	RequestHash string // sha256 метода, пути и тела запроса
+	StatusCode  int    // 0 - первый запрос еще обрабатывается
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
 }
 
 // ========== История изменений ================
@@ -77,8 +530,73 @@ type ItemHistory struct {
 	ChangedBy string           `json:"changed_by" db:"changed_by"`
 	OldData   *json.RawMessage `json:"old" db:"old_data"`
 	NewData   *json.RawMessage `json:"new" db:"new_data"`
+
+	WarehouseID *int `json:"warehouse_id,omitempty" db:"warehouse_id"` // склад, остаток на котором менялся
+	StockDelta  *int `json:"stock_delta,omitempty" db:"stock_delta"`   // относительное изменение остатка
+
+	RevertedFrom *int `json:"reverted_from,omitempty" db:"reverted_from"` // версия, к состоянию которой товар откачен (REVERT)
+
+	Changes []FieldChange `json:"changes" db:"-"` // изменившиеся поля: old -> new, считаются сервисом
+}
+
+// HistoryFilter - фильтры истории изменений товаров. Повторяемые фильтры (?action=UPDATE&action=REVERT)
+// объединяются через ИЛИ внутри одного параметра и через И между параметрами
+type HistoryFilter struct {
+	WarehouseID *int    `form:"warehouse_id"`
+	Ignore      *string `form:"ignore"` // поля через запятую, не попадающие в changes; не задан - HistoryNoiseFields
+
+	ChangedBy []string `form:"changed_by"`
+	Action    []string `form:"action"`
+	ItemID    []int    `form:"item_id"`
+	Field     []string `form:"field"` // только записи, в которых изменилось хотя бы одно из полей
+}
+
+// действия в истории товаров, кроме PUTAWAY и MOVE - они объявлены рядом с перемещениями
+const (
+	HistoryActionInsert     = "INSERT"
+	HistoryActionUpdate     = "UPDATE"
+	HistoryActionSoftDelete = "SOFT DELETE"
+	HistoryActionPurge      = "COMPLETE DELETE"
+	HistoryActionRestore    = "RESTORE"
+	HistoryActionRevert     = "REVERT"
+)
+
+var HistoryActionsMap = map[string]struct{}{
+	HistoryActionInsert:     {},
+	HistoryActionUpdate:     {},
+	HistoryActionSoftDelete: {},
+	HistoryActionPurge:      {},
+	HistoryActionPutaway:    {},
+	HistoryActionMove:       {},
+	HistoryActionRestore:    {},
+	HistoryActionRevert:     {},
+}
+
+// FieldChange - изменение одного поля товара; отсутствующее значение (до создания, после удаления) - null
+type FieldChange struct {
+	Field string          `json:"field"`
+	Old   json.RawMessage `json:"old"`
+	New   json.RawMessage `json:"new"`
+}
+
+// ItemDiff - отличия между состояниями товара после версий From и To
+type ItemDiff struct {
+	ItemID  int           `json:"item_id"`
+	From    int           `json:"from"`
+	To      int           `json:"to"`
+	Changes []FieldChange `json:"changes"`
+}
+
+// ItemDiffRequest - параметры сравнения версий товара
+type ItemDiffRequest struct {
+	From   int     `form:"from"`
+	To     int     `form:"to"`
+	Ignore *string `form:"ignore"` // как в HistoryFilter
 }
 
+// HistoryNoiseFields меняются при каждой записи и по умолчанию не показываются в changes
+var HistoryNoiseFields = []string{"updated_at", "updated_by"}
+
 type RequestParam struct {
 	OrderBy   *string    `form:"order_by"` // возможно есть смысл вынести orderby/asc/desc в отдельную структуру
 	ASC       bool       `form:"asc"`
@@ -97,12 +615,21 @@ const (
 	HistoryOrderByActor   = "actor"
 )
 
-var OrderByHistoryMap = map[string]struct{}{
-	HistoryOrderByID:      {}, // вместо пустой структуры можно смапить название поля в БД
-	HistoryOrderByItemID:  {},
-	HistoryOrderByAction:  {},
-	HistoryOrderByVersion: {},
-	HistoryOrderByActor:   {},
+// OrderByHistoryMap - поле сортировки в API -> колонка в БД
+var OrderByHistoryMap = map[string]string{
+	HistoryOrderByID:      "id",
+	HistoryOrderByItemID:  "item_id",
+	HistoryOrderByAction:  "action",
+	HistoryOrderByVersion: "version",
+	HistoryOrderByActor:   "changed_by",
+}
+
+// OrderByUserHistoryMap - то же для истории пользователей: в ней нет item_id
+var OrderByUserHistoryMap = map[string]string{
+	HistoryOrderByID:      "id",
+	HistoryOrderByAction:  "action",
+	HistoryOrderByVersion: "version",
+	HistoryOrderByActor:   "changed_by",
 }
 
 //====================================
@@ -113,3 +640,17 @@ func RequestIDFromCtx(ctx context.Context) string {
 	}
 	return ""
 }
+
+func ClientIPFromCtx(ctx context.Context) string {
+	if v := ctx.Value("client_ip"); v != nil {
+		return v.(string)
+	}
+	return ""
+}
+
+func UserAgentFromCtx(ctx context.Context) string {
+	if v := ctx.Value("user_agent"); v != nil {
+		return v.(string)
+	}
+	return ""
+}
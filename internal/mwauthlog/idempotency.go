@@ -0,0 +1,125 @@
+package mwauthlog
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	IdempotencyHeader = "Idempotency-Key"
+	ReplayedHeader    = "Idempotent-Replayed" // ответ взят из сохраненного, повторно запрос не выполнялся
+)
+
+const maxIdempotencyKeyLen = 255
+
+// способ входа, который RequireAuth кладет в контекст как auth_kind
+const (
+	AuthKindUser   = "user"
+	AuthKindAPIKey = "apikey"
+)
+
+// IdempotencyStore хранит первые ответы на запросы с Idempotency-Key
+type IdempotencyStore interface {
+	ClaimIdempotencyKey(ctx context.Context, rec *model.IdempotencyRecord) (*model.IdempotencyRecord, error)
+	SaveIdempotentResponse(ctx context.Context, rec *model.IdempotencyRecord)
+	ReleaseIdempotencyKey(ctx context.Context, owner, key string)
+}
+
+// responseRecorder копирует тело ответа, чтобы сохранить его для повторов
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *responseRecorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
+
+// Idempotency делает повторы запроса с Idempotency-Key безопасными: первый ответ сохраняется, повтор с тем же
+// ключом и телом получает его же, не выполняя запрос второй раз. Тот же ключ с другим телом - 409.
+// Ответ 5xx не сохраняется - после сбоя клиент может повторить запрос с тем же ключом.
+// Ставится после RequireAuth: ключи у каждого пользователя свои. Без заголовка или store ничего не делает
+func Idempotency(store IdempotencyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := strings.TrimSpace(c.GetHeader(IdempotencyHeader))
+		if store == nil || key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": model.ErrInvalidIdempotency.Error()})
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		hash.Write([]byte(c.Request.Method + " " + c.Request.URL.Path + "\n"))
+		hash.Write(body)
+		// пользователь и API-ключ с тем же именем не должны делить ключи
+		rec := &model.IdempotencyRecord{
+			Owner:       c.GetString("auth_kind") + ":" + c.GetString("username"),
+			Key:         key,
+			RequestHash: hex.EncodeToString(hash.Sum(nil)),
+		}
+
+		// ответ сохраняется и после обрыва соединения клиентом - иначе его повтор получит 409
+		ctx := context.WithoutCancel(c.Request.Context())
+
+		saved, err := store.ClaimIdempotencyKey(ctx, rec)
+		switch {
+		case errors.Is(err, model.ErrIdempotencyKeyReused), errors.Is(err, model.ErrIdempotencyInProgress):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		case err != nil:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		case saved != nil:
+			c.Header(ReplayedHeader, "true")
+			c.Data(saved.StatusCode, saved.ContentType, saved.Body)
+			c.Abort()
+			return
+		}
+
+		// ключ освобождается, если ответ не сохранен - в т.ч. при панике в хендлере
+		stored := false
+		defer func() {
+			if !stored {
+				store.ReleaseIdempotencyKey(ctx, rec.Owner, rec.Key)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		if recorder.Status() >= http.StatusInternalServerError {
+			return
+		}
+		rec.StatusCode = recorder.Status()
+		rec.ContentType = recorder.Header().Get("Content-Type")
+		rec.Body = recorder.body.Bytes()
+		store.SaveIdempotentResponse(ctx, rec)
+		stored = true
+	}
+}
@@ -5,16 +5,17 @@ import (
 
 	"github.com/UnendingLoop/WarehouseControl/internal/model"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 type JWTManager struct {
-	secret []byte
+	keys   *KeySet
 	ttl    time.Duration
 	issuer string
 }
 
-func NewJWTManager(secret []byte, ttl time.Duration, issuer string) *JWTManager {
-	return &JWTManager{secret: secret, ttl: ttl, issuer: issuer}
+func NewJWTManager(keys *KeySet, ttl time.Duration, issuer string) *JWTManager {
+	return &JWTManager{keys: keys, ttl: ttl, issuer: issuer}
 }
 
 func (j *JWTManager) Generate(uid int, userName string, role string) (string, error) {
@@ -26,12 +27,15 @@ func (j *JWTManager) Generate(uid int, userName string, role string) (string, er
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(j.ttl)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    j.issuer,
+			ID:        uuid.NewString(), // jti - по нему токен можно отозвать до истечения
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signing := j.keys.signing
+	token := jwt.NewWithClaims(signing.method, claims)
+	token.Header["kid"] = signing.kid // по kid проверяющая сторона выбирает ключ из JWKS
 
-	signed, err := token.SignedString(j.secret)
+	signed, err := token.SignedString(signing.private)
 	if err != nil {
 		return "", err
 	}
@@ -43,12 +47,10 @@ func (j *JWTManager) Parse(tokenStr string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(
 		tokenStr,
 		&Claims{},
-		func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, model.ErrInvalidToken
-			}
-			return j.secret, nil
-		},
+		j.keys.lookup,
+		jwt.WithIssuer(j.issuer),
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithExpirationRequired(),
 	)
 	if err != nil {
 		return nil, model.ErrInvalidToken
@@ -61,3 +63,8 @@ func (j *JWTManager) Parse(tokenStr string) (*Claims, error) {
 
 	return claims, nil
 }
+
+// JWKS - публичные ключи всех действующих ключей проверки
+func (j *JWTManager) JWKS() *model.JWKS {
+	return j.keys.JWKS()
+}
@@ -0,0 +1,178 @@
+package mwauthlog
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minRSABits - более короткие RSA-ключи не принимаем
+const minRSABits = 2048
+
+// verificationKey - ключ из набора: приватная часть есть только у ключей, которыми можно подписывать
+type verificationKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	public  crypto.PublicKey
+	private crypto.Signer
+}
+
+// KeySet - набор ключей для JWT: один активный ключ подписи и все ключи, которыми еще проверяются токены
+type KeySet struct {
+	signing *verificationKey
+	keys    map[string]*verificationKey
+}
+
+// LoadKeySet читает из dir PEM-файлы вида <kid>.pem (RSA или Ed25519, приватные или только публичные).
+// Подписывается ключом signingKID, остальные ключи только проверяют ранее выпущенные токены
+func LoadKeySet(dir, signingKID string) (*KeySet, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no *.pem keys found in %q", dir)
+	}
+
+	ks := &KeySet{keys: make(map[string]*verificationKey, len(files))}
+	for _, f := range files {
+		raw, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		kid := strings.TrimSuffix(filepath.Base(f), ".pem")
+		key, err := parsePEMKey(kid, raw)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", f, err)
+		}
+		ks.keys[kid] = key
+	}
+
+	if signingKID == "" && len(ks.keys) == 1 {
+		for kid := range ks.keys {
+			signingKID = kid
+		}
+	}
+
+	signing, ok := ks.keys[signingKID]
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("signing key %q not found in %q", signingKID, dir)
+	case signing.private == nil:
+		return nil, fmt.Errorf("signing key %q has no private part", signingKID)
+	}
+	ks.signing = signing
+
+	return ks, nil
+}
+
+// GenerateKeySet создает одноразовый Ed25519-ключ - для локального запуска без JWT_KEYS_DIR.
+// После рестарта access-токены становятся недействительны и обновляются через refresh
+func GenerateKeySet() (*KeySet, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &verificationKey{kid: "ephemeral", method: jwt.SigningMethodEdDSA, public: pub, private: priv}
+	return &KeySet{signing: key, keys: map[string]*verificationKey{key.kid: key}}, nil
+}
+
+func parsePEMKey(kid string, raw []byte) (*verificationKey, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	var parsed any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &verificationKey{kid: kid}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.private, key.public = k, &k.PublicKey
+	case ed25519.PrivateKey:
+		key.private, key.public = k, k.Public()
+	case *rsa.PublicKey, ed25519.PublicKey:
+		key.public = k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T: only RSA and Ed25519 are allowed", parsed)
+	}
+
+	switch pub := key.public.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA key is shorter than %d bits", minRSABits)
+		}
+		key.method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.method = jwt.SigningMethodEdDSA
+	}
+
+	return key, nil
+}
+
+// lookup возвращает ключ проверки по kid из заголовка токена с учетом алгоритма
+func (ks *KeySet) lookup(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+	key, ok := ks.keys[kid]
+	if !ok || t.Method.Alg() != key.method.Alg() {
+		return nil, model.ErrInvalidToken
+	}
+	return key.public, nil
+}
+
+// JWKS - публичные ключи в формате RFC 7517 для сторонних сервисов, проверяющих наши токены
+func (ks *KeySet) JWKS() *model.JWKS {
+	kids := make([]string, 0, len(ks.keys))
+	for kid := range ks.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	res := &model.JWKS{Keys: make([]model.JWK, 0, len(kids))}
+	for _, kid := range kids {
+		key := ks.keys[kid]
+		jwk := model.JWK{Kid: kid, Use: "sig", Alg: key.method.Alg()}
+
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		res.Keys = append(res.Keys, jwk)
+	}
+
+	return res
+}
@@ -3,8 +3,9 @@ package mwauthlog
 
 import (
 	"context"
+	"crypto/subtle"
 	"net/http"
-	"time"
+	"strings"
 
 	"github.com/UnendingLoop/WarehouseControl/internal/model"
 	"github.com/gin-gonic/gin"
@@ -21,13 +22,41 @@ type (
 	}
 )
 
-var ReqID = "request_id"
+var (
+	ReqID     = "request_id"
+	ClientIP  = "client_ip"
+	UserAgent = "user_agent"
+
+	// double-submit CSRF: значение cookie (читается JS, не HttpOnly) должно прийти еще и в заголовке
+	CSRFCookie = "csrf_token"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// TokenParser проверяет подпись и срок access-токена
+type TokenParser interface {
+	Parse(tokenStr string) (*Claims, error)
+}
+
+// SessionChecker проверяет на стороне сервера, что сессия из валидного JWT все еще действительна
+// (пользователь не отключен/не удален, роль не отозвана), а также API-ключи сервисных аккаунтов
+type SessionChecker interface {
+	CheckSession(ctx context.Context, claims *Claims) error
+	CheckAPIKey(ctx context.Context, key string) (*Claims, error)
+}
+
+// AuthEventRecorder пишет событие в журнал аутентификации
+type AuthEventRecorder interface {
+	RecordAuthEvent(ctx context.Context, event *model.AuthEvent)
+}
 
 func RequestID() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		rid := uuid.New().String()
 
 		ctx := context.WithValue(c.Request.Context(), ReqID, rid)
+		// адрес и клиент нужны сервису для защиты логина от перебора и для аудита
+		ctx = context.WithValue(ctx, ClientIP, c.ClientIP())
+		ctx = context.WithValue(ctx, UserAgent, c.Request.UserAgent())
 		c.Request = c.Request.WithContext(ctx)
 
 		c.Header("X-Request-ID", rid)
@@ -35,47 +64,69 @@ func RequestID() gin.HandlerFunc {
 	}
 }
 
-func GenerateToken(userID int, role string, secret []byte) (string, error) {
-	claims := Claims{
-		UserID: userID,
-		Role:   role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-		},
+// apiKeyFromRequest достает API-ключ из X-API-Key или из Authorization: Bearer (если там ключ, а не JWT)
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && strings.HasPrefix(bearer, model.APIKeyPrefix) {
+		return bearer
 	}
+	return ""
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(secret)
+// accessTokenFromRequest достает JWT из Authorization: Bearer (скрипты, мобильные клиенты), иначе - из cookie браузера.
+// API-ключ в Bearer сюда не доходит - он проверяется раньше
+func accessTokenFromRequest(r *http.Request) string {
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(bearer)
+	}
+	if cookie, err := r.Cookie("access_token"); err == nil {
+		return cookie.Value
+	}
+	return ""
 }
 
-func RequireAuth(secret []byte) gin.HandlerFunc {
+// RequireAuth пропускает запрос с действующим API-ключом или access-токеном. Токен проверяется
+// тем же TokenParser (JWTManager.Parse), что и везде: подпись, алгоритм ключа, срок и издатель
+func RequireAuth(tokens TokenParser, sessions SessionChecker) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		cookie, err := c.Request.Cookie("access_token")
-		if err != nil {
-			c.AbortWithStatus(401)
+		// машинные клиенты (ERP, сканеры) авторизуются API-ключом вместо cookie
+		if key := apiKeyFromRequest(c.Request); key != "" {
+			claims, err := sessions.CheckAPIKey(c.Request.Context(), key)
+			if err != nil {
+				c.Set("auth_error", "invalid api key")
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+
+			// имя сервисного аккаунта попадает в updated_by/changed_by так же, как username
+			c.Set("user_id", claims.UserID)
+			c.Set("role", claims.Role)
+			c.Set("username", claims.Username)
+			c.Set("auth_kind", AuthKindAPIKey)
+
+			c.Next()
 			return
 		}
 
-		tokenStr := cookie.Value
-
-		token, err := jwt.ParseWithClaims(
-			tokenStr,
-			&Claims{},
-			func(token *jwt.Token) (any, error) {
-				return secret, nil
-			},
-		)
-
-		if err != nil || !token.Valid {
+		token := accessTokenFromRequest(c.Request)
+		if token == "" {
+			c.Set("auth_error", "no access token")
 			c.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
 
-		claims := token.Claims.(*Claims)
+		claims, err := tokens.Parse(token)
+		if err != nil {
+			c.Set("auth_error", "invalid access token")
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
 
-		// проверяем корректность роли - принадлежит ли она списку ролей приложения
-		_, ok := model.RolesMap[claims.Role]
-		if !ok {
+		// токен может быть еще валиден, а пользователь уже отключен или роль удалена из политики
+		if err := sessions.CheckSession(c.Request.Context(), claims); err != nil {
+			c.Set("auth_error", "session rejected")
 			c.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
@@ -84,31 +135,63 @@ func RequireAuth(secret []byte) gin.HandlerFunc {
 		c.Set("user_id", claims.UserID)
 		c.Set("role", claims.Role)
 		c.Set("username", claims.Username)
+		c.Set("auth_kind", AuthKindUser)
 
 		c.Next()
 	}
 }
 
-func RequireAuthTest(secret []byte) gin.HandlerFunc {
+// RequireCSRF защищает изменяющие запросы (POST/PUT/PATCH/DELETE) cookie-сессий по схеме double-submit:
+// токен из cookie csrf_token должен совпасть с заголовком X-CSRF-Token. Чужой сайт может заставить браузер
+// отправить cookie, но прочитать ее и поставить заголовок - нет. Запросы с Bearer или API-ключом cookie не
+// используют, поэтому проверку не проходят
+func RequireCSRF() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		cookie, err := c.Request.Cookie("access_token")
-		if err != nil {
-			c.AbortWithStatus(401)
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
 			return
 		}
 
-		token := cookie.Value
-		if token != "jwt-token" {
-			c.AbortWithStatus(401)
+		if strings.HasPrefix(c.Request.Header.Get("Authorization"), "Bearer ") || c.Request.Header.Get("X-API-Key") != "" {
+			c.Next()
 			return
 		}
 
-		// прокидываем дальше в контекст
-		c.Set("user_id", 300)
-		c.Set("role", "testRole")
-		c.Set("username", "testUserName")
-		c.Set(ReqID, "test-request-UUID")
+		cookie, err := c.Request.Cookie(CSRFCookie)
+		header := c.Request.Header.Get(CSRFHeader)
+		if err != nil || cookie.Value == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) != 1 {
+			c.Set("auth_error", "csrf token mismatch")
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": model.ErrInvalidCSRFToken.Error()})
+			return
+		}
+
+		c.Next()
+	}
+}
 
+// AuditDenials пишет в журнал аутентификации каждый запрос, завершившийся 401 или 403 - как отказ
+// самой авторизации, так и отказ политики в хендлере. Без recorder ничего не делает
+func AuditDenials(rec AuthEventRecorder) gin.HandlerFunc {
+	return func(c *gin.Context) {
 		c.Next()
+
+		status := c.Writer.Status()
+		if rec == nil || (status != http.StatusUnauthorized && status != http.StatusForbidden) {
+			return
+		}
+
+		// данные пользователя есть только если он прошел RequireAuth
+		event := &model.AuthEvent{
+			Type:     model.AuthEventDenied,
+			UserID:   c.GetInt("user_id"),
+			Username: c.GetString("username"),
+			Role:     c.GetString("role"),
+			Status:   status,
+			Detail:   c.GetString("auth_error"),
+			Method:   c.Request.Method,
+			Path:     c.FullPath(),
+		}
+		rec.RecordAuthEvent(c.Request.Context(), event)
 	}
 }
@@ -0,0 +1,429 @@
+package mwauthlog
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func writeKey(t *testing.T, dir, kid string, key any) {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	raw := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, kid+".pem"), raw, 0o600))
+}
+
+func writePublicKey(t *testing.T, dir, kid string, key any) {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	require.NoError(t, err)
+	raw := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, kid+".pem"), raw, 0o600))
+}
+
+func TestKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	writeKey(t, dir, "2025-01", rsaKey)
+	writeKey(t, dir, "2025-02", edKey)
+
+	// до ротации подписываем старым RSA-ключом
+	oldKeys, err := LoadKeySet(dir, "2025-01")
+	require.NoError(t, err)
+	oldMngr := NewJWTManager(oldKeys, time.Minute, "test")
+	oldToken, err := oldMngr.Generate(1, "user", model.RoleViewer)
+	require.NoError(t, err)
+
+	// после ротации подписываем новым Ed25519-ключом, старые токены все еще проверяются
+	newKeys, err := LoadKeySet(dir, "2025-02")
+	require.NoError(t, err)
+	newMngr := NewJWTManager(newKeys, time.Minute, "test")
+	newToken, err := newMngr.Generate(2, "user2", model.RoleManager)
+	require.NoError(t, err)
+
+	claims, err := newMngr.Parse(oldToken)
+	require.NoError(t, err)
+	require.Equal(t, 1, claims.UserID)
+
+	claims, err = newMngr.Parse(newToken)
+	require.NoError(t, err)
+	require.Equal(t, model.RoleManager, claims.Role)
+
+	// старый ключ удален из каталога - его токены больше не принимаются
+	require.NoError(t, os.Remove(filepath.Join(dir, "2025-01.pem")))
+	retired, err := LoadKeySet(dir, "2025-02")
+	require.NoError(t, err)
+	_, err = NewJWTManager(retired, time.Minute, "test").Parse(oldToken)
+	require.ErrorIs(t, err, model.ErrInvalidToken)
+
+	// чужой издатель не принимается
+	_, err = NewJWTManager(newKeys, time.Minute, "other").Parse(newToken)
+	require.ErrorIs(t, err, model.ErrInvalidToken)
+}
+
+func TestLoadKeySet(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	weakKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	cases := []struct {
+		name    string
+		prepare func(dir string)
+		kid     string
+		wantErr bool
+	}{
+		{
+			name:    "Positive - single key is used for signing without kid",
+			prepare: func(dir string) { writeKey(t, dir, "main", edKey) },
+		},
+		{
+			name:    "Negative - empty dir",
+			prepare: func(dir string) {},
+			wantErr: true,
+		},
+		{
+			name:    "Negative - unknown signing kid",
+			prepare: func(dir string) { writeKey(t, dir, "main", edKey) },
+			kid:     "other",
+			wantErr: true,
+		},
+		{
+			name:    "Negative - signing key without private part",
+			prepare: func(dir string) { writePublicKey(t, dir, "main", edKey.Public()) },
+			kid:     "main",
+			wantErr: true,
+		},
+		{
+			name:    "Negative - weak RSA key",
+			prepare: func(dir string) { writeKey(t, dir, "weak", weakKey) },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tt.prepare(dir)
+
+			_, err := LoadKeySet(dir, tt.kid)
+
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	writeKey(t, dir, "a-rsa", rsaKey)
+	writeKey(t, dir, "b-ed", edKey)
+	writePublicKey(t, dir, "c-retired", edPub)
+
+	ks, err := LoadKeySet(dir, "b-ed")
+	require.NoError(t, err)
+
+	jwks := ks.JWKS()
+	require.Len(t, jwks.Keys, 3)
+
+	require.Equal(t, "a-rsa", jwks.Keys[0].Kid)
+	require.Equal(t, "RSA", jwks.Keys[0].Kty)
+	require.Equal(t, "RS256", jwks.Keys[0].Alg)
+	require.Equal(t, "AQAB", jwks.Keys[0].E)
+	require.NotEmpty(t, jwks.Keys[0].N)
+
+	require.Equal(t, "b-ed", jwks.Keys[1].Kid)
+	require.Equal(t, "OKP", jwks.Keys[1].Kty)
+	require.Equal(t, "Ed25519", jwks.Keys[1].Crv)
+	require.Equal(t, "EdDSA", jwks.Keys[1].Alg)
+	require.NotEmpty(t, jwks.Keys[1].X)
+}
+
+type recorderStub struct {
+	events []*model.AuthEvent
+}
+
+func (r *recorderStub) RecordAuthEvent(ctx context.Context, event *model.AuthEvent) {
+	event.RequestID = model.RequestIDFromCtx(ctx)
+	r.events = append(r.events, event)
+}
+
+func TestAuditDenials(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	rec := &recorderStub{}
+
+	r := gin.New()
+	r.Use(RequestID())
+	protected := r.Group("", AuditDenials(rec))
+	protected.GET("/private", RequireAuth(nil, nil), func(c *gin.Context) { c.Status(http.StatusOK) })
+	protected.GET("/forbidden/:id", func(c *gin.Context) {
+		c.Set("username", "viewer1")
+		c.Set("role", model.RoleViewer)
+		c.JSON(http.StatusForbidden, gin.H{"error": "access denied"})
+	})
+	protected.GET("/ok", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, path := range []string{"/private", "/forbidden/7", "/ok"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// успешные запросы в журнал не попадают
+	require.Len(t, rec.events, 2)
+
+	require.Equal(t, model.AuthEventDenied, rec.events[0].Type)
+	require.Equal(t, http.StatusUnauthorized, rec.events[0].Status)
+	require.Equal(t, "no access token", rec.events[0].Detail)
+	require.Equal(t, "/private", rec.events[0].Path)
+	require.NotEmpty(t, rec.events[0].RequestID)
+
+	require.Equal(t, http.StatusForbidden, rec.events[1].Status)
+	require.Equal(t, "/forbidden/:id", rec.events[1].Path) // шаблон маршрута, а не конкретный id
+	require.Equal(t, "viewer1", rec.events[1].Username)
+	require.Equal(t, model.RoleViewer, rec.events[1].Role)
+}
+
+// sessionsStub - любая сессия действительна, API-ключей нет
+type sessionsStub struct{}
+
+func (sessionsStub) CheckSession(ctx context.Context, claims *Claims) error { return nil }
+
+func (sessionsStub) CheckAPIKey(ctx context.Context, key string) (*Claims, error) {
+	return nil, model.ErrInvalidAPIKey
+}
+
+func TestRequireAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	ks, err := GenerateKeySet()
+	require.NoError(t, err)
+	jm := NewJWTManager(ks, time.Minute, "whc")
+
+	valid, err := jm.Generate(7, "john", model.RoleManager)
+	require.NoError(t, err)
+
+	// тот же kid и те же claims, но HMAC с публичным модулем RSA вместо подписи ключом - классическая подмена алгоритма
+	forgedClaims := Claims{UserID: 7, Username: "john", Role: model.RoleAdmin, RegisteredClaims: jwt.RegisteredClaims{
+		Issuer: "whc", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}}
+	forgedToken := jwt.NewWithClaims(jwt.SigningMethodHS256, forgedClaims)
+	forgedToken.Header["kid"] = ks.signing.kid
+	forged, err := forgedToken.SignedString([]byte("public-key-bytes"))
+	require.NoError(t, err)
+
+	r := gin.New()
+	r.GET("/private", RequireAuth(jm, sessionsStub{}), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("username")+":"+c.GetString("role"))
+	})
+
+	cases := []struct {
+		name     string
+		bearer   string
+		cookie   string
+		wantCode int
+	}{
+		{name: "Positive - bearer header", bearer: valid, wantCode: http.StatusOK},
+		{name: "Positive - cookie", cookie: valid, wantCode: http.StatusOK},
+		{name: "Positive - bearer takes precedence over stale cookie", bearer: valid, cookie: "stale", wantCode: http.StatusOK},
+		{name: "Negative - no token", wantCode: http.StatusUnauthorized},
+		{name: "Negative - garbage bearer", bearer: "not-a-jwt", wantCode: http.StatusUnauthorized},
+		{name: "Negative - foreign algorithm", bearer: forged, wantCode: http.StatusUnauthorized},
+		{name: "Negative - invalid api key", bearer: model.APIKeyPrefix + "unknown", wantCode: http.StatusUnauthorized},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/private", nil)
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "access_token", Value: tt.cookie})
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			require.Equal(t, tt.wantCode, w.Code)
+			if tt.wantCode == http.StatusOK {
+				require.Equal(t, "john:manager", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRequireCSRF(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(RequireCSRF())
+	r.Any("/items", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	cases := []struct {
+		name     string
+		method   string
+		cookie   string
+		header   string
+		authz    string
+		apiKey   string
+		wantCode int
+	}{
+		{name: "Positive - safe method without token", method: http.MethodGet, wantCode: http.StatusOK},
+		{name: "Positive - matching cookie and header", method: http.MethodPost, cookie: "tok", header: "tok", wantCode: http.StatusOK},
+		{name: "Positive - bearer is exempt", method: http.MethodPatch, authz: "Bearer jwt", wantCode: http.StatusOK},
+		{name: "Positive - api key is exempt", method: http.MethodDelete, apiKey: "whc_key", wantCode: http.StatusOK},
+		{name: "Negative - no header", method: http.MethodPut, cookie: "tok", wantCode: http.StatusForbidden},
+		{name: "Negative - no cookie", method: http.MethodPost, header: "tok", wantCode: http.StatusForbidden},
+		{name: "Negative - empty cookie and header", method: http.MethodPost, cookie: "", header: "", wantCode: http.StatusForbidden},
+		{name: "Negative - mismatch", method: http.MethodDelete, cookie: "tok", header: "other", wantCode: http.StatusForbidden},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/items", nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: CSRFCookie, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				req.Header.Set(CSRFHeader, tt.header)
+			}
+			if tt.authz != "" {
+				req.Header.Set("Authorization", tt.authz)
+			}
+			if tt.apiKey != "" {
+				req.Header.Set("X-API-Key", tt.apiKey)
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			require.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
+
+// idempotencyStub - хранилище ключей в памяти с той же логикой сверки, что и у сервиса
+type idempotencyStub struct {
+	records map[string]*model.IdempotencyRecord
+}
+
+func (s *idempotencyStub) ClaimIdempotencyKey(ctx context.Context, rec *model.IdempotencyRecord) (*model.IdempotencyRecord, error) {
+	saved, ok := s.records[rec.Owner+"/"+rec.Key]
+	switch {
+	case !ok:
+		s.records[rec.Owner+"/"+rec.Key] = &model.IdempotencyRecord{Owner: rec.Owner, Key: rec.Key, RequestHash: rec.RequestHash}
+		return nil, nil
+	case saved.RequestHash != rec.RequestHash:
+		return nil, model.ErrIdempotencyKeyReused
+	case saved.StatusCode == 0:
+		return nil, model.ErrIdempotencyInProgress
+	default:
+		return saved, nil
+	}
+}
+
+func (s *idempotencyStub) SaveIdempotentResponse(ctx context.Context, rec *model.IdempotencyRecord) {
+	s.records[rec.Owner+"/"+rec.Key] = rec
+}
+
+func (s *idempotencyStub) ReleaseIdempotencyKey(ctx context.Context, owner, key string) {
+	delete(s.records, owner+"/"+key)
+}
+
+func TestIdempotency(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := &idempotencyStub{records: map[string]*model.IdempotencyRecord{}}
+
+	created, failures := 0, 0
+	r := gin.New()
+	withUser := func(c *gin.Context) {
+		kind, name, _ := strings.Cut(c.GetHeader("X-User"), ":")
+		c.Set("auth_kind", kind)
+		c.Set("username", name)
+	}
+	r.POST("/items", withUser, Idempotency(store), func(c *gin.Context) {
+		created++
+		c.JSON(http.StatusCreated, gin.H{"id": created})
+	})
+	r.POST("/flaky", withUser, Idempotency(store), func(c *gin.Context) {
+		failures++
+		if failures == 1 {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db is down"})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	send := func(path, user, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("X-User", user)
+		if key != "" {
+			req.Header.Set(IdempotencyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// первый запрос выполняется, повтор получает тот же ответ без второго создания
+	first := send("/items", "apikey:scanner", "k-1", `{"title":"box"}`)
+	require.Equal(t, http.StatusCreated, first.Code)
+	replay := send("/items", "apikey:scanner", "k-1", `{"title":"box"}`)
+	require.Equal(t, http.StatusCreated, replay.Code)
+	require.Equal(t, first.Body.String(), replay.Body.String())
+	require.Equal(t, "true", replay.Header().Get(ReplayedHeader))
+	require.Equal(t, 1, created)
+
+	// тот же ключ с другим телом - конфликт
+	require.Equal(t, http.StatusConflict, send("/items", "apikey:scanner", "k-1", `{"title":"crate"}`).Code)
+	require.Equal(t, 1, created)
+
+	// ключи разных пользователей не пересекаются, без ключа запрос не защищен
+	require.Equal(t, http.StatusCreated, send("/items", "user:clerk", "k-1", `{"title":"box"}`).Code)
+	require.Equal(t, http.StatusCreated, send("/items", "apikey:scanner", "", `{"title":"box"}`).Code)
+	require.Equal(t, 3, created)
+
+	// пользователь и API-ключ с одинаковым именем тоже не делят ключи
+	require.Equal(t, http.StatusCreated, send("/items", "user:scanner", "k-1", `{"title":"crate"}`).Code)
+	require.Equal(t, 4, created)
+	require.Contains(t, store.records, "apikey:scanner/k-1")
+	require.Contains(t, store.records, "user:scanner/k-1")
+
+	// ответ 5xx не сохраняется - повтор с тем же ключом выполняется заново
+	require.Equal(t, http.StatusInternalServerError, send("/flaky", "apikey:scanner", "k-2", `{}`).Code)
+	require.Equal(t, http.StatusNoContent, send("/flaky", "apikey:scanner", "k-2", `{}`).Code)
+	require.Equal(t, http.StatusNoContent, send("/flaky", "apikey:scanner", "k-2", `{}`).Code)
+	require.Equal(t, 2, failures)
+
+	require.Equal(t, http.StatusBadRequest, send("/items", "apikey:scanner", strings.Repeat("k", 256), `{}`).Code)
+}
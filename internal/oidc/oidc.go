@@ -0,0 +1,336 @@
+// Package oidc provides a minimal OpenID Connect client: authorization code flow with PKCE and ID token verification
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	DefaultGroupsClaim   = "groups"
+	DefaultUsernameClaim = "preferred_username"
+
+	// jwksRefreshInterval - не чаще этого перечитываем JWKS провайдера при встрече незнакомого kid
+	jwksRefreshInterval = time.Minute
+)
+
+// Config - параметры клиента, зарегистрированного у провайдера
+type Config struct {
+	IssuerURL     string
+	ClientID      string
+	ClientSecret  string
+	RedirectURL   string
+	GroupsClaim   string // claim ID-токена со списком групп
+	UsernameClaim string // claim ID-токена с логином пользователя
+	HTTPClient    *http.Client
+}
+
+// providerMetadata - нужная нам часть /.well-known/openid-configuration
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Client - OIDC-клиент; метаданные и ключи провайдера загружаются лениво и кэшируются,
+// чтобы недоступный при старте IdP не мешал запуску сервиса
+type Client struct {
+	cfg Config
+
+	mu          sync.Mutex
+	meta        *providerMetadata
+	keys        map[string]any
+	keysFetched time.Time
+}
+
+func NewClient(cfg Config) *Client {
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = DefaultGroupsClaim
+	}
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = DefaultUsernameClaim
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Client{cfg: cfg}
+}
+
+// AuthCodeURL возвращает адрес страницы логина IdP; в запрос уходит только S256-хэш verifier'а
+func (c *Client) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	meta, err := c.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", c.cfg.ClientID)
+	q.Set("redirect_uri", c.cfg.RedirectURL)
+	q.Set("scope", "openid profile email")
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", S256Challenge(verifier))
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange обменивает код авторизации на ID-токен, проверяет его и достает из него пользователя
+func (c *Client) Exchange(ctx context.Context, code, verifier, nonce string) (*model.OIDCIdentity, error) {
+	meta, err := c.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.cfg.RedirectURL)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(c.cfg.ClientID), url.QueryEscape(c.cfg.ClientSecret))
+
+	var tokenResp struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := c.doJSON(req, &tokenResp); err != nil {
+		return nil, fmt.Errorf("token endpoint: %w", err)
+	}
+	if tokenResp.IDToken == "" {
+		return nil, errors.New("token endpoint returned no id_token")
+	}
+
+	return c.verifyIDToken(ctx, meta, tokenResp.IDToken, nonce)
+}
+
+func (c *Client) verifyIDToken(ctx context.Context, meta *providerMetadata, raw, nonce string) (*model.OIDCIdentity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims,
+		func(t *jwt.Token) (any, error) {
+			kid, _ := t.Header["kid"].(string)
+			return c.key(ctx, meta, kid)
+		},
+		jwt.WithIssuer(meta.Issuer),
+		jwt.WithAudience(c.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+
+	// nonce связывает ID-токен с конкретной попыткой логина в этом браузере
+	if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+		return nil, errors.New("id_token nonce mismatch")
+	}
+
+	identity := &model.OIDCIdentity{}
+	identity.Subject, _ = claims["sub"].(string)
+	if identity.Subject == "" {
+		return nil, errors.New("id_token has no subject")
+	}
+
+	identity.Username, _ = claims[c.cfg.UsernameClaim].(string)
+	if identity.Username == "" {
+		identity.Username, _ = claims["email"].(string)
+	}
+	if identity.Username == "" {
+		return nil, fmt.Errorf("id_token has no %q claim", c.cfg.UsernameClaim)
+	}
+
+	// группы бывают как массивом, так и одной строкой
+	switch groups := claims[c.cfg.GroupsClaim].(type) {
+	case string:
+		identity.Groups = []string{groups}
+	case []any:
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				identity.Groups = append(identity.Groups, s)
+			}
+		}
+	}
+
+	return identity, nil
+}
+
+func (c *Client) metadata(ctx context.Context) (*providerMetadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.meta != nil {
+		return c.meta, nil
+	}
+
+	wellKnown := strings.TrimSuffix(c.cfg.IssuerURL, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var meta providerMetadata
+	if err := c.doJSON(req, &meta); err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+
+	// issuer из discovery обязан совпадать с настроенным - иначе токены подписывает кто-то другой
+	if strings.TrimSuffix(meta.Issuer, "/") != strings.TrimSuffix(c.cfg.IssuerURL, "/") {
+		return nil, fmt.Errorf("discovery: issuer %q does not match configured %q", meta.Issuer, c.cfg.IssuerURL)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, errors.New("discovery: incomplete provider metadata")
+	}
+
+	c.meta = &meta
+	return c.meta, nil
+}
+
+// key возвращает ключ провайдера по kid; незнакомый kid - повод перечитать JWKS (ротация на стороне IdP)
+func (c *Client) key(ctx context.Context, meta *providerMetadata, kid string) (any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(c.keysFetched) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, meta.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var jwks model.JWKS
+	if err := c.doJSON(req, &jwks); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+
+	keys := make(map[string]any, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		key, err := parseJWK(jwk)
+		if err != nil {
+			continue // ключи неподдерживаемых типов просто пропускаем
+		}
+		keys[jwk.Kid] = key
+	}
+	c.keys = keys
+	c.keysFetched = time.Now()
+
+	key, ok := c.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+func (c *Client) doJSON(req *http.Request, dst any) error {
+	resp, err := c.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(dst)
+}
+
+func parseJWK(jwk model.JWK) (any, error) {
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+}
+
+// S256Challenge - PKCE code_challenge для code_verifier (RFC 7636)
+func S256Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// ParseGroupRoles разбирает сопоставление групп IdP ролям вида "wh-admins:admin,wh-staff:manager".
+// Порядок важен: пользователю из нескольких групп достается роль первой подходящей
+func ParseGroupRoles(raw string) ([]model.GroupRole, error) {
+	var res []model.GroupRole
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		group, role, ok := strings.Cut(pair, ":")
+		group, role = strings.TrimSpace(group), strings.TrimSpace(role)
+		if !ok || group == "" || role == "" {
+			return nil, fmt.Errorf("invalid group to role mapping %q: expected group:role", pair)
+		}
+		res = append(res, model.GroupRole{Group: group, Role: role})
+	}
+	return res, nil
+}
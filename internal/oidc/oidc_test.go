@@ -0,0 +1,308 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testClientID     = "warehouse"
+	testClientSecret = "client-secret"
+	testRedirectURL  = "http://localhost:8080/auth/oidc/callback"
+)
+
+// mockProvider - минимальный OIDC-провайдер: discovery, authorize, token и jwks
+type mockProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+
+	mu    sync.Mutex
+	codes map[string]authRequest
+
+	// что положить в ID-токен - тест может испортить отдельные claims
+	claims func(req authRequest) jwt.MapClaims
+}
+
+type authRequest struct {
+	challenge string
+	nonce     string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	p := &mockProvider{t: t, key: key, kid: "idp-key-1", codes: map[string]authRequest{}}
+	p.claims = func(req authRequest) jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":                p.server.URL,
+			"aud":                testClientID,
+			"sub":                "idp-user-42",
+			"exp":                time.Now().Add(time.Minute).Unix(),
+			"iat":                time.Now().Unix(),
+			"nonce":              req.nonce,
+			"preferred_username": "Jane.Doe",
+			"groups":             []string{"everyone", "wh-managers"},
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, model.JWKS{Keys: []model.JWK{{
+			Kty: "RSA",
+			Kid: p.kid,
+			Use: "sig",
+			Alg: "RS256",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+// authorize сразу "логинит" пользователя и возвращает браузер на redirect_uri с кодом
+func (p *mockProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != testClientID || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "bad authorize request", http.StatusBadRequest)
+		return
+	}
+
+	code := "code-" + q.Get("state")
+	p.mu.Lock()
+	p.codes[code] = authRequest{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	p.mu.Unlock()
+
+	http.Redirect(w, r, q.Get("redirect_uri")+"?code="+code+"&state="+url.QueryEscape(q.Get("state")), http.StatusFound)
+}
+
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != testClientID || secret != testClientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+
+	p.mu.Lock()
+	req, ok := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code")) // код одноразовый
+	p.mu.Unlock()
+
+	// PKCE: verifier из запроса должен соответствовать challenge из authorize
+	if !ok || S256Challenge(r.PostFormValue("code_verifier")) != req.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, p.claims(req))
+	token.Header["kid"] = p.kid
+	idToken, err := token.SignedString(p.key)
+	require.NoError(p.t, err)
+
+	writeJSON(w, map[string]string{"access_token": "access", "token_type": "Bearer", "id_token": idToken})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// login проходит authorize на мок-провайдере и возвращает код из redirect'а
+func login(t *testing.T, c *Client, state, nonce, verifier string) string {
+	authURL, err := c.AuthCodeURL(context.Background(), state, nonce, verifier)
+	require.NoError(t, err)
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noRedirect.Get(authURL)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	require.Equal(t, state, callback.Query().Get("state"))
+	return callback.Query().Get("code")
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	cases := []struct {
+		name        string
+		tamper      func(p *mockProvider)
+		verifier    string // verifier, отправляемый на token endpoint
+		nonce       string // nonce, ожидаемый при проверке ID-токена
+		secret      string
+		wantErr     bool
+		wantGroups  []string
+		wantSubject string
+	}{
+		{
+			name:        "Positive - identity with groups",
+			wantGroups:  []string{"everyone", "wh-managers"},
+			wantSubject: "idp-user-42",
+		},
+		{
+			name: "Positive - single group as string",
+			tamper: func(p *mockProvider) {
+				base := p.claims
+				p.claims = func(req authRequest) jwt.MapClaims {
+					c := base(req)
+					c["groups"] = "wh-admins"
+					return c
+				}
+			},
+			wantGroups:  []string{"wh-admins"},
+			wantSubject: "idp-user-42",
+		},
+		{
+			name:     "Negative - PKCE verifier mismatch",
+			verifier: "another-verifier",
+			wantErr:  true,
+		},
+		{
+			name:    "Negative - nonce mismatch",
+			nonce:   "another-nonce",
+			wantErr: true,
+		},
+		{
+			name:    "Negative - wrong client secret",
+			secret:  "wrong",
+			wantErr: true,
+		},
+		{
+			name: "Negative - id_token for another client",
+			tamper: func(p *mockProvider) {
+				base := p.claims
+				p.claims = func(req authRequest) jwt.MapClaims {
+					c := base(req)
+					c["aud"] = "another-client"
+					return c
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "Negative - expired id_token",
+			tamper: func(p *mockProvider) {
+				base := p.claims
+				p.claims = func(req authRequest) jwt.MapClaims {
+					c := base(req)
+					c["exp"] = time.Now().Add(-time.Minute).Unix()
+					return c
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "Negative - id_token signed by unknown key",
+			tamper: func(p *mockProvider) {
+				other, err := rsa.GenerateKey(rand.Reader, 2048)
+				require.NoError(t, err)
+				p.key = other
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newMockProvider(t)
+			secret := testClientSecret
+			if tt.secret != "" {
+				secret = tt.secret
+			}
+			c := NewClient(Config{
+				IssuerURL:    p.server.URL,
+				ClientID:     testClientID,
+				ClientSecret: secret,
+				RedirectURL:  testRedirectURL,
+			})
+
+			// ключи запрашиваются до порчи - так проверяется, что чужая подпись не проходит по кэшу
+			if tt.tamper != nil {
+				_, err := c.key(context.Background(), mustMeta(t, c), p.kid)
+				require.NoError(t, err)
+				tt.tamper(p)
+			}
+
+			code := login(t, c, "state-1", "nonce-1", "verifier-1")
+
+			verifier, nonce := "verifier-1", "nonce-1"
+			if tt.verifier != "" {
+				verifier = tt.verifier
+			}
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+
+			identity, err := c.Exchange(context.Background(), code, verifier, nonce)
+
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantSubject, identity.Subject)
+			require.Equal(t, "Jane.Doe", identity.Username)
+			require.Equal(t, tt.wantGroups, identity.Groups)
+		})
+	}
+}
+
+func mustMeta(t *testing.T, c *Client) *providerMetadata {
+	meta, err := c.metadata(context.Background())
+	require.NoError(t, err)
+	return meta
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	p := newMockProvider(t)
+	c := NewClient(Config{IssuerURL: p.server.URL + "/realms/other", ClientID: testClientID})
+
+	_, err := c.AuthCodeURL(context.Background(), "s", "n", "v")
+	require.Error(t, err)
+}
+
+func TestParseGroupRoles(t *testing.T) {
+	res, err := ParseGroupRoles(" wh-admins:admin, wh-staff : manager ,")
+	require.NoError(t, err)
+	require.Equal(t, []model.GroupRole{{Group: "wh-admins", Role: "admin"}, {Group: "wh-staff", Role: "manager"}}, res)
+
+	res, err = ParseGroupRoles("")
+	require.NoError(t, err)
+	require.Empty(t, res)
+
+	_, err = ParseGroupRoles("wh-admins")
+	require.Error(t, err)
+
+	_, err = ParseGroupRoles("wh-admins:")
+	require.Error(t, err)
+}
@@ -1,48 +1,192 @@
 // Package policy provides methods to check access to actions based on the provided role
 package policy
 
-import "github.com/UnendingLoop/WarehouseControl/internal/model"
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
-type PolicyChecker struct{}
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+	"gopkg.in/yaml.v3"
+)
 
-func (pc PolicyChecker) AccessToDelete(role string) bool {
-	return role == model.RoleAdmin
+//go:embed default.yaml
+var defaultPolicy []byte
+
+// policyFile - формат файла политики (yaml или json)
+type policyFile struct {
+	Roles map[string][]string `json:"roles" yaml:"roles"`
 }
 
-func (pc PolicyChecker) AccessToCreate(role string) bool {
-	if role == model.RoleManager || role == model.RoleAdmin {
-		return true
+// PolicyChecker хранит матрицу "роль -> разрешения" и умеет перечитывать ее на лету
+type PolicyChecker struct {
+	mu      sync.RWMutex
+	roles   map[string]map[string]struct{}
+	path    string // пустой путь - используется встроенная политика по умолчанию
+	modTime time.Time
+}
+
+// NewPolicyChecker загружает политику из файла path, либо встроенную политику, если path пустой
+func NewPolicyChecker(path string) (*PolicyChecker, error) {
+	pc := &PolicyChecker{path: path}
+	if err := pc.Reload(); err != nil {
+		return nil, err
 	}
-	return false
+	return pc, nil
 }
 
-func (pc PolicyChecker) AccessToUpdate(role string) bool {
-	if role == model.RoleManager || role == model.RoleAdmin {
+func (pc *PolicyChecker) Can(role string, permission string) bool {
+	pc.mu.RLock()
+	defer pc.mu.RUnlock()
+
+	perms, ok := pc.roles[role]
+	if !ok {
+		return false
+	}
+	if _, ok := perms[model.PermAll]; ok {
 		return true
 	}
-	return false
+	_, ok = perms[permission]
+	return ok
 }
 
-func (pc PolicyChecker) AccessToGetHistory(role string) bool {
-	if role == model.RoleAuditor || role == model.RoleAdmin {
-		return true
+func (pc *PolicyChecker) IsCorrectRole(role string) bool {
+	pc.mu.RLock()
+	defer pc.mu.RUnlock()
+
+	_, exists := pc.roles[role]
+	return exists
+}
+
+// Roles возвращает копию текущей матрицы прав
+func (pc *PolicyChecker) Roles() map[string][]string {
+	pc.mu.RLock()
+	defer pc.mu.RUnlock()
+
+	result := make(map[string][]string, len(pc.roles))
+	for role, perms := range pc.roles {
+		list := make([]string, 0, len(perms))
+		for p := range perms {
+			list = append(list, p)
+		}
+		slices.Sort(list)
+		result[role] = list
 	}
-	return false
+	return result
 }
 
-func (pc PolicyChecker) AccessToGetItems(role string) bool {
-	_, ok := model.RolesMap[role]
-	return ok
+// Reload перечитывает политику. При ошибке продолжает действовать прежняя матрица
+func (pc *PolicyChecker) Reload() error {
+	raw := defaultPolicy
+	ext := ".yaml"
+	var modTime time.Time
+
+	if pc.path != "" {
+		info, err := os.Stat(pc.path)
+		if err != nil {
+			return err
+		}
+		raw, err = os.ReadFile(pc.path)
+		if err != nil {
+			return err
+		}
+		ext = strings.ToLower(filepath.Ext(pc.path))
+		modTime = info.ModTime()
+	}
+
+	roles, err := parsePolicy(raw, ext)
+	if err != nil {
+		return err
+	}
+
+	pc.mu.Lock()
+	pc.roles = roles
+	pc.modTime = modTime
+	pc.mu.Unlock()
+
+	return nil
 }
 
-func (pc PolicyChecker) AccessToSeeDeleted(role string) bool {
-	if role == model.RoleAuditor || role == model.RoleAdmin {
-		return true
+// Watch раз в interval проверяет дату изменения файла политики и перечитывает его при изменении
+func (pc *PolicyChecker) Watch(ctx context.Context, interval time.Duration) {
+	if pc.path == "" || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(pc.path)
+			if err != nil {
+				log.Printf("Failed to stat policy file %q: %v", pc.path, err)
+				continue
+			}
+
+			pc.mu.RLock()
+			changed := !info.ModTime().Equal(pc.modTime)
+			pc.mu.RUnlock()
+
+			if !changed {
+				continue
+			}
+			if err := pc.Reload(); err != nil {
+				log.Printf("Failed to reload policy file %q, keeping previous policy: %v", pc.path, err)
+				continue
+			}
+			log.Printf("Policy file %q reloaded", pc.path)
+		}
 	}
-	return false
 }
 
-func (pc PolicyChecker) IsCorrectRole(role string) bool {
-	_, exists := model.RolesMap[role]
-	return exists
+func parsePolicy(raw []byte, ext string) (map[string]map[string]struct{}, error) {
+	var pf policyFile
+
+	switch ext {
+	case ".json":
+		if err := json.Unmarshal(raw, &pf); err != nil {
+			return nil, fmt.Errorf("invalid policy json: %w", err)
+		}
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(raw, &pf); err != nil {
+			return nil, fmt.Errorf("invalid policy yaml: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported policy file extension %q", ext)
+	}
+
+	if len(pf.Roles) == 0 {
+		return nil, fmt.Errorf("policy defines no roles")
+	}
+
+	// опечатка в названии разрешения молча лишила бы роль доступа - такие файлы отклоняем
+	roles := make(map[string]map[string]struct{}, len(pf.Roles))
+	for role, perms := range pf.Roles {
+		role = strings.TrimSpace(role)
+		if role == "" {
+			return nil, fmt.Errorf("policy contains empty role name")
+		}
+		set := make(map[string]struct{}, len(perms))
+		for _, p := range perms {
+			if _, ok := model.PermissionsMap[p]; !ok && p != model.PermAll {
+				return nil, fmt.Errorf("unknown permission %q for role %q", p, role)
+			}
+			set[p] = struct{}{}
+		}
+		roles[role] = set
+	}
+
+	return roles, nil
 }
@@ -0,0 +1,56 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultPolicy(t *testing.T) {
+	pc, err := NewPolicyChecker("")
+	require.NoError(t, err)
+
+	cases := []struct {
+		name       string
+		role       string
+		permission string
+		want       bool
+	}{
+		{name: "admin has everything", role: model.RoleAdmin, permission: model.PermPolicyManage, want: true},
+		{name: "manager can delete (README)", role: model.RoleManager, permission: model.PermItemsDelete, want: true},
+		{name: "manager cannot read history", role: model.RoleManager, permission: model.PermHistoryRead, want: false},
+		{name: "auditor can export history", role: model.RoleAuditor, permission: model.PermHistoryExport, want: true},
+		{name: "auditor can read auth log", role: model.RoleAuditor, permission: model.PermAuditRead, want: true},
+		{name: "manager cannot read auth log", role: model.RoleManager, permission: model.PermAuditRead, want: false},
+		{name: "viewer cannot update", role: model.RoleViewer, permission: model.PermItemsUpdate, want: false},
+		{name: "unknown role has nothing", role: "intern", permission: model.PermItemsRead, want: false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, pc.Can(tt.role, tt.permission))
+		})
+	}
+}
+
+func TestReloadPolicyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"roles": {"viewer": ["items.read"]}}`), 0o600))
+
+	pc, err := NewPolicyChecker(path)
+	require.NoError(t, err)
+	require.False(t, pc.IsCorrectRole("storekeeper"))
+
+	// кастомная роль появляется после перечитывания файла
+	require.NoError(t, os.WriteFile(path, []byte(`{"roles": {"viewer": ["items.read"], "storekeeper": ["items.read", "items.update"]}}`), 0o600))
+	require.NoError(t, pc.Reload())
+	require.True(t, pc.Can("storekeeper", model.PermItemsUpdate))
+
+	// битый файл отклоняется, прежняя политика продолжает действовать
+	require.NoError(t, os.WriteFile(path, []byte(`{"roles": {"viewer": ["items.raed"]}}`), 0o600))
+	require.Error(t, pc.Reload())
+	require.True(t, pc.Can("storekeeper", model.PermItemsUpdate))
+}
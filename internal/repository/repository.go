@@ -4,6 +4,7 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"log"
 	"path/filepath"
 	"time"
@@ -19,16 +20,89 @@ import (
 
 type WHCRepo interface {
 	CreateUser(ctx context.Context, newUser *model.User) error
+	CreateUserWithInvite(ctx context.Context, newUser *model.User, tokenHash string) error
+	UpsertOIDCUser(ctx context.Context, user *model.User, subject string) error
 	GetUserByName(ctx context.Context, user string) (*model.User, error)
+	GetUsersList(ctx context.Context, rpu *model.RequestParam) ([]*model.User, error)
+	GetUserSessionState(ctx context.Context, userID int, role string, jti string, issuedAt time.Time) (*model.SessionState, error)
+	UpdateUserRole(ctx context.Context, userID int, role string, updatedBy string) error
+	SetUserDisabled(ctx context.Context, userID int, disabled bool, updatedBy string) error
+	UpdateUserPassword(ctx context.Context, userID int, passHash string, updatedBy string) error
+	DeleteUser(ctx context.Context, userID int, deletedBy string) error
+	GetUserHistory(ctx context.Context, rph *model.RequestParam, userID int) ([]*model.UserHistory, error)
+	GetUserRoles(ctx context.Context, userID int) ([]string, error)
+	GrantUserRole(ctx context.Context, userID int, role string, grantedBy string) error
+	RevokeUserRole(ctx context.Context, userID int, role string, revokedBy string) error
+
+	GetUserTOTP(ctx context.Context, userID int) (*model.TOTPState, error)
+	SaveTOTPSecret(ctx context.Context, userID int, secret string) error
+	ConfirmTOTP(ctx context.Context, userID int, step int64, recoveryHashes []string) error
+	UseTOTPStep(ctx context.Context, userID int, step int64) error
+	UseRecoveryCode(ctx context.Context, userID int, codeHash string) error
+	DeleteUserTOTP(ctx context.Context, userID int) error
+	CreateLoginChallenge(ctx context.Context, userID int, role, tokenHash string, expiresAt time.Time) error
+	GetLoginChallenge(ctx context.Context, tokenHash string) (*model.LoginChallenge, error)
+	FailLoginChallenge(ctx context.Context, challengeID int) error
+	CompleteLoginChallenge(ctx context.Context, challengeID int) error
+
+	RecordLoginAttempt(ctx context.Context, attempt *model.LoginAttempt) error
+	GetLoginFailures(ctx context.Context, username, ip string, window time.Duration) (*model.LoginFailures, error)
+	GetLoginAttempts(ctx context.Context, rp *model.RequestParam, filter *model.LoginAttemptFilter) ([]*model.LoginAttempt, error)
+
+	CreateAuthEvent(ctx context.Context, event *model.AuthEvent) error
+	GetAuthEvents(ctx context.Context, rp *model.RequestParam, filter *model.AuthEventFilter) ([]*model.AuthEvent, error)
+
+	ClaimIdempotencyKey(ctx context.Context, rec *model.IdempotencyRecord) (*model.IdempotencyRecord, error)
+	SaveIdempotentResponse(ctx context.Context, rec *model.IdempotencyRecord) error
+	DeleteIdempotencyKey(ctx context.Context, owner, key string) error
+
+	CreateRefreshToken(ctx context.Context, userID int, role string, tokenHash string, expiresAt time.Time) error
+	RotateRefreshToken(ctx context.Context, oldHash, newHash string, expiresAt time.Time) (*model.User, error)
+	RevokeRefreshToken(ctx context.Context, tokenHash string) error
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	RevokeUserSessions(ctx context.Context, userID int, revokedBy string) error
+
+	CreateInvite(ctx context.Context, invite *model.Invite, tokenHash string) error
+	GetInvitesList(ctx context.Context) ([]*model.Invite, error)
+	RevokeInvite(ctx context.Context, inviteID int) error
+
+	CreateAPIKey(ctx context.Context, key *model.APIKey, keyHash string) error
+	GetAPIKeysList(ctx context.Context) ([]*model.APIKey, error)
+	RevokeAPIKey(ctx context.Context, keyID int) error
+	UseAPIKey(ctx context.Context, keyHash string) (*model.APIKey, error)
 
 	CreateItem(ctx context.Context, newItem *model.Item) error
-	DeleteItem(ctx context.Context, itemID int, username string) error
+	DeleteItem(ctx context.Context, itemID int, version *int, username string) error
 	UpdateItem(ctx context.Context, uItem *model.ItemUpdate, showDeleted bool) error
+	RestoreItem(ctx context.Context, itemID int, username string) error
+	PurgeItem(ctx context.Context, itemID int, username string) error
+	PurgeDeletedItems(ctx context.Context, before time.Time, actor string) ([]*model.Item, error)
 
 	GetItemByID(ctx context.Context, itemID int, showDeleted bool) (*model.Item, error)
-	GetItemsList(ctx context.Context, rpi *model.RequestParam, showDeleted bool) ([]*model.Item, error)
-	GetItemHistoryByID(ctx context.Context, rph *model.RequestParam, itemID int) ([]*model.ItemHistory, error)
-	GetItemHistoryAll(ctx context.Context, rph *model.RequestParam) ([]*model.ItemHistory, error)
+	GetItemsList(ctx context.Context, rpi *model.RequestParam, filter *model.ItemFilter, showDeleted bool) ([]*model.Item, error)
+	GetDeletedItems(ctx context.Context, rp *model.RequestParam) ([]*model.Item, error)
+	GetItemHistoryByID(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, itemID int) ([]*model.ItemHistory, error)
+	GetItemHistoryAll(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter) ([]*model.ItemHistory, error)
+	GetItemHistoryVersions(ctx context.Context, itemID, from, to int) ([]*model.ItemHistory, error)
+	GetItemSnapshot(ctx context.Context, itemID int) (*json.RawMessage, error)
+
+	CreateWarehouse(ctx context.Context, wh *model.Warehouse) error
+	GetWarehouses(ctx context.Context) ([]*model.Warehouse, error)
+	GetItemStock(ctx context.Context, itemID int) ([]*model.WarehouseStock, error)
+	GetUserWarehouses(ctx context.Context, userID int) ([]int, error)
+	GrantUserWarehouse(ctx context.Context, userID, warehouseID int, grantedBy string) error
+	RevokeUserWarehouse(ctx context.Context, userID, warehouseID int, revokedBy string) error
+
+	CreateLocation(ctx context.Context, loc *model.Location) error
+	GetLocation(ctx context.Context, locationID int) (*model.Location, error)
+	GetReceivingLocation(ctx context.Context, warehouseID int) (*model.Location, error)
+	GetLocations(ctx context.Context, warehouseID int) ([]*model.Location, error)
+	GetLocationStock(ctx context.Context, locationID int) ([]*model.LocationStock, error)
+	GetItemLocations(ctx context.Context, itemID int) ([]*model.LocationStock, error)
+	MoveItemStock(ctx context.Context, mv *model.StockMove) error
+
+	CreateMovement(ctx context.Context, m *model.Movement) ([]*model.Movement, error)
+	GetMovements(ctx context.Context, rp *model.RequestParam, filter *model.MovementFilter) ([]*model.Movement, error)
 }
 
 func NewPostgresImageRepo(dbconn *dbpg.DB) WHCRepo {
@@ -0,0 +1,105 @@
+package whcpostgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+)
+
+func (pr PostgresRepo) CreateAPIKey(ctx context.Context, key *model.APIKey, keyHash string) error {
+	query := `INSERT INTO api_keys (id, key_hash, key_prefix, name, role, expires_at, created_by, created_at)
+	VALUES (DEFAULT, $1, $2, $3, $4, $5, $6, DEFAULT) RETURNING id, created_at`
+	err := pr.DB.QueryRowContext(ctx, query,
+		keyHash,
+		key.Prefix,
+		key.Name,
+		key.Role,
+		key.ExpiresAt,
+		key.CreatedBy).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (pr PostgresRepo) GetAPIKeysList(ctx context.Context) ([]*model.APIKey, error) {
+	query := `SELECT id, key_prefix, name, role, expires_at, created_by, created_at, last_used_at, revoked_at
+	FROM api_keys
+	ORDER BY created_at DESC`
+
+	rows, err := pr.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error while closing *sql.Rows after scanning: %v", err)
+		}
+	}()
+
+	keys := make([]*model.APIKey, 0)
+
+	for rows.Next() {
+		var k model.APIKey
+		if err := rows.Scan(&k.ID,
+			&k.Prefix,
+			&k.Name,
+			&k.Role,
+			&k.ExpiresAt,
+			&k.CreatedBy,
+			&k.CreatedAt,
+			&k.LastUsedAt,
+			&k.RevokedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, &k)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return keys, nil
+}
+
+func (pr PostgresRepo) RevokeAPIKey(ctx context.Context, keyID int) error {
+	// отозванный ключ остается в таблице, чтобы по changed_by можно было понять, чей это был ключ
+	query := `UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL`
+
+	res, err := pr.DB.ExecContext(ctx, query, keyID)
+	if err != nil {
+		return err // 500
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err // 500
+	}
+	if rows == 0 {
+		return model.ErrAPIKeyNotFound // 404
+	}
+	return nil
+}
+
+// UseAPIKey находит действующий ключ по хэшу и отмечает время его использования
+func (pr PostgresRepo) UseAPIKey(ctx context.Context, keyHash string) (*model.APIKey, error) {
+	query := `UPDATE api_keys SET last_used_at = now()
+	WHERE key_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now())
+	RETURNING id, key_prefix, name, role, expires_at`
+
+	var k model.APIKey
+	err := pr.DB.QueryRowContext(ctx, query, keyHash).Scan(&k.ID, &k.Prefix, &k.Name, &k.Role, &k.ExpiresAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, model.ErrInvalidAPIKey // 401
+		default:
+			return nil, err // 500
+		}
+	}
+	return &k, nil
+}
@@ -0,0 +1,102 @@
+package whcpostgres
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+)
+
+// CreateAuthEvent пишет событие в журнал аутентификации; пустые необязательные поля сохраняются как NULL
+func (pr PostgresRepo) CreateAuthEvent(ctx context.Context, event *model.AuthEvent) error {
+	query := `INSERT INTO auth_events (id, event_type, user_id, username, role, status, detail, method, path, request_id, ip, user_agent, created_at)
+	VALUES (DEFAULT, $1, NULLIF($2, 0), NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, 0), NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), $9, $10, $11, DEFAULT)`
+	_, err := pr.DB.ExecContext(ctx, query,
+		event.Type,
+		event.UserID,
+		event.Username,
+		event.Role,
+		event.Status,
+		event.Detail,
+		event.Method,
+		event.Path,
+		event.RequestID,
+		event.IP,
+		event.UserAgent)
+	return err
+}
+
+func (pr PostgresRepo) GetAuthEvents(ctx context.Context, rp *model.RequestParam, filter *model.AuthEventFilter) ([]*model.AuthEvent, error) {
+	query := `SELECT id, event_type, COALESCE(user_id, 0), COALESCE(username, ''), COALESCE(role, ''), COALESCE(status, 0),
+		COALESCE(detail, ''), COALESCE(method, ''), COALESCE(path, ''), request_id, ip, user_agent, created_at
+	FROM auth_events
+	WHERE true`
+
+	// значения фильтров передаются только параметрами
+	var args []any
+	if filter.Type != nil {
+		args = append(args, *filter.Type)
+		query += fmt.Sprintf(" AND event_type = $%d", len(args))
+	}
+	if filter.Username != nil {
+		args = append(args, *filter.Username)
+		query += fmt.Sprintf(" AND username = $%d", len(args))
+	}
+	if filter.IP != nil {
+		args = append(args, *filter.IP)
+		query += fmt.Sprintf(" AND ip = $%d", len(args))
+	}
+	if filter.RequestID != nil {
+		args = append(args, *filter.RequestID)
+		query += fmt.Sprintf(" AND request_id = $%d", len(args))
+	}
+
+	// добавляем ограничение по времени
+	periodExpr := definePeriodExpr(rp.StartTime, rp.EndTime, "AND", "created_at")
+
+	// применяем лимит и оффсет
+	limofExpr := defineLimitOffsetExpr(rp.Limit, rp.Page)
+
+	// собираем конечный квери - свежие события первыми
+	query = query + periodExpr + " ORDER BY created_at DESC, id DESC " + limofExpr
+
+	rows, err := pr.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error while closing *sql.Rows after scanning: %v", err)
+		}
+	}()
+
+	events := make([]*model.AuthEvent, 0)
+
+	for rows.Next() {
+		var e model.AuthEvent
+		if err := rows.Scan(&e.ID,
+			&e.Type,
+			&e.UserID,
+			&e.Username,
+			&e.Role,
+			&e.Status,
+			&e.Detail,
+			&e.Method,
+			&e.Path,
+			&e.RequestID,
+			&e.IP,
+			&e.UserAgent,
+			&e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, &e)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return events, nil
+}
@@ -0,0 +1,73 @@
+package whcpostgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+)
+
+// ClaimIdempotencyKey закрепляет ключ за запросом. Если ключ уже занят, возвращает его запись -
+// одновременный повтор ждет на уникальном индексе, пока первый запрос не закоммитит свою
+func (pr PostgresRepo) ClaimIdempotencyKey(ctx context.Context, rec *model.IdempotencyRecord) (*model.IdempotencyRecord, error) {
+	var saved *model.IdempotencyRecord
+
+	err := pr.DB.WithTx(ctx, func(tx *sql.Tx) error {
+		// заодно чистим истекшие ключи - повтор по ним уже считается новым запросом
+		if _, err := tx.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at < now()`); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, `INSERT INTO idempotency_keys (owner, key, request_hash, created_at, expires_at)
+		VALUES ($1, $2, $3, DEFAULT, $4)
+		ON CONFLICT (owner, key) DO NOTHING`, rec.Owner, rec.Key, rec.RequestHash, rec.ExpiresAt)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 1 {
+			return nil // ключ свободен - запрос выполняется впервые
+		}
+
+		saved = &model.IdempotencyRecord{Owner: rec.Owner, Key: rec.Key}
+		var status sql.NullInt64
+		var contentType sql.NullString
+		err = tx.QueryRowContext(ctx, `SELECT request_hash, status_code, content_type, body, created_at, expires_at
+		FROM idempotency_keys
+		WHERE owner = $1 AND key = $2`, rec.Owner, rec.Key).Scan(
+			&saved.RequestHash,
+			&status,
+			&contentType,
+			&saved.Body,
+			&saved.CreatedAt,
+			&saved.ExpiresAt)
+		if err != nil {
+			return err
+		}
+		saved.StatusCode = int(status.Int64)
+		saved.ContentType = contentType.String
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return saved, nil
+}
+
+func (pr PostgresRepo) SaveIdempotentResponse(ctx context.Context, rec *model.IdempotencyRecord) error {
+	query := `UPDATE idempotency_keys SET status_code = $3, content_type = $4, body = $5
+	WHERE owner = $1 AND key = $2`
+
+	_, err := pr.DB.ExecContext(ctx, query, rec.Owner, rec.Key, rec.StatusCode, rec.ContentType, rec.Body)
+	return err
+}
+
+// DeleteIdempotencyKey освобождает ключ, ответ по которому сохранять не нужно
+func (pr PostgresRepo) DeleteIdempotencyKey(ctx context.Context, owner, key string) error {
+	_, err := pr.DB.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE owner = $1 AND key = $2`, owner, key)
+	return err
+}
@@ -0,0 +1,111 @@
+package whcpostgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+)
+
+func (pr PostgresRepo) CreateInvite(ctx context.Context, invite *model.Invite, tokenHash string) error {
+	query := `INSERT INTO invites (id, token_hash, role, expires_at, created_by, created_at)
+	VALUES (DEFAULT, $1, $2, $3, $4, DEFAULT) RETURNING id, created_at`
+	err := pr.DB.QueryRowContext(ctx, query,
+		tokenHash,
+		invite.Role,
+		invite.ExpiresAt,
+		invite.CreatedBy).Scan(&invite.ID, &invite.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (pr PostgresRepo) GetInvitesList(ctx context.Context) ([]*model.Invite, error) {
+	query := `SELECT id, role, expires_at, created_by, created_at, used_at, used_by
+	FROM invites
+	ORDER BY created_at DESC`
+
+	rows, err := pr.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error while closing *sql.Rows after scanning: %v", err)
+		}
+	}()
+
+	invites := make([]*model.Invite, 0)
+
+	for rows.Next() {
+		var inv model.Invite
+		if err := rows.Scan(&inv.ID,
+			&inv.Role,
+			&inv.ExpiresAt,
+			&inv.CreatedBy,
+			&inv.CreatedAt,
+			&inv.UsedAt,
+			&inv.UsedBy); err != nil {
+			return nil, err
+		}
+		invites = append(invites, &inv)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return invites, nil
+}
+
+func (pr PostgresRepo) RevokeInvite(ctx context.Context, inviteID int) error {
+	// использованный инвайт остается в таблице как след регистрации
+	query := `DELETE FROM invites WHERE id = $1 AND used_at IS NULL`
+
+	res, err := pr.DB.ExecContext(ctx, query, inviteID)
+	if err != nil {
+		return err // 500
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err // 500
+	}
+	if rows == 0 {
+		return model.ErrInviteNotFound // 404
+	}
+	return nil
+}
+
+// CreateUserWithInvite в одной транзакции гасит инвайт и создает пользователя с ролью из инвайта -
+// если создание пользователя не удалось, инвайт остается неиспользованным
+func (pr PostgresRepo) CreateUserWithInvite(ctx context.Context, newUser *model.User, tokenHash string) error {
+	return pr.DB.WithTx(ctx, func(tx *sql.Tx) error {
+		query := `UPDATE invites SET used_at = now(), used_by = $2
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()
+		RETURNING role`
+
+		err := tx.QueryRowContext(ctx, query, tokenHash, newUser.UserName).Scan(&newUser.Role)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return model.ErrInvalidInvite
+			default:
+				return err // 500
+			}
+		}
+
+		query = `INSERT INTO users (id, username, role, pass_hash, created_at, updated_by)
+		VALUES (DEFAULT, $1, $2, $3, DEFAULT, $1) RETURNING id, created_at`
+		return tx.QueryRowContext(ctx, query,
+			newUser.UserName,
+			newUser.Role,
+			newUser.PassHash).Scan(
+			&newUser.ID,
+			&newUser.CreatedAt)
+	})
+}
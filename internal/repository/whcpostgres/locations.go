@@ -0,0 +1,250 @@
+package whcpostgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+)
+
+func (pr PostgresRepo) CreateLocation(ctx context.Context, loc *model.Location) error {
+	// вставка через SELECT: несуществующий склад дает пустой результат вместо ошибки внешнего ключа
+	query := `INSERT INTO locations (warehouse_id, parent_id, kind, code, updated_by)
+	SELECT id, $2::INT, $3, $4, $5 FROM warehouses WHERE id = $1
+	RETURNING id, created_at`
+	err := pr.DB.QueryRowContext(ctx, query,
+		loc.WarehouseID,
+		loc.ParentID,
+		loc.Kind,
+		loc.Code,
+		loc.UpdatedBy).Scan(&loc.ID, &loc.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return model.ErrWarehouseNotFound // 404
+		default:
+			return err // 500
+		}
+	}
+	return nil
+}
+
+func (pr PostgresRepo) GetLocation(ctx context.Context, locationID int) (*model.Location, error) {
+	query := `SELECT id, warehouse_id, parent_id, kind, code, created_at
+	FROM locations
+	WHERE id = $1`
+
+	var loc model.Location
+	err := pr.DB.QueryRowContext(ctx, query, locationID).Scan(
+		&loc.ID,
+		&loc.WarehouseID,
+		&loc.ParentID,
+		&loc.Kind,
+		&loc.Code,
+		&loc.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, model.ErrLocationNotFound // 404
+		default:
+			return nil, err // 500
+		}
+	}
+	return &loc, nil
+}
+
+// GetReceivingLocation возвращает ячейку приемки склада
+func (pr PostgresRepo) GetReceivingLocation(ctx context.Context, warehouseID int) (*model.Location, error) {
+	query := `SELECT id, warehouse_id, parent_id, kind, code, created_at
+	FROM locations
+	WHERE warehouse_id = $1 AND code = $2`
+
+	var loc model.Location
+	err := pr.DB.QueryRowContext(ctx, query, warehouseID, model.ReceivingLocationCode).Scan(
+		&loc.ID,
+		&loc.WarehouseID,
+		&loc.ParentID,
+		&loc.Kind,
+		&loc.Code,
+		&loc.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, model.ErrWarehouseNotFound // 404
+		default:
+			return nil, err // 500
+		}
+	}
+	return &loc, nil
+}
+
+func (pr PostgresRepo) GetLocations(ctx context.Context, warehouseID int) ([]*model.Location, error) {
+	query := `SELECT id, warehouse_id, parent_id, kind, code, created_at
+	FROM locations
+	WHERE warehouse_id = $1
+	ORDER BY code`
+
+	rows, err := pr.DB.QueryContext(ctx, query, warehouseID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error while closing *sql.Rows after scanning: %v", err)
+		}
+	}()
+
+	locations := make([]*model.Location, 0)
+
+	for rows.Next() {
+		var loc model.Location
+		if err := rows.Scan(&loc.ID, &loc.WarehouseID, &loc.ParentID, &loc.Kind, &loc.Code, &loc.CreatedAt); err != nil {
+			return nil, err
+		}
+		locations = append(locations, &loc)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return locations, nil
+}
+
+// GetLocationStock возвращает ненулевые остатки в месте хранения и во всех вложенных в него ячейках
+func (pr PostgresRepo) GetLocationStock(ctx context.Context, locationID int) ([]*model.LocationStock, error) {
+	query := `WITH RECURSIVE subtree AS (
+		SELECT id FROM locations WHERE id = $1
+		UNION ALL
+		SELECT l.id FROM locations l JOIN subtree t ON l.parent_id = t.id
+	)
+	SELECT s.location_id, l.code, l.warehouse_id, s.item_id, i.title, s.amount, s.updated_at
+	FROM location_stock s
+	JOIN subtree t ON t.id = s.location_id
+	JOIN locations l ON l.id = s.location_id
+	JOIN items i ON i.id = s.item_id
+	WHERE s.amount > 0
+	ORDER BY l.code, s.item_id`
+
+	return pr.queryLocationStock(ctx, query, locationID)
+}
+
+// GetItemLocations возвращает ячейки, в которых лежит товар
+func (pr PostgresRepo) GetItemLocations(ctx context.Context, itemID int) ([]*model.LocationStock, error) {
+	query := `SELECT s.location_id, l.code, l.warehouse_id, s.item_id, i.title, s.amount, s.updated_at
+	FROM location_stock s
+	JOIN locations l ON l.id = s.location_id
+	JOIN items i ON i.id = s.item_id
+	WHERE s.item_id = $1 AND s.amount > 0
+	ORDER BY l.warehouse_id, l.code`
+
+	return pr.queryLocationStock(ctx, query, itemID)
+}
+
+func (pr PostgresRepo) queryLocationStock(ctx context.Context, query string, id int) ([]*model.LocationStock, error) {
+	rows, err := pr.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error while closing *sql.Rows after scanning: %v", err)
+		}
+	}()
+
+	stock := make([]*model.LocationStock, 0)
+
+	for rows.Next() {
+		var s model.LocationStock
+		if err := rows.Scan(&s.LocationID, &s.LocationCode, &s.WarehouseID, &s.ItemID, &s.ItemTitle, &s.Amount, &s.UpdatedAt); err != nil {
+			return nil, err
+		}
+		stock = append(stock, &s)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return stock, nil
+}
+
+// MoveItemStock переносит остаток между ячейками одного склада: в журнал движений попадает пара записей transfer,
+// в историю товара - операция размещения или перемещения. Остаток склада и available_amount при этом не меняются
+func (pr PostgresRepo) MoveItemStock(ctx context.Context, mv *model.StockMove) error {
+	return pr.DB.WithTx(ctx, func(tx *sql.Tx) error {
+		if err := lockItem(ctx, tx, mv.ItemID); err != nil {
+			return err
+		}
+
+		out := model.Movement{
+			ItemID:      mv.ItemID,
+			Type:        model.MovementTransfer,
+			WarehouseID: &mv.WarehouseID,
+			LocationID:  mv.FromLocationID,
+			Quantity:    -mv.Amount,
+			ReasonCode:  mv.Action,
+			CreatedBy:   mv.UpdatedBy,
+		}
+		if err := recordMovement(ctx, tx, &out); err != nil {
+			return err
+		}
+		in := out
+		in.LocationID = &mv.ToLocationID
+		in.Quantity = mv.Amount
+		in.RelatedID = &out.ID
+		if err := recordMovement(ctx, tx, &in); err != nil {
+			return err
+		}
+
+		// items не меняется, поэтому триггер истории не срабатывает - запись добавляем сами
+		_, err := tx.ExecContext(ctx, `INSERT INTO items_history (item_id, version, action, old_data, new_data, changed_by, warehouse_id)
+		SELECT $1, COALESCE(MAX(version), 0) + 1, $2, NULL,
+			jsonb_build_object('from_location_id', $3::INT, 'to_location_id', $4::INT, 'amount', $5::INT), $6, $7::INT
+		FROM items_history WHERE item_id = $1`,
+			mv.ItemID, mv.Action, *mv.FromLocationID, mv.ToLocationID, mv.Amount, mv.UpdatedBy, mv.WarehouseID)
+		return err
+	})
+}
+
+// setReceivingStock раскладывает остаток склада по ячейкам: все, что не размещено в ячейках хранения,
+// считается лежащим в приемке. Разница с прежним остатком приемки проводится через журнал движений,
+// остаток меньше уже размещенного отклоняется
+func setReceivingStock(ctx context.Context, tx *sql.Tx, itemID, warehouseID, amount int, reasonCode, updatedBy string) error {
+	var receivingID, placed, received int
+	err := tx.QueryRowContext(ctx, `SELECT r.id,
+		COALESCE((SELECT SUM(s.amount) FROM location_stock s JOIN locations l ON l.id = s.location_id
+			WHERE s.item_id = $1 AND l.warehouse_id = $2 AND l.id <> r.id), 0),
+		COALESCE((SELECT s.amount FROM location_stock s WHERE s.item_id = $1 AND s.location_id = r.id), 0)
+	FROM locations r
+	WHERE r.warehouse_id = $2 AND r.code = $3`, itemID, warehouseID, model.ReceivingLocationCode).Scan(&receivingID, &placed, &received)
+	if err != nil {
+		return err // 500
+	}
+	if amount < placed {
+		return model.ErrStockPlacedInBins // 409
+	}
+
+	delta := amount - placed - received
+	if delta == 0 {
+		return nil
+	}
+
+	movementType := model.MovementAdjustment
+	if reasonCode == model.ReasonInitial {
+		movementType = model.MovementReceipt
+	}
+	return recordMovement(ctx, tx, &model.Movement{
+		ItemID:      itemID,
+		Type:        movementType,
+		WarehouseID: &warehouseID,
+		LocationID:  &receivingID,
+		Quantity:    delta,
+		ReasonCode:  reasonCode,
+		CreatedBy:   updatedBy,
+	})
+}
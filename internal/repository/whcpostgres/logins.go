@@ -0,0 +1,105 @@
+package whcpostgres
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+)
+
+func (pr PostgresRepo) RecordLoginAttempt(ctx context.Context, attempt *model.LoginAttempt) error {
+	query := `INSERT INTO login_attempts (id, username, ip, success, reason, attempted_at)
+	VALUES (DEFAULT, $1, $2, $3, $4, DEFAULT)`
+	_, err := pr.DB.ExecContext(ctx, query,
+		attempt.Username,
+		attempt.IP,
+		attempt.Success,
+		attempt.Reason)
+	return err
+}
+
+// GetLoginFailures считает неудачные попытки входа за последние window: по пользователю - только после
+// его последнего успешного входа, по IP - все. Попытки во время блокировки не считаются, иначе
+// перебор продлевал бы блокировку бесконечно
+func (pr PostgresRepo) GetLoginFailures(ctx context.Context, username, ip string, window time.Duration) (*model.LoginFailures, error) {
+	query := `SELECT
+		count(*) FILTER (WHERE username = $1 AND attempted_at > COALESCE(
+			(SELECT max(attempted_at) FROM login_attempts WHERE username = $1 AND success), '-infinity')),
+		count(*) FILTER (WHERE ip = $2)
+	FROM login_attempts
+	WHERE NOT success
+		AND reason IS DISTINCT FROM $4
+		AND attempted_at > now() - make_interval(secs => $3)
+		AND (username = $1 OR ip = $2)`
+
+	var res model.LoginFailures
+	err := pr.DB.QueryRowContext(ctx, query, username, ip, window.Seconds(), model.LoginFailLocked).Scan(&res.ByUser, &res.ByIP)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (pr PostgresRepo) GetLoginAttempts(ctx context.Context, rp *model.RequestParam, filter *model.LoginAttemptFilter) ([]*model.LoginAttempt, error) {
+	query := `SELECT id, username, ip, success, reason, attempted_at
+	FROM login_attempts
+	WHERE true`
+
+	// значения фильтров передаются только параметрами
+	var args []any
+	if filter.Username != nil {
+		args = append(args, *filter.Username)
+		query += fmt.Sprintf(" AND username = $%d", len(args))
+	}
+	if filter.IP != nil {
+		args = append(args, *filter.IP)
+		query += fmt.Sprintf(" AND ip = $%d", len(args))
+	}
+	if filter.Success != nil {
+		args = append(args, *filter.Success)
+		query += fmt.Sprintf(" AND success = $%d", len(args))
+	}
+
+	// добавляем ограничение по времени
+	periodExpr := definePeriodExpr(rp.StartTime, rp.EndTime, "AND", "attempted_at")
+
+	// применяем лимит и оффсет
+	limofExpr := defineLimitOffsetExpr(rp.Limit, rp.Page)
+
+	// собираем конечный квери - свежие попытки первыми
+	query = query + periodExpr + " ORDER BY attempted_at DESC " + limofExpr
+
+	rows, err := pr.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error while closing *sql.Rows after scanning: %v", err)
+		}
+	}()
+
+	attempts := make([]*model.LoginAttempt, 0)
+
+	for rows.Next() {
+		var a model.LoginAttempt
+		if err := rows.Scan(&a.ID,
+			&a.Username,
+			&a.IP,
+			&a.Success,
+			&a.Reason,
+			&a.AttemptedAt); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, &a)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return attempts, nil
+}
@@ -0,0 +1,309 @@
+package whcpostgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+)
+
+// CreateMovement проводит движение: пишет журнал и обновляет балансы ячейки, склада и товара.
+// Склад и ячейка уже определены сервисом; для transfer в журнал попадают две связанные записи.
+// Списание без ячейки снимается с ячеек склада по порядку - по записи на каждую ячейку
+func (pr PostgresRepo) CreateMovement(ctx context.Context, m *model.Movement) ([]*model.Movement, error) {
+	var res []*model.Movement
+
+	err := pr.DB.WithTx(ctx, func(tx *sql.Tx) error {
+		if err := lockItem(ctx, tx, m.ItemID); err != nil {
+			return err
+		}
+		// склад движения попадает в запись истории товара
+		if err := setTxWarehouse(ctx, tx, *m.WarehouseID); err != nil {
+			return err
+		}
+
+		if m.Type != model.MovementTransfer {
+			if m.LocationID == nil && m.Quantity < 0 {
+				var err error
+				if res, err = takeFromBins(ctx, tx, m); err != nil {
+					return err
+				}
+			} else {
+				if err := recordMovement(ctx, tx, m); err != nil {
+					return err
+				}
+				res = []*model.Movement{m}
+			}
+			if err := applyStockDelta(ctx, tx, m.ItemID, *m.WarehouseID, m.Quantity, m.CreatedBy); err != nil {
+				return err
+			}
+			return fillBalanceAfter(ctx, tx, res)
+		}
+
+		// перемещение не меняет общий остаток товара - только балансы ячеек и складов
+		out := *m
+		out.Quantity = -m.Quantity
+		if err := recordMovement(ctx, tx, &out); err != nil {
+			return err
+		}
+		in := *m
+		in.WarehouseID, in.LocationID = m.ToWarehouseID, m.ToLocationID
+		in.RelatedID = &out.ID
+		if err := recordMovement(ctx, tx, &in); err != nil {
+			return err
+		}
+		if *out.WarehouseID != *in.WarehouseID {
+			if err := upsertItemStock(ctx, tx, m.ItemID, *out.WarehouseID, out.Quantity, m.CreatedBy); err != nil {
+				return err
+			}
+			if err := upsertItemStock(ctx, tx, m.ItemID, *in.WarehouseID, in.Quantity, m.CreatedBy); err != nil {
+				return err
+			}
+		}
+		res = []*model.Movement{&out, &in}
+		return fillBalanceAfter(ctx, tx, res)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (pr PostgresRepo) GetMovements(ctx context.Context, rp *model.RequestParam, filter *model.MovementFilter) ([]*model.Movement, error) {
+	// общий остаток после движения - накопленная сумма журнала товара, считается до применения фильтров
+	query := `SELECT id, item_id, movement_type, warehouse_id, location_id, quantity, reason_code, reference, comment,
+		related_id, balance_after, created_at, created_by
+	FROM (
+		SELECT *, SUM(quantity) OVER (PARTITION BY item_id ORDER BY id) AS balance_after
+		FROM stock_movements
+	) m
+	WHERE true`
+
+	// значения фильтров передаются только параметрами
+	var args []any
+	if filter.ItemID != nil {
+		args = append(args, *filter.ItemID)
+		query += fmt.Sprintf(" AND item_id = $%d", len(args))
+	}
+	if filter.WarehouseID != nil {
+		args = append(args, *filter.WarehouseID)
+		query += fmt.Sprintf(" AND warehouse_id = $%d", len(args))
+	}
+	if filter.Type != nil {
+		args = append(args, *filter.Type)
+		query += fmt.Sprintf(" AND movement_type = $%d", len(args))
+	}
+	if filter.ReasonCode != nil {
+		args = append(args, *filter.ReasonCode)
+		query += fmt.Sprintf(" AND reason_code = $%d", len(args))
+	}
+	if filter.Reference != nil {
+		args = append(args, *filter.Reference)
+		query += fmt.Sprintf(" AND reference = $%d", len(args))
+	}
+
+	// добавляем ограничение по времени
+	periodExpr := definePeriodExpr(rp.StartTime, rp.EndTime, "AND", "created_at")
+
+	// применяем лимит и оффсет
+	limofExpr := defineLimitOffsetExpr(rp.Limit, rp.Page)
+
+	// собираем конечный квери - свежие движения первыми
+	query = query + periodExpr + " ORDER BY id DESC " + limofExpr
+
+	rows, err := pr.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error while closing *sql.Rows after scanning: %v", err)
+		}
+	}()
+
+	movements := make([]*model.Movement, 0)
+
+	for rows.Next() {
+		var m model.Movement
+		if err := rows.Scan(&m.ID,
+			&m.ItemID,
+			&m.Type,
+			&m.WarehouseID,
+			&m.LocationID,
+			&m.Quantity,
+			&m.ReasonCode,
+			&m.Reference,
+			&m.Comment,
+			&m.RelatedID,
+			&m.BalanceAfter,
+			&m.CreatedAt,
+			&m.CreatedBy); err != nil {
+			return nil, err
+		}
+		movements = append(movements, &m)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return movements, nil
+}
+
+// lockItem блокирует неудаленный товар до конца транзакции: операции над его остатками выстраиваются в очередь
+func lockItem(ctx context.Context, tx *sql.Tx, itemID int) error {
+	var id int
+	err := tx.QueryRowContext(ctx, `SELECT id FROM items WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, itemID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return model.ErrItemNotFound // 404
+		default:
+			return err // 500
+		}
+	}
+	return nil
+}
+
+// recordMovement пишет движение в журнал и применяет его к остатку ячейки; уйти в минус ячейка не может
+func recordMovement(ctx context.Context, tx *sql.Tx, m *model.Movement) error {
+	if m.Quantity < 0 {
+		res, err := tx.ExecContext(ctx, `UPDATE location_stock SET amount = amount + $3, updated_at = now(), updated_by = $4
+		WHERE item_id = $1 AND location_id = $2 AND amount + $3 >= 0`, m.ItemID, *m.LocationID, m.Quantity, m.CreatedBy)
+		if err != nil {
+			return err // 500
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err // 500
+		}
+		if rows == 0 {
+			return model.ErrInsufficientStock // 409
+		}
+	} else {
+		_, err := tx.ExecContext(ctx, `INSERT INTO location_stock (item_id, location_id, amount, updated_at, updated_by)
+		VALUES ($1, $2, $3, now(), $4)
+		ON CONFLICT (item_id, location_id) DO UPDATE
+		SET amount = location_stock.amount + EXCLUDED.amount, updated_at = EXCLUDED.updated_at, updated_by = EXCLUDED.updated_by`,
+			m.ItemID, *m.LocationID, m.Quantity, m.CreatedBy)
+		if err != nil {
+			return err // 500
+		}
+	}
+
+	query := `INSERT INTO stock_movements (id, item_id, movement_type, warehouse_id, location_id, quantity, reason_code, reference, comment, related_id, created_at, created_by)
+	VALUES (DEFAULT, $1, $2, $3, $4, $5, $6, $7, $8, $9, DEFAULT, $10) RETURNING id, created_at`
+	return tx.QueryRowContext(ctx, query,
+		m.ItemID,
+		m.Type,
+		*m.WarehouseID,
+		*m.LocationID,
+		m.Quantity,
+		m.ReasonCode,
+		m.Reference,
+		m.Comment,
+		m.RelatedID,
+		m.CreatedBy).Scan(&m.ID, &m.CreatedAt)
+}
+
+// takeFromBins списывает товар со склада без указания ячейки: сначала с приемки, затем с ячеек по коду.
+// Проверяется общий остаток склада - если его не хватает, списание отклоняется целиком
+func takeFromBins(ctx context.Context, tx *sql.Tx, m *model.Movement) ([]*model.Movement, error) {
+	type binStock struct {
+		locationID int
+		amount     int
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT s.location_id, s.amount
+	FROM location_stock s JOIN locations l ON l.id = s.location_id
+	WHERE s.item_id = $1 AND l.warehouse_id = $2 AND s.amount > 0
+	ORDER BY l.code = $3 DESC, l.code`, m.ItemID, *m.WarehouseID, model.ReceivingLocationCode)
+	if err != nil {
+		return nil, err // 500
+	}
+
+	var bins []binStock
+	var total int
+	for rows.Next() {
+		var b binStock
+		if err := rows.Scan(&b.locationID, &b.amount); err != nil {
+			_ = rows.Close()
+			return nil, err // 500
+		}
+		bins = append(bins, b)
+		total += b.amount
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return nil, err // 500
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err // 500
+	}
+
+	need := -m.Quantity
+	if total < need {
+		return nil, model.ErrInsufficientStock // 409
+	}
+
+	var res []*model.Movement
+	for _, b := range bins {
+		if need == 0 {
+			break
+		}
+		take := min(need, b.amount)
+		part := *m
+		part.LocationID = &b.locationID
+		part.Quantity = -take
+		if err := recordMovement(ctx, tx, &part); err != nil {
+			return nil, err
+		}
+		res = append(res, &part)
+		need -= take
+	}
+
+	return res, nil
+}
+
+// upsertItemStock меняет остаток товара на складе на delta
+func upsertItemStock(ctx context.Context, tx *sql.Tx, itemID, warehouseID, delta int, updatedBy string) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO item_stock (item_id, warehouse_id, amount, updated_at, updated_by)
+	VALUES ($1, $2, $3, now(), $4)
+	ON CONFLICT (item_id, warehouse_id) DO UPDATE
+	SET amount = item_stock.amount + EXCLUDED.amount, updated_at = EXCLUDED.updated_at, updated_by = EXCLUDED.updated_by`,
+		itemID, warehouseID, delta, updatedBy)
+	return err
+}
+
+// applyStockDelta меняет остаток склада и общий остаток товара; изменение товара вместе с delta
+// пишет в историю его триггер
+func applyStockDelta(ctx context.Context, tx *sql.Tx, itemID, warehouseID, delta int, updatedBy string) error {
+	if err := upsertItemStock(ctx, tx, itemID, warehouseID, delta, updatedBy); err != nil {
+		return err
+	}
+	if err := setTxStockDelta(ctx, tx, delta); err != nil {
+		return err
+	}
+	return execItemUpdate(ctx, tx, `UPDATE items SET available_amount = available_amount + $2, updated_by = $3
+	WHERE id = $1`, []any{itemID, delta, updatedBy})
+}
+
+// fillBalanceAfter проставляет каждой записи общий остаток товара сразу после нее - так же, как его считает
+// GET /movements (нарастающая сумма по id): от итогового остатка отнимаются более поздние записи.
+// Записи идут в порядке вставки
+func fillBalanceAfter(ctx context.Context, tx *sql.Tx, movements []*model.Movement) error {
+	var balance int
+	if err := tx.QueryRowContext(ctx, `SELECT available_amount FROM items WHERE id = $1`, movements[0].ItemID).Scan(&balance); err != nil {
+		return err
+	}
+	for i := len(movements) - 1; i >= 0; i-- {
+		movements[i].BalanceAfter = balance
+		balance -= movements[i].Quantity
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package whcpostgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+)
+
+func (pr PostgresRepo) CreateRefreshToken(ctx context.Context, userID int, role string, tokenHash string, expiresAt time.Time) error {
+	query := `INSERT INTO refresh_tokens (token_hash, user_id, role, expires_at)
+	VALUES ($1, $2, $3, $4)`
+
+	_, err := pr.DB.ExecContext(ctx, query, tokenHash, userID, role, expiresAt)
+	return err
+}
+
+// RotateRefreshToken гасит предъявленный refresh-токен и выпускает новый для той же сессии.
+// Токен отклоняется, если он истек/уже использован, пользователь отключен, сессии пользователя
+// отозваны или роль сессии у него отобрали.
+func (pr PostgresRepo) RotateRefreshToken(ctx context.Context, oldHash, newHash string, expiresAt time.Time) (*model.User, error) {
+	var user model.User
+
+	err := pr.DB.WithTx(ctx, func(tx *sql.Tx) error {
+		query := `UPDATE refresh_tokens rt SET revoked_at = now()
+		FROM users u
+		WHERE rt.user_id = u.id
+		AND rt.token_hash = $1
+		AND rt.revoked_at IS NULL
+		AND rt.expires_at > now()
+		AND u.disabled_at IS NULL
+		AND (u.tokens_valid_after IS NULL OR rt.created_at >= u.tokens_valid_after)
+		AND (u.role = rt.role OR EXISTS (SELECT 1 FROM user_roles ur WHERE ur.user_id = u.id AND ur.role = rt.role))
+		RETURNING rt.id, u.id, u.username, rt.role`
+
+		var oldID int
+		err := tx.QueryRowContext(ctx, query, oldHash).Scan(&oldID, &user.ID, &user.UserName, &user.Role)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return model.ErrInvalidRefreshToken
+			default:
+				return err // 500
+			}
+		}
+
+		query = `INSERT INTO refresh_tokens (token_hash, user_id, role, expires_at)
+		VALUES ($1, $2, $3, $4) RETURNING id`
+
+		var newID int
+		if err := tx.QueryRowContext(ctx, query, newHash, user.ID, user.Role, expiresAt).Scan(&newID); err != nil {
+			return err // 500
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE refresh_tokens SET replaced_by = $2 WHERE id = $1`, oldID, newID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (pr PostgresRepo) RevokeRefreshToken(ctx context.Context, tokenHash string) error {
+	// повторный logout тем же токеном не ошибка
+	query := `UPDATE refresh_tokens SET revoked_at = now()
+	WHERE token_hash = $1 AND revoked_at IS NULL`
+
+	_, err := pr.DB.ExecContext(ctx, query, tokenHash)
+	return err
+}
+
+func (pr PostgresRepo) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	return pr.DB.WithTx(ctx, func(tx *sql.Tx) error {
+		// заодно чистим записи об уже истекших токенах - проверять их больше незачем
+		if _, err := tx.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expires_at < now()`); err != nil {
+			return err
+		}
+
+		query := `INSERT INTO revoked_tokens (jti, expires_at)
+		VALUES ($1, $2)
+		ON CONFLICT (jti) DO NOTHING`
+
+		_, err := tx.ExecContext(ctx, query, jti, expiresAt)
+		return err
+	})
+}
+
+// RevokeUserSessions отзывает все выпущенные пользователю токены: refresh гасятся сразу,
+// access отклоняются по отметке tokens_valid_after (с округлением вверх до секунды, т.к. iat в JWT - в секундах)
+func (pr PostgresRepo) RevokeUserSessions(ctx context.Context, userID int, revokedBy string) error {
+	return pr.DB.WithTx(ctx, func(tx *sql.Tx) error {
+		query := `UPDATE users SET tokens_valid_after = date_trunc('second', now()) + interval '1 second',
+		updated_at = now(), updated_by = $2
+		WHERE id = $1`
+
+		res, err := tx.ExecContext(ctx, query, userID, revokedBy)
+		if err != nil {
+			return err // 500
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err // 500
+		}
+		if rows == 0 {
+			return model.ErrUserNotFound // 404
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE refresh_tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+		return err
+	})
+}
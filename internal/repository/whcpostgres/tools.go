@@ -1,7 +1,11 @@
 package whcpostgres
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
@@ -40,15 +44,15 @@ func defineLimitOffsetExpr(lim, p *int) string {
 	return fmt.Sprintf("LIMIT %d OFFSET %d", limit, offset)
 }
 
-func defineOrderExpr(orderBy *string, asc, desc bool) (string, error) {
+// defineOrderExpr собирает ORDER BY; поле сверяется со списком allowed той таблицы, которую сортируем,
+// и заменяется на колонку в БД
+func defineOrderExpr(orderBy *string, asc, desc bool, allowed map[string]string) (string, error) {
 	if orderBy == nil {
 		return "", nil
 	}
 
-	_, okItems := model.OrderByItemsMap[*orderBy]
-	_, okHistory := model.OrderByHistoryMap[*orderBy]
-
-	if !(okItems || okHistory) {
+	column, ok := allowed[*orderBy]
+	if !ok {
 		return "", model.ErrInvalidOrderBy
 	}
 
@@ -62,7 +66,7 @@ func defineOrderExpr(orderBy *string, asc, desc bool) (string, error) {
 		direction = "DESC"
 	}
 
-	return fmt.Sprintf(" ORDER BY %s %s ", *orderBy, direction), nil
+	return fmt.Sprintf(" ORDER BY %s %s ", column, direction), nil
 }
 
 func definePeriodExpr(start, end *time.Time, leadOp string, dbField string) string {
@@ -78,6 +82,49 @@ func definePeriodExpr(start, end *time.Time, leadOp string, dbField string) stri
 	}
 }
 
+// historyFilterExpr дописывает к запросу истории фильтры по авторам, действиям, товарам и изменившимся полям;
+// значения уходят только параметрами, нумерация продолжает args
+func historyFilterExpr(filter *model.HistoryFilter, args []any) (string, []any) {
+	var expr string
+
+	if len(filter.ChangedBy) > 0 {
+		var list string
+		list, args = placeholderList(filter.ChangedBy, args)
+		expr += " AND changed_by IN (" + list + ")"
+	}
+	if len(filter.Action) > 0 {
+		var list string
+		list, args = placeholderList(filter.Action, args)
+		expr += " AND action IN (" + list + ")"
+	}
+	if len(filter.ItemID) > 0 {
+		var list string
+		list, args = placeholderList(filter.ItemID, args)
+		expr += " AND item_id IN (" + list + ")"
+	}
+	// поле изменилось, если его значения в снимках различаются - в т.ч. при появлении в INSERT и исчезновении в COMPLETE DELETE
+	if len(filter.Field) > 0 {
+		conds := make([]string, 0, len(filter.Field))
+		for _, f := range filter.Field {
+			args = append(args, f)
+			conds = append(conds, fmt.Sprintf("old_data -> $%d::text IS DISTINCT FROM new_data -> $%d::text", len(args), len(args)))
+		}
+		expr += " AND (" + strings.Join(conds, " OR ") + ")"
+	}
+
+	return expr, args
+}
+
+// placeholderList дописывает значения в args и возвращает их плейсхолдеры через запятую
+func placeholderList[T any](values []T, args []any) (string, []any) {
+	placeholders := make([]string, 0, len(values))
+	for _, v := range values {
+		args = append(args, v)
+		placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+	}
+	return strings.Join(placeholders, ", "), args
+}
+
 func updateQueryBuilder(uItem *model.ItemUpdate) (string, []any, error) {
 	var sets []string
 	var values []any
@@ -105,10 +152,10 @@ func updateQueryBuilder(uItem *model.ItemUpdate) (string, []any, error) {
 		values = append(values, *uItem.Visible)
 		counter++
 	}
-	if uItem.AvailableAmount != nil {
-		sets = append(sets, fmt.Sprintf("available_amount = $%d", counter+1))
-		values = append(values, *uItem.AvailableAmount)
-		counter++
+	// остаток меняется только на конкретном складе: он уже записан в item_stock (с движением) в той же транзакции,
+	// общий остаток считаем по всем складам. Задать available_amount напрямую, минуя журнал движений, нельзя
+	if uItem.AvailableAmount != nil && uItem.WarehouseID != nil {
+		sets = append(sets, "available_amount = (SELECT COALESCE(SUM(amount), 0) FROM item_stock WHERE item_id = $1)")
 	}
 
 	// вставляем обновителя записи
@@ -124,3 +171,76 @@ func updateQueryBuilder(uItem *model.ItemUpdate) (string, []any, error) {
 
 	return setClause, values, nil
 }
+
+// itemVersionExpr - текущая версия товара: номер последней записи его истории
+const itemVersionExpr = `(SELECT COALESCE(MAX(version), 0) FROM items_history WHERE item_id = items.id) AS version`
+
+// checkItemVersion блокирует товар до конца транзакции и сверяет его версию с ожидаемой.
+// Версия читается отдельным запросом уже после блокировки - иначе можно увидеть историю до чужого коммита
+func checkItemVersion(ctx context.Context, tx *sql.Tx, itemID, version int, canSeeDeleted bool) error {
+	lockQuery := `SELECT id FROM items WHERE id = $1`
+	if !canSeeDeleted {
+		lockQuery += ` AND deleted_at IS NULL`
+	}
+	lockQuery += ` FOR UPDATE`
+
+	var id int
+	if err := tx.QueryRowContext(ctx, lockQuery, itemID).Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return model.ErrItemNotFound // 404
+		}
+		return err // 500
+	}
+
+	var current int
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM items_history WHERE item_id = $1`, itemID).Scan(&current); err != nil {
+		return err // 500
+	}
+	if current != version {
+		return model.ErrVersionMismatch // 412
+	}
+	return nil
+}
+
+// execer - общий интерфейс пула соединений и транзакции для запросов без результата
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// execItemUpdate выполняет обновление товара; ни одной затронутой строки - товар не найден
+func execItemUpdate(ctx context.Context, db execer, query string, args []any) error {
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return model.ErrItemNotFound
+	}
+	return nil
+}
+
+// setTxActor передает автора изменения в триггеры через локальную настройку транзакции -
+// для DELETE другого способа сообщить триггеру, кто удаляет запись, нет
+func setTxActor(ctx context.Context, tx *sql.Tx, actor string) error {
+	_, err := tx.ExecContext(ctx, `SELECT set_config('whc.changed_by', $1, true)`, actor)
+	return err
+}
+
+// setTxWarehouse передает склад изменения остатка в триггер истории товаров
+func setTxWarehouse(ctx context.Context, tx *sql.Tx, warehouseID int) error {
+	_, err := tx.ExecContext(ctx, `SELECT set_config('whc.warehouse_id', $1, true)`, strconv.Itoa(warehouseID))
+	return err
+}
+
+// setTxRevert передает в триггер истории товаров версию, к которой откатывается товар
+func setTxRevert(ctx context.Context, tx *sql.Tx, version int) error {
+	_, err := tx.ExecContext(ctx, `SELECT set_config('whc.revert_version', $1, true)`, strconv.Itoa(version))
+	return err
+}
+
+// setTxStockDelta передает в триггер истории товаров относительное изменение остатка
+func setTxStockDelta(ctx context.Context, tx *sql.Tx, delta int) error {
+	_, err := tx.ExecContext(ctx, `SELECT set_config('whc.stock_delta', $1, true)`, strconv.Itoa(delta))
+	return err
+}
@@ -0,0 +1,198 @@
+package whcpostgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+)
+
+func (pr PostgresRepo) GetUserTOTP(ctx context.Context, userID int) (*model.TOTPState, error) {
+	query := `SELECT user_id, secret, confirmed_at, last_step FROM user_totp WHERE user_id = $1`
+
+	var state model.TOTPState
+	err := pr.DB.QueryRowContext(ctx, query, userID).Scan(
+		&state.UserID,
+		&state.Secret,
+		&state.ConfirmedAt,
+		&state.LastStep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, model.ErrTOTPNotEnrolled // 409
+		}
+		return nil, err // 500
+	}
+	return &state, nil
+}
+
+// SaveTOTPSecret сохраняет новый неподтвержденный секрет; подтвержденный второй фактор не перезаписывается
+func (pr PostgresRepo) SaveTOTPSecret(ctx context.Context, userID int, secret string) error {
+	query := `INSERT INTO user_totp (user_id, secret, confirmed_at, last_step, created_at)
+	VALUES ($1, $2, NULL, 0, DEFAULT)
+	ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_step = 0, created_at = now()
+	WHERE user_totp.confirmed_at IS NULL`
+
+	res, err := pr.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err // 500
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err // 500
+	}
+	if rows == 0 {
+		return model.ErrTOTPAlreadyActive // 409
+	}
+	return nil
+}
+
+// ConfirmTOTP включает второй фактор первым принятым кодом и заменяет коды восстановления
+func (pr PostgresRepo) ConfirmTOTP(ctx context.Context, userID int, step int64, recoveryHashes []string) error {
+	return pr.DB.WithTx(ctx, func(tx *sql.Tx) error {
+		query := `UPDATE user_totp SET confirmed_at = now(), last_step = $2
+		WHERE user_id = $1 AND confirmed_at IS NULL AND last_step < $2`
+
+		res, err := tx.ExecContext(ctx, query, userID, step)
+		if err != nil {
+			return err // 500
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err // 500
+		}
+		if rows == 0 {
+			return model.ErrInvalidOTP // 401 - параллельное подтверждение тем же кодом
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+			return err // 500
+		}
+
+		for _, hash := range recoveryHashes {
+			query = `INSERT INTO recovery_codes (id, user_id, code_hash, used_at, created_at)
+			VALUES (DEFAULT, $1, $2, NULL, DEFAULT)`
+			if _, err := tx.ExecContext(ctx, query, userID, hash); err != nil {
+				return err // 500
+			}
+		}
+		return nil
+	})
+}
+
+// UseTOTPStep отмечает интервал принятого кода; код того же или более старого интервала больше не пройдет
+func (pr PostgresRepo) UseTOTPStep(ctx context.Context, userID int, step int64) error {
+	query := `UPDATE user_totp SET last_step = $2
+	WHERE user_id = $1 AND confirmed_at IS NOT NULL AND last_step < $2`
+
+	res, err := pr.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return err // 500
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err // 500
+	}
+	if rows == 0 {
+		return model.ErrInvalidOTP // 401
+	}
+	return nil
+}
+
+// UseRecoveryCode гасит неиспользованный код восстановления
+func (pr PostgresRepo) UseRecoveryCode(ctx context.Context, userID int, codeHash string) error {
+	query := `UPDATE recovery_codes SET used_at = now()
+	WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+
+	res, err := pr.DB.ExecContext(ctx, query, userID, codeHash)
+	if err != nil {
+		return err // 500
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err // 500
+	}
+	if rows == 0 {
+		return model.ErrInvalidOTP // 401
+	}
+	return nil
+}
+
+// DeleteUserTOTP выключает второй фактор вместе с кодами восстановления
+func (pr PostgresRepo) DeleteUserTOTP(ctx context.Context, userID int) error {
+	return pr.DB.WithTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID)
+		if err != nil {
+			return err // 500
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err // 500
+		}
+		if rows == 0 {
+			return model.ErrTOTPNotEnrolled // 409
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+		return err
+	})
+}
+
+func (pr PostgresRepo) CreateLoginChallenge(ctx context.Context, userID int, role, tokenHash string, expiresAt time.Time) error {
+	query := `INSERT INTO login_challenges (id, token_hash, user_id, role, expires_at, attempts, used_at, created_at)
+	VALUES (DEFAULT, $1, $2, $3, $4, 0, NULL, DEFAULT)`
+	_, err := pr.DB.ExecContext(ctx, query, tokenHash, userID, role, expiresAt)
+	return err
+}
+
+// GetLoginChallenge находит действующий челлендж; отключенные за это время пользователи не проходят
+func (pr PostgresRepo) GetLoginChallenge(ctx context.Context, tokenHash string) (*model.LoginChallenge, error) {
+	query := `SELECT c.id, c.user_id, u.username, c.role, c.expires_at, c.attempts
+	FROM login_challenges c
+	JOIN users u ON u.id = c.user_id
+	WHERE c.token_hash = $1 AND c.used_at IS NULL AND c.expires_at > now() AND u.disabled_at IS NULL`
+
+	var c model.LoginChallenge
+	err := pr.DB.QueryRowContext(ctx, query, tokenHash).Scan(
+		&c.ID,
+		&c.UserID,
+		&c.Username,
+		&c.Role,
+		&c.ExpiresAt,
+		&c.Attempts)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, model.ErrInvalidChallenge // 401
+		}
+		return nil, err // 500
+	}
+	return &c, nil
+}
+
+func (pr PostgresRepo) FailLoginChallenge(ctx context.Context, challengeID int) error {
+	_, err := pr.DB.ExecContext(ctx, `UPDATE login_challenges SET attempts = attempts + 1 WHERE id = $1`, challengeID)
+	return err
+}
+
+// CompleteLoginChallenge гасит челлендж; повторное использование того же токена вернет ошибку
+func (pr PostgresRepo) CompleteLoginChallenge(ctx context.Context, challengeID int) error {
+	query := `UPDATE login_challenges SET used_at = now() WHERE id = $1 AND used_at IS NULL`
+
+	res, err := pr.DB.ExecContext(ctx, query, challengeID)
+	if err != nil {
+		return err // 500
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err // 500
+	}
+	if rows == 0 {
+		return model.ErrInvalidChallenge // 401
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+package whcpostgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+)
+
+func (pr PostgresRepo) GetUsersList(ctx context.Context, rpu *model.RequestParam) ([]*model.User, error) {
+	query := `SELECT id, username, role, created_at, disabled_at
+	FROM users`
+
+	// добавляем ограничение по времени
+	periodExpr := definePeriodExpr(rpu.StartTime, rpu.EndTime, "WHERE", "created_at")
+
+	// добавляем сортировку по полю
+	orderExpr, err := defineOrderExpr(rpu.OrderBy, rpu.ASC, rpu.DESC, model.OrderByUsersMap)
+	if err != nil {
+		return nil, err
+	}
+
+	// применяем лимит и оффсет
+	limofExpr := defineLimitOffsetExpr(rpu.Limit, rpu.Page)
+
+	// собираем конечный квери
+	query = query + periodExpr + orderExpr + limofExpr
+
+	// выполняем запрос
+	rows, err := pr.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error while closing *sql.Rows after scanning: %v", err)
+		}
+	}()
+
+	users := make([]*model.User, 0)
+
+	for rows.Next() {
+		var user model.User
+		if err := rows.Scan(&user.ID,
+			&user.UserName,
+			&user.Role,
+			&user.CreatedAt,
+			&user.DisabledAt); err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return users, nil
+}
+
+func (pr PostgresRepo) GetUserSessionState(ctx context.Context, userID int, role string, jti string, issuedAt time.Time) (*model.SessionState, error) {
+	// роль сессии должна оставаться основной либо выданной ролью пользователя,
+	// а сам токен - не попасть в список отозванных
+	query := `SELECT u.disabled_at IS NULL,
+	u.role = $2 OR EXISTS (SELECT 1 FROM user_roles ur WHERE ur.user_id = u.id AND ur.role = $2),
+	EXISTS (SELECT 1 FROM revoked_tokens rt WHERE rt.jti = $3) OR COALESCE(u.tokens_valid_after > $4, false)
+	FROM users u
+	WHERE u.id = $1`
+
+	var state model.SessionState
+
+	err := pr.DB.QueryRowContext(ctx, query, userID, role, jti, issuedAt).Scan(&state.Active, &state.HasRole, &state.Revoked)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, model.ErrUserNotFound
+		default:
+			return nil, err // 500
+		}
+	}
+	return &state, nil
+}
+
+func (pr PostgresRepo) UpdateUserRole(ctx context.Context, userID int, role string, updatedBy string) error {
+	query := `UPDATE users SET role = $2, updated_at = now(), updated_by = $3
+	WHERE id = $1`
+
+	return pr.execUserUpdate(ctx, query, userID, role, updatedBy)
+}
+
+func (pr PostgresRepo) SetUserDisabled(ctx context.Context, userID int, disabled bool, updatedBy string) error {
+	// повторное отключение не сдвигает дату отключения
+	query := `UPDATE users SET disabled_at = CASE WHEN $2 THEN COALESCE(disabled_at, now()) ELSE NULL END,
+	updated_at = now(), updated_by = $3
+	WHERE id = $1`
+
+	return pr.execUserUpdate(ctx, query, userID, disabled, updatedBy)
+}
+
+func (pr PostgresRepo) UpdateUserPassword(ctx context.Context, userID int, passHash string, updatedBy string) error {
+	// смена пароля заодно отзывает все действующие сессии пользователя
+	query := `UPDATE users SET pass_hash = $2, tokens_valid_after = date_trunc('second', now()) + interval '1 second',
+	updated_at = now(), updated_by = $3
+	WHERE id = $1`
+
+	return pr.execUserUpdate(ctx, query, userID, passHash, updatedBy)
+}
+
+func (pr PostgresRepo) DeleteUser(ctx context.Context, userID int, deletedBy string) error {
+	return pr.DB.WithTx(ctx, func(tx *sql.Tx) error {
+		// автора удаления триггер берет из настроек транзакции
+		if err := setTxActor(ctx, tx, deletedBy); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, userID)
+		if err != nil {
+			return err // 500
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err // 500
+		}
+		if rows == 0 {
+			return model.ErrUserNotFound // 404
+		}
+		return nil
+	})
+}
+
+func (pr PostgresRepo) GetUserHistory(ctx context.Context, rph *model.RequestParam, userID int) ([]*model.UserHistory, error) {
+	query := `SELECT id, user_id, version, action, changed_at, changed_by, old_data, new_data
+	FROM users_history
+	WHERE user_id = $1`
+
+	// добавляем ограничение по времени
+	periodExpr := definePeriodExpr(rph.StartTime, rph.EndTime, "AND", "changed_at")
+
+	// добавляем сортировку по полю
+	orderExpr, err := defineOrderExpr(rph.OrderBy, rph.ASC, rph.DESC, model.OrderByUserHistoryMap)
+	if err != nil {
+		return nil, err
+	}
+
+	// применяем лимит и оффсет
+	limofExpr := defineLimitOffsetExpr(rph.Limit, rph.Page)
+
+	// собираем конечный квери
+	query = query + periodExpr + orderExpr + limofExpr
+
+	// выполняем запрос
+	rows, err := pr.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error while closing *sql.Rows after scanning: %v", err)
+		}
+	}()
+
+	history := make([]*model.UserHistory, 0)
+
+	for rows.Next() {
+		var h model.UserHistory
+		if err := rows.Scan(&h.ID,
+			&h.UserID,
+			&h.Version,
+			&h.Action,
+			&h.ChangedAt,
+			&h.ChangedBy,
+			&h.OldData,
+			&h.NewData); err != nil {
+			return nil, err
+		}
+		history = append(history, &h)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return history, nil
+}
+
+func (pr PostgresRepo) GetUserRoles(ctx context.Context, userID int) ([]string, error) {
+	query := `SELECT role
+	FROM user_roles
+	WHERE user_id = $1
+	ORDER BY role`
+
+	rows, err := pr.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error while closing *sql.Rows after scanning: %v", err)
+		}
+	}()
+
+	roles := make([]string, 0)
+
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return roles, nil
+}
+
+func (pr PostgresRepo) GrantUserRole(ctx context.Context, userID int, role string, grantedBy string) error {
+	// повторная выдача той же роли просто обновляет автора и время выдачи
+	query := `INSERT INTO user_roles (user_id, role, granted_by, granted_at)
+	SELECT id, $2, $3, now() FROM users WHERE id = $1
+	ON CONFLICT (user_id, role) DO UPDATE SET granted_by = EXCLUDED.granted_by, granted_at = EXCLUDED.granted_at`
+
+	res, err := pr.DB.ExecContext(ctx, query, userID, role, grantedBy)
+	if err != nil {
+		return err // 500
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err // 500
+	}
+	if rows == 0 {
+		return model.ErrUserNotFound // 404
+	}
+	return nil
+}
+
+func (pr PostgresRepo) RevokeUserRole(ctx context.Context, userID int, role string, revokedBy string) error {
+	return pr.DB.WithTx(ctx, func(tx *sql.Tx) error {
+		// автора отзыва триггер берет из настроек транзакции
+		if err := setTxActor(ctx, tx, revokedBy); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, `DELETE FROM user_roles WHERE user_id = $1 AND role = $2`, userID, role)
+		if err != nil {
+			return err // 500
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err // 500
+		}
+		if rows == 0 {
+			return model.ErrRoleNotGranted // 404
+		}
+		return nil
+	})
+}
+
+func (pr PostgresRepo) execUserUpdate(ctx context.Context, query string, userID int, value any, updatedBy string) error {
+	res, err := pr.DB.ExecContext(ctx, query, userID, value, updatedBy)
+	if err != nil {
+		return err // 500
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err // 500
+	}
+	if rows == 0 {
+		return model.ErrUserNotFound // 404
+	}
+	return nil
+}
+
+// UpsertOIDCUser находит пользователя по subject IdP или создает его при первом логине.
+// Роль всегда синхронизируется с группами IdP - источником правды о роли является IdP
+func (pr PostgresRepo) UpsertOIDCUser(ctx context.Context, user *model.User, subject string) error {
+	return pr.DB.WithTx(ctx, func(tx *sql.Tx) error {
+		query := `SELECT id, username, role, created_at, disabled_at
+		FROM users
+		WHERE oidc_subject = $1
+		FOR UPDATE`
+
+		var currentRole string
+		err := tx.QueryRowContext(ctx, query, subject).Scan(
+			&user.ID,
+			&user.UserName,
+			&currentRole,
+			&user.CreatedAt,
+			&user.DisabledAt)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			query = `INSERT INTO users (id, username, role, pass_hash, oidc_subject, created_at, updated_by)
+			VALUES (DEFAULT, $1, $2, '', $3, DEFAULT, $1) RETURNING id, created_at`
+			return tx.QueryRowContext(ctx, query, user.UserName, user.Role, subject).Scan(&user.ID, &user.CreatedAt)
+		case err != nil:
+			return err // 500
+		}
+
+		if currentRole == user.Role {
+			return nil
+		}
+
+		query = `UPDATE users SET role = $2, updated_at = now(), updated_by = 'oidc' WHERE id = $1`
+		_, err = tx.ExecContext(ctx, query, user.ID, user.Role)
+		return err
+	})
+}
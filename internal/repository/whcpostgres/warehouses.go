@@ -0,0 +1,187 @@
+package whcpostgres
+
+import (
+	"context"
+	"database/sql"
+	"log"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+)
+
+func (pr PostgresRepo) CreateWarehouse(ctx context.Context, wh *model.Warehouse) error {
+	query := `INSERT INTO warehouses (id, code, name, created_at, updated_by)
+	VALUES (DEFAULT, $1, $2, DEFAULT, $3) RETURNING id, created_at`
+	err := pr.DB.QueryRowContext(ctx, query,
+		wh.Code,
+		wh.Name,
+		wh.UpdatedBy).Scan(&wh.ID, &wh.CreatedAt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (pr PostgresRepo) GetWarehouses(ctx context.Context) ([]*model.Warehouse, error) {
+	query := `SELECT id, code, name, created_at
+	FROM warehouses
+	ORDER BY id`
+
+	rows, err := pr.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error while closing *sql.Rows after scanning: %v", err)
+		}
+	}()
+
+	warehouses := make([]*model.Warehouse, 0)
+
+	for rows.Next() {
+		var wh model.Warehouse
+		if err := rows.Scan(&wh.ID, &wh.Code, &wh.Name, &wh.CreatedAt); err != nil {
+			return nil, err
+		}
+		warehouses = append(warehouses, &wh)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return warehouses, nil
+}
+
+// GetUserWarehouses возвращает склады, которыми ограничен пользователь; пустой список - ограничений нет
+func (pr PostgresRepo) GetUserWarehouses(ctx context.Context, userID int) ([]int, error) {
+	query := `SELECT warehouse_id
+	FROM user_warehouses
+	WHERE user_id = $1
+	ORDER BY warehouse_id`
+
+	rows, err := pr.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error while closing *sql.Rows after scanning: %v", err)
+		}
+	}()
+
+	ids := make([]int, 0)
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return ids, nil
+}
+
+func (pr PostgresRepo) GrantUserWarehouse(ctx context.Context, userID, warehouseID int, grantedBy string) error {
+	return pr.DB.WithTx(ctx, func(tx *sql.Tx) error {
+		if err := checkWarehouseExists(ctx, tx, warehouseID); err != nil {
+			return err
+		}
+
+		// повторная выдача того же склада просто обновляет автора и время выдачи
+		query := `INSERT INTO user_warehouses (user_id, warehouse_id, granted_by, granted_at)
+		SELECT id, $2, $3, now() FROM users WHERE id = $1
+		ON CONFLICT (user_id, warehouse_id) DO UPDATE SET granted_by = EXCLUDED.granted_by, granted_at = EXCLUDED.granted_at`
+
+		res, err := tx.ExecContext(ctx, query, userID, warehouseID, grantedBy)
+		if err != nil {
+			return err // 500
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err // 500
+		}
+		if rows == 0 {
+			return model.ErrUserNotFound // 404
+		}
+		return nil
+	})
+}
+
+func (pr PostgresRepo) RevokeUserWarehouse(ctx context.Context, userID, warehouseID int, revokedBy string) error {
+	return pr.DB.WithTx(ctx, func(tx *sql.Tx) error {
+		// автора отзыва триггер берет из настроек транзакции
+		if err := setTxActor(ctx, tx, revokedBy); err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, `DELETE FROM user_warehouses WHERE user_id = $1 AND warehouse_id = $2`, userID, warehouseID)
+		if err != nil {
+			return err // 500
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err // 500
+		}
+		if rows == 0 {
+			return model.ErrWarehouseNotGranted // 404
+		}
+		return nil
+	})
+}
+
+// GetItemStock возвращает ненулевые остатки товара по складам
+func (pr PostgresRepo) GetItemStock(ctx context.Context, itemID int) ([]*model.WarehouseStock, error) {
+	query := `SELECT s.warehouse_id, w.code, s.amount, s.updated_at
+	FROM item_stock s
+	JOIN warehouses w ON w.id = s.warehouse_id
+	WHERE s.item_id = $1 AND s.amount > 0
+	ORDER BY s.warehouse_id`
+
+	rows, err := pr.DB.QueryContext(ctx, query, itemID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error while closing *sql.Rows after scanning: %v", err)
+		}
+	}()
+
+	stock := make([]*model.WarehouseStock, 0)
+
+	for rows.Next() {
+		var s model.WarehouseStock
+		if err := rows.Scan(&s.WarehouseID, &s.WarehouseCode, &s.Amount, &s.UpdatedAt); err != nil {
+			return nil, err
+		}
+		stock = append(stock, &s)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return stock, nil
+}
+
+func checkWarehouseExists(ctx context.Context, tx *sql.Tx, warehouseID int) error {
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM warehouses WHERE id = $1)`, warehouseID).Scan(&exists); err != nil {
+		return err // 500
+	}
+	if !exists {
+		return model.ErrWarehouseNotFound // 404
+	}
+	return nil
+}
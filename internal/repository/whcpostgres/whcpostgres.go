@@ -4,9 +4,11 @@ package whcpostgres
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/UnendingLoop/WarehouseControl/internal/model"
 	"github.com/wb-go/wbf/dbpg"
@@ -17,8 +19,8 @@ type PostgresRepo struct {
 }
 
 func (pr PostgresRepo) CreateUser(ctx context.Context, newUser *model.User) error {
-	query := `INSERT INTO users (id, username, role, pass_hash, created_at)
-	VALUES (DEFAULT, $1, $2, $3, DEFAULT) RETURNING id, created_at`
+	query := `INSERT INTO users (id, username, role, pass_hash, created_at, updated_by)
+	VALUES (DEFAULT, $1, $2, $3, DEFAULT, $1) RETURNING id, created_at`
 	err := pr.DB.QueryRowContext(ctx, query,
 		newUser.UserName,
 		newUser.Role,
@@ -32,7 +34,7 @@ func (pr PostgresRepo) CreateUser(ctx context.Context, newUser *model.User) erro
 }
 
 func (pr PostgresRepo) GetUserByName(ctx context.Context, userName string) (*model.User, error) {
-	query := `SELECT id, role, pass_hash, created_at 
+	query := `SELECT id, role, pass_hash, created_at, disabled_at 
 	FROM users 
 	WHERE username = $1`
 
@@ -42,7 +44,8 @@ func (pr PostgresRepo) GetUserByName(ctx context.Context, userName string) (*mod
 		&user.ID,
 		&user.Role,
 		&user.PassHash,
-		&user.CreatedAt)
+		&user.CreatedAt,
+		&user.DisabledAt)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -55,24 +58,60 @@ func (pr PostgresRepo) GetUserByName(ctx context.Context, userName string) (*mod
 }
 
 func (pr PostgresRepo) CreateItem(ctx context.Context, newItem *model.Item) error {
-	query := `INSERT INTO items (id, title, description, price, visible, available_amount, created_at, updated_at, updated_by)
-	VALUES (DEFAULT, $1, $2, $3, $4, $5, DEFAULT,DEFAULT,$6) RETURNING id, created_at, updated_at`
-	err := pr.DB.QueryRowContext(ctx, query,
-		newItem.Title,
-		newItem.Description,
-		newItem.Price,
-		newItem.Visible,
-		newItem.AvailableAmount,
-		newItem.UpdatedBy).Scan(&newItem.ID, &newItem.CreatedAt, &newItem.UpdatedAt)
-	if err != nil {
-		return err
-	}
-	return nil
+	return pr.DB.WithTx(ctx, func(tx *sql.Tx) error {
+		if newItem.WarehouseID != nil {
+			if err := checkWarehouseExists(ctx, tx, *newItem.WarehouseID); err != nil {
+				return err
+			}
+			// склад начального остатка попадает в запись истории INSERT
+			if err := setTxWarehouse(ctx, tx, *newItem.WarehouseID); err != nil {
+				return err
+			}
+		}
+
+		query := `INSERT INTO items (id, title, description, price, visible, available_amount, created_at, updated_at, updated_by)
+		VALUES (DEFAULT, $1, $2, $3, $4, $5, DEFAULT,DEFAULT,$6) RETURNING id, created_at, updated_at`
+		err := tx.QueryRowContext(ctx, query,
+			newItem.Title,
+			newItem.Description,
+			newItem.Price,
+			newItem.Visible,
+			newItem.AvailableAmount,
+			newItem.UpdatedBy).Scan(&newItem.ID, &newItem.CreatedAt, &newItem.UpdatedAt)
+		if err != nil {
+			return err
+		}
+
+		if newItem.WarehouseID == nil {
+			return nil
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO item_stock (item_id, warehouse_id, amount, updated_at, updated_by)
+		VALUES ($1, $2, $3, DEFAULT, $4)`, newItem.ID, *newItem.WarehouseID, newItem.AvailableAmount, newItem.UpdatedBy)
+		if err != nil {
+			return err
+		}
+
+		// начальный остаток приходуется в ячейку приемки
+		return setReceivingStock(ctx, tx, newItem.ID, *newItem.WarehouseID, newItem.AvailableAmount, model.ReasonInitial, newItem.UpdatedBy)
+	})
 }
 
-func (pr PostgresRepo) DeleteItem(ctx context.Context, itemID int, username string) error {
+// DeleteItem помечает товар удаленным; уже удаленный товар не найден - повторное удаление
+// не переписывает deleted_at и автора и не добавляет запись в историю
+func (pr PostgresRepo) DeleteItem(ctx context.Context, itemID int, version *int, username string) error {
 	query := `UPDATE items SET deleted_at = NOW(), updated_by = $2
-	WHERE id = $1`
+	WHERE id = $1 AND deleted_at IS NULL`
+
+	// с If-Match версия сверяется под блокировкой товара, чтобы между проверкой и удалением никто не успел его изменить
+	if version != nil {
+		return pr.DB.WithTx(ctx, func(tx *sql.Tx) error {
+			if err := checkItemVersion(ctx, tx, itemID, *version, true); err != nil {
+				return err
+			}
+			return execItemUpdate(ctx, tx, query, []any{itemID, username})
+		})
+	}
 
 	res, err := pr.DB.ExecContext(ctx, query, itemID, username)
 	if err != nil {
@@ -89,6 +128,155 @@ func (pr PostgresRepo) DeleteItem(ctx context.Context, itemID int, username stri
 	return nil
 }
 
+// RestoreItem снимает с товара пометку удаления; триггер пишет в историю действие RESTORE
+func (pr PostgresRepo) RestoreItem(ctx context.Context, itemID int, username string) error {
+	return pr.DB.WithTx(ctx, func(tx *sql.Tx) error {
+		var deletedAt sql.NullTime
+		err := tx.QueryRowContext(ctx, `SELECT deleted_at FROM items WHERE id = $1 FOR UPDATE`, itemID).Scan(&deletedAt)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return model.ErrItemNotFound // 404
+			default:
+				return err // 500
+			}
+		}
+		if !deletedAt.Valid {
+			return model.ErrItemNotDeleted // 409
+		}
+
+		return execItemUpdate(ctx, tx, `UPDATE items SET deleted_at = NULL, updated_by = $2 WHERE id = $1`, []any{itemID, username})
+	})
+}
+
+// PurgeItem окончательно удаляет товар из корзины; остатки удаляются каскадом, а триггер пишет в историю
+// COMPLETE DELETE от имени username
+func (pr PostgresRepo) PurgeItem(ctx context.Context, itemID int, username string) error {
+	return pr.DB.WithTx(ctx, func(tx *sql.Tx) error {
+		var deletedAt sql.NullTime
+		err := tx.QueryRowContext(ctx, `SELECT deleted_at FROM items WHERE id = $1 FOR UPDATE`, itemID).Scan(&deletedAt)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return model.ErrItemNotFound // 404
+			default:
+				return err // 500
+			}
+		}
+		// окончательно удаляется только то, что уже лежит в корзине
+		if !deletedAt.Valid {
+			return model.ErrItemNotDeleted // 409
+		}
+
+		if err := setTxActor(ctx, tx, username); err != nil {
+			return err
+		}
+		return execItemUpdate(ctx, tx, `DELETE FROM items WHERE id = $1`, []any{itemID})
+	})
+}
+
+// PurgeDeletedItems окончательно удаляет товары, помеченные удаленными раньше before, и возвращает их
+func (pr PostgresRepo) PurgeDeletedItems(ctx context.Context, before time.Time, actor string) ([]*model.Item, error) {
+	items := make([]*model.Item, 0)
+
+	err := pr.DB.WithTx(ctx, func(tx *sql.Tx) error {
+		if err := setTxActor(ctx, tx, actor); err != nil {
+			return err
+		}
+
+		rows, err := tx.QueryContext(ctx, `DELETE FROM items WHERE deleted_at IS NOT NULL AND deleted_at < $1
+		RETURNING id, title, description, price, visible, available_amount, created_at, updated_at, deleted_at`, before)
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			if err := rows.Close(); err != nil {
+				log.Printf("Error while closing *sql.Rows after scanning: %v", err)
+			}
+		}()
+
+		for rows.Next() {
+			var item model.Item
+			if err := rows.Scan(&item.ID,
+				&item.Title,
+				&item.Description,
+				&item.Price,
+				&item.Visible,
+				&item.AvailableAmount,
+				&item.CreatedAt,
+				&item.UpdatedAt,
+				&item.DeletedAt); err != nil {
+				return err
+			}
+			items = append(items, &item)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// GetDeletedItems - корзина: удаленные товары, свежие удаления первыми. Автор удаления берется из последней
+// записи SOFT DELETE в истории - updated_by мог смениться правкой уже удаленного товара
+func (pr PostgresRepo) GetDeletedItems(ctx context.Context, rp *model.RequestParam) ([]*model.Item, error) {
+	query := `SELECT id, title, description, price, visible, available_amount, created_at, updated_at, deleted_at,
+		(SELECT changed_by FROM items_history WHERE item_id = items.id AND action = 'SOFT DELETE' ORDER BY version DESC LIMIT 1) AS deleted_by,
+		` + itemVersionExpr + `
+	FROM items
+	WHERE deleted_at IS NOT NULL`
+
+	// период задает время удаления
+	periodExpr := definePeriodExpr(rp.StartTime, rp.EndTime, "AND", "deleted_at")
+
+	// применяем лимит и оффсет
+	limofExpr := defineLimitOffsetExpr(rp.Limit, rp.Page)
+
+	// собираем конечный квери
+	query = query + periodExpr + " ORDER BY deleted_at DESC, id DESC " + limofExpr
+
+	rows, err := pr.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error while closing *sql.Rows after scanning: %v", err)
+		}
+	}()
+
+	items := make([]*model.Item, 0)
+
+	for rows.Next() {
+		var item model.Item
+		if err := rows.Scan(&item.ID,
+			&item.Title,
+			&item.Description,
+			&item.Price,
+			&item.Visible,
+			&item.AvailableAmount,
+			&item.CreatedAt,
+			&item.UpdatedAt,
+			&item.DeletedAt,
+			&item.DeletedBy,
+			&item.Version); err != nil {
+			return nil, err
+		}
+		items = append(items, &item)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return items, nil
+}
+
 func (pr PostgresRepo) UpdateItem(ctx context.Context, uItem *model.ItemUpdate, canSeeDeleted bool) error {
 	setClause, values, err := updateQueryBuilder(uItem)
 	if err != nil {
@@ -108,19 +296,57 @@ func (pr PostgresRepo) UpdateItem(ctx context.Context, uItem *model.ItemUpdate,
 
 	// log.Printf("Update-query: %q \nArguments: %v", query, args)
 
-	res, err := pr.DB.ExecContext(ctx, query, args...)
-	if err != nil {
-		return err
+	stockChanged := uItem.AvailableAmount != nil && uItem.WarehouseID != nil
+	if !stockChanged && uItem.Version == nil && uItem.RevertOf == nil {
+		return execItemUpdate(ctx, pr.DB, query, args)
 	}
 
-	if n, _ := res.RowsAffected(); n == 0 {
-		return model.ErrItemNotFound
-	}
-	return nil
+	return pr.DB.WithTx(ctx, func(tx *sql.Tx) error {
+		// с If-Match версия сверяется под блокировкой товара до любых изменений
+		if uItem.Version != nil {
+			if err := checkItemVersion(ctx, tx, uItem.ID, *uItem.Version, canSeeDeleted); err != nil {
+				return err
+			}
+		}
+		// откат пишется в историю отдельным действием со ссылкой на восстановленную версию
+		if uItem.RevertOf != nil {
+			if err := setTxRevert(ctx, tx, *uItem.RevertOf); err != nil {
+				return err
+			}
+		}
+		if !stockChanged {
+			return execItemUpdate(ctx, tx, query, args)
+		}
+
+		// остаток меняется на конкретном складе, общий available_amount пересчитывается из item_stock
+		if err := checkWarehouseExists(ctx, tx, *uItem.WarehouseID); err != nil {
+			return err
+		}
+		if err := setTxWarehouse(ctx, tx, *uItem.WarehouseID); err != nil {
+			return err
+		}
+
+		stockQuery := `INSERT INTO item_stock (item_id, warehouse_id, amount, updated_at, updated_by)
+		SELECT id, $2, $3, now(), $4 FROM items WHERE id = $1`
+		if !canSeeDeleted {
+			stockQuery += ` AND deleted_at IS NULL`
+		}
+		stockQuery += ` ON CONFLICT (item_id, warehouse_id) DO UPDATE
+		SET amount = EXCLUDED.amount, updated_at = EXCLUDED.updated_at, updated_by = EXCLUDED.updated_by`
+
+		if err := execItemUpdate(ctx, tx, stockQuery, []any{uItem.ID, *uItem.WarehouseID, *uItem.AvailableAmount, uItem.UpdatedBy}); err != nil {
+			return err
+		}
+		if err := setReceivingStock(ctx, tx, uItem.ID, *uItem.WarehouseID, *uItem.AvailableAmount, model.ReasonManualEdit, uItem.UpdatedBy); err != nil {
+			return err
+		}
+
+		return execItemUpdate(ctx, tx, query, args)
+	})
 }
 
 func (pr PostgresRepo) GetItemByID(ctx context.Context, itemID int, canSeeDeleted bool) (*model.Item, error) {
-	query := `SELECT id, title, description, price, visible, available_amount, created_at, updated_at, deleted_at 
+	query := `SELECT id, title, description, price, visible, available_amount, created_at, updated_at, deleted_at, ` + itemVersionExpr + `
 	FROM items 
 	WHERE id = $1`
 
@@ -139,7 +365,8 @@ func (pr PostgresRepo) GetItemByID(ctx context.Context, itemID int, canSeeDelete
 		&item.AvailableAmount,
 		&item.CreatedAt,
 		&item.UpdatedAt,
-		&item.DeletedAt)
+		&item.DeletedAt,
+		&item.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -151,24 +378,60 @@ func (pr PostgresRepo) GetItemByID(ctx context.Context, itemID int, canSeeDelete
 	return &item, nil
 }
 
-func (pr PostgresRepo) GetItemsList(ctx context.Context, rpi *model.RequestParam, canSeeDeleted bool) ([]*model.Item, error) {
-	query := `SELECT id, title, description, price, visible, available_amount, created_at, updated_at, deleted_at 
+func (pr PostgresRepo) GetItemsList(ctx context.Context, rpi *model.RequestParam, filter *model.ItemFilter, canSeeDeleted bool) ([]*model.Item, error) {
+	query := `SELECT id, title, description, price, visible, available_amount, created_at, updated_at, deleted_at, ` + itemVersionExpr + `
 	FROM items`
+	var args []any
+
+	switch {
+	case filter.AsOf != nil:
+		// на момент as_of товар - снимок new_data его последней записи History до этого момента;
+		// после COMPLETE DELETE снимка нет - товара тогда уже не существовало. PUTAWAY и MOVE пропускаются:
+		// в их new_data перемещение по ячейкам, а не снимок товара
+		args = append(args, *filter.AsOf)
+		query = `SELECT id, title, description, price, visible, available_amount, created_at, updated_at, deleted_at, version
+		FROM (
+			SELECT h.item_id AS id,
+				h.new_data->>'title' AS title,
+				COALESCE(h.new_data->>'description', '') AS description,
+				(h.new_data->>'price')::BIGINT AS price,
+				(h.new_data->>'visible')::BOOLEAN AS visible,
+				(h.new_data->>'available_amount')::INT AS available_amount,
+				(h.new_data->>'created_at')::TIMESTAMP AS created_at,
+				(h.new_data->>'updated_at')::TIMESTAMP AS updated_at,
+				(h.new_data->>'deleted_at')::TIMESTAMP AS deleted_at,
+				h.version
+			FROM (
+				SELECT DISTINCT ON (item_id) item_id, version, new_data
+				FROM items_history
+				WHERE changed_at <= $1 AND action NOT IN ('PUTAWAY', 'MOVE')
+				ORDER BY item_id, version DESC
+			) h
+			WHERE h.new_data IS NOT NULL
+		) items`
+	case filter.WarehouseID != nil:
+		// при фильтре по складу показываем только товары с остатком на нем и сам этот остаток;
+		// алиас нужен, чтобы сортировка по available_amount шла по остатку склада, а не по общему
+		args = append(args, *filter.WarehouseID)
+		query = `SELECT items.id, items.title, items.description, items.price, items.visible, s.amount AS available_amount, items.created_at, items.updated_at, items.deleted_at, ` + itemVersionExpr + `
+		FROM items
+		JOIN item_stock s ON s.item_id = items.id AND s.warehouse_id = $1 AND s.amount > 0`
+	}
 	// добавляем сортировку по полю
-	orderExpr, err := defineOrderExpr(rpi.OrderBy, rpi.ASC, rpi.DESC)
+	orderExpr, err := defineOrderExpr(rpi.OrderBy, rpi.ASC, rpi.DESC, model.OrderByItemsMap)
 	if err != nil {
 		return nil, err
 	}
 
 	// добавляем ограничение по времени
-	periodExpr := definePeriodExpr(rpi.StartTime, rpi.EndTime, "WHERE", "created_at")
-	// если нет доступа на просмотр удаленных - добавляем условие
+	periodExpr := definePeriodExpr(rpi.StartTime, rpi.EndTime, "WHERE", "items.created_at")
+	// если нет доступа на просмотр удаленных - добавляем условие; для as_of - удаленных на тот момент
 	if !canSeeDeleted {
 		switch periodExpr {
 		case "":
-			periodExpr = ` WHERE deleted_at IS NULL `
+			periodExpr = ` WHERE items.deleted_at IS NULL `
 		default:
-			periodExpr += ` AND deleted_at IS NULL `
+			periodExpr += ` AND items.deleted_at IS NULL `
 		}
 	}
 
@@ -178,7 +441,7 @@ func (pr PostgresRepo) GetItemsList(ctx context.Context, rpi *model.RequestParam
 	query = query + periodExpr + orderExpr + limofExpr
 
 	// выполняем запрос
-	rows, err := pr.DB.QueryContext(ctx, query)
+	rows, err := pr.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -201,7 +464,8 @@ func (pr PostgresRepo) GetItemsList(ctx context.Context, rpi *model.RequestParam
 			&item.AvailableAmount,
 			&item.CreatedAt,
 			&item.UpdatedAt,
-			&item.DeletedAt); err != nil {
+			&item.DeletedAt,
+			&item.Version); err != nil {
 			return nil, err
 		}
 		items = append(items, &item)
@@ -214,16 +478,25 @@ func (pr PostgresRepo) GetItemsList(ctx context.Context, rpi *model.RequestParam
 	return items, nil
 }
 
-func (pr PostgresRepo) GetItemHistoryByID(ctx context.Context, rph *model.RequestParam, itemID int) ([]*model.ItemHistory, error) {
-	query := `SELECT id, item_id, version, action, changed_at, changed_by, old_data, new_data 
+func (pr PostgresRepo) GetItemHistoryByID(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, itemID int) ([]*model.ItemHistory, error) {
+	query := `SELECT id, item_id, version, action, changed_at, changed_by, old_data, new_data, warehouse_id, stock_delta, reverted_from 
 	FROM items_history
 	WHERE item_id = $1`
+	args := []any{itemID}
+
+	if filter.WarehouseID != nil {
+		args = append(args, *filter.WarehouseID)
+		query += fmt.Sprintf(" AND warehouse_id = $%d", len(args))
+	}
+
+	filterExpr, args := historyFilterExpr(filter, args)
+	query += filterExpr
 
 	// добавляем ограничение по времени
 	periodExpr := definePeriodExpr(rph.StartTime, rph.EndTime, "AND", "changed_at")
 
 	// добавляем сортировку по полю
-	orderExpr, err := defineOrderExpr(rph.OrderBy, rph.ASC, rph.DESC)
+	orderExpr, err := defineOrderExpr(rph.OrderBy, rph.ASC, rph.DESC, model.OrderByHistoryMap)
 	if err != nil {
 		return nil, err
 	}
@@ -232,10 +505,10 @@ func (pr PostgresRepo) GetItemHistoryByID(ctx context.Context, rph *model.Reques
 	limofExpr := defineLimitOffsetExpr(rph.Limit, rph.Page)
 
 	// собираем конечный квери
-	query = query + orderExpr + periodExpr + limofExpr
+	query = query + periodExpr + orderExpr + limofExpr
 
 	// выполняем запрос
-	rows, err := pr.DB.QueryContext(ctx, query, itemID)
+	rows, err := pr.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -257,7 +530,10 @@ func (pr PostgresRepo) GetItemHistoryByID(ctx context.Context, rph *model.Reques
 			&h.ChangedAt,
 			&h.ChangedBy,
 			&h.OldData,
-			&h.NewData); err != nil {
+			&h.NewData,
+			&h.WarehouseID,
+			&h.StockDelta,
+			&h.RevertedFrom); err != nil {
 			return nil, err
 		}
 		history = append(history, &h)
@@ -270,15 +546,25 @@ func (pr PostgresRepo) GetItemHistoryByID(ctx context.Context, rph *model.Reques
 	return history, nil
 }
 
-func (pr PostgresRepo) GetItemHistoryAll(ctx context.Context, rph *model.RequestParam) ([]*model.ItemHistory, error) {
-	query := `SELECT id, item_id, version, action, changed_at, changed_by, old_data, new_data 
-	FROM items_history `
+func (pr PostgresRepo) GetItemHistoryAll(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter) ([]*model.ItemHistory, error) {
+	query := `SELECT id, item_id, version, action, changed_at, changed_by, old_data, new_data, warehouse_id, stock_delta, reverted_from 
+	FROM items_history
+	WHERE TRUE`
+	var args []any
+
+	if filter.WarehouseID != nil {
+		args = append(args, *filter.WarehouseID)
+		query += fmt.Sprintf(" AND warehouse_id = $%d", len(args))
+	}
+
+	filterExpr, args := historyFilterExpr(filter, args)
+	query += filterExpr
 
 	// добавляем ограничение по времени
-	periodExpr := definePeriodExpr(rph.StartTime, rph.EndTime, "WHERE", "changed_at")
+	periodExpr := definePeriodExpr(rph.StartTime, rph.EndTime, "AND", "changed_at")
 
 	// добавляем сортировку по полю
-	orderExpr, err := defineOrderExpr(rph.OrderBy, rph.ASC, rph.DESC)
+	orderExpr, err := defineOrderExpr(rph.OrderBy, rph.ASC, rph.DESC, model.OrderByHistoryMap)
 	if err != nil {
 		return nil, err
 	}
@@ -290,7 +576,7 @@ func (pr PostgresRepo) GetItemHistoryAll(ctx context.Context, rph *model.Request
 	query = query + periodExpr + orderExpr + limofExpr
 
 	// выполняем запрос
-	rows, err := pr.DB.QueryContext(ctx, query)
+	rows, err := pr.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -312,7 +598,70 @@ func (pr PostgresRepo) GetItemHistoryAll(ctx context.Context, rph *model.Request
 			&h.ChangedAt,
 			&h.ChangedBy,
 			&h.OldData,
-			&h.NewData); err != nil {
+			&h.NewData,
+			&h.WarehouseID,
+			&h.StockDelta,
+			&h.RevertedFrom); err != nil {
+			return nil, err
+		}
+		history = append(history, &h)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return history, nil
+}
+
+// GetItemSnapshot возвращает текущую строку неудаленного товара в том же виде, в каком триггер пишет снимки в историю
+func (pr PostgresRepo) GetItemSnapshot(ctx context.Context, itemID int) (*json.RawMessage, error) {
+	var snapshot json.RawMessage
+	err := pr.DB.QueryRowContext(ctx, `SELECT to_jsonb(items) FROM items WHERE id = $1 AND deleted_at IS NULL`, itemID).Scan(&snapshot)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, model.ErrItemNotFound // 404
+		default:
+			return nil, err // 500
+		}
+	}
+	return &snapshot, nil
+}
+
+// GetItemHistoryVersions возвращает записи истории товара с версиями from и to
+func (pr PostgresRepo) GetItemHistoryVersions(ctx context.Context, itemID, from, to int) ([]*model.ItemHistory, error) {
+	query := `SELECT id, item_id, version, action, changed_at, changed_by, old_data, new_data, warehouse_id, stock_delta, reverted_from 
+	FROM items_history
+	WHERE item_id = $1 AND version IN ($2, $3)
+	ORDER BY version`
+
+	rows, err := pr.DB.QueryContext(ctx, query, itemID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Printf("Error while closing *sql.Rows after scanning: %v", err)
+		}
+	}()
+
+	history := make([]*model.ItemHistory, 0, 2)
+
+	for rows.Next() {
+		var h model.ItemHistory
+		if err := rows.Scan(&h.ID,
+			&h.ItemID,
+			&h.Version,
+			&h.Action,
+			&h.ChangedAt,
+			&h.ChangedBy,
+			&h.OldData,
+			&h.NewData,
+			&h.WarehouseID,
+			&h.StockDelta,
+			&h.RevertedFrom); err != nil {
 			return nil, err
 		}
 		history = append(history, &h)
@@ -24,6 +24,21 @@ func newMockRepo(t *testing.T) (*PostgresRepo, sqlmock.Sqlmock) {
 	return &PostgresRepo{DB: &dbConn}, mock
 }
 
+// expectMovement ожидает проводку движения: изменение остатка ячейки и запись в журнал
+func expectMovement(mock sqlmock.Sqlmock, itemID, locationID, quantity int, movementID int64) {
+	if quantity < 0 {
+		mock.ExpectExec(`UPDATE location_stock SET amount = amount \+ \$3`).
+			WithArgs(itemID, locationID, quantity, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	} else {
+		mock.ExpectExec(`INSERT INTO location_stock .+ SET amount = location_stock.amount \+ EXCLUDED.amount`).
+			WithArgs(itemID, locationID, quantity, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectQuery(`INSERT INTO stock_movements`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(movementID, time.Now()))
+}
+
 // ================= METHODS TESTS ====================
 func TestCreateUser(t *testing.T) {
 	repo, mock := newMockRepo(t)
@@ -103,7 +118,7 @@ func TestGetUserByName(t *testing.T) {
 		{
 			name:     "Positive case - username found",
 			arg:      "john",
-			mockRows: sqlmock.NewRows([]string{"id", "role", "pass_hash", "created_at"}).AddRow(1, "admin", "hash", timeNow),
+			mockRows: sqlmock.NewRows([]string{"id", "role", "pass_hash", "created_at", "disabled_at"}).AddRow(1, "admin", "hash", timeNow, nil),
 			mockErr:  nil,
 			wantErr:  nil,
 			wantUser: &model.User{
@@ -135,7 +150,7 @@ func TestGetUserByName(t *testing.T) {
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
 			exp := mock.ExpectQuery(
-				`SELECT id, role, pass_hash, created_at`,
+				`SELECT id, role, pass_hash, created_at, disabled_at`,
 			).WithArgs(tt.arg)
 
 			if tt.mockRows != nil {
@@ -146,10 +161,647 @@ func TestGetUserByName(t *testing.T) {
 
 			user, err := repo.GetUserByName(context.Background(), tt.arg)
 
-			require.ErrorIs(t, err, tt.wantErr)
-			require.Equal(t, tt.wantUser, user)
-		})
-	}
+			require.ErrorIs(t, err, tt.wantErr)
+			require.Equal(t, tt.wantUser, user)
+		})
+	}
+}
+
+func TestGrantUserRole(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	someErr := errors.New("some error")
+
+	cases := []struct {
+		name         string
+		userID       int
+		role         string
+		mockErr      error
+		wantErr      error
+		mockAffected int
+	}{
+		{
+			name:         "Positive case - role granted",
+			userID:       5,
+			role:         "manager",
+			mockAffected: 1,
+		},
+		{
+			name:         "Negative case - user not found",
+			userID:       5,
+			role:         "manager",
+			wantErr:      model.ErrUserNotFound,
+			mockAffected: 0,
+		},
+		{
+			name:    "Negative case - some DB error",
+			userID:  5,
+			role:    "manager",
+			mockErr: someErr,
+			wantErr: someErr,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			exp := mock.ExpectExec(`INSERT INTO user_roles`).
+				WithArgs(tt.userID, tt.role, "admin")
+
+			if tt.mockErr != nil {
+				exp.WillReturnError(tt.mockErr)
+			} else {
+				exp.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockAffected)))
+			}
+
+			err := repo.GrantUserRole(context.Background(), tt.userID, tt.role, "admin")
+
+			require.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRevokeUserRole(t *testing.T) {
+	repo, mock := newMockRepo(t)
+
+	cases := []struct {
+		name         string
+		mockAffected int
+		wantErr      error
+	}{
+		{
+			name:         "Positive case - role revoked",
+			mockAffected: 1,
+		},
+		{
+			name:         "Negative case - role was not granted",
+			mockAffected: 0,
+			wantErr:      model.ErrRoleNotGranted,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.ExpectBegin()
+			mock.ExpectExec(`SELECT set_config`).
+				WithArgs("admin").
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(`DELETE FROM user_roles`).
+				WithArgs(5, "auditor").
+				WillReturnResult(sqlmock.NewResult(0, int64(tt.mockAffected)))
+			if tt.wantErr != nil {
+				mock.ExpectRollback()
+			} else {
+				mock.ExpectCommit()
+			}
+
+			err := repo.RevokeUserRole(context.Background(), 5, "auditor", "admin")
+
+			require.ErrorIs(t, err, tt.wantErr)
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGetUserRoles(t *testing.T) {
+	repo, mock := newMockRepo(t)
+
+	mock.ExpectQuery(`SELECT role\s+FROM user_roles`).
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow("auditor").AddRow("manager"))
+
+	roles, err := repo.GetUserRoles(context.Background(), 5)
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"auditor", "manager"}, roles)
+}
+
+func TestGetUsersList(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	timeNow := time.Now()
+
+	mock.ExpectQuery(`SELECT id, username, role, created_at, disabled_at\s+FROM users ORDER BY username ASC LIMIT 10 OFFSET 10`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role", "created_at", "disabled_at"}).
+			AddRow(1, "john", "admin", timeNow, nil).
+			AddRow(2, "alice", "viewer", timeNow, timeNow))
+
+	users, err := repo.GetUsersList(context.Background(), &model.RequestParam{
+		OrderBy: ptrMaker(model.UsersOrderByUserName),
+		ASC:     true,
+		Page:    ptrMaker(2),
+		Limit:   ptrMaker(10),
+	})
+
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+	require.Nil(t, users[0].DisabledAt)
+	require.NotNil(t, users[1].DisabledAt)
+}
+
+func TestGetUserSessionState(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	dbErr := errors.New("some error")
+	issuedAt := time.Now()
+
+	cases := []struct {
+		name      string
+		mockRows  *sqlmock.Rows
+		mockErr   error
+		wantState *model.SessionState
+		wantErr   error
+	}{
+		{
+			name:      "Positive case - active user with role",
+			mockRows:  sqlmock.NewRows([]string{"active", "has_role", "revoked"}).AddRow(true, true, false),
+			wantState: &model.SessionState{Active: true, HasRole: true},
+		},
+		{
+			name:      "Positive case - disabled user",
+			mockRows:  sqlmock.NewRows([]string{"active", "has_role", "revoked"}).AddRow(false, true, false),
+			wantState: &model.SessionState{Active: false, HasRole: true},
+		},
+		{
+			name:      "Positive case - revoked token",
+			mockRows:  sqlmock.NewRows([]string{"active", "has_role", "revoked"}).AddRow(true, true, true),
+			wantState: &model.SessionState{Active: true, HasRole: true, Revoked: true},
+		},
+		{
+			name:    "Negative case - user deleted",
+			mockErr: sql.ErrNoRows,
+			wantErr: model.ErrUserNotFound,
+		},
+		{
+			name:    "Negative case - DB error",
+			mockErr: dbErr,
+			wantErr: dbErr,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			exp := mock.ExpectQuery(`SELECT u.disabled_at IS NULL`).WithArgs(5, "manager", "some-jti", issuedAt)
+			if tt.mockRows != nil {
+				exp.WillReturnRows(tt.mockRows)
+			} else {
+				exp.WillReturnError(tt.mockErr)
+			}
+
+			state, err := repo.GetUserSessionState(context.Background(), 5, "manager", "some-jti", issuedAt)
+
+			require.ErrorIs(t, err, tt.wantErr)
+			require.Equal(t, tt.wantState, state)
+		})
+	}
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	expiresAt := time.Now().Add(time.Hour)
+
+	cases := []struct {
+		name     string
+		oldRows  *sqlmock.Rows
+		wantErr  error
+		wantUser *model.User
+	}{
+		{
+			name:     "Positive case - token rotated",
+			oldRows:  sqlmock.NewRows([]string{"id", "user_id", "username", "role"}).AddRow(7, 5, "john", "manager"),
+			wantUser: &model.User{ID: 5, UserName: "john", Role: "manager"},
+		},
+		{
+			name:    "Negative case - token used, expired or revoked",
+			oldRows: sqlmock.NewRows([]string{"id", "user_id", "username", "role"}),
+			wantErr: model.ErrInvalidRefreshToken,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.ExpectBegin()
+			mock.ExpectQuery(`UPDATE refresh_tokens rt SET revoked_at`).
+				WithArgs("old-hash").
+				WillReturnRows(tt.oldRows)
+			if tt.wantErr != nil {
+				mock.ExpectRollback()
+			} else {
+				mock.ExpectQuery(`INSERT INTO refresh_tokens`).
+					WithArgs("new-hash", 5, "manager", expiresAt).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8))
+				mock.ExpectExec(`UPDATE refresh_tokens SET replaced_by`).
+					WithArgs(7, 8).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			}
+
+			user, err := repo.RotateRefreshToken(context.Background(), "old-hash", "new-hash", expiresAt)
+
+			require.ErrorIs(t, err, tt.wantErr)
+			require.Equal(t, tt.wantUser, user)
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestSetUserDisabled(t *testing.T) {
+	repo, mock := newMockRepo(t)
+
+	cases := []struct {
+		name         string
+		mockAffected int
+		wantErr      error
+	}{
+		{
+			name:         "Positive case - user disabled",
+			mockAffected: 1,
+		},
+		{
+			name:         "Negative case - user not found",
+			mockAffected: 0,
+			wantErr:      model.ErrUserNotFound,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.ExpectExec(`UPDATE users SET disabled_at`).
+				WithArgs(5, true, "admin").
+				WillReturnResult(sqlmock.NewResult(0, int64(tt.mockAffected)))
+
+			err := repo.SetUserDisabled(context.Background(), 5, true, "admin")
+
+			require.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	repo, mock := newMockRepo(t)
+
+	cases := []struct {
+		name         string
+		mockAffected int
+		wantErr      error
+	}{
+		{
+			name:         "Positive case - user deleted",
+			mockAffected: 1,
+		},
+		{
+			name:         "Negative case - user not found",
+			mockAffected: 0,
+			wantErr:      model.ErrUserNotFound,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.ExpectBegin()
+			mock.ExpectExec(`SELECT set_config`).
+				WithArgs("admin").
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(`DELETE FROM users`).
+				WithArgs(5).
+				WillReturnResult(sqlmock.NewResult(0, int64(tt.mockAffected)))
+			if tt.wantErr != nil {
+				mock.ExpectRollback()
+			} else {
+				mock.ExpectCommit()
+			}
+
+			err := repo.DeleteUser(context.Background(), 5, "admin")
+
+			require.ErrorIs(t, err, tt.wantErr)
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestCreateUserWithInvite(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	timeNow := time.Now()
+
+	cases := []struct {
+		name       string
+		inviteRows *sqlmock.Rows
+		wantErr    error
+		wantRole   string
+	}{
+		{
+			name:       "Positive case - invite consumed, user created",
+			inviteRows: sqlmock.NewRows([]string{"role"}).AddRow("manager"),
+			wantRole:   "manager",
+		},
+		{
+			name:       "Negative case - invite invalid or used",
+			inviteRows: sqlmock.NewRows([]string{"role"}),
+			wantErr:    model.ErrInvalidInvite,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.ExpectBegin()
+			mock.ExpectQuery(`UPDATE invites SET used_at`).
+				WithArgs("hash", "john").
+				WillReturnRows(tt.inviteRows)
+			if tt.wantErr != nil {
+				mock.ExpectRollback()
+			} else {
+				mock.ExpectQuery(`INSERT INTO users`).
+					WithArgs("john", tt.wantRole, "passhash").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, timeNow))
+				mock.ExpectCommit()
+			}
+
+			user := &model.User{UserName: "john", PassHash: "passhash"}
+			err := repo.CreateUserWithInvite(context.Background(), user, "hash")
+
+			require.ErrorIs(t, err, tt.wantErr)
+			if tt.wantErr == nil {
+				require.Equal(t, tt.wantRole, user.Role)
+				require.Equal(t, 1, user.ID)
+			}
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestUpsertOIDCUser(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	timeNow := time.Now()
+
+	cases := []struct {
+		name      string
+		existing  *sqlmock.Rows
+		expectSQL func()
+		wantID    int
+		wantName  string
+	}{
+		{
+			name:     "Positive case - first login creates user",
+			existing: sqlmock.NewRows([]string{"id", "username", "role", "created_at", "disabled_at"}),
+			expectSQL: func() {
+				mock.ExpectQuery(`INSERT INTO users`).
+					WithArgs("jane", "manager", "sub-1").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(7, timeNow))
+			},
+			wantID:   7,
+			wantName: "jane",
+		},
+		{
+			name: "Positive case - known user, role synced from IdP",
+			existing: sqlmock.NewRows([]string{"id", "username", "role", "created_at", "disabled_at"}).
+				AddRow(3, "jane.old", "viewer", timeNow, nil),
+			expectSQL: func() {
+				mock.ExpectExec(`UPDATE users SET role`).
+					WithArgs(3, "manager").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			wantID:   3,
+			wantName: "jane.old",
+		},
+		{
+			name: "Positive case - known user, role unchanged",
+			existing: sqlmock.NewRows([]string{"id", "username", "role", "created_at", "disabled_at"}).
+				AddRow(3, "jane", "manager", timeNow, nil),
+			expectSQL: func() {},
+			wantID:    3,
+			wantName:  "jane",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT id, username, role, created_at, disabled_at`).
+				WithArgs("sub-1").
+				WillReturnRows(tt.existing)
+			tt.expectSQL()
+			mock.ExpectCommit()
+
+			user := &model.User{UserName: "jane", Role: "manager"}
+			err := repo.UpsertOIDCUser(context.Background(), user, "sub-1")
+
+			require.NoError(t, err)
+			require.Equal(t, tt.wantID, user.ID)
+			require.Equal(t, tt.wantName, user.UserName)
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRevokeInvite(t *testing.T) {
+	repo, mock := newMockRepo(t)
+
+	cases := []struct {
+		name         string
+		mockAffected int
+		wantErr      error
+	}{
+		{
+			name:         "Positive case - invite revoked",
+			mockAffected: 1,
+		},
+		{
+			name:         "Negative case - invite not found or used",
+			mockAffected: 0,
+			wantErr:      model.ErrInviteNotFound,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.ExpectExec(`DELETE FROM invites`).
+				WithArgs(3).
+				WillReturnResult(sqlmock.NewResult(0, int64(tt.mockAffected)))
+
+			err := repo.RevokeInvite(context.Background(), 3)
+
+			require.ErrorIs(t, err, tt.wantErr)
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestUseAPIKey(t *testing.T) {
+	repo, mock := newMockRepo(t)
+
+	cases := []struct {
+		name     string
+		mockRows *sqlmock.Rows
+		wantErr  error
+	}{
+		{
+			name: "Positive case - active key",
+			mockRows: sqlmock.NewRows([]string{"id", "key_prefix", "name", "role", "expires_at"}).
+				AddRow(1, "whc_12345678", "erp-sync", "manager", nil),
+		},
+		{
+			name:     "Negative case - revoked, expired or unknown key",
+			mockRows: sqlmock.NewRows([]string{"id", "key_prefix", "name", "role", "expires_at"}),
+			wantErr:  model.ErrInvalidAPIKey,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.ExpectQuery(`UPDATE api_keys SET last_used_at = now\(\)`).
+				WithArgs("key-hash").
+				WillReturnRows(tt.mockRows)
+
+			res, err := repo.UseAPIKey(context.Background(), "key-hash")
+
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, "erp-sync", res.Name)
+				require.Equal(t, "manager", res.Role)
+			}
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	repo, mock := newMockRepo(t)
+
+	cases := []struct {
+		name         string
+		mockAffected int
+		wantErr      error
+	}{
+		{
+			name:         "Positive case - key revoked",
+			mockAffected: 1,
+		},
+		{
+			name:         "Negative case - key not found or already revoked",
+			mockAffected: 0,
+			wantErr:      model.ErrAPIKeyNotFound,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.ExpectExec(`UPDATE api_keys SET revoked_at = now\(\)`).
+				WithArgs(5).
+				WillReturnResult(sqlmock.NewResult(0, int64(tt.mockAffected)))
+
+			err := repo.RevokeAPIKey(context.Background(), 5)
+
+			require.ErrorIs(t, err, tt.wantErr)
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestUseTOTPStep(t *testing.T) {
+	repo, mock := newMockRepo(t)
+
+	cases := []struct {
+		name         string
+		mockAffected int
+		wantErr      error
+	}{
+		{
+			name:         "Positive case - newer interval accepted",
+			mockAffected: 1,
+		},
+		{
+			name:         "Negative case - interval already used",
+			mockAffected: 0,
+			wantErr:      model.ErrInvalidOTP,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.ExpectExec(`UPDATE user_totp SET last_step = \$2\s+WHERE user_id = \$1 AND confirmed_at IS NOT NULL AND last_step < \$2`).
+				WithArgs(5, int64(1000)).
+				WillReturnResult(sqlmock.NewResult(0, int64(tt.mockAffected)))
+
+			err := repo.UseTOTPStep(context.Background(), 5, 1000)
+
+			require.ErrorIs(t, err, tt.wantErr)
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestConfirmTOTP(t *testing.T) {
+	repo, mock := newMockRepo(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE user_totp SET confirmed_at = now\(\), last_step = \$2`).
+		WithArgs(5, int64(1000)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM recovery_codes WHERE user_id = \$1`).
+		WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`INSERT INTO recovery_codes`).
+		WithArgs(5, "hash-1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`INSERT INTO recovery_codes`).
+		WithArgs(5, "hash-2").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	err := repo.ConfirmTOTP(context.Background(), 5, 1000, []string{"hash-1", "hash-2"})
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetLoginFailures(t *testing.T) {
+	repo, mock := newMockRepo(t)
+
+	mock.ExpectQuery(`SELECT\s+count\(\*\) FILTER`).
+		WithArgs("somename", "10.0.0.1", float64(900), model.LoginFailLocked).
+		WillReturnRows(sqlmock.NewRows([]string{"by_user", "by_ip"}).AddRow(3, 7))
+
+	res, err := repo.GetLoginFailures(context.Background(), "somename", "10.0.0.1", 15*time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, &model.LoginFailures{ByUser: 3, ByIP: 7}, res)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetLoginAttempts(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	username := "somename"
+	success := false
+
+	// значения фильтров уходят параметрами, а не в текст запроса
+	mock.ExpectQuery(`FROM login_attempts\s+WHERE true AND username = \$1 AND success = \$2 ORDER BY attempted_at DESC`).
+		WithArgs(username, success).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "ip", "success", "reason", "attempted_at"}).
+			AddRow(1, username, "10.0.0.1", false, model.LoginFailBadPassword, time.Now()))
+
+	res, err := repo.GetLoginAttempts(context.Background(), &model.RequestParam{},
+		&model.LoginAttemptFilter{Username: &username, Success: &success})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	require.Equal(t, model.LoginFailBadPassword, *res[0].Reason)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetAuthEvents(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	eventType := model.AuthEventDenied
+	ip := "10.0.0.1"
+
+	// значения фильтров уходят параметрами, а не в текст запроса
+	mock.ExpectQuery(`FROM auth_events\s+WHERE true AND event_type = \$1 AND ip = \$2 ORDER BY created_at DESC`).
+		WithArgs(eventType, ip).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "event_type", "user_id", "username", "role", "status", "detail",
+			"method", "path", "request_id", "ip", "user_agent", "created_at"}).
+			AddRow(1, eventType, 0, "", "", 401, "no access token", "GET", "/items", "rid", ip, "curl/8.0", time.Now()))
+
+	res, err := repo.GetAuthEvents(context.Background(), &model.RequestParam{},
+		&model.AuthEventFilter{Type: &eventType, IP: &ip})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	require.Equal(t, 401, res[0].Status)
+	require.Equal(t, "/items", res[0].Path)
+	require.NoError(t, mock.ExpectationsWereMet())
 }
 
 func TestCreateItem(t *testing.T) {
@@ -209,6 +861,7 @@ func TestCreateItem(t *testing.T) {
 
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
+			mock.ExpectBegin()
 			exp := mock.ExpectQuery(`INSERT INTO items`).
 				WithArgs(tt.arg.Title,
 					tt.arg.Description,
@@ -219,8 +872,10 @@ func TestCreateItem(t *testing.T) {
 
 			if tt.mockRows != nil {
 				exp.WillReturnRows(tt.mockRows)
+				mock.ExpectCommit()
 			} else {
 				exp.WillReturnError(tt.mockErr)
+				mock.ExpectRollback()
 			}
 
 			err := repo.CreateItem(context.Background(), tt.arg)
@@ -262,6 +917,14 @@ func TestDeleteItem(t *testing.T) {
 			wantErr:      model.ErrItemNotFound,
 			mockAffected: 0,
 		},
+		{
+			name:         "Negative case - item is already deleted",
+			itemID:       5,
+			username:     "otherName",
+			mockErr:      nil,
+			wantErr:      model.ErrItemNotFound,
+			mockAffected: 0,
+		},
 		{
 			name:         "Negative case - some DB error",
 			itemID:       5,
@@ -274,7 +937,7 @@ func TestDeleteItem(t *testing.T) {
 
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
-			exp := mock.ExpectExec(`UPDATE items SET deleted_at`).
+			exp := mock.ExpectExec(`UPDATE items SET deleted_at = NOW\(\), updated_by = \$2 WHERE id = \$1 AND deleted_at IS NULL`).
 				WithArgs(tt.itemID, tt.username)
 
 			if tt.mockErr != nil {
@@ -283,13 +946,274 @@ func TestDeleteItem(t *testing.T) {
 				exp.WillReturnResult(sqlmock.NewResult(0, int64(tt.mockAffected)))
 			}
 
-			err := repo.DeleteItem(context.Background(), tt.itemID, tt.username)
+			err := repo.DeleteItem(context.Background(), tt.itemID, nil, tt.username)
+
+			require.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRestoreItem(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	deletedAt := time.Now()
+
+	cases := []struct {
+		name       string
+		lockRows   *sqlmock.Rows
+		restored   bool
+		wantErr    error
+		wantCommit bool
+	}{
+		{
+			name:       "Positive case - deleted item restored",
+			lockRows:   sqlmock.NewRows([]string{"deleted_at"}).AddRow(deletedAt),
+			restored:   true,
+			wantErr:    nil,
+			wantCommit: true,
+		},
+		{
+			name:     "Negative case - item not found",
+			lockRows: sqlmock.NewRows([]string{"deleted_at"}),
+			wantErr:  model.ErrItemNotFound,
+		},
+		{
+			name:     "Negative case - item is not deleted",
+			lockRows: sqlmock.NewRows([]string{"deleted_at"}).AddRow(nil),
+			wantErr:  model.ErrItemNotDeleted,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT deleted_at FROM items WHERE id = \$1 FOR UPDATE`).
+				WithArgs(5).
+				WillReturnRows(tt.lockRows)
+			if tt.restored {
+				mock.ExpectExec(`UPDATE items SET deleted_at = NULL, updated_by = \$2 WHERE id = \$1`).
+					WithArgs(5, "admin").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			if tt.wantCommit {
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			err := repo.RestoreItem(context.Background(), 5, "admin")
 
 			require.ErrorIs(t, err, tt.wantErr)
+			require.NoError(t, mock.ExpectationsWereMet())
 		})
 	}
 }
 
+func TestPurgeItem(t *testing.T) {
+	repo, mock := newMockRepo(t)
+
+	t.Run("Positive case - deleted item purged on behalf of admin", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT deleted_at FROM items WHERE id = \$1 FOR UPDATE`).
+			WithArgs(5).
+			WillReturnRows(sqlmock.NewRows([]string{"deleted_at"}).AddRow(time.Now()))
+		mock.ExpectExec(`SELECT set_config\('whc.changed_by', \$1, true\)`).
+			WithArgs("admin").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`DELETE FROM items WHERE id = \$1`).
+			WithArgs(5).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := repo.PurgeItem(context.Background(), 5, "admin")
+
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Negative case - item is not in trash", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT deleted_at FROM items WHERE id = \$1 FOR UPDATE`).
+			WithArgs(5).
+			WillReturnRows(sqlmock.NewRows([]string{"deleted_at"}).AddRow(nil))
+		mock.ExpectRollback()
+
+		err := repo.PurgeItem(context.Background(), 5, "admin")
+
+		require.ErrorIs(t, err, model.ErrItemNotDeleted)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPurgeDeletedItems(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	timeNow := time.Now()
+	before := timeNow.AddDate(0, 0, -30)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT set_config\('whc.changed_by', \$1, true\)`).
+		WithArgs(model.PurgeJobActor).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`DELETE FROM items WHERE deleted_at IS NOT NULL AND deleted_at < \$1 RETURNING id, .+, deleted_at`).
+		WithArgs(before).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "price", "visible", "available_amount", "created_at", "updated_at", "deleted_at"}).
+			AddRow(1, "old", "description", 100500, true, 0, timeNow, timeNow, before.Add(-time.Hour)).
+			AddRow(2, "older", "description", 100500, true, 0, timeNow, timeNow, before.Add(-48*time.Hour)))
+	mock.ExpectCommit()
+
+	res, err := repo.PurgeDeletedItems(context.Background(), before, model.PurgeJobActor)
+
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetDeletedItems(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	timeNow := time.Now()
+	limit := 10
+
+	mock.ExpectQuery(`SELECT id, .+, deleted_at, \(SELECT changed_by FROM items_history WHERE item_id = items.id AND action = 'SOFT DELETE' ORDER BY version DESC LIMIT 1\) AS deleted_by, .+ FROM items WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC, id DESC LIMIT 10`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "price", "visible", "available_amount", "created_at", "updated_at", "deleted_at", "deleted_by", "version"}).
+			AddRow(1, "title", "description", 100500, true, 4, timeNow, timeNow, timeNow, "manager", 3))
+
+	res, err := repo.GetDeletedItems(context.Background(), &model.RequestParam{Limit: &limit})
+
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	require.Equal(t, "manager", *res[0].DeletedBy)
+	require.NotNil(t, res[0].DeletedAt)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestItemVersionCheck(t *testing.T) {
+	repo, mock := newMockRepo(t)
+
+	expectVersion := func(itemID, current int) {
+		mock.ExpectQuery(`SELECT id FROM items WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).
+			WithArgs(itemID).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(itemID))
+		mock.ExpectQuery(`SELECT COALESCE\(MAX\(version\), 0\) FROM items_history WHERE item_id = \$1`).
+			WithArgs(itemID).
+			WillReturnRows(sqlmock.NewRows([]string{"coalesce"}).AddRow(current))
+	}
+
+	t.Run("Positive case - update with matching version", func(t *testing.T) {
+		title := "new title"
+		version := 3
+
+		mock.ExpectBegin()
+		expectVersion(1, 3)
+		mock.ExpectExec(`UPDATE items SET title = \$2, updated_by = \$3 WHERE id = \$1 AND deleted_at IS NULL`).
+			WithArgs(1, title, "clerk").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := repo.UpdateItem(context.Background(), &model.ItemUpdate{ID: 1, Title: &title, UpdatedBy: "clerk", Version: &version}, false)
+
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Negative case - update with stale version", func(t *testing.T) {
+		title := "new title"
+		version := 2
+
+		mock.ExpectBegin()
+		expectVersion(1, 3)
+		mock.ExpectRollback()
+
+		err := repo.UpdateItem(context.Background(), &model.ItemUpdate{ID: 1, Title: &title, UpdatedBy: "clerk", Version: &version}, false)
+
+		require.ErrorIs(t, err, model.ErrVersionMismatch)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Negative case - delete with stale version", func(t *testing.T) {
+		version := 5
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT id FROM items WHERE id = \$1 FOR UPDATE`).
+			WithArgs(7).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+		mock.ExpectQuery(`SELECT COALESCE\(MAX\(version\), 0\) FROM items_history WHERE item_id = \$1`).
+			WithArgs(7).
+			WillReturnRows(sqlmock.NewRows([]string{"coalesce"}).AddRow(6))
+		mock.ExpectRollback()
+
+		err := repo.DeleteItem(context.Background(), 7, &version, "clerk")
+
+		require.ErrorIs(t, err, model.ErrVersionMismatch)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Negative case - repeated delete with current version", func(t *testing.T) {
+		version := 6
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT id FROM items WHERE id = \$1 FOR UPDATE`).
+			WithArgs(7).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+		mock.ExpectQuery(`SELECT COALESCE\(MAX\(version\), 0\) FROM items_history WHERE item_id = \$1`).
+			WithArgs(7).
+			WillReturnRows(sqlmock.NewRows([]string{"coalesce"}).AddRow(6))
+		mock.ExpectExec(`UPDATE items SET deleted_at = NOW\(\), updated_by = \$2 WHERE id = \$1 AND deleted_at IS NULL`).
+			WithArgs(7, "clerk").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		err := repo.DeleteItem(context.Background(), 7, &version, "clerk")
+
+		require.ErrorIs(t, err, model.ErrItemNotFound)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestRevertItem(t *testing.T) {
+	repo, mock := newMockRepo(t)
+
+	t.Run("Positive case - revert version is passed to history trigger", func(t *testing.T) {
+		title := "old title"
+		revertOf := 3
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`SELECT set_config\('whc.revert_version', \$1, true\)`).
+			WithArgs("3").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE items SET title = \$2, updated_by = \$3 WHERE id = \$1 AND deleted_at IS NULL`).
+			WithArgs(1, title, "admin").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := repo.UpdateItem(context.Background(), &model.ItemUpdate{ID: 1, Title: &title, UpdatedBy: "admin", RevertOf: &revertOf}, false)
+
+		require.NoError(t, err)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Positive case - current item snapshot", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT to_jsonb\(items\) FROM items WHERE id = \$1 AND deleted_at IS NULL`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"to_jsonb"}).AddRow([]byte(`{"id": 1}`)))
+
+		res, err := repo.GetItemSnapshot(context.Background(), 1)
+
+		require.NoError(t, err)
+		require.JSONEq(t, `{"id": 1}`, string(*res))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Negative case - snapshot of deleted item", func(t *testing.T) {
+		mock.ExpectQuery(`SELECT to_jsonb\(items\) FROM items`).
+			WithArgs(2).
+			WillReturnError(sql.ErrNoRows)
+
+		_, err := repo.GetItemSnapshot(context.Background(), 2)
+
+		require.ErrorIs(t, err, model.ErrItemNotFound)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
 func TestUpdateItem(t *testing.T) {
 	repo, mock := newMockRepo(t)
 	someErr := errors.New("some error")
@@ -383,6 +1307,306 @@ func TestUpdateItem(t *testing.T) {
 	}
 }
 
+func TestCreateItemWithWarehouse(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	timeNow := time.Now()
+	warehouseID := 2
+
+	cases := []struct {
+		name    string
+		exists  bool
+		wantErr error
+	}{
+		{
+			name:   "Positive case - item created with initial stock",
+			exists: true,
+		},
+		{
+			name:    "Negative case - warehouse not found",
+			exists:  false,
+			wantErr: model.ErrWarehouseNotFound,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			item := &model.Item{Title: "title", Price: 100, Visible: true, AvailableAmount: 7, UpdatedBy: "someone", WarehouseID: &warehouseID}
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM warehouses`).
+				WithArgs(warehouseID).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tt.exists))
+			if tt.wantErr != nil {
+				mock.ExpectRollback()
+			} else {
+				mock.ExpectExec(`SELECT set_config\('whc.warehouse_id'`).
+					WithArgs("2").
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(`INSERT INTO items`).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(10, timeNow, timeNow))
+				mock.ExpectExec(`INSERT INTO item_stock`).
+					WithArgs(10, warehouseID, 7, "someone").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				// начальный остаток приходуется в приемку через журнал движений
+				mock.ExpectQuery(`SELECT r.id, .+ FROM locations r WHERE r.warehouse_id = \$2 AND r.code = \$3`).
+					WithArgs(10, warehouseID, model.ReceivingLocationCode).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "placed", "received"}).AddRow(3, 0, 0))
+				expectMovement(mock, 10, 3, 7, 1)
+				mock.ExpectCommit()
+			}
+
+			err := repo.CreateItem(context.Background(), item)
+
+			require.ErrorIs(t, err, tt.wantErr)
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestUpdateItemStock(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	amount := 15
+	warehouseID := 2
+
+	cases := []struct {
+		name          string
+		stockAffected int
+		placed        int
+		wantErr       error
+	}{
+		{
+			name:          "Positive case - stock set and total recalculated",
+			stockAffected: 1,
+			placed:        10,
+		},
+		{
+			name:          "Negative case - item not found",
+			stockAffected: 0,
+			wantErr:       model.ErrItemNotFound,
+		},
+		{
+			name:          "Negative case - more stock already placed in bins",
+			stockAffected: 1,
+			placed:        20,
+			wantErr:       model.ErrStockPlacedInBins,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM warehouses`).
+				WithArgs(warehouseID).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			mock.ExpectExec(`SELECT set_config\('whc.warehouse_id'`).
+				WithArgs("2").
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(`INSERT INTO item_stock .+ FROM items WHERE id = \$1 AND deleted_at IS NULL ON CONFLICT`).
+				WithArgs(1, warehouseID, amount, "someone").
+				WillReturnResult(sqlmock.NewResult(0, int64(tt.stockAffected)))
+			if tt.stockAffected > 0 {
+				mock.ExpectQuery(`SELECT r.id, .+ FROM locations r WHERE r.warehouse_id = \$2 AND r.code = \$3`).
+					WithArgs(1, warehouseID, model.ReceivingLocationCode).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "placed", "received"}).AddRow(3, tt.placed, 4))
+			}
+			if tt.wantErr != nil {
+				mock.ExpectRollback()
+			} else {
+				// в приемке остается то, что не размещено по ячейкам хранения, разница проводится корректировкой
+				expectMovement(mock, 1, 3, amount-tt.placed-4, 1)
+				mock.ExpectExec(`UPDATE items SET available_amount = \(SELECT COALESCE\(SUM\(amount\), 0\) FROM item_stock WHERE item_id = \$1\), updated_by = \$2`).
+					WithArgs(1, "someone").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			}
+
+			err := repo.UpdateItem(context.Background(), &model.ItemUpdate{ID: 1, AvailableAmount: &amount, WarehouseID: &warehouseID, UpdatedBy: "someone"}, false)
+
+			require.ErrorIs(t, err, tt.wantErr)
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestMoveItemStock(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	from := 5
+
+	cases := []struct {
+		name         string
+		itemFound    bool
+		fromAffected int
+		wantErr      error
+	}{
+		{
+			name:         "Positive case - stock moved and logged",
+			itemFound:    true,
+			fromAffected: 1,
+		},
+		{
+			name:      "Negative case - item not found",
+			itemFound: false,
+			wantErr:   model.ErrItemNotFound,
+		},
+		{
+			name:         "Negative case - not enough stock in source bin",
+			itemFound:    true,
+			fromAffected: 0,
+			wantErr:      model.ErrInsufficientStock,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mv := &model.StockMove{ItemID: 1, FromLocationID: &from, ToLocationID: 7, Amount: 3, WarehouseID: 2, Action: model.HistoryActionMove, UpdatedBy: "picker"}
+
+			mock.ExpectBegin()
+			rows := sqlmock.NewRows([]string{"id"})
+			if tt.itemFound {
+				rows.AddRow(1)
+			}
+			mock.ExpectQuery(`SELECT id FROM items WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).
+				WithArgs(1).
+				WillReturnRows(rows)
+			if tt.itemFound {
+				mock.ExpectExec(`UPDATE location_stock SET amount = amount \+ \$3`).
+					WithArgs(1, from, -3, "picker").
+					WillReturnResult(sqlmock.NewResult(0, int64(tt.fromAffected)))
+			}
+			if tt.wantErr != nil {
+				mock.ExpectRollback()
+			} else {
+				mock.ExpectQuery(`INSERT INTO stock_movements`).
+					WithArgs(1, model.MovementTransfer, 2, from, -3, model.HistoryActionMove, nil, nil, nil, "picker").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(41, time.Now()))
+				expectMovement(mock, 1, 7, 3, 42)
+				mock.ExpectExec(`INSERT INTO items_history`).
+					WithArgs(1, model.HistoryActionMove, from, 7, 3, "picker", 2).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			}
+
+			err := repo.MoveItemStock(context.Background(), mv)
+
+			require.ErrorIs(t, err, tt.wantErr)
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestGetItemsListByWarehouse(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	timeNow := time.Now()
+	warehouseID := 2
+
+	mock.ExpectQuery(`SELECT items.id, .+, s.amount AS available_amount, .+ FROM items JOIN item_stock s ON s.item_id = items.id AND s.warehouse_id = \$1 AND s.amount > 0 WHERE items.deleted_at IS NULL`).
+		WithArgs(warehouseID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "description", "price", "visible", "amount", "created_at", "updated_at", "deleted_at", "version"}).
+			AddRow(1, "title", "description", 100500, true, 4, timeNow, timeNow, nil, 2))
+
+	res, err := repo.GetItemsList(context.Background(), &model.RequestParam{}, &model.ItemFilter{WarehouseID: &warehouseID}, false)
+
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	require.Equal(t, 4, res[0].AvailableAmount)
+}
+
+func TestGetItemsListAsOf(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	timeNow := time.Now()
+	asOf := time.Date(2026, 12, 31, 23, 59, 59, 0, time.UTC)
+	orderBy := model.ItemsOrderByTitle
+	columns := []string{"id", "title", "description", "price", "visible", "available_amount", "created_at", "updated_at", "deleted_at", "version"}
+	asOfQuery := `SELECT DISTINCT ON \(item_id\) item_id, version, new_data FROM items_history ` +
+		`WHERE changed_at <= \$1 AND action NOT IN \('PUTAWAY', 'MOVE'\) ORDER BY item_id, version DESC \) h ` +
+		`WHERE h.new_data IS NOT NULL \) items WHERE items.deleted_at IS NULL +ORDER BY title ASC +LIMIT 10 OFFSET 0`
+	rp := &model.RequestParam{OrderBy: &orderBy, ASC: true, Page: ptrMaker(1), Limit: ptrMaker(10)}
+
+	t.Run("Positive case - items rebuilt from latest snapshots", func(t *testing.T) {
+		mock.ExpectQuery(asOfQuery).
+			WithArgs(asOf).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(1, "title", "", 100500, true, 7, timeNow, timeNow, nil, 4))
+
+		res, err := repo.GetItemsList(context.Background(), rp, &model.ItemFilter{AsOf: &asOf}, false)
+
+		require.NoError(t, err)
+		require.Len(t, res, 1)
+		require.Equal(t, 7, res[0].AvailableAmount)
+		require.Equal(t, 4, res[0].Version)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Positive case - latest MOVE before as_of is skipped for previous snapshot", func(t *testing.T) {
+		// версия 5 - MOVE без снимка товара, поэтому товар строится по версии 4
+		mock.ExpectQuery(asOfQuery).
+			WithArgs(asOf).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(2, "crate", "wooden", 300, true, 12, timeNow, timeNow, nil, 4))
+
+		res, err := repo.GetItemsList(context.Background(), rp, &model.ItemFilter{AsOf: &asOf}, false)
+
+		require.NoError(t, err)
+		require.Len(t, res, 1)
+		require.Equal(t, "crate", res[0].Title)
+		require.Equal(t, int64(300), res[0].Price)
+		require.Equal(t, 4, res[0].Version)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGrantUserWarehouse(t *testing.T) {
+	repo, mock := newMockRepo(t)
+
+	cases := []struct {
+		name         string
+		exists       bool
+		mockAffected int
+		wantErr      error
+	}{
+		{
+			name:         "Positive case - warehouse granted",
+			exists:       true,
+			mockAffected: 1,
+		},
+		{
+			name:    "Negative case - warehouse not found",
+			exists:  false,
+			wantErr: model.ErrWarehouseNotFound,
+		},
+		{
+			name:         "Negative case - user not found",
+			exists:       true,
+			mockAffected: 0,
+			wantErr:      model.ErrUserNotFound,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM warehouses`).
+				WithArgs(2).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tt.exists))
+			if tt.exists {
+				mock.ExpectExec(`INSERT INTO user_warehouses`).
+					WithArgs(5, 2, "admin").
+					WillReturnResult(sqlmock.NewResult(0, int64(tt.mockAffected)))
+			}
+			if tt.wantErr != nil {
+				mock.ExpectRollback()
+			} else {
+				mock.ExpectCommit()
+			}
+
+			err := repo.GrantUserWarehouse(context.Background(), 5, 2, "admin")
+
+			require.ErrorIs(t, err, tt.wantErr)
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
 func TestGetItemByID(t *testing.T) {
 	repo, mock := newMockRepo(t)
 
@@ -403,8 +1627,8 @@ func TestGetItemByID(t *testing.T) {
 			name:       "Positive case - itemID found",
 			arg:        1,
 			permission: true,
-			mockRows: sqlmock.NewRows([]string{"id", "title", "description", "price", "visible", "available_amount", "created_at", "updated_at", "deleted_at"}).
-				AddRow(1, "title", "description", 100500, true, 300, timeNow, timeNow, nil),
+			mockRows: sqlmock.NewRows([]string{"id", "title", "description", "price", "visible", "available_amount", "created_at", "updated_at", "deleted_at", "version"}).
+				AddRow(1, "title", "description", 100500, true, 300, timeNow, timeNow, nil, 4),
 			mockErr: nil,
 			wantErr: nil,
 			wantItem: &model.Item{
@@ -417,6 +1641,7 @@ func TestGetItemByID(t *testing.T) {
 				CreatedAt:       timeNow,
 				UpdatedAt:       timeNow,
 				DeletedAt:       nil,
+				Version:         4,
 			},
 		},
 		{
@@ -450,7 +1675,7 @@ func TestGetItemByID(t *testing.T) {
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
 			exp := mock.ExpectQuery(
-				`SELECT id, title, description, price, visible, available_amount, created_at, updated_at, deleted_at FROM items	WHERE id =`,
+				`SELECT id, title, description, price, visible, available_amount, created_at, updated_at, deleted_at, \(SELECT COALESCE\(MAX\(version\), 0\) FROM items_history WHERE item_id = items.id\) AS version FROM items	WHERE id =`,
 			).WithArgs(tt.arg)
 
 			if tt.mockRows != nil {
@@ -487,8 +1712,8 @@ func TestGetItemsList(t *testing.T) {
 			name:       "Positive case - array of 1 item",
 			arg:        &model.RequestParam{},
 			permission: true,
-			mockRows: sqlmock.NewRows([]string{"id", "title", "description", "price", "visible", "available_amount", "created_at", "updated_at", "deleted_at"}).
-				AddRow(1, "title", "description", 100500, true, 300, timeNow, timeNow, nil),
+			mockRows: sqlmock.NewRows([]string{"id", "title", "description", "price", "visible", "available_amount", "created_at", "updated_at", "deleted_at", "version"}).
+				AddRow(1, "title", "description", 100500, true, 300, timeNow, timeNow, nil, 4),
 			mockErr: nil,
 			wantErr: nil,
 			wantResult: []*model.Item{{
@@ -501,6 +1726,7 @@ func TestGetItemsList(t *testing.T) {
 				CreatedAt:       timeNow,
 				UpdatedAt:       timeNow,
 				DeletedAt:       nil,
+				Version:         4,
 			}},
 		},
 		{
@@ -533,7 +1759,7 @@ func TestGetItemsList(t *testing.T) {
 
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
-			exp := mock.ExpectQuery(`SELECT id, title, description, price, visible, available_amount, created_at, updated_at, deleted_at FROM items`)
+			exp := mock.ExpectQuery(`SELECT id, title, description, price, visible, available_amount, created_at, updated_at, deleted_at, \(SELECT COALESCE\(MAX\(version\), 0\) FROM items_history WHERE item_id = items.id\) AS version FROM items`)
 
 			if tt.mockRows != nil {
 				exp.WillReturnRows(tt.mockRows)
@@ -541,7 +1767,7 @@ func TestGetItemsList(t *testing.T) {
 				exp.WillReturnError(tt.mockErr)
 			}
 
-			res, err := repo.GetItemsList(context.Background(), tt.arg, tt.permission)
+			res, err := repo.GetItemsList(context.Background(), tt.arg, &model.ItemFilter{}, tt.permission)
 
 			if tt.wantErr != nil {
 				require.ErrorIs(t, err, tt.wantErr)
@@ -556,6 +1782,7 @@ func TestGetItemHistoryByID(t *testing.T) {
 	repo, mock := newMockRepo(t)
 	dbError := errors.New("DB error. Try later")
 	timeNow := time.Now()
+	mainWarehouse := 1
 
 	cases := []struct {
 		name       string
@@ -570,16 +1797,18 @@ func TestGetItemHistoryByID(t *testing.T) {
 			name:   "Positive case - array of 2 histories",
 			arg:    &model.RequestParam{},
 			itemID: 1,
-			mockRows: sqlmock.NewRows([]string{"id", "item_id", "version", "action", "changed_at", "changed_by", "old_data", "new_data"}).
-				AddRow(1, 1, 2, "UPDATE", timeNow, "someone", json.RawMessage("some old data"), json.RawMessage("some new data")).
-				AddRow(2, 1, 3, "DELETE", timeNow, "elseone", json.RawMessage("some old data"), json.RawMessage("some new data")),
+			mockRows: sqlmock.NewRows([]string{"id", "item_id", "version", "action", "changed_at", "changed_by", "old_data", "new_data", "warehouse_id", "stock_delta", "reverted_from"}).
+				AddRow(1, 1, 2, "UPDATE", timeNow, "someone", json.RawMessage("some old data"), json.RawMessage("some new data"), 1, -3, nil).
+				AddRow(2, 1, 3, "DELETE", timeNow, "elseone", json.RawMessage("some old data"), json.RawMessage("some new data"), nil, nil, nil),
 			mockErr: nil,
 			wantErr: nil,
 			wantResult: []*model.ItemHistory{{
 				ID: 1, ItemID: 1, Version: 2, Action: "UPDATE",
 				ChangedAt: timeNow, ChangedBy: "someone",
-				OldData: jsonPtrMaker(json.RawMessage("some old data")),
-				NewData: jsonPtrMaker(json.RawMessage("some new data")),
+				OldData:     jsonPtrMaker(json.RawMessage("some old data")),
+				NewData:     jsonPtrMaker(json.RawMessage("some new data")),
+				WarehouseID: &mainWarehouse,
+				StockDelta:  ptrMaker(-3),
 			}, {
 				ID: 2, ItemID: 1, Version: 3, Action: "DELETE",
 				ChangedAt: timeNow, ChangedBy: "elseone",
@@ -609,7 +1838,7 @@ func TestGetItemHistoryByID(t *testing.T) {
 
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
-			exp := mock.ExpectQuery(`SELECT id, item_id, version, action, changed_at, changed_by, old_data, new_data 
+			exp := mock.ExpectQuery(`SELECT id, item_id, version, action, changed_at, changed_by, old_data, new_data, warehouse_id, stock_delta, reverted_from 
 			FROM items_history 
 			WHERE item_id =`)
 
@@ -619,7 +1848,7 @@ func TestGetItemHistoryByID(t *testing.T) {
 				exp.WillReturnError(tt.mockErr)
 			}
 
-			res, err := repo.GetItemHistoryByID(context.Background(), tt.arg, tt.itemID)
+			res, err := repo.GetItemHistoryByID(context.Background(), tt.arg, &model.HistoryFilter{}, tt.itemID)
 
 			if tt.wantErr != nil {
 				require.ErrorIs(t, err, tt.wantErr)
@@ -636,6 +1865,7 @@ func TestGetItemHistoryAll(t *testing.T) {
 	dbError := errors.New("DB error. Try later")
 
 	timeNow := time.Now()
+	mainWarehouse := 1
 
 	cases := []struct {
 		name       string
@@ -648,16 +1878,18 @@ func TestGetItemHistoryAll(t *testing.T) {
 		{
 			name: "Positive case - array of 2 histories",
 			arg:  &model.RequestParam{},
-			mockRows: sqlmock.NewRows([]string{"id", "item_id", "version", "action", "changed_at", "changed_by", "old_data", "new_data"}).
-				AddRow(1, 1, 2, "UPDATE", timeNow, "someone", json.RawMessage("some old data"), json.RawMessage("some new data")).
-				AddRow(2, 2, 3, "DELETE", timeNow, "elseone", json.RawMessage("some old data"), json.RawMessage("some new data")),
+			mockRows: sqlmock.NewRows([]string{"id", "item_id", "version", "action", "changed_at", "changed_by", "old_data", "new_data", "warehouse_id", "stock_delta", "reverted_from"}).
+				AddRow(1, 1, 2, "UPDATE", timeNow, "someone", json.RawMessage("some old data"), json.RawMessage("some new data"), 1, -3, nil).
+				AddRow(2, 2, 3, "DELETE", timeNow, "elseone", json.RawMessage("some old data"), json.RawMessage("some new data"), nil, nil, nil),
 			mockErr: nil,
 			wantErr: nil,
 			wantResult: []*model.ItemHistory{{
 				ID: 1, ItemID: 1, Version: 2, Action: "UPDATE",
 				ChangedAt: timeNow, ChangedBy: "someone",
-				OldData: jsonPtrMaker(json.RawMessage("some old data")),
-				NewData: jsonPtrMaker(json.RawMessage("some new data")),
+				OldData:     jsonPtrMaker(json.RawMessage("some old data")),
+				NewData:     jsonPtrMaker(json.RawMessage("some new data")),
+				WarehouseID: &mainWarehouse,
+				StockDelta:  ptrMaker(-3),
 			}, {
 				ID: 2, ItemID: 2, Version: 3, Action: "DELETE",
 				ChangedAt: timeNow, ChangedBy: "elseone",
@@ -685,7 +1917,7 @@ func TestGetItemHistoryAll(t *testing.T) {
 
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
-			exp := mock.ExpectQuery(`SELECT id, item_id, version, action, changed_at, changed_by, old_data, new_data 
+			exp := mock.ExpectQuery(`SELECT id, item_id, version, action, changed_at, changed_by, old_data, new_data, warehouse_id, stock_delta, reverted_from 
 			FROM items_history`)
 
 			if tt.mockRows != nil {
@@ -694,7 +1926,7 @@ func TestGetItemHistoryAll(t *testing.T) {
 				exp.WillReturnError(tt.mockErr)
 			}
 
-			res, err := repo.GetItemHistoryAll(context.Background(), tt.arg)
+			res, err := repo.GetItemHistoryAll(context.Background(), tt.arg, &model.HistoryFilter{})
 
 			if tt.wantErr != nil {
 				require.ErrorIs(t, err, tt.wantErr)
@@ -706,20 +1938,43 @@ func TestGetItemHistoryAll(t *testing.T) {
 }
 
 // ==================== TOOLS TABLE TESTS ======================
+func TestGetItemHistoryVersions(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	timeNow := time.Now()
+
+	mock.ExpectQuery(`SELECT id, item_id, version, .+ FROM items_history WHERE item_id = \$1 AND version IN \(\$2, \$3\) ORDER BY version`).
+		WithArgs(1, 3, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "item_id", "version", "action", "changed_at", "changed_by", "old_data", "new_data", "warehouse_id", "stock_delta", "reverted_from"}).
+			AddRow(10, 1, 3, "UPDATE", timeNow, "manager", []byte(`{}`), []byte(`{"title":"Bolt"}`), nil, nil, nil).
+			AddRow(14, 1, 7, "UPDATE", timeNow, "manager", []byte(`{}`), []byte(`{"title":"Bolt M8"}`), nil, nil, nil))
+
+	res, err := repo.GetItemHistoryVersions(context.Background(), 1, 3, 7)
+
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+	require.Equal(t, 3, res[0].Version)
+	require.Equal(t, 7, res[1].Version)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestDefineOrderExpr(t *testing.T) {
 	tests := []struct {
 		inputOrderBy string
 		inputAsc     bool
 		inputDesc    bool
+		allowed      map[string]string
 		wantErr      error
 		wantString   string
 	}{
-		{model.ItemsOrderByTitle, true, false, nil, " ORDER BY title ASC "},
-		{model.HistoryOrderByAction, false, true, nil, " ORDER BY action DESC "},
-		{model.ItemsOrderByID, true, true, nil, " ORDER BY id DESC "},
-		{model.HistoryOrderByActor, false, false, nil, " ORDER BY actor DESC "},
-		{"foobar", true, false, model.ErrInvalidOrderBy, ""},
-		{"", true, false, nil, ""},
+		{model.ItemsOrderByTitle, true, false, model.OrderByItemsMap, nil, " ORDER BY title ASC "},
+		{model.HistoryOrderByAction, false, true, model.OrderByHistoryMap, nil, " ORDER BY action DESC "},
+		{model.ItemsOrderByID, true, true, model.OrderByItemsMap, nil, " ORDER BY id DESC "},
+		{model.HistoryOrderByActor, false, false, model.OrderByHistoryMap, nil, " ORDER BY changed_by DESC "},
+		{model.ItemsOrderByAvailability, true, false, model.OrderByItemsMap, nil, " ORDER BY available_amount ASC "},
+		{model.UsersOrderByRole, true, false, model.OrderByItemsMap, model.ErrInvalidOrderBy, ""},
+		{model.ItemsOrderByPrice, true, false, model.OrderByUsersMap, model.ErrInvalidOrderBy, ""},
+		{"foobar", true, false, model.OrderByItemsMap, model.ErrInvalidOrderBy, ""},
+		{"", true, false, model.OrderByItemsMap, nil, ""},
 	}
 
 	for _, tt := range tests {
@@ -729,7 +1984,7 @@ func TestDefineOrderExpr(t *testing.T) {
 				ptrOrderBy = nil
 			}
 
-			res, err := defineOrderExpr(ptrOrderBy, tt.inputAsc, tt.inputDesc)
+			res, err := defineOrderExpr(ptrOrderBy, tt.inputAsc, tt.inputDesc, tt.allowed)
 			require.ErrorIs(t, err, tt.wantErr)
 			require.Equal(t, tt.wantString, res)
 		})
@@ -875,6 +2130,7 @@ func TestUpdateQueryBuilder(t *testing.T) {
 	price := int64(100500)
 	visible := true
 	availamount := 100500
+	warehouseID := 1
 	updatedby := "user"
 
 	cases := []struct {
@@ -996,11 +2252,21 @@ func TestUpdateQueryBuilder(t *testing.T) {
 				Price:           &price,
 				Visible:         &visible,
 				AvailableAmount: &availamount,
+				WarehouseID:     &warehouseID,
 				UpdatedBy:       updatedby,
 			},
-			wantString:  "SET title = $2, description = $3, price = $4, visible = $5, available_amount = $6, updated_by = $7",
-			wantArgsLen: 6,
+			wantString:  "SET title = $2, description = $3, price = $4, visible = $5, available_amount = (SELECT COALESCE(SUM(amount), 0) FROM item_stock WHERE item_id = $1), updated_by = $6",
+			wantArgsLen: 5,
 			wantErr:     nil,
+		}, {
+			name: "stock without warehouse is not written to items directly",
+			itemUPD: &model.ItemUpdate{
+				AvailableAmount: &availamount,
+				UpdatedBy:       updatedby,
+			},
+			wantString:  "",
+			wantArgsLen: 0,
+			wantErr:     model.ErrNoFieldsToUpdate,
 		},
 	}
 
@@ -1023,3 +2289,303 @@ func TestUpdateQueryBuilder(t *testing.T) {
 func jsonPtrMaker(input json.RawMessage) *json.RawMessage {
 	return &input
 }
+
+func ptrMaker[T int | string | int64 | bool](input T) *T {
+	return &input
+}
+
+func TestGetItemHistoryAllByWarehouse(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	warehouseID := 3
+
+	mock.ExpectQuery(`FROM items_history WHERE TRUE AND warehouse_id = \$1 ORDER BY id DESC`).
+		WithArgs(warehouseID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "item_id", "version", "action", "changed_at", "changed_by", "old_data", "new_data", "warehouse_id", "stock_delta", "reverted_from"}))
+
+	orderBy := "id"
+	res, err := repo.GetItemHistoryAll(context.Background(), &model.RequestParam{OrderBy: &orderBy}, &model.HistoryFilter{WarehouseID: &warehouseID})
+
+	require.NoError(t, err)
+	require.Empty(t, res)
+}
+
+func TestGetItemHistoryAllFilters(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	warehouseID := 3
+
+	mock.ExpectQuery(`FROM items_history WHERE TRUE AND warehouse_id = \$1 AND changed_by IN \(\$2, \$3\) AND action IN \(\$4\) `+
+		`AND item_id IN \(\$5, \$6\) AND \(old_data -> \$7::text IS DISTINCT FROM new_data -> \$7::text `+
+		`OR old_data -> \$8::text IS DISTINCT FROM new_data -> \$8::text\)$`).
+		WithArgs(warehouseID, "alice", "bob", "UPDATE", 1, 2, "price", "title").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "item_id", "version", "action", "changed_at", "changed_by", "old_data", "new_data", "warehouse_id", "stock_delta", "reverted_from"}))
+
+	res, err := repo.GetItemHistoryAll(context.Background(), &model.RequestParam{}, &model.HistoryFilter{
+		WarehouseID: &warehouseID,
+		ChangedBy:   []string{"alice", "bob"},
+		Action:      []string{"UPDATE"},
+		ItemID:      []int{1, 2},
+		Field:       []string{"price", "title"},
+	})
+
+	require.NoError(t, err)
+	require.Empty(t, res)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetItemHistoryByIDFilters(t *testing.T) {
+	repo, mock := newMockRepo(t)
+
+	mock.ExpectQuery(`FROM items_history WHERE item_id = \$1 AND changed_by IN \(\$2\) AND action IN \(\$3, \$4\) `+
+		`AND \(old_data -> \$5::text IS DISTINCT FROM new_data -> \$5::text\)$`).
+		WithArgs(7, "alice", "UPDATE", "REVERT", "price").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "item_id", "version", "action", "changed_at", "changed_by", "old_data", "new_data", "warehouse_id", "stock_delta", "reverted_from"}))
+
+	res, err := repo.GetItemHistoryByID(context.Background(), &model.RequestParam{}, &model.HistoryFilter{
+		ChangedBy: []string{"alice"},
+		Action:    []string{"UPDATE", "REVERT"},
+		Field:     []string{"price"},
+	}, 7)
+
+	require.NoError(t, err)
+	require.Empty(t, res)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateMovement(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	mainWarehouse, northWarehouse := 1, 2
+	mainBin, northBin := 3, 8
+
+	t.Run("Positive case - issue changes stock and item total", func(t *testing.T) {
+		m := &model.Movement{ItemID: 1, Type: model.MovementIssue, WarehouseID: &mainWarehouse, LocationID: &mainBin, Quantity: -38, ReasonCode: "SALE", CreatedBy: "clerk"}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT id FROM items WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec(`SELECT set_config\('whc.warehouse_id'`).
+			WithArgs("1").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		expectMovement(mock, 1, mainBin, -38, 100)
+		mock.ExpectExec(`INSERT INTO item_stock .+ SET amount = item_stock.amount \+ EXCLUDED.amount`).
+			WithArgs(1, mainWarehouse, -38, "clerk").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`SELECT set_config\('whc.stock_delta'`).
+			WithArgs("-38").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`UPDATE items SET available_amount = available_amount \+ \$2`).
+			WithArgs(1, -38, "clerk").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT available_amount FROM items WHERE id = \$1`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"available_amount"}).AddRow(12))
+		mock.ExpectCommit()
+
+		res, err := repo.CreateMovement(context.Background(), m)
+
+		require.NoError(t, err)
+		require.Len(t, res, 1)
+		require.Equal(t, int64(100), res[0].ID)
+		require.Equal(t, 12, res[0].BalanceAfter)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Positive case - transfer between warehouses writes linked pair", func(t *testing.T) {
+		m := &model.Movement{ItemID: 1, Type: model.MovementTransfer, WarehouseID: &mainWarehouse, LocationID: &mainBin,
+			ToWarehouseID: &northWarehouse, ToLocationID: &northBin, Quantity: 5, ReasonCode: "REBALANCE", CreatedBy: "clerk"}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT id FROM items WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec(`SELECT set_config\('whc.warehouse_id'`).
+			WithArgs("1").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		expectMovement(mock, 1, mainBin, -5, 200)
+		mock.ExpectExec(`INSERT INTO location_stock`).
+			WithArgs(1, northBin, 5, "clerk").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`INSERT INTO stock_movements`).
+			WithArgs(1, model.MovementTransfer, northWarehouse, northBin, 5, "REBALANCE", nil, nil, int64(200), "clerk").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(201, time.Now()))
+		mock.ExpectExec(`INSERT INTO item_stock`).
+			WithArgs(1, mainWarehouse, -5, "clerk").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO item_stock`).
+			WithArgs(1, northWarehouse, 5, "clerk").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT available_amount FROM items WHERE id = \$1`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"available_amount"}).AddRow(50))
+		mock.ExpectCommit()
+
+		res, err := repo.CreateMovement(context.Background(), m)
+
+		require.NoError(t, err)
+		require.Len(t, res, 2)
+		require.Equal(t, -5, res[0].Quantity)
+		require.Equal(t, 5, res[1].Quantity)
+		require.Equal(t, int64(200), *res[1].RelatedID)
+		// остаток после каждой записи - как в нарастающей сумме GET /movements
+		require.Equal(t, 45, res[0].BalanceAfter)
+		require.Equal(t, 50, res[1].BalanceAfter)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Negative case - not enough stock in bin", func(t *testing.T) {
+		m := &model.Movement{ItemID: 1, Type: model.MovementWriteOff, WarehouseID: &mainWarehouse, LocationID: &mainBin, Quantity: -500, ReasonCode: "DAMAGED", CreatedBy: "clerk"}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT id FROM items WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec(`SELECT set_config\('whc.warehouse_id'`).
+			WithArgs("1").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`UPDATE location_stock SET amount = amount \+ \$3`).
+			WithArgs(1, mainBin, -500, "clerk").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		res, err := repo.CreateMovement(context.Background(), m)
+
+		require.ErrorIs(t, err, model.ErrInsufficientStock)
+		require.Nil(t, res)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	binsQuery := `SELECT s.location_id, s.amount FROM location_stock s JOIN locations l ON l.id = s.location_id
+	WHERE s.item_id = \$1 AND l.warehouse_id = \$2 AND s.amount > 0 ORDER BY l.code = \$3 DESC, l.code`
+
+	t.Run("Positive case - decrement without bin is taken from receiving and then put away stock", func(t *testing.T) {
+		// на приемке осталось 2, остальное размещено: списание 5 проходит по общему остатку склада
+		m := &model.Movement{ItemID: 1, Type: model.MovementAdjustment, WarehouseID: &mainWarehouse, Quantity: -5, ReasonCode: model.ReasonStockDelta, CreatedBy: "clerk"}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT id FROM items WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec(`SELECT set_config\('whc.warehouse_id'`).
+			WithArgs("1").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(binsQuery).
+			WithArgs(1, mainWarehouse, model.ReceivingLocationCode).
+			WillReturnRows(sqlmock.NewRows([]string{"location_id", "amount"}).AddRow(1, 2).AddRow(mainBin, 10).AddRow(4, 7))
+		expectMovement(mock, 1, 1, -2, 300)
+		expectMovement(mock, 1, mainBin, -3, 301)
+		mock.ExpectExec(`INSERT INTO item_stock`).
+			WithArgs(1, mainWarehouse, -5, "clerk").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`SELECT set_config\('whc.stock_delta'`).
+			WithArgs("-5").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`UPDATE items SET available_amount = available_amount \+ \$2`).
+			WithArgs(1, -5, "clerk").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`SELECT available_amount FROM items WHERE id = \$1`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"available_amount"}).AddRow(14))
+		mock.ExpectCommit()
+
+		res, err := repo.CreateMovement(context.Background(), m)
+
+		require.NoError(t, err)
+		require.Len(t, res, 2)
+		require.Equal(t, 1, *res[0].LocationID)
+		require.Equal(t, -2, res[0].Quantity)
+		require.Equal(t, mainBin, *res[1].LocationID)
+		require.Equal(t, -3, res[1].Quantity)
+		require.Equal(t, 17, res[0].BalanceAfter)
+		require.Equal(t, 14, res[1].BalanceAfter)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Negative case - decrement without bin exceeds warehouse total", func(t *testing.T) {
+		m := &model.Movement{ItemID: 1, Type: model.MovementAdjustment, WarehouseID: &mainWarehouse, Quantity: -20, ReasonCode: model.ReasonStockDelta, CreatedBy: "clerk"}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT id FROM items WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec(`SELECT set_config\('whc.warehouse_id'`).
+			WithArgs("1").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(binsQuery).
+			WithArgs(1, mainWarehouse, model.ReceivingLocationCode).
+			WillReturnRows(sqlmock.NewRows([]string{"location_id", "amount"}).AddRow(1, 2).AddRow(mainBin, 10))
+		mock.ExpectRollback()
+
+		res, err := repo.CreateMovement(context.Background(), m)
+
+		require.ErrorIs(t, err, model.ErrInsufficientStock)
+		require.Nil(t, res)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGetMovements(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	itemID := 1
+	movementType := model.MovementIssue
+	warehouseID := 1
+
+	mock.ExpectQuery(`SUM\(quantity\) OVER \(PARTITION BY item_id ORDER BY id\) AS balance_after FROM stock_movements \) m WHERE true AND item_id = \$1 AND movement_type = \$2 ORDER BY id DESC`).
+		WithArgs(itemID, movementType).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "item_id", "movement_type", "warehouse_id", "location_id", "quantity", "reason_code",
+			"reference", "comment", "related_id", "balance_after", "created_at", "created_by"}).
+			AddRow(7, 1, model.MovementIssue, warehouseID, 3, -38, "SALE", "INV-17", nil, nil, 12, time.Now(), "clerk"))
+
+	res, err := repo.GetMovements(context.Background(), &model.RequestParam{}, &model.MovementFilter{ItemID: &itemID, Type: &movementType})
+
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	require.Equal(t, 12, res[0].BalanceAfter)
+	require.Equal(t, "INV-17", *res[0].Reference)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestClaimIdempotencyKey(t *testing.T) {
+	repo, mock := newMockRepo(t)
+	expiresAt := time.Now().Add(time.Hour)
+
+	t.Run("Positive case - free key is claimed", func(t *testing.T) {
+		rec := &model.IdempotencyRecord{Owner: "scanner", Key: "k-1", RequestHash: "abc", ExpiresAt: expiresAt}
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM idempotency_keys WHERE expires_at < now\(\)`).
+			WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectExec(`INSERT INTO idempotency_keys .+ ON CONFLICT \(owner, key\) DO NOTHING`).
+			WithArgs("scanner", "k-1", "abc", expiresAt).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		saved, err := repo.ClaimIdempotencyKey(context.Background(), rec)
+
+		require.NoError(t, err)
+		require.Nil(t, saved)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Positive case - taken key returns saved response", func(t *testing.T) {
+		rec := &model.IdempotencyRecord{Owner: "scanner", Key: "k-1", RequestHash: "abc", ExpiresAt: expiresAt}
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM idempotency_keys WHERE expires_at < now\(\)`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`INSERT INTO idempotency_keys`).
+			WithArgs("scanner", "k-1", "abc", expiresAt).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT request_hash, status_code, content_type, body, created_at, expires_at FROM idempotency_keys WHERE owner = \$1 AND key = \$2`).
+			WithArgs("scanner", "k-1").
+			WillReturnRows(sqlmock.NewRows([]string{"request_hash", "status_code", "content_type", "body", "created_at", "expires_at"}).
+				AddRow("abc", 201, "application/json; charset=utf-8", []byte(`{"id":1}`), time.Now(), expiresAt))
+		mock.ExpectCommit()
+
+		saved, err := repo.ClaimIdempotencyKey(context.Background(), rec)
+
+		require.NoError(t, err)
+		require.Equal(t, 201, saved.StatusCode)
+		require.Equal(t, `{"id":1}`, string(saved.Body))
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
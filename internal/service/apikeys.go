@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+	"github.com/UnendingLoop/WarehouseControl/internal/mwauthlog"
+)
+
+// apiKeyPrefixLen - сколько символов ключа после model.APIKeyPrefix хранится открыто, чтобы отличать ключи в списке
+const apiKeyPrefixLen = 8
+
+// CreateAPIKey выпускает ключ сервисного аккаунта; ttl == 0 - бессрочный ключ
+func (svc WHCService) CreateAPIKey(ctx context.Context, name, keyRole string, ttl time.Duration, role, username string) (*model.APIKey, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if !svc.policy.Can(role, model.PermUsersManage) {
+		return nil, model.ErrAccessDenied
+	}
+
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return nil, model.ErrEmptyAPIKeyName
+	}
+
+	if !svc.policy.IsCorrectRole(keyRole) {
+		return nil, model.ErrIncorrectUserRole
+	}
+
+	if ttl < 0 {
+		return nil, model.ErrInvalidAPIKeyTTL
+	}
+
+	secret, err := newToken()
+	if err != nil {
+		log.Printf("RID %q Failed to generate api key in 'CreateAPIKey': %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+	key := model.APIKeyPrefix + secret
+
+	apiKey := &model.APIKey{
+		Prefix:    key[:len(model.APIKeyPrefix)+apiKeyPrefixLen],
+		Name:      name,
+		Role:      keyRole,
+		CreatedBy: username,
+	}
+	if ttl > 0 {
+		expiresAt := time.Now().UTC().Add(ttl)
+		apiKey.ExpiresAt = &expiresAt
+	}
+
+	if err := svc.repo.CreateAPIKey(ctx, apiKey, hashToken(key)); err != nil {
+		log.Printf("RID %q Failed to put new api key to DB in 'CreateAPIKey': %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+
+	// открытый ключ отдается только один раз - в БД его нет
+	apiKey.Key = key
+
+	return apiKey, nil
+}
+
+func (svc WHCService) GetAPIKeysList(ctx context.Context, role string) ([]*model.APIKey, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if !svc.policy.Can(role, model.PermUsersManage) {
+		return nil, model.ErrAccessDenied
+	}
+
+	res, err := svc.repo.GetAPIKeysList(ctx)
+	if err != nil {
+		log.Printf("RID %q Failed to get api keys list from DB in 'GetAPIKeysList': %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+
+	return res, nil
+}
+
+func (svc WHCService) RevokeAPIKey(ctx context.Context, keyID int, role string) error {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if keyID <= 0 {
+		return model.ErrAPIKeyNotFound
+	}
+
+	if !svc.policy.Can(role, model.PermUsersManage) {
+		return model.ErrAccessDenied
+	}
+
+	if err := svc.repo.RevokeAPIKey(ctx, keyID); err != nil {
+		switch {
+		case errors.Is(err, model.ErrAPIKeyNotFound):
+			return err
+		default:
+			log.Printf("RID %q Failed to revoke api key in DB in 'RevokeAPIKey': %q", rid, err)
+			return model.ErrCommon500
+		}
+	}
+
+	return nil
+}
+
+// CheckAPIKey вызывается из RequireAuth для запросов с API-ключом: возвращает данные сервисного аккаунта
+// в виде claims, чтобы дальше запрос обрабатывался так же, как запрос пользователя
+func (svc WHCService) CheckAPIKey(ctx context.Context, key string) (*mwauthlog.Claims, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if !strings.HasPrefix(key, model.APIKeyPrefix) {
+		return nil, model.ErrInvalidAPIKey
+	}
+
+	apiKey, err := svc.repo.UseAPIKey(ctx, hashToken(key))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrInvalidAPIKey):
+			return nil, err
+		default:
+			log.Printf("RID %q Failed to check api key in DB in 'CheckAPIKey': %q", rid, err)
+			return nil, model.ErrCommon500
+		}
+	}
+
+	// роль могла быть удалена из политики после выпуска ключа
+	if !svc.policy.IsCorrectRole(apiKey.Role) {
+		return nil, model.ErrIncorrectUserRole
+	}
+
+	return &mwauthlog.Claims{Username: model.APIKeyActorPrefix + apiKey.Name, Role: apiKey.Role}, nil
+}
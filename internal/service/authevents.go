@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+)
+
+// recordAuthEvent дополняет событие данными запроса и пишет его в журнал аутентификации.
+// Ошибка записи только логируется - из-за журнала ни вход, ни ответ на запрос не ломаем
+func (svc WHCService) recordAuthEvent(ctx context.Context, event *model.AuthEvent) {
+	event.RequestID = model.RequestIDFromCtx(ctx)
+	event.IP = model.ClientIPFromCtx(ctx)
+	event.UserAgent = model.UserAgentFromCtx(ctx)
+
+	if err := svc.repo.CreateAuthEvent(ctx, event); err != nil {
+		log.Printf("RID %q Failed to record auth event %q in DB: %q", event.RequestID, event.Type, err)
+	}
+}
+
+// RecordAuthEvent - запись в журнал аутентификации для middleware (отказы 401/403)
+func (svc WHCService) RecordAuthEvent(ctx context.Context, event *model.AuthEvent) {
+	svc.recordAuthEvent(ctx, event)
+}
+
+// recordSignupFailure пишет отклоненную регистрацию; причина - текст ошибки, отданной клиенту
+func (svc WHCService) recordSignupFailure(ctx context.Context, user *model.User, reason error) {
+	event := &model.AuthEvent{Type: model.AuthEventSignupFailed, Detail: reason.Error()}
+	if user != nil {
+		event.Username = strings.ToLower(strings.TrimSpace(user.UserName))
+	}
+	svc.recordAuthEvent(ctx, event)
+}
+
+func (svc WHCService) GetAuthEvents(ctx context.Context, rp *model.RequestParam, filter *model.AuthEventFilter, role string) ([]*model.AuthEvent, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if !svc.policy.Can(role, model.PermAuditRead) {
+		return nil, model.ErrAccessDenied
+	}
+
+	if err := validateReqParams(rp, nil); err != nil {
+		return nil, err
+	}
+
+	if filter.Type != nil {
+		if _, ok := model.AuthEventTypesMap[*filter.Type]; !ok {
+			return nil, model.ErrInvalidEventType
+		}
+	}
+
+	res, err := svc.repo.GetAuthEvents(ctx, rp, filter)
+	if err != nil {
+		log.Printf("RID %q Failed to get auth events from DB in 'GetAuthEvents': %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+
+	return res, nil
+}
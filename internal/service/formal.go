@@ -1,8 +1,12 @@
 package service
 
 import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
 	"github.com/UnendingLoop/WarehouseControl/internal/mwauthlog"
-	"github.com/UnendingLoop/WarehouseControl/internal/policy"
 	"github.com/UnendingLoop/WarehouseControl/internal/repository"
 )
 
@@ -10,23 +14,122 @@ type WHCService struct {
 	repo       repository.WHCRepo
 	policy     PolicyChecker
 	jwtManager JWTManager
+	oidc       OIDCProvider // nil - вход через IdP не настроен
+	cfg        Config
 }
 
-func NewWHBService(ebrepo repository.WHCRepo, jwt JWTManager) *WHCService {
-	return &WHCService{repo: ebrepo, policy: policy.PolicyChecker{}, jwtManager: jwt}
+const (
+	DefaultAccessTokenTTL  = 15 * time.Minute
+	DefaultRefreshTokenTTL = 7 * 24 * time.Hour
+
+	DefaultLoginWindow          = 15 * time.Minute
+	DefaultLoginMaxUserFailures = 5
+	DefaultLoginMaxIPFailures   = 50
+
+	DefaultWarehouseID = 1 // склад MAIN из миграции
+
+	DefaultIdempotencyTTL = 24 * time.Hour
+
+	DefaultItemsPurgeInterval = 24 * time.Hour
+)
+
+// Config - настройки сервиса, задаваемые через env
+type Config struct {
+	SignupMode        string // model.SignupDisabled / model.SignupOpen / model.SignupInvite
+	SignupDefaultRole string // роль, принудительно выдаваемая при открытой регистрации без инвайта
+	AccessTokenTTL    time.Duration
+	RefreshTokenTTL   time.Duration
+	OIDCGroupRoles    []model.GroupRole // группы IdP -> роли, по порядку приоритета
+
+	LoginWindow          time.Duration // скользящее окно, в котором считаются неудачные попытки входа
+	LoginMaxUserFailures int           // после стольких неудач подряд логин временно блокируется
+	LoginMaxIPFailures   int           // после стольких неудач с одного IP блокируется адрес
+
+	TOTPRequiredRoles []string // роли, для которых второй фактор обязателен
+	TOTPIssuer        string   // имя сервиса в приложении-аутентификаторе
+
+	DefaultWarehouseID int // склад, на который приходуется остаток, если клиент не указал склад
+
+	IdempotencyTTL time.Duration // сколько хранится ответ на запрос с Idempotency-Key
+
+	ItemsRetentionDays int  // сколько дней удаленный товар лежит в корзине до плановой очистки; 0 - не очищать
+	ItemsPurgeDryRun   bool // плановая очистка только пишет в лог, что удалила бы
+}
+
+func NewWHBService(ebrepo repository.WHCRepo, jwt JWTManager, pc PolicyChecker, idp OIDCProvider, cfg Config) *WHCService {
+	if cfg.SignupMode == "" {
+		cfg.SignupMode = model.SignupOpen
+	}
+	if cfg.SignupDefaultRole == "" {
+		cfg.SignupDefaultRole = model.RoleViewer
+	}
+	if cfg.AccessTokenTTL <= 0 {
+		cfg.AccessTokenTTL = DefaultAccessTokenTTL
+	}
+	if cfg.RefreshTokenTTL <= 0 {
+		cfg.RefreshTokenTTL = DefaultRefreshTokenTTL
+	}
+	if cfg.LoginWindow <= 0 {
+		cfg.LoginWindow = DefaultLoginWindow
+	}
+	if cfg.LoginMaxUserFailures <= 0 {
+		cfg.LoginMaxUserFailures = DefaultLoginMaxUserFailures
+	}
+	if cfg.LoginMaxIPFailures <= 0 {
+		cfg.LoginMaxIPFailures = DefaultLoginMaxIPFailures
+	}
+	if cfg.TOTPIssuer == "" {
+		cfg.TOTPIssuer = DefaultTOTPIssuer
+	}
+	if cfg.DefaultWarehouseID <= 0 {
+		cfg.DefaultWarehouseID = DefaultWarehouseID
+	}
+	if cfg.IdempotencyTTL <= 0 {
+		cfg.IdempotencyTTL = DefaultIdempotencyTTL
+	}
+
+	switch cfg.SignupMode {
+	case model.SignupDisabled, model.SignupOpen, model.SignupInvite:
+	default:
+		log.Fatalf("Incorrect signup mode %q provided. Must be 'disabled', 'open' or 'invite'.", cfg.SignupMode)
+	}
+
+	// роль с правом управлять пользователями через открытую регистрацию получить нельзя ни при каких настройках
+	if !pc.IsCorrectRole(cfg.SignupDefaultRole) || pc.Can(cfg.SignupDefaultRole, model.PermUsersManage) {
+		log.Fatalf("Incorrect default signup role %q provided.", cfg.SignupDefaultRole)
+	}
+
+	for _, gr := range cfg.OIDCGroupRoles {
+		if !pc.IsCorrectRole(gr.Role) {
+			log.Fatalf("Incorrect role %q mapped to identity provider group %q.", gr.Role, gr.Group)
+		}
+	}
+
+	for _, r := range cfg.TOTPRequiredRoles {
+		if !pc.IsCorrectRole(r) {
+			log.Fatalf("Incorrect role %q provided in roles requiring two-factor authentication.", r)
+		}
+	}
+
+	return &WHCService{repo: ebrepo, policy: pc, jwtManager: jwt, oidc: idp, cfg: cfg}
 }
 
+// PolicyChecker - матрица прав "роль -> разрешения" (model.Perm*), перечитываемая на лету
 type PolicyChecker interface {
-	AccessToDelete(role string) bool
-	AccessToCreate(role string) bool
-	AccessToUpdate(role string) bool
-	AccessToGetHistory(role string) bool
-	AccessToGetItems(role string) bool
-	AccessToSeeDeleted(role string) bool
+	Can(role string, permission string) bool
 	IsCorrectRole(role string) bool
+	Roles() map[string][]string
+	Reload() error
+}
+
+// OIDCProvider - внешний IdP: страница логина и обмен кода авторизации на подтвержденного пользователя
+type OIDCProvider interface {
+	AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error)
+	Exchange(ctx context.Context, code, verifier, nonce string) (*model.OIDCIdentity, error)
 }
 
 type JWTManager interface {
 	Generate(uid int, userName string, role string) (string, error)
 	Parse(tokenStr string) (*mwauthlog.Claims, error)
+	JWKS() *model.JWKS
 }
@@ -2,21 +2,86 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"time"
 
 	"github.com/UnendingLoop/WarehouseControl/internal/model"
 	"github.com/UnendingLoop/WarehouseControl/internal/mwauthlog"
 )
 
 type repoMock struct {
-	CreateItemFn         func(ctx context.Context, item *model.Item) error
-	GetItemByIDFn        func(ctx context.Context, id int, seeDeleted bool) (*model.Item, error)
-	UpdateItemFn         func(ctx context.Context, item *model.ItemUpdate, seeDeleted bool) error
-	DeleteItemFn         func(ctx context.Context, itemID int, username string) error
-	CreateUserFn         func(ctx context.Context, user *model.User) error
-	GetUserByNameFn      func(ctx context.Context, username string) (*model.User, error)
-	GetItemsListFn       func(ctx context.Context, rp *model.RequestParam, seeDeleted bool) ([]*model.Item, error)
-	GetItemHistoryByIDFn func(ctx context.Context, rp *model.RequestParam, id int) ([]*model.ItemHistory, error)
-	GetItemHistoryAllFn  func(ctx context.Context, rp *model.RequestParam) ([]*model.ItemHistory, error)
+	CreateItemFn             func(ctx context.Context, item *model.Item) error
+	GetItemByIDFn            func(ctx context.Context, id int, seeDeleted bool) (*model.Item, error)
+	UpdateItemFn             func(ctx context.Context, item *model.ItemUpdate, seeDeleted bool) error
+	DeleteItemFn             func(ctx context.Context, itemID int, version *int, username string) error
+	RestoreItemFn            func(ctx context.Context, itemID int, username string) error
+	PurgeItemFn              func(ctx context.Context, itemID int, username string) error
+	PurgeDeletedItemsFn      func(ctx context.Context, before time.Time, actor string) ([]*model.Item, error)
+	CreateUserFn             func(ctx context.Context, user *model.User) error
+	CreateUserInviteFn       func(ctx context.Context, user *model.User, tokenHash string) error
+	UpsertOIDCUserFn         func(ctx context.Context, user *model.User, subject string) error
+	GetUserByNameFn          func(ctx context.Context, username string) (*model.User, error)
+	GetUsersListFn           func(ctx context.Context, rpu *model.RequestParam) ([]*model.User, error)
+	GetUserSessionStateFn    func(ctx context.Context, userID int, role string, jti string, issuedAt time.Time) (*model.SessionState, error)
+	UpdateUserRoleFn         func(ctx context.Context, userID int, role string, updatedBy string) error
+	SetUserDisabledFn        func(ctx context.Context, userID int, disabled bool, updatedBy string) error
+	UpdateUserPasswordFn     func(ctx context.Context, userID int, passHash string, updatedBy string) error
+	DeleteUserFn             func(ctx context.Context, userID int, deletedBy string) error
+	GetUserHistoryFn         func(ctx context.Context, rph *model.RequestParam, userID int) ([]*model.UserHistory, error)
+	GetUserRolesFn           func(ctx context.Context, userID int) ([]string, error)
+	GrantUserRoleFn          func(ctx context.Context, userID int, role string, grantedBy string) error
+	RevokeUserRoleFn         func(ctx context.Context, userID int, role string, revokedBy string) error
+	GetUserTOTPFn            func(ctx context.Context, userID int) (*model.TOTPState, error)
+	SaveTOTPSecretFn         func(ctx context.Context, userID int, secret string) error
+	ConfirmTOTPFn            func(ctx context.Context, userID int, step int64, recoveryHashes []string) error
+	UseTOTPStepFn            func(ctx context.Context, userID int, step int64) error
+	UseRecoveryCodeFn        func(ctx context.Context, userID int, codeHash string) error
+	DeleteUserTOTPFn         func(ctx context.Context, userID int) error
+	CreateLoginChallengeFn   func(ctx context.Context, userID int, role, tokenHash string, expiresAt time.Time) error
+	GetLoginChallengeFn      func(ctx context.Context, tokenHash string) (*model.LoginChallenge, error)
+	FailLoginChallengeFn     func(ctx context.Context, challengeID int) error
+	CompleteLoginChallengeFn func(ctx context.Context, challengeID int) error
+	RecordLoginAttemptFn     func(ctx context.Context, attempt *model.LoginAttempt) error
+	GetLoginFailuresFn       func(ctx context.Context, username, ip string, window time.Duration) (*model.LoginFailures, error)
+	GetLoginAttemptsFn       func(ctx context.Context, rp *model.RequestParam, filter *model.LoginAttemptFilter) ([]*model.LoginAttempt, error)
+	CreateAuthEventFn        func(ctx context.Context, event *model.AuthEvent) error
+	GetAuthEventsFn          func(ctx context.Context, rp *model.RequestParam, filter *model.AuthEventFilter) ([]*model.AuthEvent, error)
+	ClaimIdempotencyKeyFn    func(ctx context.Context, rec *model.IdempotencyRecord) (*model.IdempotencyRecord, error)
+	SaveIdempotentResponseFn func(ctx context.Context, rec *model.IdempotencyRecord) error
+	DeleteIdempotencyKeyFn   func(ctx context.Context, owner, key string) error
+	CreateRefreshTokenFn     func(ctx context.Context, userID int, role string, tokenHash string, expiresAt time.Time) error
+	RotateRefreshTokenFn     func(ctx context.Context, oldHash, newHash string, expiresAt time.Time) (*model.User, error)
+	RevokeRefreshTokenFn     func(ctx context.Context, tokenHash string) error
+	RevokeAccessTokenFn      func(ctx context.Context, jti string, expiresAt time.Time) error
+	RevokeUserSessionsFn     func(ctx context.Context, userID int, revokedBy string) error
+	CreateInviteFn           func(ctx context.Context, invite *model.Invite, tokenHash string) error
+	GetInvitesListFn         func(ctx context.Context) ([]*model.Invite, error)
+	RevokeInviteFn           func(ctx context.Context, inviteID int) error
+	CreateAPIKeyFn           func(ctx context.Context, key *model.APIKey, keyHash string) error
+	GetAPIKeysListFn         func(ctx context.Context) ([]*model.APIKey, error)
+	RevokeAPIKeyFn           func(ctx context.Context, keyID int) error
+	UseAPIKeyFn              func(ctx context.Context, keyHash string) (*model.APIKey, error)
+	GetItemsListFn           func(ctx context.Context, rp *model.RequestParam, filter *model.ItemFilter, seeDeleted bool) ([]*model.Item, error)
+	GetDeletedItemsFn        func(ctx context.Context, rp *model.RequestParam) ([]*model.Item, error)
+	GetItemHistoryByIDFn     func(ctx context.Context, rp *model.RequestParam, filter *model.HistoryFilter, id int) ([]*model.ItemHistory, error)
+	GetItemHistoryAllFn      func(ctx context.Context, rp *model.RequestParam, filter *model.HistoryFilter) ([]*model.ItemHistory, error)
+	GetItemHistoryVersionsFn func(ctx context.Context, itemID, from, to int) ([]*model.ItemHistory, error)
+	GetItemSnapshotFn        func(ctx context.Context, itemID int) (*json.RawMessage, error)
+	CreateWarehouseFn        func(ctx context.Context, wh *model.Warehouse) error
+	GetWarehousesFn          func(ctx context.Context) ([]*model.Warehouse, error)
+	GetItemStockFn           func(ctx context.Context, itemID int) ([]*model.WarehouseStock, error)
+	GetUserWarehousesFn      func(ctx context.Context, userID int) ([]int, error)
+	GrantUserWarehouseFn     func(ctx context.Context, userID, warehouseID int, grantedBy string) error
+	RevokeUserWarehouseFn    func(ctx context.Context, userID, warehouseID int, revokedBy string) error
+	CreateLocationFn         func(ctx context.Context, loc *model.Location) error
+	GetLocationFn            func(ctx context.Context, locationID int) (*model.Location, error)
+	GetReceivingLocationFn   func(ctx context.Context, warehouseID int) (*model.Location, error)
+	GetLocationsFn           func(ctx context.Context, warehouseID int) ([]*model.Location, error)
+	GetLocationStockFn       func(ctx context.Context, locationID int) ([]*model.LocationStock, error)
+	GetItemLocationsFn       func(ctx context.Context, itemID int) ([]*model.LocationStock, error)
+	MoveItemStockFn          func(ctx context.Context, mv *model.StockMove) error
+	CreateMovementFn         func(ctx context.Context, m *model.Movement) ([]*model.Movement, error)
+	GetMovementsFn           func(ctx context.Context, rp *model.RequestParam, filter *model.MovementFilter) ([]*model.Movement, error)
 }
 
 func (m *repoMock) CreateItem(ctx context.Context, item *model.Item) error {
@@ -31,28 +96,280 @@ func (m *repoMock) UpdateItem(ctx context.Context, item *model.ItemUpdate, seeDe
 	return m.UpdateItemFn(ctx, item, seeDeleted)
 }
 
-func (m *repoMock) DeleteItem(ctx context.Context, itemID int, username string) error {
-	return m.DeleteItemFn(ctx, itemID, username)
+func (m *repoMock) RestoreItem(ctx context.Context, itemID int, username string) error {
+	return m.RestoreItemFn(ctx, itemID, username)
+}
+
+func (m *repoMock) PurgeItem(ctx context.Context, itemID int, username string) error {
+	return m.PurgeItemFn(ctx, itemID, username)
+}
+
+func (m *repoMock) PurgeDeletedItems(ctx context.Context, before time.Time, actor string) ([]*model.Item, error) {
+	return m.PurgeDeletedItemsFn(ctx, before, actor)
+}
+
+func (m *repoMock) DeleteItem(ctx context.Context, itemID int, version *int, username string) error {
+	return m.DeleteItemFn(ctx, itemID, version, username)
 }
 
 func (m *repoMock) CreateUser(ctx context.Context, user *model.User) error {
 	return m.CreateUserFn(ctx, user)
 }
 
+func (m *repoMock) CreateUserWithInvite(ctx context.Context, user *model.User, tokenHash string) error {
+	return m.CreateUserInviteFn(ctx, user, tokenHash)
+}
+
+func (m *repoMock) UpsertOIDCUser(ctx context.Context, user *model.User, subject string) error {
+	return m.UpsertOIDCUserFn(ctx, user, subject)
+}
+
 func (m *repoMock) GetUserByName(ctx context.Context, username string) (*model.User, error) {
 	return m.GetUserByNameFn(ctx, username)
 }
 
-func (m *repoMock) GetItemsList(ctx context.Context, rp *model.RequestParam, seeDeleted bool) ([]*model.Item, error) {
-	return m.GetItemsListFn(ctx, rp, seeDeleted)
+func (m *repoMock) GetUsersList(ctx context.Context, rpu *model.RequestParam) ([]*model.User, error) {
+	return m.GetUsersListFn(ctx, rpu)
+}
+
+func (m *repoMock) GetUserSessionState(ctx context.Context, userID int, role string, jti string, issuedAt time.Time) (*model.SessionState, error) {
+	return m.GetUserSessionStateFn(ctx, userID, role, jti, issuedAt)
+}
+
+func (m *repoMock) UpdateUserRole(ctx context.Context, userID int, role string, updatedBy string) error {
+	return m.UpdateUserRoleFn(ctx, userID, role, updatedBy)
+}
+
+func (m *repoMock) SetUserDisabled(ctx context.Context, userID int, disabled bool, updatedBy string) error {
+	return m.SetUserDisabledFn(ctx, userID, disabled, updatedBy)
+}
+
+func (m *repoMock) UpdateUserPassword(ctx context.Context, userID int, passHash string, updatedBy string) error {
+	return m.UpdateUserPasswordFn(ctx, userID, passHash, updatedBy)
+}
+
+func (m *repoMock) DeleteUser(ctx context.Context, userID int, deletedBy string) error {
+	return m.DeleteUserFn(ctx, userID, deletedBy)
+}
+
+func (m *repoMock) GetUserHistory(ctx context.Context, rph *model.RequestParam, userID int) ([]*model.UserHistory, error) {
+	return m.GetUserHistoryFn(ctx, rph, userID)
+}
+
+func (m *repoMock) GetUserRoles(ctx context.Context, userID int) ([]string, error) {
+	return m.GetUserRolesFn(ctx, userID)
+}
+
+func (m *repoMock) GrantUserRole(ctx context.Context, userID int, role string, grantedBy string) error {
+	return m.GrantUserRoleFn(ctx, userID, role, grantedBy)
+}
+
+func (m *repoMock) RevokeUserRole(ctx context.Context, userID int, role string, revokedBy string) error {
+	return m.RevokeUserRoleFn(ctx, userID, role, revokedBy)
+}
+
+func (m *repoMock) GetUserTOTP(ctx context.Context, userID int) (*model.TOTPState, error) {
+	return m.GetUserTOTPFn(ctx, userID)
+}
+
+func (m *repoMock) SaveTOTPSecret(ctx context.Context, userID int, secret string) error {
+	return m.SaveTOTPSecretFn(ctx, userID, secret)
+}
+
+func (m *repoMock) ConfirmTOTP(ctx context.Context, userID int, step int64, recoveryHashes []string) error {
+	return m.ConfirmTOTPFn(ctx, userID, step, recoveryHashes)
+}
+
+func (m *repoMock) UseTOTPStep(ctx context.Context, userID int, step int64) error {
+	return m.UseTOTPStepFn(ctx, userID, step)
+}
+
+func (m *repoMock) UseRecoveryCode(ctx context.Context, userID int, codeHash string) error {
+	return m.UseRecoveryCodeFn(ctx, userID, codeHash)
+}
+
+func (m *repoMock) DeleteUserTOTP(ctx context.Context, userID int) error {
+	return m.DeleteUserTOTPFn(ctx, userID)
+}
+
+func (m *repoMock) CreateLoginChallenge(ctx context.Context, userID int, role, tokenHash string, expiresAt time.Time) error {
+	return m.CreateLoginChallengeFn(ctx, userID, role, tokenHash, expiresAt)
+}
+
+func (m *repoMock) GetLoginChallenge(ctx context.Context, tokenHash string) (*model.LoginChallenge, error) {
+	return m.GetLoginChallengeFn(ctx, tokenHash)
+}
+
+func (m *repoMock) FailLoginChallenge(ctx context.Context, challengeID int) error {
+	return m.FailLoginChallengeFn(ctx, challengeID)
+}
+
+func (m *repoMock) CompleteLoginChallenge(ctx context.Context, challengeID int) error {
+	return m.CompleteLoginChallengeFn(ctx, challengeID)
+}
+
+func (m *repoMock) RecordLoginAttempt(ctx context.Context, attempt *model.LoginAttempt) error {
+	return m.RecordLoginAttemptFn(ctx, attempt)
+}
+
+func (m *repoMock) GetLoginFailures(ctx context.Context, username, ip string, window time.Duration) (*model.LoginFailures, error) {
+	return m.GetLoginFailuresFn(ctx, username, ip, window)
+}
+
+func (m *repoMock) GetLoginAttempts(ctx context.Context, rp *model.RequestParam, filter *model.LoginAttemptFilter) ([]*model.LoginAttempt, error) {
+	return m.GetLoginAttemptsFn(ctx, rp, filter)
+}
+
+func (m *repoMock) CreateAuthEvent(ctx context.Context, event *model.AuthEvent) error {
+	return m.CreateAuthEventFn(ctx, event)
+}
+
+func (m *repoMock) GetAuthEvents(ctx context.Context, rp *model.RequestParam, filter *model.AuthEventFilter) ([]*model.AuthEvent, error) {
+	return m.GetAuthEventsFn(ctx, rp, filter)
+}
+
+func (m *repoMock) ClaimIdempotencyKey(ctx context.Context, rec *model.IdempotencyRecord) (*model.IdempotencyRecord, error) {
+	return m.ClaimIdempotencyKeyFn(ctx, rec)
+}
+
+func (m *repoMock) SaveIdempotentResponse(ctx context.Context, rec *model.IdempotencyRecord) error {
+	return m.SaveIdempotentResponseFn(ctx, rec)
+}
+
+func (m *repoMock) DeleteIdempotencyKey(ctx context.Context, owner, key string) error {
+	return m.DeleteIdempotencyKeyFn(ctx, owner, key)
+}
+
+func (m *repoMock) CreateRefreshToken(ctx context.Context, userID int, role string, tokenHash string, expiresAt time.Time) error {
+	return m.CreateRefreshTokenFn(ctx, userID, role, tokenHash, expiresAt)
+}
+
+func (m *repoMock) RotateRefreshToken(ctx context.Context, oldHash, newHash string, expiresAt time.Time) (*model.User, error) {
+	return m.RotateRefreshTokenFn(ctx, oldHash, newHash, expiresAt)
+}
+
+func (m *repoMock) RevokeRefreshToken(ctx context.Context, tokenHash string) error {
+	return m.RevokeRefreshTokenFn(ctx, tokenHash)
+}
+
+func (m *repoMock) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	return m.RevokeAccessTokenFn(ctx, jti, expiresAt)
+}
+
+func (m *repoMock) RevokeUserSessions(ctx context.Context, userID int, revokedBy string) error {
+	return m.RevokeUserSessionsFn(ctx, userID, revokedBy)
+}
+
+func (m *repoMock) CreateInvite(ctx context.Context, invite *model.Invite, tokenHash string) error {
+	return m.CreateInviteFn(ctx, invite, tokenHash)
+}
+
+func (m *repoMock) GetInvitesList(ctx context.Context) ([]*model.Invite, error) {
+	return m.GetInvitesListFn(ctx)
+}
+
+func (m *repoMock) RevokeInvite(ctx context.Context, inviteID int) error {
+	return m.RevokeInviteFn(ctx, inviteID)
+}
+
+func (m *repoMock) CreateAPIKey(ctx context.Context, key *model.APIKey, keyHash string) error {
+	return m.CreateAPIKeyFn(ctx, key, keyHash)
+}
+
+func (m *repoMock) GetAPIKeysList(ctx context.Context) ([]*model.APIKey, error) {
+	return m.GetAPIKeysListFn(ctx)
+}
+
+func (m *repoMock) RevokeAPIKey(ctx context.Context, keyID int) error {
+	return m.RevokeAPIKeyFn(ctx, keyID)
+}
+
+func (m *repoMock) UseAPIKey(ctx context.Context, keyHash string) (*model.APIKey, error) {
+	return m.UseAPIKeyFn(ctx, keyHash)
+}
+
+func (m *repoMock) GetItemsList(ctx context.Context, rp *model.RequestParam, filter *model.ItemFilter, seeDeleted bool) ([]*model.Item, error) {
+	return m.GetItemsListFn(ctx, rp, filter, seeDeleted)
+}
+
+func (m *repoMock) GetItemHistoryVersions(ctx context.Context, itemID, from, to int) ([]*model.ItemHistory, error) {
+	return m.GetItemHistoryVersionsFn(ctx, itemID, from, to)
+}
+
+func (m *repoMock) GetItemSnapshot(ctx context.Context, itemID int) (*json.RawMessage, error) {
+	return m.GetItemSnapshotFn(ctx, itemID)
+}
+
+func (m *repoMock) GetDeletedItems(ctx context.Context, rp *model.RequestParam) ([]*model.Item, error) {
+	return m.GetDeletedItemsFn(ctx, rp)
+}
+
+func (m *repoMock) GetItemHistoryByID(ctx context.Context, rp *model.RequestParam, filter *model.HistoryFilter, id int) ([]*model.ItemHistory, error) {
+	return m.GetItemHistoryByIDFn(ctx, rp, filter, id)
+}
+
+func (m *repoMock) GetItemHistoryAll(ctx context.Context, rp *model.RequestParam, filter *model.HistoryFilter) ([]*model.ItemHistory, error) {
+	return m.GetItemHistoryAllFn(ctx, rp, filter)
+}
+
+func (m *repoMock) CreateWarehouse(ctx context.Context, wh *model.Warehouse) error {
+	return m.CreateWarehouseFn(ctx, wh)
+}
+
+func (m *repoMock) GetWarehouses(ctx context.Context) ([]*model.Warehouse, error) {
+	return m.GetWarehousesFn(ctx)
 }
 
-func (m *repoMock) GetItemHistoryByID(ctx context.Context, rp *model.RequestParam, id int) ([]*model.ItemHistory, error) {
-	return m.GetItemHistoryByIDFn(ctx, rp, id)
+func (m *repoMock) GetItemStock(ctx context.Context, itemID int) ([]*model.WarehouseStock, error) {
+	return m.GetItemStockFn(ctx, itemID)
 }
 
-func (m *repoMock) GetItemHistoryAll(ctx context.Context, rp *model.RequestParam) ([]*model.ItemHistory, error) {
-	return m.GetItemHistoryAllFn(ctx, rp)
+func (m *repoMock) GetUserWarehouses(ctx context.Context, userID int) ([]int, error) {
+	return m.GetUserWarehousesFn(ctx, userID)
+}
+
+func (m *repoMock) GrantUserWarehouse(ctx context.Context, userID, warehouseID int, grantedBy string) error {
+	return m.GrantUserWarehouseFn(ctx, userID, warehouseID, grantedBy)
+}
+
+func (m *repoMock) RevokeUserWarehouse(ctx context.Context, userID, warehouseID int, revokedBy string) error {
+	return m.RevokeUserWarehouseFn(ctx, userID, warehouseID, revokedBy)
+}
+
+func (m *repoMock) CreateLocation(ctx context.Context, loc *model.Location) error {
+	return m.CreateLocationFn(ctx, loc)
+}
+
+func (m *repoMock) GetLocation(ctx context.Context, locationID int) (*model.Location, error) {
+	return m.GetLocationFn(ctx, locationID)
+}
+
+func (m *repoMock) GetReceivingLocation(ctx context.Context, warehouseID int) (*model.Location, error) {
+	return m.GetReceivingLocationFn(ctx, warehouseID)
+}
+
+func (m *repoMock) GetLocations(ctx context.Context, warehouseID int) ([]*model.Location, error) {
+	return m.GetLocationsFn(ctx, warehouseID)
+}
+
+func (m *repoMock) GetLocationStock(ctx context.Context, locationID int) ([]*model.LocationStock, error) {
+	return m.GetLocationStockFn(ctx, locationID)
+}
+
+func (m *repoMock) GetItemLocations(ctx context.Context, itemID int) ([]*model.LocationStock, error) {
+	return m.GetItemLocationsFn(ctx, itemID)
+}
+
+func (m *repoMock) MoveItemStock(ctx context.Context, mv *model.StockMove) error {
+	return m.MoveItemStockFn(ctx, mv)
+}
+
+func (m *repoMock) CreateMovement(ctx context.Context, mv *model.Movement) ([]*model.Movement, error) {
+	return m.CreateMovementFn(ctx, mv)
+}
+
+func (m *repoMock) GetMovements(ctx context.Context, rp *model.RequestParam, filter *model.MovementFilter) ([]*model.Movement, error) {
+	return m.GetMovementsFn(ctx, rp, filter)
 }
 
 //=========================================================
@@ -64,16 +381,43 @@ type policyMock struct {
 	canGetItems   bool
 	canGetHistory bool
 	canSeeDeleted bool
+	canRestore    bool
+	canPurge      bool
+	canRevert     bool
+	canManageUser bool
 	correctRole   bool
 }
 
-func (p policyMock) AccessToCreate(string) bool     { return p.canCreate }
-func (p policyMock) AccessToUpdate(string) bool     { return p.canUpdate }
-func (p policyMock) AccessToDelete(string) bool     { return p.canDelete }
-func (p policyMock) AccessToGetItems(string) bool   { return p.canGetItems }
-func (p policyMock) AccessToGetHistory(string) bool { return p.canGetHistory }
-func (p policyMock) AccessToSeeDeleted(string) bool { return p.canSeeDeleted }
+func (p policyMock) Can(_ string, permission string) bool {
+	switch permission {
+	case model.PermItemsCreate:
+		return p.canCreate
+	case model.PermItemsUpdate:
+		return p.canUpdate
+	case model.PermItemsDelete:
+		return p.canDelete
+	case model.PermItemsRead:
+		return p.canGetItems
+	case model.PermHistoryRead, model.PermHistoryExport:
+		return p.canGetHistory
+	case model.PermItemsSeeDeleted:
+		return p.canSeeDeleted
+	case model.PermItemsRestore:
+		return p.canRestore
+	case model.PermItemsPurge:
+		return p.canPurge
+	case model.PermItemsRevert:
+		return p.canRevert
+	case model.PermUsersManage, model.PermPolicyManage, model.PermWarehousesManage:
+		return p.canManageUser
+	default:
+		return false
+	}
+}
+
 func (p policyMock) IsCorrectRole(role string) bool { return p.correctRole }
+func (p policyMock) Roles() map[string][]string     { return nil }
+func (p policyMock) Reload() error                  { return nil }
 
 //=========================================================
 
@@ -90,3 +434,22 @@ func (j *jwtMock) Generate(id int, username, role string) (string, error) {
 func (j *jwtMock) Parse(tokenStr string) (*mwauthlog.Claims, error) {
 	return j.claims, j.err
 }
+
+func (j *jwtMock) JWKS() *model.JWKS {
+	return &model.JWKS{}
+}
+
+//=========================================================
+
+type oidcMock struct {
+	identity *model.OIDCIdentity
+	err      error
+}
+
+func (o *oidcMock) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	return "https://idp.example.com/authorize?state=" + state, o.err
+}
+
+func (o *oidcMock) Exchange(ctx context.Context, code, verifier, nonce string) (*model.OIDCIdentity, error) {
+	return o.identity, o.err
+}
@@ -0,0 +1,280 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+)
+
+// GetItemHistoryDiff сравнивает состояния товара после версий from и to
+func (svc WHCService) GetItemHistoryDiff(ctx context.Context, req *model.ItemDiffRequest, itemID int, role string) (*model.ItemDiff, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if itemID <= 0 {
+		return nil, model.ErrIncorrectItemID
+	}
+
+	if !svc.policy.Can(role, model.PermHistoryRead) {
+		return nil, model.ErrAccessDenied
+	}
+
+	if req.From <= 0 || req.To <= 0 || req.From >= req.To {
+		return nil, model.ErrInvalidVersionRange
+	}
+
+	res, err := svc.repo.GetItemHistoryVersions(ctx, itemID, req.From, req.To)
+	if err != nil {
+		log.Printf("RID %q Failed to get item versions from DB in 'GetItemHistoryDiff': %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+	if len(res) != 2 {
+		return nil, model.ErrVersionNotFound
+	}
+	if isMovementRecord(res[0]) || isMovementRecord(res[1]) {
+		return nil, model.ErrVersionNotSnapshot
+	}
+
+	// состояние после версии - ее new_data; после COMPLETE DELETE оно пустое
+	changes, err := diffSnapshots(res[0].NewData, res[1].NewData, ignoredFields(req.Ignore))
+	if err != nil {
+		log.Printf("RID %q Failed to compare item versions in 'GetItemHistoryDiff': %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+
+	return &model.ItemDiff{ItemID: itemID, From: req.From, To: req.To, Changes: changes}, nil
+}
+
+// RevertItemByID возвращает товар к состоянию после версии rv.Version обычным обновлением: история не переписывается,
+// а получает запись REVERT со ссылкой на эту версию. Остаток не откатывается - он меняется только движениями
+func (svc WHCService) RevertItemByID(ctx context.Context, itemID int, rv *model.ItemRevert, version *int, userID int, role, username string) error {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if itemID <= 0 {
+		return model.ErrIncorrectItemID
+	}
+	if rv.Version <= 0 {
+		return model.ErrInvalidRevertVersion
+	}
+
+	if !svc.policy.Can(role, model.PermItemsRevert) {
+		return model.ErrAccessDenied
+	}
+
+	// откатить товар с чужого склада нельзя так же, как и удалить
+	if err := svc.checkItemScope(ctx, rid, "RevertItemByID", itemID, userID); err != nil {
+		return err
+	}
+
+	// обе границы выборки - одна и та же версия
+	res, err := svc.repo.GetItemHistoryVersions(ctx, itemID, rv.Version, rv.Version)
+	if err != nil {
+		log.Printf("RID %q Failed to get item version from DB in 'RevertItemByID': %q", rid, err)
+		return model.ErrCommon500
+	}
+	if len(res) == 0 {
+		return model.ErrVersionNotFound
+	}
+	// у перемещения по ячейкам нет состояния товара, к которому можно вернуться
+	if isMovementRecord(res[0]) {
+		return model.ErrVersionNotSnapshot
+	}
+
+	// удаленный товар откатывается только после восстановления
+	current, err := svc.repo.GetItemSnapshot(ctx, itemID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrItemNotFound):
+			return err
+		default:
+			log.Printf("RID %q Failed to get item from DB in 'RevertItemByID': %q", rid, err)
+			return model.ErrCommon500
+		}
+	}
+
+	item, err := revertUpdate(res[0].NewData, current)
+	if err != nil {
+		log.Printf("RID %q Item #%d version %d cannot be reverted in 'RevertItemByID': %q", rid, itemID, rv.Version, err)
+		return model.ErrSnapshotSchemaMismatch
+	}
+	item.ID = itemID
+	item.UpdatedBy = username
+	item.Version = version
+	item.RevertOf = &rv.Version
+
+	if err := validateItemUpdate(item); err != nil {
+		return err // 400
+	}
+
+	if err := svc.repo.UpdateItem(ctx, item, false); err != nil {
+		switch {
+		case errors.Is(err, model.ErrItemNotFound), errors.Is(err, model.ErrVersionMismatch):
+			return err
+		default:
+			log.Printf("RID %q Failed to revert item in DB in 'RevertItemByID': %q", rid, err)
+			return model.ErrCommon500
+		}
+	}
+
+	return nil
+}
+
+// revertUpdate собирает обновление товара из снимка версии. Снимок применим, только если в нем ровно те же поля,
+// что и в текущей строке товара: после смены схемы часть состояния восстановить уже нельзя
+func revertUpdate(snapshot, current *json.RawMessage) (*model.ItemUpdate, error) {
+	if snapshot == nil {
+		return nil, errors.New("version has no snapshot")
+	}
+
+	oldFields, err := snapshotFields(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	curFields, err := snapshotFields(current)
+	if err != nil {
+		return nil, err
+	}
+	if len(oldFields) != len(curFields) {
+		return nil, fmt.Errorf("snapshot has %d fields, item has %d", len(oldFields), len(curFields))
+	}
+	for k := range curFields {
+		if _, ok := oldFields[k]; !ok {
+			return nil, fmt.Errorf("snapshot has no field %q", k)
+		}
+	}
+
+	var fields struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+		Price       *int64  `json:"price"`
+		Visible     *bool   `json:"visible"`
+	}
+	if err := json.Unmarshal(*snapshot, &fields); err != nil {
+		return nil, err
+	}
+	if fields.Title == nil || fields.Price == nil || fields.Visible == nil {
+		return nil, errors.New("snapshot has null in required field")
+	}
+
+	// description допускает NULL - такой снимок откатывает описание в пустое
+	description := ""
+	if fields.Description != nil {
+		description = *fields.Description
+	}
+
+	return &model.ItemUpdate{
+		Title:       fields.Title,
+		Description: &description,
+		Price:       fields.Price,
+		Visible:     fields.Visible,
+	}, nil
+}
+
+// fillChanges заполняет changes у записей истории; запись с нечитаемым снимком и запись перемещения
+// по ячейкам остаются без них
+func fillChanges(rid string, history []*model.ItemHistory, ignore *string) {
+	ignored := ignoredFields(ignore)
+	for _, h := range history {
+		if isMovementRecord(h) {
+			h.Changes = []model.FieldChange{}
+			continue
+		}
+		changes, err := diffSnapshots(h.OldData, h.NewData, ignored)
+		if err != nil {
+			log.Printf("RID %q Failed to compare snapshots of history record #%d: %q", rid, h.ID, err)
+			changes = []model.FieldChange{}
+		}
+		h.Changes = changes
+	}
+}
+
+// isMovementRecord - запись PUTAWAY или MOVE: в new_data у нее перемещение по ячейкам, а не снимок товара
+func isMovementRecord(h *model.ItemHistory) bool {
+	return h.Action == model.HistoryActionPutaway || h.Action == model.HistoryActionMove
+}
+
+// ignoredFields разбирает список полей через запятую; без списка скрываются шумовые поля, пустой список не скрывает ничего
+func ignoredFields(raw *string) []string {
+	if raw == nil {
+		return model.HistoryNoiseFields
+	}
+
+	var res []string
+	for _, f := range strings.Split(*raw, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			res = append(res, f)
+		}
+	}
+	return res
+}
+
+// diffSnapshots возвращает поля, различающиеся в двух снимках товара, по алфавиту
+func diffSnapshots(oldData, newData *json.RawMessage, ignored []string) ([]model.FieldChange, error) {
+	oldFields, err := snapshotFields(oldData)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := snapshotFields(newData)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(oldFields)+len(newFields))
+	for k := range oldFields {
+		keys = append(keys, k)
+	}
+	for k := range newFields {
+		if _, ok := oldFields[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+
+	changes := make([]model.FieldChange, 0)
+	for _, k := range keys {
+		if slices.Contains(ignored, k) {
+			continue
+		}
+		oldValue, newValue := oldFields[k], newFields[k]
+		if bytes.Equal(oldValue, newValue) {
+			continue
+		}
+		changes = append(changes, model.FieldChange{Field: k, Old: nullIfEmpty(oldValue), New: nullIfEmpty(newValue)})
+	}
+
+	return changes, nil
+}
+
+// snapshotFields раскладывает снимок на поля с компактными значениями - равные значения дают равные байты
+func snapshotFields(data *json.RawMessage) (map[string]json.RawMessage, error) {
+	fields := make(map[string]json.RawMessage)
+	if data == nil {
+		return fields, nil
+	}
+	if err := json.Unmarshal(*data, &fields); err != nil {
+		return nil, err
+	}
+
+	for k, v := range fields {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, v); err != nil {
+			return nil, err
+		}
+		fields[k] = buf.Bytes()
+	}
+	return fields, nil
+}
+
+// nullIfEmpty - отсутствующее в снимке поле отдается как null
+func nullIfEmpty(v json.RawMessage) json.RawMessage {
+	if v == nil {
+		return json.RawMessage("null")
+	}
+	return v
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+)
+
+// ClaimIdempotencyKey закрепляет Idempotency-Key за запросом. nil без ошибки - запрос выполняется впервые,
+// иначе возвращается сохраненный ответ для повтора
+func (svc WHCService) ClaimIdempotencyKey(ctx context.Context, rec *model.IdempotencyRecord) (*model.IdempotencyRecord, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	rec.ExpiresAt = time.Now().Add(svc.cfg.IdempotencyTTL)
+
+	saved, err := svc.repo.ClaimIdempotencyKey(ctx, rec)
+	if err != nil {
+		log.Printf("RID %q Failed to claim idempotency key in DB in 'ClaimIdempotencyKey': %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+
+	switch {
+	case saved == nil:
+		return nil, nil
+	case saved.RequestHash != rec.RequestHash:
+		return nil, model.ErrIdempotencyKeyReused
+	case saved.StatusCode == 0:
+		return nil, model.ErrIdempotencyInProgress
+	default:
+		return saved, nil
+	}
+}
+
+// SaveIdempotentResponse сохраняет ответ для повторов. Ошибка только логируется: изменение уже выполнено,
+// а повтор без сохраненного ответа получит 409 до истечения ключа
+func (svc WHCService) SaveIdempotentResponse(ctx context.Context, rec *model.IdempotencyRecord) {
+	if err := svc.repo.SaveIdempotentResponse(ctx, rec); err != nil {
+		log.Printf("RID %q Failed to save idempotent response in DB: %q", model.RequestIDFromCtx(ctx), err)
+	}
+}
+
+// ReleaseIdempotencyKey освобождает ключ, если запрос не выполнен - повтор с ним пойдет как новый запрос
+func (svc WHCService) ReleaseIdempotencyKey(ctx context.Context, owner, key string) {
+	if err := svc.repo.DeleteIdempotencyKey(ctx, owner, key); err != nil {
+		log.Printf("RID %q Failed to release idempotency key in DB: %q", model.RequestIDFromCtx(ctx), err)
+	}
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+)
+
+// DefaultInviteTTL - срок действия инвайта, если админ не указал свой
+const DefaultInviteTTL = 72 * time.Hour
+
+func (svc WHCService) CreateInvite(ctx context.Context, inviteRole string, ttl time.Duration, role, username string) (*model.Invite, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if !svc.policy.Can(role, model.PermUsersManage) {
+		return nil, model.ErrAccessDenied
+	}
+
+	if !svc.policy.IsCorrectRole(inviteRole) {
+		return nil, model.ErrIncorrectUserRole
+	}
+
+	switch {
+	case ttl < 0:
+		return nil, model.ErrInvalidInviteTTL
+	case ttl == 0:
+		ttl = DefaultInviteTTL
+	}
+
+	token, err := newToken()
+	if err != nil {
+		log.Printf("RID %q Failed to generate invite token in 'CreateInvite': %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+
+	invite := &model.Invite{
+		Role:      inviteRole,
+		ExpiresAt: time.Now().UTC().Add(ttl),
+		CreatedBy: username,
+	}
+
+	if err := svc.repo.CreateInvite(ctx, invite, hashToken(token)); err != nil {
+		log.Printf("RID %q Failed to put new invite to DB in 'CreateInvite': %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+
+	// открытый токен отдается только один раз - в БД его нет
+	invite.Token = token
+
+	return invite, nil
+}
+
+func (svc WHCService) GetInvitesList(ctx context.Context, role string) ([]*model.Invite, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if !svc.policy.Can(role, model.PermUsersManage) {
+		return nil, model.ErrAccessDenied
+	}
+
+	res, err := svc.repo.GetInvitesList(ctx)
+	if err != nil {
+		log.Printf("RID %q Failed to get invites list from DB in 'GetInvitesList': %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+
+	return res, nil
+}
+
+func (svc WHCService) RevokeInvite(ctx context.Context, inviteID int, role string) error {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if inviteID <= 0 {
+		return model.ErrInviteNotFound
+	}
+
+	if !svc.policy.Can(role, model.PermUsersManage) {
+		return model.ErrAccessDenied
+	}
+
+	if err := svc.repo.RevokeInvite(ctx, inviteID); err != nil {
+		switch {
+		case errors.Is(err, model.ErrInviteNotFound):
+			return err
+		default:
+			log.Printf("RID %q Failed to revoke invite in DB in 'RevokeInvite': %q", rid, err)
+			return model.ErrCommon500
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+)
+
+func (svc WHCService) CreateLocation(ctx context.Context, loc *model.Location, role, username string) error {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if loc.WarehouseID <= 0 {
+		return model.ErrIncorrectWarehouseID
+	}
+
+	if !svc.policy.Can(role, model.PermWarehousesManage) {
+		return model.ErrAccessDenied
+	}
+
+	loc.Code = strings.ToUpper(strings.TrimSpace(loc.Code))
+	loc.Kind = strings.ToUpper(strings.TrimSpace(loc.Kind))
+	if loc.Code == "" {
+		return model.ErrEmptyLocationInfo
+	}
+	level, ok := model.LocationLevels[loc.Kind]
+	if !ok {
+		return model.ErrInvalidLocationKind
+	}
+
+	// родитель должен быть на том же складе и ровно уровнем выше: зона -> ряд -> стеллаж -> ячейка
+	if loc.ParentID != nil {
+		parent, err := svc.repo.GetLocation(ctx, *loc.ParentID)
+		if err != nil {
+			switch {
+			case errors.Is(err, model.ErrLocationNotFound):
+				return model.ErrInvalidLocationTree
+			default:
+				log.Printf("RID %q Failed to get parent location from DB in 'CreateLocation': %q", rid, err)
+				return model.ErrCommon500
+			}
+		}
+		if parent.WarehouseID != loc.WarehouseID || model.LocationLevels[parent.Kind] != level-1 {
+			return model.ErrInvalidLocationTree
+		}
+	}
+	loc.UpdatedBy = username
+
+	if err := svc.repo.CreateLocation(ctx, loc); err != nil {
+		switch {
+		case errors.Is(err, model.ErrWarehouseNotFound):
+			return err
+		case isUniqueViolation(err):
+			return model.ErrLocationAlreadyExists
+		default:
+			log.Printf("RID %q Failed to create location in DB in 'CreateLocation': %q", rid, err)
+			return model.ErrCommon500
+		}
+	}
+
+	return nil
+}
+
+func (svc WHCService) GetLocations(ctx context.Context, warehouseID int, role string) ([]*model.Location, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if warehouseID <= 0 {
+		return nil, model.ErrIncorrectWarehouseID
+	}
+
+	if !svc.policy.Can(role, model.PermItemsRead) {
+		return nil, model.ErrAccessDenied
+	}
+
+	res, err := svc.repo.GetLocations(ctx, warehouseID)
+	if err != nil {
+		log.Printf("RID %q Failed to get locations from DB in 'GetLocations': %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+
+	// у каждого склада есть хотя бы ячейка приемки
+	if len(res) == 0 {
+		return nil, model.ErrWarehouseNotFound
+	}
+
+	return res, nil
+}
+
+func (svc WHCService) GetLocationStock(ctx context.Context, locationID int, role string) ([]*model.LocationStock, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if locationID <= 0 {
+		return nil, model.ErrIncorrectLocationID
+	}
+
+	if !svc.policy.Can(role, model.PermItemsRead) {
+		return nil, model.ErrAccessDenied
+	}
+
+	if _, err := svc.repo.GetLocation(ctx, locationID); err != nil {
+		switch {
+		case errors.Is(err, model.ErrLocationNotFound):
+			return nil, err
+		default:
+			log.Printf("RID %q Failed to get location from DB in 'GetLocationStock': %q", rid, err)
+			return nil, model.ErrCommon500
+		}
+	}
+
+	res, err := svc.repo.GetLocationStock(ctx, locationID)
+	if err != nil {
+		log.Printf("RID %q Failed to get location stock from DB in 'GetLocationStock': %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+
+	return res, nil
+}
+
+func (svc WHCService) GetItemLocations(ctx context.Context, itemID int, role string) ([]*model.LocationStock, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if itemID <= 0 {
+		return nil, model.ErrIncorrectItemID
+	}
+
+	if !svc.policy.Can(role, model.PermItemsRead) {
+		return nil, model.ErrAccessDenied
+	}
+
+	res, err := svc.repo.GetItemLocations(ctx, itemID)
+	if err != nil {
+		log.Printf("RID %q Failed to get item locations from DB in 'GetItemLocations': %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+
+	return res, nil
+}
+
+// MoveItemStock размещает товар из приемки (mv.FromLocationID не задан) или переносит его между ячейками склада
+func (svc WHCService) MoveItemStock(ctx context.Context, mv *model.StockMove, userID int, role string) error {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if mv.ItemID <= 0 {
+		return model.ErrIncorrectItemID
+	}
+	if mv.ToLocationID <= 0 || (mv.FromLocationID != nil && *mv.FromLocationID <= 0) {
+		return model.ErrIncorrectLocationID
+	}
+	if mv.Amount <= 0 {
+		return model.ErrInvalidMoveAmount
+	}
+
+	if !svc.policy.Can(role, model.PermItemsUpdate) {
+		return model.ErrAccessDenied
+	}
+
+	to, err := svc.repo.GetLocation(ctx, mv.ToLocationID)
+	if err != nil {
+		return svc.locationError(rid, "MoveItemStock", err)
+	}
+
+	var from *model.Location
+	if mv.FromLocationID == nil {
+		mv.Action = model.HistoryActionPutaway
+		from, err = svc.repo.GetReceivingLocation(ctx, to.WarehouseID)
+	} else {
+		mv.Action = model.HistoryActionMove
+		from, err = svc.repo.GetLocation(ctx, *mv.FromLocationID)
+	}
+	if err != nil {
+		return svc.locationError(rid, "MoveItemStock", err)
+	}
+
+	// остатки лежат только в ячейках, а между складами товар перемещается не здесь
+	if to.Kind != model.LocationBin || from.Kind != model.LocationBin ||
+		from.ID == to.ID || from.WarehouseID != to.WarehouseID {
+		return model.ErrInvalidStockMove
+	}
+
+	if err := svc.checkWarehouseScope(ctx, userID, to.WarehouseID); err != nil {
+		return svc.warehouseError(rid, "MoveItemStock", err)
+	}
+
+	mv.FromLocationID = &from.ID
+	mv.WarehouseID = to.WarehouseID
+
+	if err := svc.repo.MoveItemStock(ctx, mv); err != nil {
+		switch {
+		case errors.Is(err, model.ErrItemNotFound), errors.Is(err, model.ErrInsufficientStock):
+			return err
+		default:
+			log.Printf("RID %q Failed to move item stock in DB in 'MoveItemStock': %q", rid, err)
+			return model.ErrCommon500
+		}
+	}
+
+	return nil
+}
+
+// locationError пропускает клиенту ненайденные места хранения, остальные ошибки логирует как внутренние
+func (svc WHCService) locationError(rid, method string, err error) error {
+	switch {
+	case errors.Is(err, model.ErrLocationNotFound), errors.Is(err, model.ErrWarehouseNotFound):
+		return err
+	default:
+		log.Printf("RID %q Failed to get location from DB in %q: %q", rid, method, err)
+		return model.ErrCommon500
+	}
+}
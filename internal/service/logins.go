@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"log"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyPassHash сравнивается с паролем для несуществующего логина, чтобы время ответа
+// не выдавало, есть ли такой пользователь
+var dummyPassHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+// checkLoginThrottle блокирует вход, если за окно накопилось слишком много неудач по логину или по IP.
+// Попытка во время блокировки тоже пишется в журнал, но в счетчик не входит
+func (svc WHCService) checkLoginThrottle(ctx context.Context, username, role string) error {
+	rid := model.RequestIDFromCtx(ctx)
+	ip := model.ClientIPFromCtx(ctx)
+
+	failures, err := svc.repo.GetLoginFailures(ctx, username, ip, svc.cfg.LoginWindow)
+	if err != nil {
+		log.Printf("RID %q Failed to count login failures in DB in 'LoginUser': %q", rid, err)
+		return model.ErrCommon500
+	}
+
+	if failures.ByUser >= svc.cfg.LoginMaxUserFailures || failures.ByIP >= svc.cfg.LoginMaxIPFailures {
+		log.Printf("RID %q Login locked for username %q from IP %q: %d user failures, %d IP failures",
+			rid, username, ip, failures.ByUser, failures.ByIP)
+		svc.recordLoginAttempt(ctx, username, role, model.LoginFailLocked)
+		return model.ErrTooManyLoginAttempts
+	}
+
+	return nil
+}
+
+// recordLoginAttempt пишет попытку входа в счетчик неудач и в журнал аутентификации; пустой reason - успешный вход.
+// Ошибка записи только логируется - из-за журнала вход не ломаем
+func (svc WHCService) recordLoginAttempt(ctx context.Context, username, role, reason string) {
+	attempt := &model.LoginAttempt{Username: username, IP: model.ClientIPFromCtx(ctx), Success: reason == ""}
+	event := &model.AuthEvent{Type: model.AuthEventLogin, Username: username, Role: role}
+	if reason != "" {
+		attempt.Reason = &reason
+		event.Type = model.AuthEventLoginFailed
+		event.Detail = reason
+	}
+
+	if err := svc.repo.RecordLoginAttempt(ctx, attempt); err != nil {
+		log.Printf("RID %q Failed to record login attempt in DB in 'LoginUser': %q", model.RequestIDFromCtx(ctx), err)
+	}
+	svc.recordAuthEvent(ctx, event)
+}
+
+func (svc WHCService) GetLoginAttempts(ctx context.Context, rp *model.RequestParam, filter *model.LoginAttemptFilter, role string) ([]*model.LoginAttempt, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if !svc.policy.Can(role, model.PermUsersManage) {
+		return nil, model.ErrAccessDenied
+	}
+
+	if err := validateReqParams(rp, nil); err != nil {
+		return nil, err
+	}
+
+	res, err := svc.repo.GetLoginAttempts(ctx, rp, filter)
+	if err != nil {
+		log.Printf("RID %q Failed to get login attempts from DB in 'GetLoginAttempts': %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+
+	return res, nil
+}
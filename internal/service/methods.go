@@ -5,16 +5,17 @@ import (
 	"context"
 	"errors"
 	"log"
+	"slices"
 	"strings"
 
 	"github.com/UnendingLoop/WarehouseControl/internal/model"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func (svc WHCService) CreateItem(ctx context.Context, item *model.Item, role string) error {
+func (svc WHCService) CreateItem(ctx context.Context, item *model.Item, userID int, role string) error {
 	rid := model.RequestIDFromCtx(ctx)
 
-	if !svc.policy.AccessToCreate(role) {
+	if !svc.policy.Can(role, model.PermItemsCreate) {
 		return model.ErrAccessDenied
 	}
 
@@ -22,9 +23,23 @@ func (svc WHCService) CreateItem(ctx context.Context, item *model.Item, role str
 		return err // 400
 	}
 
+	// начальный остаток всегда приходуется на конкретный склад
+	if item.WarehouseID != nil || item.AvailableAmount > 0 {
+		whID, err := svc.resolveWarehouse(ctx, item.WarehouseID, userID)
+		if err != nil {
+			return svc.warehouseError(rid, "CreateItem", err)
+		}
+		item.WarehouseID = whID
+	}
+
 	if err := svc.repo.CreateItem(ctx, item); err != nil {
-		log.Printf("RID %q Failed to create new item in DB in 'CreateItem': %v", rid, err)
-		return model.ErrCommon500
+		switch {
+		case errors.Is(err, model.ErrWarehouseNotFound):
+			return err
+		default:
+			log.Printf("RID %q Failed to create new item in DB in 'CreateItem': %v", rid, err)
+			return model.ErrCommon500
+		}
 	}
 
 	return nil
@@ -37,27 +52,38 @@ func (svc WHCService) GetItemByID(ctx context.Context, id int, role string) (*mo
 		return nil, model.ErrIncorrectItemID
 	}
 
-	if !svc.policy.AccessToGetItems(role) {
+	if !svc.policy.Can(role, model.PermItemsRead) {
 		return nil, model.ErrAccessDenied
 	}
 
-	res, err := svc.repo.GetItemByID(ctx, id, svc.policy.AccessToSeeDeleted(role))
+	res, err := svc.repo.GetItemByID(ctx, id, svc.policy.Can(role, model.PermItemsSeeDeleted))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrItemNotFound):
+			return nil, err
+		default:
+			log.Printf("RID %q Failed to get item from DB in 'GetItemByID': %q", rid, err)
+			return nil, model.ErrCommon500
+		}
+	}
+
+	res.Stock, err = svc.repo.GetItemStock(ctx, id)
 	if err != nil {
-		log.Printf("RID %q Failed to get item from DB in 'GetItemByID': %q", rid, err)
+		log.Printf("RID %q Failed to get item stock from DB in 'GetItemByID': %q", rid, err)
 		return nil, model.ErrCommon500
 	}
 
 	return res, nil
 }
 
-func (svc WHCService) UpdateItemByID(ctx context.Context, item *model.ItemUpdate, role string) error {
+func (svc WHCService) UpdateItemByID(ctx context.Context, item *model.ItemUpdate, userID int, role string) error {
 	rid := model.RequestIDFromCtx(ctx)
 
 	if item.ID <= 0 {
 		return model.ErrIncorrectItemID
 	}
 
-	if !svc.policy.AccessToUpdate(role) {
+	if !svc.policy.Can(role, model.PermItemsUpdate) {
 		return model.ErrAccessDenied
 	}
 
@@ -65,9 +91,19 @@ func (svc WHCService) UpdateItemByID(ctx context.Context, item *model.ItemUpdate
 		return err // 400
 	}
 
-	if err := svc.repo.UpdateItem(ctx, item, svc.policy.AccessToSeeDeleted(role)); err != nil {
+	// остаток меняется только на одном складе, к которому у пользователя должен быть доступ
+	if item.AvailableAmount != nil {
+		whID, err := svc.resolveWarehouse(ctx, item.WarehouseID, userID)
+		if err != nil {
+			return svc.warehouseError(rid, "UpdateItemByID", err)
+		}
+		item.WarehouseID = whID
+	}
+
+	if err := svc.repo.UpdateItem(ctx, item, svc.policy.Can(role, model.PermItemsSeeDeleted)); err != nil {
 		switch {
-		case errors.Is(err, model.ErrItemNotFound):
+		case errors.Is(err, model.ErrItemNotFound), errors.Is(err, model.ErrWarehouseNotFound), errors.Is(err, model.ErrStockPlacedInBins),
+			errors.Is(err, model.ErrVersionMismatch):
 			return err
 		default:
 			log.Printf("RID %q Failed to update item in DB in 'UpdateItemByID': %q", rid, err)
@@ -78,20 +114,25 @@ func (svc WHCService) UpdateItemByID(ctx context.Context, item *model.ItemUpdate
 	return nil
 }
 
-func (svc WHCService) DeleteItemByID(ctx context.Context, itemID int, role, username string) error {
+func (svc WHCService) DeleteItemByID(ctx context.Context, itemID int, version *int, userID int, role, username string) error {
 	rid := model.RequestIDFromCtx(ctx)
 
 	if itemID <= 0 {
 		return model.ErrIncorrectItemID
 	}
 
-	if !svc.policy.AccessToDelete(role) {
+	if !svc.policy.Can(role, model.PermItemsDelete) {
 		return model.ErrAccessDenied
 	}
 
-	if err := svc.repo.DeleteItem(ctx, itemID, username); err != nil {
+	// пользователь, ограниченный складами, не может списать товар, который лежит на чужом складе
+	if err := svc.checkItemScope(ctx, rid, "DeleteItemByID", itemID, userID); err != nil {
+		return err
+	}
+
+	if err := svc.repo.DeleteItem(ctx, itemID, version, username); err != nil {
 		switch {
-		case errors.Is(err, model.ErrItemNotFound):
+		case errors.Is(err, model.ErrItemNotFound), errors.Is(err, model.ErrVersionMismatch):
 			return err
 		default:
 			log.Printf("RID %q Failed to delete item in DB in 'DeleteItemByID': %q", rid, err)
@@ -101,85 +142,234 @@ func (svc WHCService) DeleteItemByID(ctx context.Context, itemID int, role, user
 	return nil
 }
 
-func (svc WHCService) CreateUser(ctx context.Context, user *model.User) (string, error) {
+// RestoreItemByID возвращает удаленный товар из корзины
+func (svc WHCService) RestoreItemByID(ctx context.Context, itemID int, userID int, role, username string) error {
 	rid := model.RequestIDFromCtx(ctx)
 
+	if itemID <= 0 {
+		return model.ErrIncorrectItemID
+	}
+
+	if !svc.policy.Can(role, model.PermItemsRestore) {
+		return model.ErrAccessDenied
+	}
+
+	// вернуть товар с чужого склада нельзя так же, как и удалить
+	if err := svc.checkItemScope(ctx, rid, "RestoreItemByID", itemID, userID); err != nil {
+		return err
+	}
+
+	if err := svc.repo.RestoreItem(ctx, itemID, username); err != nil {
+		switch {
+		case errors.Is(err, model.ErrItemNotFound), errors.Is(err, model.ErrItemNotDeleted):
+			return err
+		default:
+			log.Printf("RID %q Failed to restore item in DB in 'RestoreItemByID': %q", rid, err)
+			return model.ErrCommon500
+		}
+	}
+	return nil
+}
+
+// checkItemScope проверяет, что все остатки товара лежат на складах, доступных пользователю
+func (svc WHCService) checkItemScope(ctx context.Context, rid, method string, itemID, userID int) error {
+	scope, err := svc.warehouseScope(ctx, userID)
+	if err != nil {
+		log.Printf("RID %q Failed to get user warehouses from DB in %q: %q", rid, method, err)
+		return model.ErrCommon500
+	}
+	if scope == nil {
+		return nil
+	}
+
+	stock, err := svc.repo.GetItemStock(ctx, itemID)
+	if err != nil {
+		log.Printf("RID %q Failed to get item stock from DB in %q: %q", rid, method, err)
+		return model.ErrCommon500
+	}
+	for _, s := range stock {
+		if !slices.Contains(scope, s.WarehouseID) {
+			return model.ErrWarehouseAccessDenied
+		}
+	}
+	return nil
+}
+
+func (svc WHCService) CreateUser(ctx context.Context, user *model.User, inviteToken string) (*model.AuthTokens, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	// проверяем, разрешена ли регистрация в текущем режиме
+	switch {
+	case svc.cfg.SignupMode == model.SignupDisabled:
+		svc.recordSignupFailure(ctx, user, model.ErrSignupDisabled)
+		return nil, model.ErrSignupDisabled
+	case svc.cfg.SignupMode == model.SignupInvite && inviteToken == "":
+		svc.recordSignupFailure(ctx, user, model.ErrInviteRequired)
+		return nil, model.ErrInviteRequired
+	}
+
 	// валидируем инфу о пользователе
 	if err := validateNormalizeNewUser(user); err != nil {
-		return "", err
+		return nil, err
 	}
 
-	// Проверка роли
-	if !svc.policy.IsCorrectRole(user.Role) {
-		return "", model.ErrIncorrectUserRole
+	// роль никогда не берется из запроса: либо из инвайта, либо дефолтная
+	var err error
+	switch inviteToken {
+	case "":
+		user.Role = svc.cfg.SignupDefaultRole
+		err = svc.repo.CreateUser(ctx, user)
+	default:
+		err = svc.repo.CreateUserWithInvite(ctx, user, hashToken(inviteToken))
 	}
-
-	// создаем его в бд
-	err := svc.repo.CreateUser(ctx, user)
 	if err != nil {
 		switch {
+		case errors.Is(err, model.ErrInvalidInvite):
+			svc.recordSignupFailure(ctx, user, err)
+			return nil, err
 		case strings.Contains(err.Error(), "unique violation"):
-			return "", model.ErrUserAlreadyExists
+			svc.recordSignupFailure(ctx, user, model.ErrUserAlreadyExists)
+			return nil, model.ErrUserAlreadyExists
 		default:
 			log.Printf("RID %q Failed to put new user to DB in 'CreateUser': %q", rid, err)
-			return "", model.ErrCommon500
+			return nil, model.ErrCommon500
 		}
 	}
 
-	// сразу генерируем токен авторизации
-	token, err := svc.jwtManager.Generate(user.ID, user.UserName, user.Role)
+	user.Permissions = svc.permissionsOf(user.Role)
+
+	// сразу выпускаем токены сессии
+	tokens, err := svc.issueTokens(ctx, user)
 	if err != nil {
-		return "", model.ErrCommon500
+		return nil, err
 	}
 
-	return token, nil
+	svc.recordAuthEvent(ctx, &model.AuthEvent{Type: model.AuthEventSignup, UserID: user.ID, Username: user.UserName, Role: user.Role})
+
+	return tokens, nil
 }
 
-func (svc WHCService) LoginUser(ctx context.Context, username string, password string, role string) (string, *model.User, error) {
+func (svc WHCService) LoginUser(ctx context.Context, username string, password string, role string) (*model.AuthTokens, *model.User, *model.LoginChallenge, error) {
 	rid := model.RequestIDFromCtx(ctx)
 
-	if !svc.policy.IsCorrectRole(role) {
-		return "", nil, model.ErrIncorrectUserRole
+	// роль в запросе необязательна - если не указана, используется основная роль пользователя из БД
+	if role != "" && !svc.policy.IsCorrectRole(role) {
+		return nil, nil, nil, model.ErrIncorrectUserRole
+	}
+
+	username = strings.ToLower(username)
+
+	// защита от перебора: сначала проверяем, не заблокирован ли логин или адрес
+	if err := svc.checkLoginThrottle(ctx, username, role); err != nil {
+		return nil, nil, nil, err
 	}
 
 	// получаем инфу о пользователе из БД
-	user, err := svc.repo.GetUserByName(ctx, strings.ToLower(username))
+	user, err := svc.repo.GetUserByName(ctx, username)
 	if err != nil {
 		switch {
 		case errors.Is(err, model.ErrUserNotFound):
-			return "", nil, err
+			// несуществующий логин неотличим от неверного пароля - ни по ответу, ни по времени
+			_ = bcrypt.CompareHashAndPassword(dummyPassHash, []byte(password))
+			svc.recordLoginAttempt(ctx, username, role, model.LoginFailUnknownUser)
+			return nil, nil, nil, model.ErrInvalidCredentials
 		default:
 			log.Printf("RID %q Failed to get user from DB in 'LoginUser': %q", rid, err)
-			return "", nil, model.ErrCommon500
+			return nil, nil, nil, model.ErrCommon500
 		}
 	}
 
 	// сравниваем предоставленный пароль с хранимым хэшом
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PassHash), []byte(password)); err != nil {
-		return "", nil, model.ErrInvalidCredentials
+		svc.recordLoginAttempt(ctx, username, role, model.LoginFailBadPassword)
+		return nil, nil, nil, model.ErrInvalidCredentials
+	}
+
+	// отключенная учетная запись не может авторизоваться
+	if user.DisabledAt != nil {
+		svc.recordLoginAttempt(ctx, username, role, model.LoginFailDisabled)
+		return nil, nil, nil, model.ErrUserDisabled
+	}
+
+	// проверяем, что запрошенная роль действительно принадлежит пользователю
+	resolved, err := svc.resolveLoginRole(ctx, user, role)
+	if err != nil {
+		if errors.Is(err, model.ErrAccessDenied) {
+			// пароль верный - в счетчик перебора не пишем, но попытку взять чужую роль видно в журнале
+			svc.recordAuthEvent(ctx, &model.AuthEvent{Type: model.AuthEventLoginFailed, UserID: user.ID, Username: username,
+				Role: role, Detail: model.LoginFailRoleNotGranted})
+		}
+		return nil, nil, nil, err
+	}
+	role = resolved
+
+	// в ответе и токенах - роль текущей сессии
+	user.Role = role
+	user.Permissions = svc.permissionsOf(role)
+
+	// при втором факторе токены выдаются только после кода из приложения - клиент получает челлендж
+	challenge, err := svc.loginChallenge(ctx, user)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if challenge != nil {
+		return nil, nil, challenge, nil
+	}
+
+	// выпускаем токены сессии
+	tokens, err := svc.issueTokens(ctx, user)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// успешный вход обнуляет счетчик неудач по логину
+	svc.recordLoginAttempt(ctx, username, role, "")
+
+	return tokens, user, nil, nil
+}
+
+// resolveLoginRole возвращает роль для сессии: основную роль пользователя, либо одну из выданных ему админом.
+// Любая другая роль отклоняется.
+func (svc WHCService) resolveLoginRole(ctx context.Context, user *model.User, requested string) (string, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if requested == "" || requested == user.Role {
+		return user.Role, nil
 	}
 
-	// генерируем токен авторизации
-	token, err := svc.jwtManager.Generate(user.ID, user.UserName, role)
+	granted, err := svc.repo.GetUserRoles(ctx, user.ID)
 	if err != nil {
-		return "", nil, model.ErrCommon500
+		log.Printf("RID %q Failed to get user roles from DB in 'LoginUser': %q", rid, err)
+		return "", model.ErrCommon500
+	}
+
+	if !slices.Contains(granted, requested) {
+		return "", model.ErrAccessDenied
 	}
 
-	return token, user, nil
+	return requested, nil
 }
 
-func (svc WHCService) GetItemsList(ctx context.Context, rpi *model.RequestParam, role string) ([]*model.Item, error) {
+func (svc WHCService) GetItemsList(ctx context.Context, rpi *model.RequestParam, filter *model.ItemFilter, role string) ([]*model.Item, error) {
 	rid := model.RequestIDFromCtx(ctx)
 
-	if !svc.policy.AccessToGetItems(role) {
+	if !svc.policy.Can(role, model.PermItemsRead) {
 		return nil, model.ErrAccessDenied
 	}
 
-	if err := validateReqParams(rpi); err != nil {
+	if err := validateReqParams(rpi, model.OrderByItemsMap); err != nil {
 		return nil, err
 	}
 
-	res, err := svc.repo.GetItemsList(ctx, rpi, svc.policy.AccessToSeeDeleted(role))
+	if err := validateWarehouseFilter(filter.WarehouseID); err != nil {
+		return nil, err
+	}
+	// остатки по складам в History не хранятся - восстановить их на прошлый момент нельзя
+	if filter.AsOf != nil && filter.WarehouseID != nil {
+		return nil, model.ErrAsOfWithWarehouse
+	}
+
+	res, err := svc.repo.GetItemsList(ctx, rpi, filter, svc.policy.Can(role, model.PermItemsSeeDeleted))
 	if err != nil {
 		log.Printf("RID %q Failed to get items list from DB in 'GetItemsList': %q", rid, err)
 		return nil, model.ErrCommon500
@@ -188,22 +378,56 @@ func (svc WHCService) GetItemsList(ctx context.Context, rpi *model.RequestParam,
 	return res, nil
 }
 
-func (svc WHCService) GetItemHistoryByID(ctx context.Context, rph *model.RequestParam, id int, role string) ([]*model.ItemHistory, error) {
+// GetDeletedItems - корзина: удаленные товары с автором и временем удаления
+func (svc WHCService) GetDeletedItems(ctx context.Context, rp *model.RequestParam, role string) ([]*model.Item, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if !svc.policy.Can(role, model.PermItemsSeeDeleted) {
+		return nil, model.ErrAccessDenied
+	}
+
+	if err := validateReqParams(rp, nil); err != nil {
+		return nil, err
+	}
+
+	res, err := svc.repo.GetDeletedItems(ctx, rp)
+	if err != nil {
+		log.Printf("RID %q Failed to get deleted items from DB in 'GetDeletedItems': %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+
+	return res, nil
+}
+
+func (svc WHCService) GetItemHistoryByID(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, id int, role string) ([]*model.ItemHistory, error) {
+	return svc.itemHistoryByID(ctx, rph, filter, id, role, model.PermHistoryRead)
+}
+
+// ExportItemHistoryByID - то же самое для выгрузки в CSV, но под отдельным разрешением
+func (svc WHCService) ExportItemHistoryByID(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, id int, role string) ([]*model.ItemHistory, error) {
+	return svc.itemHistoryByID(ctx, rph, filter, id, role, model.PermHistoryExport)
+}
+
+func (svc WHCService) itemHistoryByID(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, id int, role string, permission string) ([]*model.ItemHistory, error) {
 	rid := model.RequestIDFromCtx(ctx)
 
 	if id <= 0 {
 		return nil, model.ErrIncorrectItemID
 	}
 
-	if !svc.policy.AccessToGetHistory(role) {
+	if !svc.policy.Can(role, permission) {
 		return nil, model.ErrAccessDenied
 	}
 
-	if err := validateReqParams(rph); err != nil {
+	if err := validateReqParams(rph, model.OrderByHistoryMap); err != nil {
+		return nil, err
+	}
+
+	if err := validateNormalizeHistoryFilter(filter); err != nil {
 		return nil, err
 	}
 
-	res, err := svc.repo.GetItemHistoryByID(ctx, rph, id)
+	res, err := svc.repo.GetItemHistoryByID(ctx, rph, filter, id)
 	if err != nil {
 		log.Printf("RID %q Failed to get item history from DB in 'GetItemHistoryByID': %q", rid, err)
 		return nil, model.ErrCommon500
@@ -213,25 +437,42 @@ func (svc WHCService) GetItemHistoryByID(ctx context.Context, rph *model.Request
 		return nil, model.ErrItemNotFound
 	}
 
+	fillChanges(rid, res, filter.Ignore)
+
 	return res, nil
 }
 
-func (svc WHCService) GetItemHistoryAll(ctx context.Context, rph *model.RequestParam, role string) ([]*model.ItemHistory, error) {
+func (svc WHCService) GetItemHistoryAll(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, role string) ([]*model.ItemHistory, error) {
+	return svc.itemHistoryAll(ctx, rph, filter, role, model.PermHistoryRead)
+}
+
+// ExportItemHistoryAll - то же самое для выгрузки в CSV, но под отдельным разрешением
+func (svc WHCService) ExportItemHistoryAll(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, role string) ([]*model.ItemHistory, error) {
+	return svc.itemHistoryAll(ctx, rph, filter, role, model.PermHistoryExport)
+}
+
+func (svc WHCService) itemHistoryAll(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, role string, permission string) ([]*model.ItemHistory, error) {
 	rid := model.RequestIDFromCtx(ctx)
 
-	if !svc.policy.AccessToGetHistory(role) {
+	if !svc.policy.Can(role, permission) {
 		return nil, model.ErrAccessDenied
 	}
 
-	if err := validateReqParams(rph); err != nil {
+	if err := validateReqParams(rph, model.OrderByHistoryMap); err != nil {
 		return nil, err
 	}
 
-	res, err := svc.repo.GetItemHistoryAll(ctx, rph)
+	if err := validateNormalizeHistoryFilter(filter); err != nil {
+		return nil, err
+	}
+
+	res, err := svc.repo.GetItemHistoryAll(ctx, rph, filter)
 	if err != nil {
 		log.Printf("RID %q Failed to get all history from DB in 'GetItemHistoryAll': %q", rid, err)
 		return nil, model.ErrCommon500
 	}
 
+	fillChanges(rid, res, filter.Ignore)
+
 	return res, nil
 }
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+)
+
+func (svc WHCService) CreateMovement(ctx context.Context, m *model.Movement, userID int, role string) ([]*model.Movement, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	// права проверяются до разбора тела: без них клиент не узнает подробностей валидации
+	if !svc.policy.Can(role, model.PermItemsUpdate) {
+		return nil, model.ErrAccessDenied
+	}
+
+	if err := validateNormalizeMovement(m); err != nil {
+		return nil, err // 400
+	}
+
+	// определяем склад и ячейку движения, для перемещения - еще и получателя
+	var err error
+	m.WarehouseID, m.LocationID, err = svc.resolveBin(ctx, m.WarehouseID, m.LocationID, userID)
+	if err != nil {
+		return nil, svc.movementError(rid, err)
+	}
+	if m.Type == model.MovementTransfer {
+		if m.ToWarehouseID == nil {
+			m.ToWarehouseID = m.WarehouseID
+		}
+		m.ToWarehouseID, m.ToLocationID, err = svc.resolveBin(ctx, m.ToWarehouseID, m.ToLocationID, userID)
+		if err != nil {
+			return nil, svc.movementError(rid, err)
+		}
+		if *m.ToLocationID == *m.LocationID {
+			return nil, model.ErrInvalidTransfer
+		}
+	}
+
+	res, err := svc.repo.CreateMovement(ctx, m)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrItemNotFound), errors.Is(err, model.ErrInsufficientStock):
+			return nil, err
+		default:
+			log.Printf("RID %q Failed to create movement in DB in 'CreateMovement': %q", rid, err)
+			return nil, model.ErrCommon500
+		}
+	}
+
+	return res, nil
+}
+
+// AdjustItemStock меняет остаток товара на delta движением adjustment: изменение применяется к значению в БД
+// под блокировкой товара, а уход ячейки в минус отклоняется целиком. Списание без ячейки проверяется
+// по общему остатку склада и снимается с ячеек по порядку - сначала с приемки, затем по коду ячейки
+func (svc WHCService) AdjustItemStock(ctx context.Context, adj *model.StockAdjustment, userID int, role string) ([]*model.Movement, error) {
+	if !svc.policy.Can(role, model.PermItemsUpdate) {
+		return nil, model.ErrAccessDenied
+	}
+
+	if adj.ItemID <= 0 {
+		return nil, model.ErrIncorrectItemID
+	}
+	if adj.Delta == 0 {
+		return nil, model.ErrInvalidStockDelta
+	}
+	if adj.ReasonCode == "" {
+		adj.ReasonCode = model.ReasonStockDelta
+	}
+
+	m := model.Movement{
+		ItemID:      adj.ItemID,
+		Type:        model.MovementAdjustment,
+		WarehouseID: adj.WarehouseID,
+		LocationID:  adj.LocationID,
+		Quantity:    adj.Delta,
+		ReasonCode:  adj.ReasonCode,
+		Reference:   adj.Reference,
+		Comment:     adj.Comment,
+		CreatedBy:   adj.UpdatedBy,
+	}
+
+	var res []*model.Movement
+	var err error
+	if adj.Delta < 0 && adj.LocationID == nil {
+		res, err = svc.takeFromWarehouse(ctx, &m, userID)
+	} else {
+		res, err = svc.CreateMovement(ctx, &m, userID, role)
+	}
+	if err != nil {
+		if errors.Is(err, model.ErrInsufficientStock) {
+			return nil, model.ErrNegativeStock
+		}
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// takeFromWarehouse проводит списание без ячейки: склад проверяется как для любого движения,
+// а ячейки, с которых снимается товар, выбирает репозиторий
+func (svc WHCService) takeFromWarehouse(ctx context.Context, m *model.Movement, userID int) ([]*model.Movement, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if err := validateNormalizeMovement(m); err != nil {
+		return nil, err // 400
+	}
+
+	var err error
+	if m.WarehouseID, _, err = svc.resolveBin(ctx, m.WarehouseID, nil, userID); err != nil {
+		return nil, svc.movementError(rid, err)
+	}
+
+	res, err := svc.repo.CreateMovement(ctx, m)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrItemNotFound), errors.Is(err, model.ErrInsufficientStock):
+			return nil, err
+		default:
+			log.Printf("RID %q Failed to create movement in DB in 'AdjustItemStock': %q", rid, err)
+			return nil, model.ErrCommon500
+		}
+	}
+
+	return res, nil
+}
+
+func (svc WHCService) GetMovements(ctx context.Context, rp *model.RequestParam, filter *model.MovementFilter, role string) ([]*model.Movement, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if !svc.policy.Can(role, model.PermHistoryRead) {
+		return nil, model.ErrAccessDenied
+	}
+
+	if err := validateReqParams(rp, nil); err != nil {
+		return nil, err
+	}
+
+	if filter.ItemID != nil && *filter.ItemID <= 0 {
+		return nil, model.ErrIncorrectItemID
+	}
+	if err := validateWarehouseFilter(filter.WarehouseID); err != nil {
+		return nil, err
+	}
+	if filter.Type != nil {
+		if _, ok := model.MovementTypesMap[*filter.Type]; !ok {
+			return nil, model.ErrInvalidMovementType
+		}
+	}
+
+	res, err := svc.repo.GetMovements(ctx, rp, filter)
+	if err != nil {
+		log.Printf("RID %q Failed to get movements from DB in 'GetMovements': %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+
+	return res, nil
+}
+
+// resolveBin определяет склад и ячейку движения: склад без ячейки - приемка склада,
+// ячейка без склада - ее склад, ничего не задано - приемка склада по умолчанию
+func (svc WHCService) resolveBin(ctx context.Context, warehouseID, locationID *int, userID int) (*int, *int, error) {
+	var loc *model.Location
+	if locationID != nil {
+		if *locationID <= 0 {
+			return nil, nil, model.ErrIncorrectLocationID
+		}
+		var err error
+		if loc, err = svc.repo.GetLocation(ctx, *locationID); err != nil {
+			return nil, nil, err
+		}
+		if warehouseID == nil {
+			warehouseID = &loc.WarehouseID
+		}
+	}
+
+	whID, err := svc.resolveWarehouse(ctx, warehouseID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if loc == nil {
+		if loc, err = svc.repo.GetReceivingLocation(ctx, *whID); err != nil {
+			return nil, nil, err
+		}
+	}
+	if loc.Kind != model.LocationBin || loc.WarehouseID != *whID {
+		return nil, nil, model.ErrLocationNotBin
+	}
+
+	return whID, &loc.ID, nil
+}
+
+// movementError пропускает клиенту ошибки выбора склада и ячейки, остальные логирует как внутренние
+func (svc WHCService) movementError(rid string, err error) error {
+	switch {
+	case errors.Is(err, model.ErrIncorrectWarehouseID),
+		errors.Is(err, model.ErrWarehouseAccessDenied),
+		errors.Is(err, model.ErrWarehouseNotFound),
+		errors.Is(err, model.ErrIncorrectLocationID),
+		errors.Is(err, model.ErrLocationNotFound),
+		errors.Is(err, model.ErrLocationNotBin):
+		return err
+	default:
+		log.Printf("RID %q Failed to resolve movement location in 'CreateMovement': %q", rid, err)
+		return model.ErrCommon500
+	}
+}
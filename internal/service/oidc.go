@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+)
+
+// StartOIDCLogin готовит одноразовые state/nonce/PKCE-verifier и адрес страницы логина IdP
+func (svc WHCService) StartOIDCLogin(ctx context.Context) (*model.OIDCFlow, string, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if svc.oidc == nil {
+		return nil, "", model.ErrOIDCDisabled
+	}
+
+	var flow model.OIDCFlow
+	for _, v := range []*string{&flow.State, &flow.Nonce, &flow.Verifier} {
+		token, err := newToken()
+		if err != nil {
+			log.Printf("RID %q Failed to generate oidc flow values in 'StartOIDCLogin': %q", rid, err)
+			return nil, "", model.ErrCommon500
+		}
+		*v = token
+	}
+
+	redirectURL, err := svc.oidc.AuthCodeURL(ctx, flow.State, flow.Nonce, flow.Verifier)
+	if err != nil {
+		log.Printf("RID %q Failed to build identity provider login url in 'StartOIDCLogin': %q", rid, err)
+		return nil, "", model.ErrOIDCLoginFailed
+	}
+
+	return &flow, redirectURL, nil
+}
+
+// CompleteOIDCLogin завершает вход через IdP: проверяет state, обменивает код, создает пользователя
+// при первом входе (роль - по группам IdP) и выпускает обычные токены сессии. Второй фактор спрашивается
+// так же, как при входе по паролю: вместо токенов возвращается челлендж
+func (svc WHCService) CompleteOIDCLogin(ctx context.Context, flow *model.OIDCFlow, state, code string) (*model.AuthTokens, *model.User, *model.LoginChallenge, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if svc.oidc == nil {
+		return nil, nil, nil, model.ErrOIDCDisabled
+	}
+
+	// state из callback должен совпасть с выданным этому браузеру - защита от подмены логина (CSRF)
+	if flow == nil || flow.State == "" || code == "" || subtle.ConstantTimeCompare([]byte(flow.State), []byte(state)) != 1 {
+		return nil, nil, nil, model.ErrInvalidOIDCState
+	}
+
+	identity, err := svc.oidc.Exchange(ctx, code, flow.Verifier, flow.Nonce)
+	if err != nil {
+		log.Printf("RID %q Failed to exchange oidc code in 'CompleteOIDCLogin': %q", rid, err)
+		svc.recordOIDCFailure(ctx, "", model.ErrOIDCLoginFailed)
+		return nil, nil, nil, model.ErrOIDCLoginFailed
+	}
+
+	role := svc.roleForGroups(identity.Groups)
+	if role == "" {
+		svc.recordOIDCFailure(ctx, identity.Username, model.ErrNoOIDCRole)
+		return nil, nil, nil, model.ErrNoOIDCRole
+	}
+
+	user := &model.User{UserName: strings.ToLower(strings.TrimSpace(identity.Username)), Role: role}
+	if strings.HasPrefix(user.UserName, model.APIKeyActorPrefix) {
+		svc.recordOIDCFailure(ctx, user.UserName, model.ErrIncorrectUserName)
+		return nil, nil, nil, model.ErrIncorrectUserName
+	}
+	if err := svc.repo.UpsertOIDCUser(ctx, user, identity.Subject); err != nil {
+		switch {
+		case isUniqueViolation(err):
+			// локальный пользователь с таким же именем автоматически не связывается - это был бы захват учетки
+			svc.recordOIDCFailure(ctx, user.UserName, model.ErrUserAlreadyExists)
+			return nil, nil, nil, model.ErrUserAlreadyExists
+		default:
+			log.Printf("RID %q Failed to upsert oidc user in DB in 'CompleteOIDCLogin': %q", rid, err)
+			return nil, nil, nil, model.ErrCommon500
+		}
+	}
+
+	if user.DisabledAt != nil {
+		svc.recordOIDCFailure(ctx, user.UserName, model.ErrUserDisabled)
+		return nil, nil, nil, model.ErrUserDisabled
+	}
+
+	user.Permissions = svc.permissionsOf(user.Role)
+
+	// MFA на стороне IdP приложению не видна - роли из TOTPRequiredRoles и включившие TOTP проходят его и здесь
+	challenge, err := svc.loginChallenge(ctx, user)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if challenge != nil {
+		return nil, nil, challenge, nil
+	}
+
+	tokens, err := svc.issueTokens(ctx, user)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	svc.recordAuthEvent(ctx, &model.AuthEvent{Type: model.AuthEventLogin, UserID: user.ID, Username: user.UserName,
+		Role: user.Role, Detail: "oidc"})
+
+	return tokens, user, nil, nil
+}
+
+// recordOIDCFailure пишет неудачный вход через IdP; в счетчик перебора паролей такие попытки не входят
+func (svc WHCService) recordOIDCFailure(ctx context.Context, username string, reason error) {
+	svc.recordAuthEvent(ctx, &model.AuthEvent{Type: model.AuthEventLoginFailed, Username: strings.ToLower(strings.TrimSpace(username)),
+		Detail: "oidc: " + reason.Error()})
+}
+
+// roleForGroups возвращает роль первой из настроенных групп, в которой состоит пользователь
+func (svc WHCService) roleForGroups(groups []string) string {
+	for _, gr := range svc.cfg.OIDCGroupRoles {
+		if slices.Contains(groups, gr.Group) && svc.policy.IsCorrectRole(gr.Role) {
+			return gr.Role
+		}
+	}
+	return ""
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"context"
+	"log"
+	"slices"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+)
+
+func (svc WHCService) GetPolicy(ctx context.Context, role string) (map[string][]string, error) {
+	if !svc.policy.Can(role, model.PermPolicyManage) {
+		return nil, model.ErrAccessDenied
+	}
+
+	return svc.policy.Roles(), nil
+}
+
+// ReloadPolicy перечитывает файл политики, не дожидаясь плановой проверки
+func (svc WHCService) ReloadPolicy(ctx context.Context, role string) (map[string][]string, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if !svc.policy.Can(role, model.PermPolicyManage) {
+		return nil, model.ErrAccessDenied
+	}
+
+	if err := svc.policy.Reload(); err != nil {
+		log.Printf("RID %q Failed to reload policy in 'ReloadPolicy': %q", rid, err)
+		return nil, model.ErrInvalidPolicy
+	}
+
+	return svc.policy.Roles(), nil
+}
+
+// permissionsOf раскрывает разрешения роли (включая "*") в явный список - для отдачи в UI
+func (svc WHCService) permissionsOf(role string) []string {
+	perms := make([]string, 0, len(model.PermissionsMap))
+	for p := range model.PermissionsMap {
+		if svc.policy.Can(role, p) {
+			perms = append(perms, p)
+		}
+	}
+	slices.Sort(perms)
+	return perms
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+)
+
+// PurgeItemByID окончательно удаляет товар из корзины
+func (svc WHCService) PurgeItemByID(ctx context.Context, itemID int, userID int, role, username string) error {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if itemID <= 0 {
+		return model.ErrIncorrectItemID
+	}
+
+	if !svc.policy.Can(role, model.PermItemsPurge) {
+		return model.ErrAccessDenied
+	}
+
+	if err := svc.checkItemScope(ctx, rid, "PurgeItemByID", itemID, userID); err != nil {
+		return err
+	}
+
+	if err := svc.repo.PurgeItem(ctx, itemID, username); err != nil {
+		switch {
+		case errors.Is(err, model.ErrItemNotFound), errors.Is(err, model.ErrItemNotDeleted):
+			return err
+		default:
+			log.Printf("RID %q Failed to purge item in DB in 'PurgeItemByID': %q", rid, err)
+			return model.ErrCommon500
+		}
+	}
+	return nil
+}
+
+// PurgeDeletedItems очищает корзину от товаров, удаленных раньше срока хранения; в режиме DryRun только
+// возвращает кандидатов. Очистка затрагивает все склады, поэтому пользователю, ограниченному складами, недоступна
+func (svc WHCService) PurgeDeletedItems(ctx context.Context, req *model.PurgeRequest, userID int, role, username string) (*model.PurgeResult, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if !svc.policy.Can(role, model.PermItemsPurge) {
+		return nil, model.ErrAccessDenied
+	}
+
+	days := svc.cfg.ItemsRetentionDays
+	if req.OlderThanDays != nil {
+		days = *req.OlderThanDays
+	}
+	if days <= 0 {
+		return nil, model.ErrInvalidRetention
+	}
+
+	scope, err := svc.warehouseScope(ctx, userID)
+	if err != nil {
+		log.Printf("RID %q Failed to get user warehouses from DB in 'PurgeDeletedItems': %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+	if scope != nil {
+		return nil, model.ErrWarehouseAccessDenied
+	}
+
+	res, err := svc.purgeDeletedItems(ctx, days, req.DryRun, username)
+	if err != nil {
+		log.Printf("RID %q Failed to purge deleted items in DB in 'PurgeDeletedItems': %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+
+	return res, nil
+}
+
+// RunItemsPurge раз в interval очищает корзину от товаров старше ItemsRetentionDays.
+// Без срока хранения плановая очистка выключена
+func (svc WHCService) RunItemsPurge(ctx context.Context, interval time.Duration) {
+	if svc.cfg.ItemsRetentionDays <= 0 || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			res, err := svc.purgeDeletedItems(ctx, svc.cfg.ItemsRetentionDays, svc.cfg.ItemsPurgeDryRun, model.PurgeJobActor)
+			if err != nil {
+				log.Printf("Failed to purge deleted items: %v", err)
+				continue
+			}
+			if res.Count == 0 {
+				continue
+			}
+			for _, item := range res.Items {
+				log.Printf("Items purge (dry run: %t): item #%d %q deleted at %v", res.DryRun, item.ID, item.Title, item.DeletedAt)
+			}
+			log.Printf("Items purge (dry run: %t): %d items deleted before %v", res.DryRun, res.Count, res.DeletedBefore)
+		}
+	}
+}
+
+// purgeDeletedItems удаляет (или при dryRun только выбирает) товары, лежащие в корзине дольше days дней
+func (svc WHCService) purgeDeletedItems(ctx context.Context, days int, dryRun bool, actor string) (*model.PurgeResult, error) {
+	res := model.PurgeResult{
+		DryRun:        dryRun,
+		DeletedBefore: time.Now().AddDate(0, 0, -days),
+	}
+
+	var err error
+	if dryRun {
+		res.Items, err = svc.repo.GetDeletedItems(ctx, &model.RequestParam{EndTime: &res.DeletedBefore})
+	} else {
+		res.Items, err = svc.repo.PurgeDeletedItems(ctx, res.DeletedBefore, actor)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res.Count = len(res.Items)
+	return &res, nil
+}
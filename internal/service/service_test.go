@@ -2,11 +2,19 @@ package service
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/UnendingLoop/WarehouseControl/internal/model"
+	"github.com/UnendingLoop/WarehouseControl/internal/mwauthlog"
+	"github.com/UnendingLoop/WarehouseControl/internal/totp"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -16,11 +24,14 @@ func TestCreateItem(t *testing.T) {
 	ctx := context.Background()
 
 	tests := []struct {
-		name    string
-		policy  policyMock
-		repoErr error
-		item    *model.Item
-		wantErr error
+		name          string
+		policy        policyMock
+		repoErr       error
+		item          *model.Item
+		userID        int
+		scope         []int
+		wantErr       error
+		wantWarehouse *int
 	}{
 		{
 			name:    "Negative - access denied",
@@ -47,6 +58,44 @@ func TestCreateItem(t *testing.T) {
 			item:    &model.Item{Title: "ok"},
 			wantErr: nil,
 		},
+		{
+			name:          "Positive - initial stock goes to default warehouse",
+			policy:        policyMock{canCreate: true},
+			item:          &model.Item{Title: "ok", AvailableAmount: 5},
+			userID:        7,
+			wantErr:       nil,
+			wantWarehouse: ptrMaker(1),
+		},
+		{
+			name:          "Positive - warehouse within user scope",
+			policy:        policyMock{canCreate: true},
+			item:          &model.Item{Title: "ok", AvailableAmount: 5, WarehouseID: ptrMaker(2)},
+			userID:        7,
+			scope:         []int{2, 3},
+			wantErr:       nil,
+			wantWarehouse: ptrMaker(2),
+		},
+		{
+			name:    "Negative - warehouse out of user scope",
+			policy:  policyMock{canCreate: true},
+			item:    &model.Item{Title: "ok", AvailableAmount: 5},
+			userID:  7,
+			scope:   []int{2},
+			wantErr: model.ErrWarehouseAccessDenied,
+		},
+		{
+			name:    "Negative - incorrect warehouse id",
+			policy:  policyMock{canCreate: true},
+			item:    &model.Item{Title: "ok", WarehouseID: ptrMaker(-1)},
+			wantErr: model.ErrIncorrectWarehouseID,
+		},
+		{
+			name:    "Negative - warehouse not found",
+			policy:  policyMock{canCreate: true},
+			item:    &model.Item{Title: "ok", AvailableAmount: 5, WarehouseID: ptrMaker(9)},
+			repoErr: model.ErrWarehouseNotFound,
+			wantErr: model.ErrWarehouseNotFound,
+		},
 	}
 
 	for _, tt := range tests {
@@ -55,15 +104,22 @@ func TestCreateItem(t *testing.T) {
 				CreateItemFn: func(ctx context.Context, item *model.Item) error {
 					return tt.repoErr
 				},
+				GetUserWarehousesFn: func(ctx context.Context, userID int) ([]int, error) {
+					return tt.scope, nil
+				},
 			}
 
 			svc := WHCService{
 				repo:   repo,
 				policy: tt.policy,
+				cfg:    Config{DefaultWarehouseID: 1},
 			}
 
-			err := svc.CreateItem(ctx, tt.item, "admin")
+			err := svc.CreateItem(ctx, tt.item, tt.userID, "admin")
 			require.ErrorIs(t, err, tt.wantErr)
+			if tt.wantWarehouse != nil {
+				require.Equal(t, tt.wantWarehouse, tt.item.WarehouseID)
+			}
 		})
 	}
 }
@@ -75,6 +131,7 @@ func TestUpdateItemByID(t *testing.T) {
 		name    string
 		item    *model.ItemUpdate
 		repo    *repoMock
+		userID  int
 		policy  policyMock
 		role    string
 		wantErr error
@@ -145,6 +202,78 @@ func TestUpdateItemByID(t *testing.T) {
 			role:    "some role",
 			wantErr: model.ErrIncorrectItemID,
 		},
+		{
+			name: "Positive - stock updated on default warehouse",
+			item: &model.ItemUpdate{
+				ID:              1,
+				AvailableAmount: ptrMaker(10),
+				UpdatedBy:       "someone",
+			},
+			repo: &repoMock{
+				UpdateItemFn: func(ctx context.Context, item *model.ItemUpdate, seeDeleted bool) error {
+					if item.WarehouseID == nil || *item.WarehouseID != 1 {
+						return errors.New("warehouse is not resolved")
+					}
+					return nil
+				},
+				GetUserWarehousesFn: func(ctx context.Context, userID int) ([]int, error) { return nil, nil },
+			},
+			userID:  7,
+			policy:  policyMock{canUpdate: true},
+			role:    "some role",
+			wantErr: nil,
+		},
+		{
+			name: "Negative - stock of warehouse out of user scope",
+			item: &model.ItemUpdate{
+				ID:              1,
+				AvailableAmount: ptrMaker(10),
+				WarehouseID:     ptrMaker(2),
+				UpdatedBy:       "someone",
+			},
+			repo: &repoMock{
+				GetUserWarehousesFn: func(ctx context.Context, userID int) ([]int, error) { return []int{1}, nil },
+			},
+			userID:  7,
+			policy:  policyMock{canUpdate: true},
+			role:    "some role",
+			wantErr: model.ErrWarehouseAccessDenied,
+		},
+		{
+			name: "Positive - scoped user edits item card without stock",
+			item: &model.ItemUpdate{
+				ID:        1,
+				Title:     ptrMaker("new"),
+				UpdatedBy: "someone",
+			},
+			repo: &repoMock{
+				UpdateItemFn: func(ctx context.Context, item *model.ItemUpdate, seeDeleted bool) error { return nil },
+				GetUserWarehousesFn: func(ctx context.Context, userID int) ([]int, error) {
+					return nil, errors.New("scope must not be checked")
+				},
+			},
+			userID:  7,
+			policy:  policyMock{canUpdate: true},
+			role:    "some role",
+			wantErr: nil,
+		},
+		{
+			name: "Negative - scope lookup DB error",
+			item: &model.ItemUpdate{
+				ID:              1,
+				AvailableAmount: ptrMaker(10),
+				UpdatedBy:       "someone",
+			},
+			repo: &repoMock{
+				GetUserWarehousesFn: func(ctx context.Context, userID int) ([]int, error) {
+					return nil, errors.New("test DB error")
+				},
+			},
+			userID:  7,
+			policy:  policyMock{canUpdate: true},
+			role:    "some role",
+			wantErr: model.ErrCommon500,
+		},
 	}
 
 	for _, tt := range cases {
@@ -152,9 +281,10 @@ func TestUpdateItemByID(t *testing.T) {
 			svc := WHCService{
 				repo:   tt.repo,
 				policy: tt.policy,
+				cfg:    Config{DefaultWarehouseID: 1},
 			}
 
-			err := svc.UpdateItemByID(ctx, tt.item, tt.role)
+			err := svc.UpdateItemByID(ctx, tt.item, tt.userID, tt.role)
 			require.ErrorIs(t, err, tt.wantErr)
 		})
 	}
@@ -176,6 +306,8 @@ func TestGetItemByID(t *testing.T) {
 			policy: policyMock{canGetItems: true, canSeeDeleted: true},
 			repo: &repoMock{GetItemByIDFn: func(ctx context.Context, id int, seeDeleted bool) (*model.Item, error) {
 				return &model.Item{ID: 5}, nil
+			}, GetItemStockFn: func(ctx context.Context, itemID int) ([]*model.WarehouseStock, error) {
+				return []*model.WarehouseStock{{WarehouseID: 1, WarehouseCode: "MAIN", Amount: 3}}, nil
 			}},
 			role:    "some role",
 			wantErr: nil,
@@ -201,11 +333,38 @@ func TestGetItemByID(t *testing.T) {
 			itemID: 5,
 			policy: policyMock{canGetItems: true, canSeeDeleted: false},
 			repo: &repoMock{GetItemByIDFn: func(ctx context.Context, id int, seeDeleted bool) (*model.Item, error) {
+				if seeDeleted {
+					return nil, errors.New("deleted items must be hidden")
+				}
+				return &model.Item{ID: 5}, nil
+			}, GetItemStockFn: func(ctx context.Context, itemID int) ([]*model.WarehouseStock, error) {
 				return nil, nil
 			}},
 			role:    "some role",
 			wantErr: nil,
 		},
+		{
+			name:   "Negative - item not found",
+			itemID: 5,
+			policy: policyMock{canGetItems: true},
+			repo: &repoMock{GetItemByIDFn: func(ctx context.Context, id int, seeDeleted bool) (*model.Item, error) {
+				return nil, model.ErrItemNotFound
+			}},
+			role:    "some role",
+			wantErr: model.ErrItemNotFound,
+		},
+		{
+			name:   "Negative - stock DB error",
+			itemID: 5,
+			policy: policyMock{canGetItems: true},
+			repo: &repoMock{GetItemByIDFn: func(ctx context.Context, id int, seeDeleted bool) (*model.Item, error) {
+				return &model.Item{ID: 5}, nil
+			}, GetItemStockFn: func(ctx context.Context, itemID int) ([]*model.WarehouseStock, error) {
+				return nil, errors.New("test DB error")
+			}},
+			role:    "some role",
+			wantErr: model.ErrCommon500,
+		},
 	}
 
 	for _, tt := range cases {
@@ -226,6 +385,7 @@ func TestDeleteItemByID(t *testing.T) {
 	cases := []struct {
 		name     string
 		itemID   int
+		userID   int
 		repo     *repoMock
 		policy   policyMock
 		role     string
@@ -236,7 +396,7 @@ func TestDeleteItemByID(t *testing.T) {
 			name:   "Positive - delete success",
 			itemID: 1,
 			repo: &repoMock{
-				DeleteItemFn: func(ctx context.Context, id int, username string) error { return nil },
+				DeleteItemFn: func(ctx context.Context, id int, version *int, username string) error { return nil },
 			},
 			policy:   policyMock{canDelete: true},
 			role:     "some role",
@@ -247,18 +407,31 @@ func TestDeleteItemByID(t *testing.T) {
 			name:   "Negative - user not found",
 			itemID: 1,
 			repo: &repoMock{
-				DeleteItemFn: func(ctx context.Context, id int, username string) error { return model.ErrItemNotFound },
+				DeleteItemFn: func(ctx context.Context, id int, version *int, username string) error { return model.ErrItemNotFound },
 			},
 			policy:   policyMock{canDelete: true},
 			role:     "some role",
 			username: "someName",
 			wantErr:  model.ErrItemNotFound,
 		},
+		{
+			name:   "Negative - item changed since client read it",
+			itemID: 1,
+			repo: &repoMock{
+				DeleteItemFn: func(ctx context.Context, id int, version *int, username string) error {
+					return model.ErrVersionMismatch
+				},
+			},
+			policy:   policyMock{canDelete: true},
+			role:     "some role",
+			username: "someName",
+			wantErr:  model.ErrVersionMismatch,
+		},
 		{
 			name:   "Negative - DB error",
 			itemID: 1,
 			repo: &repoMock{
-				DeleteItemFn: func(ctx context.Context, id int, username string) error {
+				DeleteItemFn: func(ctx context.Context, id int, version *int, username string) error {
 					return errors.New("test DB error")
 				},
 			},
@@ -285,6 +458,37 @@ func TestDeleteItemByID(t *testing.T) {
 			username: "someName",
 			wantErr:  model.ErrAccessDenied,
 		},
+		{
+			name:   "Positive - scoped user deletes item stored in own warehouse",
+			itemID: 1,
+			userID: 7,
+			repo: &repoMock{
+				GetUserWarehousesFn: func(ctx context.Context, userID int) ([]int, error) { return []int{1}, nil },
+				GetItemStockFn: func(ctx context.Context, itemID int) ([]*model.WarehouseStock, error) {
+					return []*model.WarehouseStock{{WarehouseID: 1, Amount: 3}}, nil
+				},
+				DeleteItemFn: func(ctx context.Context, id int, version *int, username string) error { return nil },
+			},
+			policy:   policyMock{canDelete: true},
+			role:     "some role",
+			username: "someName",
+			wantErr:  nil,
+		},
+		{
+			name:   "Negative - item has stock in warehouse out of user scope",
+			itemID: 1,
+			userID: 7,
+			repo: &repoMock{
+				GetUserWarehousesFn: func(ctx context.Context, userID int) ([]int, error) { return []int{1}, nil },
+				GetItemStockFn: func(ctx context.Context, itemID int) ([]*model.WarehouseStock, error) {
+					return []*model.WarehouseStock{{WarehouseID: 1, Amount: 3}, {WarehouseID: 2, Amount: 1}}, nil
+				},
+			},
+			policy:   policyMock{canDelete: true},
+			role:     "some role",
+			username: "someName",
+			wantErr:  model.ErrWarehouseAccessDenied,
+		},
 	}
 
 	for _, tt := range cases {
@@ -294,213 +498,2517 @@ func TestDeleteItemByID(t *testing.T) {
 				policy: tt.policy,
 			}
 
-			err := svc.DeleteItemByID(ctx, tt.itemID, tt.role, tt.username)
+			err := svc.DeleteItemByID(ctx, tt.itemID, nil, tt.userID, tt.role, tt.username)
 			require.ErrorIs(t, err, tt.wantErr)
 		})
 	}
 }
 
-func TestCreateUser(t *testing.T) {
+func TestRestoreItemByID(t *testing.T) {
 	ctx := context.Background()
-
 	cases := []struct {
 		name    string
-		user    *model.User
+		itemID  int
+		userID  int
 		repo    *repoMock
-		jwt     *jwtMock
 		policy  policyMock
 		wantErr error
 	}{
 		{
-			name: "Positive - user create success",
-			user: &model.User{
-				UserName: "string",
-				Role:     "string",
+			name:   "Positive - restore success",
+			itemID: 1,
+			repo: &repoMock{
+				RestoreItemFn: func(ctx context.Context, id int, username string) error { return nil },
 			},
-			repo:    &repoMock{CreateUserFn: func(ctx context.Context, u *model.User) error { return nil }},
-			jwt:     &jwtMock{token: "jwt-token"},
-			policy:  policyMock{correctRole: true},
+			policy:  policyMock{canRestore: true},
 			wantErr: nil,
 		},
 		{
-			name: "Negative - incorrect role",
-			user: &model.User{
-				UserName: "string",
-				Role:     "string",
+			name:   "Negative - item is not deleted",
+			itemID: 1,
+			repo: &repoMock{
+				RestoreItemFn: func(ctx context.Context, id int, username string) error { return model.ErrItemNotDeleted },
 			},
-			repo:    nil,
-			jwt:     nil,
-			policy:  policyMock{correctRole: false},
-			wantErr: model.ErrIncorrectUserRole,
+			policy:  policyMock{canRestore: true},
+			wantErr: model.ErrItemNotDeleted,
 		},
 		{
-			name: "Negative - some DB error",
-			user: &model.User{
-				UserName: "string",
-				Role:     "string",
+			name:   "Negative - item not found",
+			itemID: 1,
+			repo: &repoMock{
+				RestoreItemFn: func(ctx context.Context, id int, username string) error { return model.ErrItemNotFound },
 			},
-			repo:    &repoMock{CreateUserFn: func(ctx context.Context, u *model.User) error { return errors.New("some db error") }},
-			jwt:     nil,
-			policy:  policyMock{correctRole: true},
+			policy:  policyMock{canRestore: true},
+			wantErr: model.ErrItemNotFound,
+		},
+		{
+			name:   "Negative - DB error",
+			itemID: 1,
+			repo: &repoMock{
+				RestoreItemFn: func(ctx context.Context, id int, username string) error { return errors.New("test DB error") },
+			},
+			policy:  policyMock{canRestore: true},
 			wantErr: model.ErrCommon500,
 		},
 		{
-			name: "Negative - unique violation error",
-			user: &model.User{
-				UserName: "string",
-				Role:     "string",
+			name:    "Negative - incorrect item ID",
+			itemID:  0,
+			policy:  policyMock{canRestore: true},
+			wantErr: model.ErrIncorrectItemID,
+		},
+		{
+			name:    "Negative - deleting is not enough to restore",
+			itemID:  1,
+			policy:  policyMock{canDelete: true, canSeeDeleted: true},
+			wantErr: model.ErrAccessDenied,
+		},
+		{
+			name:   "Negative - item has stock in warehouse out of user scope",
+			itemID: 1,
+			userID: 7,
+			repo: &repoMock{
+				GetUserWarehousesFn: func(ctx context.Context, userID int) ([]int, error) { return []int{1}, nil },
+				GetItemStockFn: func(ctx context.Context, itemID int) ([]*model.WarehouseStock, error) {
+					return []*model.WarehouseStock{{WarehouseID: 2, Amount: 1}}, nil
+				},
 			},
-			repo:    &repoMock{CreateUserFn: func(ctx context.Context, u *model.User) error { return errors.New("blabla unique violation blabla") }},
-			jwt:     nil,
-			policy:  policyMock{correctRole: true},
-			wantErr: model.ErrUserAlreadyExists,
+			policy:  policyMock{canRestore: true},
+			wantErr: model.ErrWarehouseAccessDenied,
 		},
 	}
 
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
 			svc := WHCService{
-				repo:       tt.repo,
-				jwtManager: tt.jwt,
-				policy:     tt.policy,
+				repo:   tt.repo,
+				policy: tt.policy,
 			}
 
-			token, err := svc.CreateUser(ctx, tt.user)
+			err := svc.RestoreItemByID(ctx, tt.itemID, tt.userID, "some role", "someName")
+			require.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestGetDeletedItems(t *testing.T) {
+	ctx := context.Background()
+	deletedBy := "manager"
+	trash := []*model.Item{{ID: 1, Title: "deleted", DeletedBy: &deletedBy}}
 
-			if tt.wantErr != nil {
-				require.ErrorIs(t, err, tt.wantErr)
-			} else {
-				require.Equal(t, "jwt-token", token)
+	cases := []struct {
+		name    string
+		repo    *repoMock
+		policy  policyMock
+		want    []*model.Item
+		wantErr error
+	}{
+		{
+			name: "Positive - trash listed",
+			repo: &repoMock{
+				GetDeletedItemsFn: func(ctx context.Context, rp *model.RequestParam) ([]*model.Item, error) { return trash, nil },
+			},
+			policy: policyMock{canSeeDeleted: true},
+			want:   trash,
+		},
+		{
+			name:    "Negative - no access to deleted items",
+			policy:  policyMock{canGetItems: true},
+			wantErr: model.ErrAccessDenied,
+		},
+		{
+			name: "Negative - DB error",
+			repo: &repoMock{
+				GetDeletedItemsFn: func(ctx context.Context, rp *model.RequestParam) ([]*model.Item, error) {
+					return nil, errors.New("test DB error")
+				},
+			},
+			policy:  policyMock{canSeeDeleted: true},
+			wantErr: model.ErrCommon500,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := WHCService{
+				repo:   tt.repo,
+				policy: tt.policy,
 			}
+
+			res, err := svc.GetDeletedItems(ctx, &model.RequestParam{}, "some role")
+			require.ErrorIs(t, err, tt.wantErr)
+			require.Equal(t, tt.want, res)
 		})
 	}
 }
 
-func TestLoginUser(t *testing.T) {
+func TestPurgeDeletedItems(t *testing.T) {
 	ctx := context.Background()
-	testPass := "youShallNotPass!"
-	testHash, err := bcrypt.GenerateFromPassword([]byte(testPass), bcrypt.DefaultCost)
-	require.NoError(t, err)
+	zero, week := 0, 7
+	candidates := []*model.Item{{ID: 1}, {ID: 2}}
 
 	cases := []struct {
-		name     string
-		userName string
-		password string
-		role     string
-		repo     *repoMock
-		policy   *policyMock
-		jwt      *jwtMock
-		wantErr  error
+		name       string
+		req        *model.PurgeRequest
+		retention  int
+		userID     int
+		repo       *repoMock
+		policy     policyMock
+		wantCount  int
+		wantPeriod time.Duration
+		wantErr    error
 	}{
 		{
-			name:     "Positive - user login success",
-			userName: "someName",
-			password: testPass,
-			role:     "some role",
-			policy:   &policyMock{correctRole: true},
-			repo: &repoMock{GetUserByNameFn: func(ctx context.Context, username string) (*model.User, error) {
-				return &model.User{
-					ID:       1,
-					UserName: "someName",
-					Role:     "some role",
-					PassHash: string(testHash),
-				}, nil
-			}},
-			jwt:     &jwtMock{token: "jwt-token"},
-			wantErr: nil,
+			name:      "Positive - purge by configured retention",
+			req:       &model.PurgeRequest{},
+			retention: 30,
+			repo: &repoMock{
+				PurgeDeletedItemsFn: func(ctx context.Context, before time.Time, actor string) ([]*model.Item, error) {
+					return candidates, nil
+				},
+			},
+			policy:     policyMock{canPurge: true},
+			wantCount:  2,
+			wantPeriod: 30 * 24 * time.Hour,
 		},
 		{
-			name:     "Negative - incorrect role",
-			userName: "someName",
-			password: testPass,
-			role:     "some role",
-			policy:   &policyMock{correctRole: false},
-			repo: &repoMock{GetUserByNameFn: func(ctx context.Context, username string) (*model.User, error) {
-				return &model.User{
-					ID:       1,
-					UserName: "someName",
-					Role:     "some role",
-					PassHash: string(testHash),
-				}, nil
-			}},
-			jwt:     nil,
-			wantErr: model.ErrIncorrectUserRole,
+			name: "Positive - dry run only lists candidates",
+			req:  &model.PurgeRequest{DryRun: true, OlderThanDays: &week},
+			repo: &repoMock{
+				GetDeletedItemsFn: func(ctx context.Context, rp *model.RequestParam) ([]*model.Item, error) {
+					return candidates[:1], nil
+				},
+			},
+			policy:     policyMock{canPurge: true},
+			wantCount:  1,
+			wantPeriod: 7 * 24 * time.Hour,
 		},
 		{
-			name:     "Negative - incorrect password",
-			userName: "someName",
-			password: "incorrectPass",
-			role:     "some role",
-			policy:   &policyMock{correctRole: true},
-			repo: &repoMock{GetUserByNameFn: func(ctx context.Context, username string) (*model.User, error) {
-				return &model.User{
-					ID:       1,
-					UserName: "someName",
-					Role:     "some role",
-					PassHash: string(testHash),
-				}, nil
+			name:    "Negative - no retention configured or provided",
+			req:     &model.PurgeRequest{},
+			policy:  policyMock{canPurge: true},
+			wantErr: model.ErrInvalidRetention,
+		},
+		{
+			name:      "Negative - zero days provided",
+			req:       &model.PurgeRequest{OlderThanDays: &zero},
+			retention: 30,
+			policy:    policyMock{canPurge: true},
+			wantErr:   model.ErrInvalidRetention,
+		},
+		{
+			name:      "Negative - no access to purge",
+			req:       &model.PurgeRequest{},
+			retention: 30,
+			policy:    policyMock{canDelete: true, canRestore: true},
+			wantErr:   model.ErrAccessDenied,
+		},
+		{
+			name:      "Negative - user limited to warehouses",
+			req:       &model.PurgeRequest{},
+			retention: 30,
+			userID:    7,
+			repo: &repoMock{
+				GetUserWarehousesFn: func(ctx context.Context, userID int) ([]int, error) { return []int{1}, nil },
+			},
+			policy:  policyMock{canPurge: true},
+			wantErr: model.ErrWarehouseAccessDenied,
+		},
+		{
+			name:      "Negative - DB error",
+			req:       &model.PurgeRequest{},
+			retention: 30,
+			repo: &repoMock{
+				PurgeDeletedItemsFn: func(ctx context.Context, before time.Time, actor string) ([]*model.Item, error) {
+					return nil, errors.New("test DB error")
+				},
+			},
+			policy:  policyMock{canPurge: true},
+			wantErr: model.ErrCommon500,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := WHCService{
+				repo:   tt.repo,
+				policy: tt.policy,
+				cfg:    Config{ItemsRetentionDays: tt.retention},
+			}
+
+			res, err := svc.PurgeDeletedItems(ctx, tt.req, tt.userID, "some role", "admin")
+			require.ErrorIs(t, err, tt.wantErr)
+			if tt.wantErr != nil {
+				return
+			}
+			require.Equal(t, tt.req.DryRun, res.DryRun)
+			require.Equal(t, tt.wantCount, res.Count)
+			require.WithinDuration(t, time.Now().Add(-tt.wantPeriod), res.DeletedBefore, time.Minute)
+		})
+	}
+}
+
+func TestPurgeItemByID(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		name    string
+		itemID  int
+		repo    *repoMock
+		policy  policyMock
+		wantErr error
+	}{
+		{
+			name:   "Positive - item purged",
+			itemID: 1,
+			repo: &repoMock{
+				PurgeItemFn: func(ctx context.Context, itemID int, username string) error { return nil },
+			},
+			policy: policyMock{canPurge: true},
+		},
+		{
+			name:   "Negative - item is not in trash",
+			itemID: 1,
+			repo: &repoMock{
+				PurgeItemFn: func(ctx context.Context, itemID int, username string) error { return model.ErrItemNotDeleted },
+			},
+			policy:  policyMock{canPurge: true},
+			wantErr: model.ErrItemNotDeleted,
+		},
+		{
+			name:    "Negative - soft delete permission is not enough",
+			itemID:  1,
+			policy:  policyMock{canDelete: true},
+			wantErr: model.ErrAccessDenied,
+		},
+		{
+			name:    "Negative - incorrect item ID",
+			itemID:  -1,
+			policy:  policyMock{canPurge: true},
+			wantErr: model.ErrIncorrectItemID,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := WHCService{
+				repo:   tt.repo,
+				policy: tt.policy,
+			}
+
+			err := svc.PurgeItemByID(ctx, tt.itemID, 0, "some role", "admin")
+			require.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestCreateUser(t *testing.T) {
+	ctx := context.Background()
+	openCfg := Config{SignupMode: model.SignupOpen, SignupDefaultRole: model.RoleViewer}
+
+	cases := []struct {
+		name     string
+		user     *model.User
+		invite   string
+		cfg      Config
+		repo     *repoMock
+		jwt      *jwtMock
+		wantRole string
+		wantErr  error
+		// тип события в журнале аутентификации; пустой - событие не пишется
+		wantEvent string
+	}{
+		{
+			name: "Positive - open signup forces default role",
+			user: &model.User{
+				UserName: "string",
+				Role:     model.RoleAdmin,
+			},
+			cfg:       openCfg,
+			repo:      &repoMock{CreateUserFn: func(ctx context.Context, u *model.User) error { return nil }},
+			jwt:       &jwtMock{token: "jwt-token"},
+			wantRole:  model.RoleViewer,
+			wantErr:   nil,
+			wantEvent: model.AuthEventSignup,
+		},
+		{
+			name: "Positive - signup with invite takes role from invite",
+			user: &model.User{
+				UserName: "string",
+			},
+			invite: "invite-token",
+			cfg:    Config{SignupMode: model.SignupInvite, SignupDefaultRole: model.RoleViewer},
+			repo: &repoMock{CreateUserInviteFn: func(ctx context.Context, u *model.User, tokenHash string) error {
+				if tokenHash != hashToken("invite-token") {
+					return errors.New("unexpected token hash")
+				}
+				u.Role = model.RoleManager
+				return nil
 			}},
-			jwt:     nil,
-			wantErr: model.ErrInvalidCredentials,
+			jwt:       &jwtMock{token: "jwt-token"},
+			wantRole:  model.RoleManager,
+			wantErr:   nil,
+			wantEvent: model.AuthEventSignup,
 		},
 		{
-			name:     "Negative - some DB error",
-			userName: "someName",
-			password: "incorrectPass",
-			role:     "some role",
-			policy:   &policyMock{correctRole: true},
-			repo: &repoMock{GetUserByNameFn: func(ctx context.Context, username string) (*model.User, error) {
-				return nil, errors.New("test DB error")
+			name:      "Negative - signup disabled",
+			user:      &model.User{UserName: "string"},
+			invite:    "invite-token",
+			cfg:       Config{SignupMode: model.SignupDisabled, SignupDefaultRole: model.RoleViewer},
+			wantErr:   model.ErrSignupDisabled,
+			wantEvent: model.AuthEventSignupFailed,
+		},
+		{
+			name:      "Negative - invite-only without token",
+			user:      &model.User{UserName: "string"},
+			cfg:       Config{SignupMode: model.SignupInvite, SignupDefaultRole: model.RoleViewer},
+			wantErr:   model.ErrInviteRequired,
+			wantEvent: model.AuthEventSignupFailed,
+		},
+		{
+			name:   "Negative - invalid invite",
+			user:   &model.User{UserName: "string"},
+			invite: "invite-token",
+			cfg:    openCfg,
+			repo: &repoMock{CreateUserInviteFn: func(ctx context.Context, u *model.User, tokenHash string) error {
+				return model.ErrInvalidInvite
 			}},
+			wantErr:   model.ErrInvalidInvite,
+			wantEvent: model.AuthEventSignupFailed,
+		},
+		{
+			name: "Negative - some DB error",
+			user: &model.User{
+				UserName: "string",
+				Role:     "string",
+			},
+			cfg:     openCfg,
+			repo:    &repoMock{CreateUserFn: func(ctx context.Context, u *model.User) error { return errors.New("some db error") }},
 			jwt:     nil,
 			wantErr: model.ErrCommon500,
 		},
 		{
-			name:     "Negative - user not found",
-			userName: "someName",
-			password: "incorrectPass",
-			role:     "some role",
-			policy:   &policyMock{correctRole: true},
-			repo: &repoMock{GetUserByNameFn: func(ctx context.Context, username string) (*model.User, error) {
-				return nil, model.ErrUserNotFound
-			}},
-			jwt:     nil,
-			wantErr: model.ErrUserNotFound,
+			name: "Negative - unique violation error",
+			user: &model.User{
+				UserName: "string",
+				Role:     "string",
+			},
+			cfg:       openCfg,
+			repo:      &repoMock{CreateUserFn: func(ctx context.Context, u *model.User) error { return errors.New("blabla unique violation blabla") }},
+			jwt:       nil,
+			wantErr:   model.ErrUserAlreadyExists,
+			wantEvent: model.AuthEventSignupFailed,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := tt.repo
+			if repo == nil {
+				repo = &repoMock{}
+			}
+			var events []*model.AuthEvent
+			repo.CreateAuthEventFn = func(ctx context.Context, event *model.AuthEvent) error {
+				events = append(events, event)
+				return nil
+			}
+			repo.CreateRefreshTokenFn = storeRefreshTokenStub
+
+			svc := WHCService{
+				repo:       repo,
+				jwtManager: tt.jwt,
+				policy:     policyMock{correctRole: true},
+				cfg:        tt.cfg,
+			}
+
+			tokens, err := svc.CreateUser(ctx, tt.user, tt.invite)
+
+			if tt.wantEvent == "" {
+				require.Empty(t, events)
+			} else {
+				require.Len(t, events, 1)
+				require.Equal(t, tt.wantEvent, events[0].Type)
+				require.Equal(t, "string", events[0].Username)
+			}
+
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, "jwt-token", tokens.AccessToken)
+				require.NotEmpty(t, tokens.RefreshToken)
+				require.Equal(t, tt.wantRole, tt.user.Role)
+			}
+		})
+	}
+}
+
+func TestCreateInvite(t *testing.T) {
+	ctx := context.Background()
+
+	cases := []struct {
+		name    string
+		ttl     time.Duration
+		repo    *repoMock
+		policy  policyMock
+		wantErr error
+	}{
+		{
+			name: "Positive - invite created, only hash goes to DB",
+			ttl:  0,
+			repo: &repoMock{CreateInviteFn: func(ctx context.Context, inv *model.Invite, tokenHash string) error {
+				if tokenHash == "" || inv.Token != "" {
+					return errors.New("raw token leaked to DB")
+				}
+				return nil
+			}},
+			policy:  policyMock{canManageUser: true, correctRole: true},
+			wantErr: nil,
+		},
+		{
+			name:    "Negative - not admin",
+			policy:  policyMock{canManageUser: false, correctRole: true},
+			wantErr: model.ErrAccessDenied,
+		},
+		{
+			name:    "Negative - incorrect role",
+			policy:  policyMock{canManageUser: true, correctRole: false},
+			wantErr: model.ErrIncorrectUserRole,
+		},
+		{
+			name:    "Negative - negative ttl",
+			ttl:     -time.Hour,
+			policy:  policyMock{canManageUser: true, correctRole: true},
+			wantErr: model.ErrInvalidInviteTTL,
+		},
+		{
+			name: "Negative - some DB error",
+			repo: &repoMock{CreateInviteFn: func(ctx context.Context, inv *model.Invite, tokenHash string) error {
+				return errors.New("some db error")
+			}},
+			policy:  policyMock{canManageUser: true, correctRole: true},
+			wantErr: model.ErrCommon500,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := WHCService{repo: tt.repo, policy: tt.policy}
+
+			res, err := svc.CreateInvite(ctx, model.RoleManager, tt.ttl, model.RoleAdmin, "admin")
+
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+				require.NotEmpty(t, res.Token)
+				require.Equal(t, "admin", res.CreatedBy)
+				require.True(t, res.ExpiresAt.After(time.Now().Add(DefaultInviteTTL-time.Minute)))
+			}
+		})
+	}
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	ctx := context.Background()
+
+	cases := []struct {
+		name     string
+		keyName  string
+		ttl      time.Duration
+		repo     *repoMock
+		policy   policyMock
+		wantErr  error
+		noExpiry bool
+	}{
+		{
+			name:    "Positive - key created, only hash goes to DB",
+			keyName: " ERP-Sync ",
+			ttl:     time.Hour,
+			repo: &repoMock{CreateAPIKeyFn: func(ctx context.Context, key *model.APIKey, keyHash string) error {
+				if keyHash == "" || key.Key != "" || strings.Contains(keyHash, key.Prefix) {
+					return errors.New("raw key leaked to DB")
+				}
+				return nil
+			}},
+			policy: policyMock{canManageUser: true, correctRole: true},
+		},
+		{
+			name:    "Positive - zero ttl means no expiry",
+			keyName: "scanner-1",
+			repo: &repoMock{CreateAPIKeyFn: func(ctx context.Context, key *model.APIKey, keyHash string) error {
+				return nil
+			}},
+			policy:   policyMock{canManageUser: true, correctRole: true},
+			noExpiry: true,
+		},
+		{
+			name:    "Negative - not admin",
+			keyName: "erp-sync",
+			policy:  policyMock{canManageUser: false, correctRole: true},
+			wantErr: model.ErrAccessDenied,
+		},
+		{
+			name:    "Negative - empty name",
+			keyName: "  ",
+			policy:  policyMock{canManageUser: true, correctRole: true},
+			wantErr: model.ErrEmptyAPIKeyName,
+		},
+		{
+			name:    "Negative - incorrect role",
+			keyName: "erp-sync",
+			policy:  policyMock{canManageUser: true, correctRole: false},
+			wantErr: model.ErrIncorrectUserRole,
+		},
+		{
+			name:    "Negative - negative ttl",
+			keyName: "erp-sync",
+			ttl:     -time.Hour,
+			policy:  policyMock{canManageUser: true, correctRole: true},
+			wantErr: model.ErrInvalidAPIKeyTTL,
+		},
+		{
+			name:    "Negative - some DB error",
+			keyName: "erp-sync",
+			repo: &repoMock{CreateAPIKeyFn: func(ctx context.Context, key *model.APIKey, keyHash string) error {
+				return errors.New("some db error")
+			}},
+			policy:  policyMock{canManageUser: true, correctRole: true},
+			wantErr: model.ErrCommon500,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := WHCService{repo: tt.repo, policy: tt.policy}
+
+			res, err := svc.CreateAPIKey(ctx, tt.keyName, model.RoleManager, tt.ttl, model.RoleAdmin, "admin")
+
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+				require.True(t, strings.HasPrefix(res.Key, model.APIKeyPrefix))
+				require.True(t, strings.HasPrefix(res.Key, res.Prefix))
+				require.Equal(t, strings.ToLower(strings.TrimSpace(tt.keyName)), res.Name)
+				require.Equal(t, tt.noExpiry, res.ExpiresAt == nil)
+			}
+		})
+	}
+}
+
+func TestCheckAPIKey(t *testing.T) {
+	ctx := context.Background()
+	const key = model.APIKeyPrefix + "secret"
+
+	cases := []struct {
+		name    string
+		key     string
+		repo    *repoMock
+		policy  policyMock
+		wantErr error
+	}{
+		{
+			name: "Positive - service account claims returned",
+			key:  key,
+			repo: &repoMock{UseAPIKeyFn: func(ctx context.Context, keyHash string) (*model.APIKey, error) {
+				if keyHash != hashToken(key) {
+					return nil, model.ErrInvalidAPIKey
+				}
+				return &model.APIKey{ID: 1, Name: "erp-sync", Role: model.RoleManager}, nil
+			}},
+			policy: policyMock{correctRole: true},
+		},
+		{
+			name:    "Negative - not an api key",
+			key:     "jwt-token",
+			policy:  policyMock{correctRole: true},
+			wantErr: model.ErrInvalidAPIKey,
+		},
+		{
+			name: "Negative - revoked or expired key",
+			key:  key,
+			repo: &repoMock{UseAPIKeyFn: func(ctx context.Context, keyHash string) (*model.APIKey, error) {
+				return nil, model.ErrInvalidAPIKey
+			}},
+			policy:  policyMock{correctRole: true},
+			wantErr: model.ErrInvalidAPIKey,
+		},
+		{
+			name: "Negative - role removed from policy",
+			key:  key,
+			repo: &repoMock{UseAPIKeyFn: func(ctx context.Context, keyHash string) (*model.APIKey, error) {
+				return &model.APIKey{ID: 1, Name: "erp-sync", Role: "old-role"}, nil
+			}},
+			policy:  policyMock{correctRole: false},
+			wantErr: model.ErrIncorrectUserRole,
+		},
+		{
+			name: "Negative - some DB error",
+			key:  key,
+			repo: &repoMock{UseAPIKeyFn: func(ctx context.Context, keyHash string) (*model.APIKey, error) {
+				return nil, errors.New("some db error")
+			}},
+			policy:  policyMock{correctRole: true},
+			wantErr: model.ErrCommon500,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := WHCService{repo: tt.repo, policy: tt.policy}
+
+			claims, err := svc.CheckAPIKey(ctx, tt.key)
+
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, model.APIKeyActorPrefix+"erp-sync", claims.Username)
+				require.Equal(t, model.RoleManager, claims.Role)
+				require.Zero(t, claims.UserID)
+			}
+		})
+	}
+}
+
+func TestCompleteOIDCLogin(t *testing.T) {
+	ctx := context.Background()
+	flow := &model.OIDCFlow{State: "state", Nonce: "nonce", Verifier: "verifier"}
+	groupRoles := []model.GroupRole{{Group: "wh-admins", Role: model.RoleAdmin}, {Group: "wh-staff", Role: model.RoleManager}}
+	identity := func(groups ...string) *oidcMock {
+		return &oidcMock{identity: &model.OIDCIdentity{Subject: "sub-1", Username: "Jane.Doe", Groups: groups}}
+	}
+
+	cases := []struct {
+		name      string
+		idp       *oidcMock
+		flow      *model.OIDCFlow
+		state     string
+		repo      *repoMock
+		wantErr   error
+		wantRole  string
+		totpRoles []string // роли с обязательным вторым фактором
+		// челлендж второго фактора вместо токенов
+		wantChallenge bool
+		// тип события в журнале аутентификации; пустой - событие не пишется
+		wantEvent string
+	}{
+		{
+			name:  "Positive - user created with role of first matching group",
+			idp:   identity("everyone", "wh-staff", "wh-admins"),
+			flow:  flow,
+			state: "state",
+			repo: &repoMock{UpsertOIDCUserFn: func(ctx context.Context, user *model.User, subject string) error {
+				if subject != "sub-1" || user.UserName != "jane.doe" {
+					return errors.New("unexpected identity")
+				}
+				user.ID = 5
+				return nil
+			}},
+			wantRole:  model.RoleAdmin,
+			wantEvent: model.AuthEventLogin,
+		},
+		{
+			name:  "Positive - role with required second factor gets challenge instead of session",
+			idp:   identity("wh-admins"),
+			flow:  flow,
+			state: "state",
+			repo: &repoMock{UpsertOIDCUserFn: func(ctx context.Context, user *model.User, subject string) error {
+				user.ID = 5
+				return nil
+			}},
+			totpRoles:     []string{model.RoleAdmin},
+			wantRole:      model.RoleAdmin,
+			wantChallenge: true,
+		},
+		{
+			name:  "Positive - user with enrolled TOTP gets challenge instead of session",
+			idp:   identity("wh-staff"),
+			flow:  flow,
+			state: "state",
+			repo: &repoMock{
+				UpsertOIDCUserFn: func(ctx context.Context, user *model.User, subject string) error {
+					user.ID = 5
+					return nil
+				},
+				GetUserTOTPFn: func(ctx context.Context, userID int) (*model.TOTPState, error) {
+					confirmed := time.Now()
+					return &model.TOTPState{ConfirmedAt: &confirmed}, nil
+				},
+			},
+			wantRole:      model.RoleManager,
+			wantChallenge: true,
+		},
+		{
+			name:    "Negative - oidc not configured",
+			flow:    flow,
+			state:   "state",
+			wantErr: model.ErrOIDCDisabled,
+		},
+		{
+			name:    "Negative - state mismatch",
+			idp:     identity("wh-admins"),
+			flow:    flow,
+			state:   "forged",
+			wantErr: model.ErrInvalidOIDCState,
+		},
+		{
+			name:    "Negative - no flow cookie",
+			idp:     identity("wh-admins"),
+			state:   "state",
+			wantErr: model.ErrInvalidOIDCState,
+		},
+		{
+			name:      "Negative - code exchange failed",
+			idp:       &oidcMock{err: errors.New("invalid_grant")},
+			flow:      flow,
+			state:     "state",
+			wantErr:   model.ErrOIDCLoginFailed,
+			wantEvent: model.AuthEventLoginFailed,
+		},
+		{
+			name:      "Negative - no mapped group",
+			idp:       identity("everyone"),
+			flow:      flow,
+			state:     "state",
+			wantErr:   model.ErrNoOIDCRole,
+			wantEvent: model.AuthEventLoginFailed,
+		},
+		{
+			name:  "Negative - local user with same name exists",
+			idp:   identity("wh-staff"),
+			flow:  flow,
+			state: "state",
+			repo: &repoMock{UpsertOIDCUserFn: func(ctx context.Context, user *model.User, subject string) error {
+				return &pq.Error{Code: "23505", Message: `duplicate key value violates unique constraint "users_username_key"`}
+			}},
+			wantErr:   model.ErrUserAlreadyExists,
+			wantEvent: model.AuthEventLoginFailed,
+		},
+		{
+			name:      "Negative - service account name from IdP",
+			idp:       &oidcMock{identity: &model.OIDCIdentity{Subject: "sub-2", Username: "apikey:erp-sync", Groups: []string{"wh-staff"}}},
+			flow:      flow,
+			state:     "state",
+			wantErr:   model.ErrIncorrectUserName,
+			wantEvent: model.AuthEventLoginFailed,
+		},
+		{
+			name:  "Negative - disabled user",
+			idp:   identity("wh-staff"),
+			flow:  flow,
+			state: "state",
+			repo: &repoMock{UpsertOIDCUserFn: func(ctx context.Context, user *model.User, subject string) error {
+				now := time.Now()
+				user.DisabledAt = &now
+				return nil
+			}},
+			wantErr:   model.ErrUserDisabled,
+			wantEvent: model.AuthEventLoginFailed,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := tt.repo
+			if repo == nil {
+				repo = &repoMock{}
+			}
+			var events []*model.AuthEvent
+			repo.CreateAuthEventFn = func(ctx context.Context, event *model.AuthEvent) error {
+				events = append(events, event)
+				return nil
+			}
+			repo.CreateRefreshTokenFn = storeRefreshTokenStub
+			if repo.GetUserTOTPFn == nil {
+				repo.GetUserTOTPFn = func(ctx context.Context, userID int) (*model.TOTPState, error) {
+					return nil, model.ErrTOTPNotEnrolled
+				}
+			}
+			var challengeRole string
+			repo.CreateLoginChallengeFn = func(ctx context.Context, userID int, role, tokenHash string, expiresAt time.Time) error {
+				challengeRole = role
+				return nil
+			}
+
+			svc := WHCService{
+				repo:       repo,
+				policy:     policyMock{correctRole: true},
+				jwtManager: &jwtMock{token: "jwt-token"},
+				cfg: Config{OIDCGroupRoles: groupRoles, TOTPRequiredRoles: tt.totpRoles,
+					AccessTokenTTL: time.Minute, RefreshTokenTTL: time.Hour},
+			}
+			if tt.idp != nil {
+				svc.oidc = tt.idp
+			}
+			tokens, user, challenge, err := svc.CompleteOIDCLogin(ctx, tt.flow, tt.state, "code")
+
+			if tt.wantEvent == "" {
+				require.Empty(t, events)
+			} else {
+				require.Len(t, events, 1)
+				require.Equal(t, tt.wantEvent, events[0].Type)
+			}
+
+			switch {
+			case tt.wantErr != nil:
+				require.ErrorIs(t, err, tt.wantErr)
+			case tt.wantChallenge:
+				// сессии нет, пока не введен код второго фактора
+				require.NoError(t, err)
+				require.Nil(t, tokens)
+				require.Nil(t, user)
+				require.NotEmpty(t, challenge.Token)
+				require.Equal(t, tt.wantRole, challengeRole)
+			default:
+				require.NoError(t, err)
+				require.Nil(t, challenge)
+				require.Equal(t, "jwt-token", tokens.AccessToken)
+				require.Equal(t, tt.wantRole, user.Role)
+				require.Equal(t, 5, user.ID)
+			}
+		})
+	}
+}
+
+func TestLoginUser(t *testing.T) {
+	ctx := context.Background()
+	testPass := "youShallNotPass!"
+	testHash, err := bcrypt.GenerateFromPassword([]byte(testPass), bcrypt.DefaultCost)
+	require.NoError(t, err)
+	now := time.Now()
+
+	cases := []struct {
+		name     string
+		userName string
+		password string
+		role     string
+		repo     *repoMock
+		policy   *policyMock
+		jwt      *jwtMock
+		failures model.LoginFailures // неудачи в окне до этой попытки
+		wantErr  error
+		// причина, с которой попытка должна попасть в журнал; пустая - не проверяется
+		wantReason string
+		// событие, которое должно последним попасть в журнал аутентификации; nil - не проверяется
+		wantEvent *model.AuthEvent
+
+		totp          *model.TOTPState // nil - второй фактор не настроен
+		requiredRoles []string         // роли с обязательным вторым фактором
+		wantChallenge *model.LoginChallenge
+	}{
+		{
+			name:     "Positive - user login success",
+			userName: "someName",
+			password: testPass,
+			role:     "some role",
+			policy:   &policyMock{correctRole: true},
+			repo: &repoMock{GetUserByNameFn: func(ctx context.Context, username string) (*model.User, error) {
+				return &model.User{
+					ID:       1,
+					UserName: "someName",
+					Role:     "some role",
+					PassHash: string(testHash),
+				}, nil
+			}},
+			jwt:     &jwtMock{token: "jwt-token"},
+			wantErr: nil,
+		},
+		{
+			name:     "Negative - incorrect role",
+			userName: "someName",
+			password: testPass,
+			role:     "some role",
+			policy:   &policyMock{correctRole: false},
+			repo: &repoMock{GetUserByNameFn: func(ctx context.Context, username string) (*model.User, error) {
+				return &model.User{
+					ID:       1,
+					UserName: "someName",
+					Role:     "some role",
+					PassHash: string(testHash),
+				}, nil
+			}},
+			jwt:     nil,
+			wantErr: model.ErrIncorrectUserRole,
+		},
+		{
+			name:     "Positive - empty role falls back to stored role",
+			userName: "someName",
+			password: testPass,
+			role:     "",
+			policy:   &policyMock{correctRole: false},
+			repo: &repoMock{GetUserByNameFn: func(ctx context.Context, username string) (*model.User, error) {
+				return &model.User{
+					ID:       1,
+					UserName: "someName",
+					Role:     "viewer",
+					PassHash: string(testHash),
+				}, nil
+			}},
+			jwt:     &jwtMock{token: "jwt-token"},
+			wantErr: nil,
+		},
+		{
+			name:     "Positive - granted role chosen",
+			userName: "someName",
+			password: testPass,
+			role:     "manager",
+			policy:   &policyMock{correctRole: true},
+			repo: &repoMock{
+				GetUserByNameFn: func(ctx context.Context, username string) (*model.User, error) {
+					return &model.User{
+						ID:       1,
+						UserName: "someName",
+						Role:     "viewer",
+						PassHash: string(testHash),
+					}, nil
+				},
+				GetUserRolesFn: func(ctx context.Context, userID int) ([]string, error) {
+					return []string{"auditor", "manager"}, nil
+				},
+			},
+			jwt:     &jwtMock{token: "jwt-token"},
+			wantErr: nil,
+		},
+		{
+			name:     "Negative - role not granted",
+			userName: "someName",
+			password: testPass,
+			role:     "admin",
+			policy:   &policyMock{correctRole: true},
+			repo: &repoMock{
+				GetUserByNameFn: func(ctx context.Context, username string) (*model.User, error) {
+					return &model.User{
+						ID:       1,
+						UserName: "someName",
+						Role:     "viewer",
+						PassHash: string(testHash),
+					}, nil
+				},
+				GetUserRolesFn: func(ctx context.Context, userID int) ([]string, error) {
+					return []string{"manager"}, nil
+				},
+			},
+			jwt:     nil,
+			wantErr: model.ErrAccessDenied,
+			wantEvent: &model.AuthEvent{Type: model.AuthEventLoginFailed, UserID: 1, Username: "somename", Role: "admin",
+				Detail: model.LoginFailRoleNotGranted},
+		},
+		{
+			name:     "Negative - user disabled",
+			userName: "someName",
+			password: testPass,
+			role:     "",
+			policy:   &policyMock{correctRole: true},
+			repo: &repoMock{GetUserByNameFn: func(ctx context.Context, username string) (*model.User, error) {
+				disabledAt := time.Now()
+				return &model.User{
+					ID:         1,
+					UserName:   "someName",
+					Role:       "viewer",
+					PassHash:   string(testHash),
+					DisabledAt: &disabledAt,
+				}, nil
+			}},
+			jwt:        nil,
+			wantErr:    model.ErrUserDisabled,
+			wantReason: model.LoginFailDisabled,
+		},
+		{
+			name:     "Negative - incorrect password",
+			userName: "someName",
+			password: "incorrectPass",
+			role:     "some role",
+			policy:   &policyMock{correctRole: true},
+			repo: &repoMock{GetUserByNameFn: func(ctx context.Context, username string) (*model.User, error) {
+				return &model.User{
+					ID:       1,
+					UserName: "someName",
+					Role:     "some role",
+					PassHash: string(testHash),
+				}, nil
+			}},
+			jwt:        nil,
+			wantErr:    model.ErrInvalidCredentials,
+			wantReason: model.LoginFailBadPassword,
+		},
+		{
+			name:     "Negative - some DB error",
+			userName: "someName",
+			password: "incorrectPass",
+			role:     "some role",
+			policy:   &policyMock{correctRole: true},
+			repo: &repoMock{GetUserByNameFn: func(ctx context.Context, username string) (*model.User, error) {
+				return nil, errors.New("test DB error")
+			}},
+			jwt:     nil,
+			wantErr: model.ErrCommon500,
+		},
+		{
+			name:     "Negative - user not found",
+			userName: "someName",
+			password: "incorrectPass",
+			role:     "some role",
+			policy:   &policyMock{correctRole: true},
+			repo: &repoMock{GetUserByNameFn: func(ctx context.Context, username string) (*model.User, error) {
+				return nil, model.ErrUserNotFound
+			}},
+			jwt:        nil,
+			wantErr:    model.ErrInvalidCredentials, // неотличимо от неверного пароля
+			wantReason: model.LoginFailUnknownUser,
+		},
+		{
+			name:     "Negative - username locked after too many failures",
+			userName: "someName",
+			password: testPass,
+			role:     "",
+			policy:   &policyMock{correctRole: true},
+			repo: &repoMock{GetUserByNameFn: func(ctx context.Context, username string) (*model.User, error) {
+				return &model.User{ID: 1, UserName: "someName", Role: "viewer", PassHash: string(testHash)}, nil
+			}},
+			jwt:        &jwtMock{token: "jwt-token"},
+			failures:   model.LoginFailures{ByUser: 5},
+			wantErr:    model.ErrTooManyLoginAttempts,
+			wantReason: model.LoginFailLocked,
+		},
+		{
+			name:     "Negative - IP locked after too many failures",
+			userName: "otherName",
+			password: testPass,
+			role:     "",
+			policy:   &policyMock{correctRole: true},
+			repo: &repoMock{GetUserByNameFn: func(ctx context.Context, username string) (*model.User, error) {
+				return &model.User{ID: 2, UserName: "otherName", Role: "viewer", PassHash: string(testHash)}, nil
+			}},
+			jwt:        &jwtMock{token: "jwt-token"},
+			failures:   model.LoginFailures{ByUser: 0, ByIP: 50},
+			wantErr:    model.ErrTooManyLoginAttempts,
+			wantReason: model.LoginFailLocked,
+		},
+		{
+			name:     "Positive - failures below limits",
+			userName: "someName",
+			password: testPass,
+			role:     "",
+			policy:   &policyMock{correctRole: true},
+			repo: &repoMock{GetUserByNameFn: func(ctx context.Context, username string) (*model.User, error) {
+				return &model.User{ID: 1, UserName: "someName", Role: "viewer", PassHash: string(testHash)}, nil
+			}},
+			jwt:      &jwtMock{token: "jwt-token"},
+			failures: model.LoginFailures{ByUser: 4, ByIP: 49},
+			wantErr:  nil,
+		},
+		{
+			name:     "Positive - enrolled second factor returns challenge",
+			userName: "someName",
+			password: testPass,
+			role:     "",
+			policy:   &policyMock{correctRole: true},
+			repo: &repoMock{GetUserByNameFn: func(ctx context.Context, username string) (*model.User, error) {
+				return &model.User{ID: 1, UserName: "someName", Role: "viewer", PassHash: string(testHash)}, nil
+			}},
+			jwt:           &jwtMock{token: "jwt-token"},
+			totp:          &model.TOTPState{UserID: 1, Secret: "secret", ConfirmedAt: &now},
+			wantChallenge: &model.LoginChallenge{UserID: 1, Username: "someName", Role: "viewer", EnrollmentRequired: false},
+		},
+		{
+			name:     "Positive - role requires second factor, enrollment pending",
+			userName: "someName",
+			password: testPass,
+			role:     "",
+			policy:   &policyMock{correctRole: true},
+			repo: &repoMock{GetUserByNameFn: func(ctx context.Context, username string) (*model.User, error) {
+				return &model.User{ID: 1, UserName: "someName", Role: "admin", PassHash: string(testHash)}, nil
+			}},
+			jwt:           &jwtMock{token: "jwt-token"},
+			totp:          &model.TOTPState{UserID: 1, Secret: "secret"}, // секрет выдан, но не подтвержден
+			requiredRoles: []string{"admin", "auditor"},
+			wantChallenge: &model.LoginChallenge{UserID: 1, Username: "someName", Role: "admin", EnrollmentRequired: true},
+		},
+		{
+			name:     "Positive - second factor not required for session role",
+			userName: "someName",
+			password: testPass,
+			role:     "",
+			policy:   &policyMock{correctRole: true},
+			repo: &repoMock{GetUserByNameFn: func(ctx context.Context, username string) (*model.User, error) {
+				return &model.User{ID: 1, UserName: "someName", Role: "viewer", PassHash: string(testHash)}, nil
+			}},
+			jwt:           &jwtMock{token: "jwt-token"},
+			requiredRoles: []string{"admin", "auditor"},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := WHCService{
+				repo:       tt.repo,
+				jwtManager: tt.jwt,
+				policy:     tt.policy,
+				cfg: Config{
+					LoginWindow:          DefaultLoginWindow,
+					LoginMaxUserFailures: DefaultLoginMaxUserFailures,
+					LoginMaxIPFailures:   DefaultLoginMaxIPFailures,
+					TOTPRequiredRoles:    tt.requiredRoles,
+				},
+			}
+
+			var recorded []*model.LoginAttempt
+			var events []*model.AuthEvent
+			if tt.repo != nil {
+				tt.repo.CreateRefreshTokenFn = storeRefreshTokenStub
+				tt.repo.CreateAuthEventFn = func(ctx context.Context, event *model.AuthEvent) error {
+					events = append(events, event)
+					return nil
+				}
+				tt.repo.GetLoginFailuresFn = func(ctx context.Context, username, ip string, window time.Duration) (*model.LoginFailures, error) {
+					failures := tt.failures
+					return &failures, nil
+				}
+				tt.repo.RecordLoginAttemptFn = func(ctx context.Context, attempt *model.LoginAttempt) error {
+					recorded = append(recorded, attempt)
+					return nil
+				}
+				tt.repo.GetUserTOTPFn = func(ctx context.Context, userID int) (*model.TOTPState, error) {
+					if tt.totp == nil {
+						return nil, model.ErrTOTPNotEnrolled
+					}
+					return tt.totp, nil
+				}
+				tt.repo.CreateLoginChallengeFn = func(ctx context.Context, userID int, role, tokenHash string, expiresAt time.Time) error {
+					return nil
+				}
+			}
+
+			tokens, _, challenge, err := svc.LoginUser(ctx, tt.userName, tt.password, tt.role)
+
+			switch {
+			case tt.wantErr != nil:
+				require.ErrorIs(t, err, tt.wantErr)
+			case tt.wantChallenge != nil:
+				// пароль верный, но сессии еще нет - ни токенов, ни успешной попытки в журнале
+				require.NoError(t, err)
+				require.Nil(t, tokens)
+				require.NotEmpty(t, challenge.Token)
+				require.Equal(t, tt.wantChallenge.UserID, challenge.UserID)
+				require.Equal(t, tt.wantChallenge.Role, challenge.Role)
+				require.Equal(t, tt.wantChallenge.EnrollmentRequired, challenge.EnrollmentRequired)
+				require.Empty(t, recorded)
+			default:
+				require.Nil(t, challenge)
+				require.NoError(t, err)
+				require.Equal(t, "jwt-token", tokens.AccessToken)
+				require.Len(t, recorded, 1)
+				require.True(t, recorded[0].Success)
+				require.Len(t, events, 1)
+				require.Equal(t, model.AuthEventLogin, events[0].Type)
+			}
+
+			if tt.wantReason != "" {
+				require.Len(t, recorded, 1)
+				require.False(t, recorded[0].Success)
+				require.Equal(t, tt.wantReason, *recorded[0].Reason)
+				require.Len(t, events, 1)
+				require.Equal(t, model.AuthEventLoginFailed, events[0].Type)
+				require.Equal(t, tt.wantReason, events[0].Detail)
+			}
+
+			if tt.wantEvent != nil {
+				require.Empty(t, recorded)
+				require.Equal(t, []*model.AuthEvent{tt.wantEvent}, events)
+			}
+		})
+	}
+}
+
+func TestCompleteLoginTOTP(t *testing.T) {
+	ctx := context.Background()
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+	validCode, err := totp.Code(secret, totp.Step(time.Now()))
+	require.NoError(t, err)
+	now := time.Now()
+
+	cases := []struct {
+		name         string
+		code         string
+		challenge    *model.LoginChallenge
+		state        *model.TOTPState
+		useStepErr   error
+		wantErr      error
+		wantRecovery bool
+		wantFailed   bool // неудача посчитана и в челлендже, и в журнале входов
+	}{
+		{
+			name:      "Positive - valid code",
+			code:      validCode,
+			challenge: &model.LoginChallenge{ID: 7, UserID: 1, Username: "someName", Role: "admin"},
+			state:     &model.TOTPState{UserID: 1, Secret: secret, ConfirmedAt: &now},
+		},
+		{
+			name:      "Positive - recovery code",
+			code:      "ABCDE-FGHIJ",
+			challenge: &model.LoginChallenge{ID: 7, UserID: 1, Username: "someName", Role: "admin"},
+			state:     &model.TOTPState{UserID: 1, Secret: secret, ConfirmedAt: &now},
+		},
+		{
+			name:         "Positive - enrollment completed during login",
+			code:         validCode,
+			challenge:    &model.LoginChallenge{ID: 7, UserID: 1, Username: "someName", Role: "admin"},
+			state:        &model.TOTPState{UserID: 1, Secret: secret},
+			wantRecovery: true,
+		},
+		{
+			name:       "Negative - wrong code",
+			code:       "000000",
+			challenge:  &model.LoginChallenge{ID: 7, UserID: 1, Username: "someName", Role: "admin"},
+			state:      &model.TOTPState{UserID: 1, Secret: secret, ConfirmedAt: &now},
+			wantErr:    model.ErrInvalidOTP,
+			wantFailed: true,
+		},
+		{
+			name:       "Negative - code replayed",
+			code:       validCode,
+			challenge:  &model.LoginChallenge{ID: 7, UserID: 1, Username: "someName", Role: "admin"},
+			state:      &model.TOTPState{UserID: 1, Secret: secret, ConfirmedAt: &now},
+			useStepErr: model.ErrInvalidOTP,
+			wantErr:    model.ErrInvalidOTP,
+			wantFailed: true,
+		},
+		{
+			name:      "Negative - challenge attempts exhausted",
+			code:      validCode,
+			challenge: &model.LoginChallenge{ID: 7, UserID: 1, Username: "someName", Role: "admin", Attempts: MaxChallengeAttempts},
+			state:     &model.TOTPState{UserID: 1, Secret: secret, ConfirmedAt: &now},
+			wantErr:   model.ErrInvalidChallenge,
+		},
+		{
+			name:    "Negative - empty code",
+			code:    " ",
+			wantErr: model.ErrEmptyOTPCode,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			var failedChallenge bool
+			var recorded []*model.LoginAttempt
+			var recoveryHashes []string
+
+			repo := &repoMock{
+				GetLoginChallengeFn: func(ctx context.Context, tokenHash string) (*model.LoginChallenge, error) {
+					require.Equal(t, hashToken("challenge-token"), tokenHash)
+					return tt.challenge, nil
+				},
+				GetLoginFailuresFn: func(ctx context.Context, username, ip string, window time.Duration) (*model.LoginFailures, error) {
+					return &model.LoginFailures{}, nil
+				},
+				GetUserTOTPFn: func(ctx context.Context, userID int) (*model.TOTPState, error) {
+					return tt.state, nil
+				},
+				UseTOTPStepFn: func(ctx context.Context, userID int, step int64) error {
+					return tt.useStepErr
+				},
+				UseRecoveryCodeFn: func(ctx context.Context, userID int, codeHash string) error {
+					// код восстановления сверяется без учета регистра и дефиса
+					if codeHash != hashToken("abcdefghij") {
+						return model.ErrInvalidOTP
+					}
+					return nil
+				},
+				ConfirmTOTPFn: func(ctx context.Context, userID int, step int64, hashes []string) error {
+					recoveryHashes = hashes
+					return nil
+				},
+				FailLoginChallengeFn: func(ctx context.Context, challengeID int) error {
+					failedChallenge = true
+					return nil
+				},
+				CompleteLoginChallengeFn: func(ctx context.Context, challengeID int) error {
+					return nil
+				},
+				RecordLoginAttemptFn: func(ctx context.Context, attempt *model.LoginAttempt) error {
+					recorded = append(recorded, attempt)
+					return nil
+				},
+				CreateAuthEventFn:    func(ctx context.Context, event *model.AuthEvent) error { return nil },
+				CreateRefreshTokenFn: storeRefreshTokenStub,
+			}
+			svc := WHCService{
+				repo:       repo,
+				jwtManager: &jwtMock{token: "jwt-token"},
+				policy:     policyMock{correctRole: true},
+				cfg: Config{
+					LoginWindow:          DefaultLoginWindow,
+					LoginMaxUserFailures: DefaultLoginMaxUserFailures,
+					LoginMaxIPFailures:   DefaultLoginMaxIPFailures,
+				},
+			}
+
+			tokens, user, recovery, err := svc.CompleteLoginTOTP(ctx, "challenge-token", tt.code)
+
+			require.Equal(t, tt.wantFailed, failedChallenge)
+			if tt.wantFailed {
+				require.Len(t, recorded, 1)
+				require.Equal(t, model.LoginFailBadOTP, *recorded[0].Reason)
+			}
+
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, "jwt-token", tokens.AccessToken)
+			require.Equal(t, tt.challenge.Role, user.Role)
+			require.True(t, recorded[0].Success)
+
+			if tt.wantRecovery {
+				require.Len(t, recovery, recoveryCodesCount)
+				require.Len(t, recoveryHashes, recoveryCodesCount)
+				require.Equal(t, hashToken(normalizeRecoveryCode(recovery[0])), recoveryHashes[0])
+			} else {
+				require.Empty(t, recovery)
+			}
+		})
+	}
+}
+
+func TestGrantUserRole(t *testing.T) {
+	ctx := context.Background()
+
+	cases := []struct {
+		name    string
+		userID  int
+		repo    *repoMock
+		policy  policyMock
+		wantErr error
+	}{
+		{
+			name:   "Positive - role granted",
+			userID: 5,
+			repo: &repoMock{GrantUserRoleFn: func(ctx context.Context, userID int, role string, grantedBy string) error {
+				return nil
+			}},
+			policy:  policyMock{canManageUser: true, correctRole: true},
+			wantErr: nil,
+		},
+		{
+			name:    "Negative - no access to manage users",
+			userID:  5,
+			repo:    nil,
+			policy:  policyMock{canManageUser: false, correctRole: true},
+			wantErr: model.ErrAccessDenied,
+		},
+		{
+			name:    "Negative - unknown role",
+			userID:  5,
+			repo:    nil,
+			policy:  policyMock{canManageUser: true, correctRole: false},
+			wantErr: model.ErrIncorrectUserRole,
+		},
+		{
+			name:    "Negative - incorrect user ID",
+			userID:  -5,
+			repo:    nil,
+			policy:  policyMock{canManageUser: true, correctRole: true},
+			wantErr: model.ErrIncorrectUserID,
+		},
+		{
+			name:   "Negative - user not found",
+			userID: 5,
+			repo: &repoMock{GrantUserRoleFn: func(ctx context.Context, userID int, role string, grantedBy string) error {
+				return model.ErrUserNotFound
+			}},
+			policy:  policyMock{canManageUser: true, correctRole: true},
+			wantErr: model.ErrUserNotFound,
+		},
+		{
+			name:   "Negative - DB error",
+			userID: 5,
+			repo: &repoMock{GrantUserRoleFn: func(ctx context.Context, userID int, role string, grantedBy string) error {
+				return errors.New("some DB error")
+			}},
+			policy:  policyMock{canManageUser: true, correctRole: true},
+			wantErr: model.ErrCommon500,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := WHCService{
+				repo:   tt.repo,
+				policy: tt.policy,
+			}
+
+			err := svc.GrantUserRole(ctx, tt.userID, "manager", "admin", "someAdmin")
+			require.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRevokeUserRole(t *testing.T) {
+	ctx := context.Background()
+
+	cases := []struct {
+		name    string
+		repo    *repoMock
+		policy  policyMock
+		wantErr error
+	}{
+		{
+			name: "Positive - role revoked",
+			repo: &repoMock{RevokeUserRoleFn: func(ctx context.Context, userID int, role string, revokedBy string) error {
+				return nil
+			}},
+			policy:  policyMock{canManageUser: true, correctRole: true},
+			wantErr: nil,
+		},
+		{
+			name: "Negative - role not granted",
+			repo: &repoMock{RevokeUserRoleFn: func(ctx context.Context, userID int, role string, revokedBy string) error {
+				return model.ErrRoleNotGranted
+			}},
+			policy:  policyMock{canManageUser: true, correctRole: true},
+			wantErr: model.ErrRoleNotGranted,
+		},
+		{
+			name:    "Negative - no access to manage users",
+			repo:    nil,
+			policy:  policyMock{canManageUser: false, correctRole: true},
+			wantErr: model.ErrAccessDenied,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := WHCService{
+				repo:   tt.repo,
+				policy: tt.policy,
+			}
+
+			err := svc.RevokeUserRole(ctx, 5, "manager", "admin", "someAdmin")
+			require.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestCreateWarehouse(t *testing.T) {
+	ctx := context.Background()
+
+	cases := []struct {
+		name     string
+		wh       *model.Warehouse
+		repoErr  error
+		policy   policyMock
+		wantErr  error
+		wantCode string
+	}{
+		{
+			name:     "Positive - warehouse created, code normalized",
+			wh:       &model.Warehouse{Code: " north ", Name: "North hub"},
+			policy:   policyMock{canManageUser: true},
+			wantErr:  nil,
+			wantCode: "NORTH",
+		},
+		{
+			name:    "Negative - no access to manage warehouses",
+			wh:      &model.Warehouse{Code: "north", Name: "North hub"},
+			policy:  policyMock{canManageUser: false},
+			wantErr: model.ErrAccessDenied,
+		},
+		{
+			name:    "Negative - empty code",
+			wh:      &model.Warehouse{Code: "  ", Name: "North hub"},
+			policy:  policyMock{canManageUser: true},
+			wantErr: model.ErrEmptyWarehouseInfo,
+		},
+		{
+			name:    "Negative - code already exists",
+			wh:      &model.Warehouse{Code: "north", Name: "North hub"},
+			repoErr: &pq.Error{Code: "23505", Message: `duplicate key value violates unique constraint "warehouses_code_key"`},
+			policy:  policyMock{canManageUser: true},
+			wantErr: model.ErrWarehouseAlreadyExists,
+		},
+		{
+			name:    "Negative - DB error",
+			wh:      &model.Warehouse{Code: "north", Name: "North hub"},
+			repoErr: errors.New("some DB error"),
+			policy:  policyMock{canManageUser: true},
+			wantErr: model.ErrCommon500,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := WHCService{
+				repo: &repoMock{CreateWarehouseFn: func(ctx context.Context, wh *model.Warehouse) error {
+					return tt.repoErr
+				}},
+				policy: tt.policy,
+			}
+
+			err := svc.CreateWarehouse(ctx, tt.wh, "admin", "someAdmin")
+			require.ErrorIs(t, err, tt.wantErr)
+			if tt.wantErr == nil {
+				require.Equal(t, tt.wantCode, tt.wh.Code)
+				require.Equal(t, "someAdmin", tt.wh.UpdatedBy)
+			}
+		})
+	}
+}
+
+func TestCheckWarehouseScope(t *testing.T) {
+	ctx := context.Background()
+	scoped := &repoMock{GetUserWarehousesFn: func(ctx context.Context, userID int) ([]int, error) { return []int{1}, nil }}
+
+	cases := []struct {
+		name        string
+		userID      int
+		warehouseID int
+		repo        *repoMock
+		wantErr     error
+	}{
+		{
+			name:        "Positive - warehouse within user scope",
+			userID:      7,
+			warehouseID: 1,
+			repo:        scoped,
+		},
+		{
+			name:        "Positive - user without granted warehouses is not restricted",
+			userID:      7,
+			warehouseID: 2,
+			repo:        &repoMock{GetUserWarehousesFn: func(ctx context.Context, userID int) ([]int, error) { return nil, nil }},
+		},
+		{
+			// у API-ключа нет userID: склады ему не выдаются, ключ работает со всеми складами
+			name:        "Positive - api key is not restricted by warehouses",
+			userID:      0,
+			warehouseID: 2,
+			repo:        nil,
+		},
+		{
+			name:        "Negative - warehouse out of user scope",
+			userID:      7,
+			warehouseID: 2,
+			repo:        scoped,
+			wantErr:     model.ErrWarehouseAccessDenied,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := WHCService{repo: tt.repo}
+
+			err := svc.checkWarehouseScope(ctx, tt.userID, tt.warehouseID)
+			require.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestGrantUserWarehouse(t *testing.T) {
+	ctx := context.Background()
+
+	cases := []struct {
+		name        string
+		userID      int
+		warehouseID int
+		repo        *repoMock
+		policy      policyMock
+		wantErr     error
+	}{
+		{
+			name:        "Positive - warehouse granted",
+			userID:      5,
+			warehouseID: 2,
+			repo: &repoMock{GrantUserWarehouseFn: func(ctx context.Context, userID, warehouseID int, grantedBy string) error {
+				return nil
+			}},
+			policy:  policyMock{canManageUser: true},
+			wantErr: nil,
+		},
+		{
+			name:        "Negative - no access to manage users",
+			userID:      5,
+			warehouseID: 2,
+			repo:        nil,
+			policy:      policyMock{canManageUser: false},
+			wantErr:     model.ErrAccessDenied,
+		},
+		{
+			name:        "Negative - incorrect warehouse ID",
+			userID:      5,
+			warehouseID: 0,
+			repo:        nil,
+			policy:      policyMock{canManageUser: true},
+			wantErr:     model.ErrIncorrectWarehouseID,
+		},
+		{
+			name:        "Negative - warehouse not found",
+			userID:      5,
+			warehouseID: 2,
+			repo: &repoMock{GrantUserWarehouseFn: func(ctx context.Context, userID, warehouseID int, grantedBy string) error {
+				return model.ErrWarehouseNotFound
+			}},
+			policy:  policyMock{canManageUser: true},
+			wantErr: model.ErrWarehouseNotFound,
+		},
+		{
+			name:        "Negative - DB error",
+			userID:      5,
+			warehouseID: 2,
+			repo: &repoMock{GrantUserWarehouseFn: func(ctx context.Context, userID, warehouseID int, grantedBy string) error {
+				return errors.New("some DB error")
+			}},
+			policy:  policyMock{canManageUser: true},
+			wantErr: model.ErrCommon500,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := WHCService{
+				repo:   tt.repo,
+				policy: tt.policy,
+			}
+
+			err := svc.GrantUserWarehouse(ctx, tt.userID, tt.warehouseID, "admin", "someAdmin")
+			require.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestCreateLocation(t *testing.T) {
+	ctx := context.Background()
+	zone := &model.Location{ID: 3, WarehouseID: 1, Kind: model.LocationZone, Code: "A"}
+	shelf := &model.Location{ID: 4, WarehouseID: 1, Kind: model.LocationShelf, Code: "A-01-1"}
+	otherWarehouse := &model.Location{ID: 5, WarehouseID: 2, Kind: model.LocationZone, Code: "B"}
+	parentID := func(id int) *int { return &id }
+
+	cases := []struct {
+		name     string
+		loc      *model.Location
+		parent   *model.Location
+		repoErr  error
+		policy   policyMock
+		wantErr  error
+		wantCode string
+	}{
+		{
+			name:     "Positive - root zone created, code and kind normalized",
+			loc:      &model.Location{WarehouseID: 1, Kind: "zone", Code: " a "},
+			policy:   policyMock{canManageUser: true},
+			wantCode: "A",
+		},
+		{
+			name:     "Positive - aisle inside zone",
+			loc:      &model.Location{WarehouseID: 1, ParentID: parentID(3), Kind: "AISLE", Code: "A-01"},
+			parent:   zone,
+			policy:   policyMock{canManageUser: true},
+			wantCode: "A-01",
+		},
+		{
+			name:    "Negative - no access to manage warehouses",
+			loc:     &model.Location{WarehouseID: 1, Kind: "ZONE", Code: "A"},
+			policy:  policyMock{canManageUser: false},
+			wantErr: model.ErrAccessDenied,
+		},
+		{
+			name:    "Negative - unknown kind",
+			loc:     &model.Location{WarehouseID: 1, Kind: "ROOM", Code: "A"},
+			policy:  policyMock{canManageUser: true},
+			wantErr: model.ErrInvalidLocationKind,
+		},
+		{
+			name:    "Negative - parent skips a level",
+			loc:     &model.Location{WarehouseID: 1, ParentID: parentID(3), Kind: "SHELF", Code: "A-01-1"},
+			parent:  zone,
+			policy:  policyMock{canManageUser: true},
+			wantErr: model.ErrInvalidLocationTree,
+		},
+		{
+			name:    "Negative - parent in another warehouse",
+			loc:     &model.Location{WarehouseID: 1, ParentID: parentID(5), Kind: "AISLE", Code: "B-01"},
+			parent:  otherWarehouse,
+			policy:  policyMock{canManageUser: true},
+			wantErr: model.ErrInvalidLocationTree,
+		},
+		{
+			name:     "Positive - bin on shelf",
+			loc:      &model.Location{WarehouseID: 1, ParentID: parentID(4), Kind: "BIN", Code: "A-01-1-3"},
+			parent:   shelf,
+			policy:   policyMock{canManageUser: true},
+			wantCode: "A-01-1-3",
+		},
+		{
+			name:    "Negative - code already exists",
+			loc:     &model.Location{WarehouseID: 1, Kind: "BIN", Code: "RECEIVING"},
+			repoErr: &pq.Error{Code: "23505", Message: `duplicate key value violates unique constraint "locations_warehouse_id_code_key"`},
+			policy:  policyMock{canManageUser: true},
+			wantErr: model.ErrLocationAlreadyExists,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := WHCService{
+				repo: &repoMock{
+					GetLocationFn: func(ctx context.Context, locationID int) (*model.Location, error) {
+						return tt.parent, nil
+					},
+					CreateLocationFn: func(ctx context.Context, loc *model.Location) error {
+						return tt.repoErr
+					},
+				},
+				policy: tt.policy,
+			}
+
+			err := svc.CreateLocation(ctx, tt.loc, "admin", "someAdmin")
+			require.ErrorIs(t, err, tt.wantErr)
+			if tt.wantErr == nil {
+				require.Equal(t, tt.wantCode, tt.loc.Code)
+				require.Equal(t, "someAdmin", tt.loc.UpdatedBy)
+			}
+		})
+	}
+}
+
+func TestMoveItemStock(t *testing.T) {
+	ctx := context.Background()
+	locations := map[int]*model.Location{
+		1:  {ID: 1, WarehouseID: 1, Kind: model.LocationBin, Code: model.ReceivingLocationCode},
+		10: {ID: 10, WarehouseID: 1, Kind: model.LocationBin, Code: "A-01-1-1"},
+		11: {ID: 11, WarehouseID: 1, Kind: model.LocationBin, Code: "A-01-1-2"},
+		12: {ID: 12, WarehouseID: 1, Kind: model.LocationShelf, Code: "A-01-1"},
+		20: {ID: 20, WarehouseID: 2, Kind: model.LocationBin, Code: "B-01-1-1"},
+	}
+	locID := func(id int) *int { return &id }
+
+	cases := []struct {
+		name       string
+		mv         *model.StockMove
+		userID     int
+		scope      []int
+		repoErr    error
+		policy     policyMock
+		wantErr    error
+		wantFrom   int
+		wantAction string
+	}{
+		{
+			name:       "Positive - putaway from receiving bin",
+			mv:         &model.StockMove{ItemID: 1, ToLocationID: 10, Amount: 5},
+			policy:     policyMock{canUpdate: true},
+			wantFrom:   1,
+			wantAction: model.HistoryActionPutaway,
+		},
+		{
+			name:       "Positive - move between bins",
+			mv:         &model.StockMove{ItemID: 1, FromLocationID: locID(10), ToLocationID: 11, Amount: 5},
+			policy:     policyMock{canUpdate: true},
+			wantFrom:   10,
+			wantAction: model.HistoryActionMove,
+		},
+		{
+			name:    "Negative - no access to update items",
+			mv:      &model.StockMove{ItemID: 1, ToLocationID: 10, Amount: 5},
+			policy:  policyMock{canUpdate: false},
+			wantErr: model.ErrAccessDenied,
+		},
+		{
+			name:    "Negative - zero amount",
+			mv:      &model.StockMove{ItemID: 1, ToLocationID: 10, Amount: 0},
+			policy:  policyMock{canUpdate: true},
+			wantErr: model.ErrInvalidMoveAmount,
+		},
+		{
+			name:    "Negative - target is not a bin",
+			mv:      &model.StockMove{ItemID: 1, ToLocationID: 12, Amount: 5},
+			policy:  policyMock{canUpdate: true},
+			wantErr: model.ErrInvalidStockMove,
+		},
+		{
+			name:    "Negative - bins in different warehouses",
+			mv:      &model.StockMove{ItemID: 1, FromLocationID: locID(10), ToLocationID: 20, Amount: 5},
+			policy:  policyMock{canUpdate: true},
+			wantErr: model.ErrInvalidStockMove,
+		},
+		{
+			name:    "Negative - same bin",
+			mv:      &model.StockMove{ItemID: 1, FromLocationID: locID(10), ToLocationID: 10, Amount: 5},
+			policy:  policyMock{canUpdate: true},
+			wantErr: model.ErrInvalidStockMove,
+		},
+		{
+			name:    "Negative - target location not found",
+			mv:      &model.StockMove{ItemID: 1, ToLocationID: 99, Amount: 5},
+			policy:  policyMock{canUpdate: true},
+			wantErr: model.ErrLocationNotFound,
+		},
+		{
+			name:    "Negative - warehouse out of user scope",
+			mv:      &model.StockMove{ItemID: 1, ToLocationID: 10, Amount: 5},
+			userID:  7,
+			scope:   []int{2},
+			policy:  policyMock{canUpdate: true},
+			wantErr: model.ErrWarehouseAccessDenied,
+		},
+		{
+			name:    "Negative - not enough stock",
+			mv:      &model.StockMove{ItemID: 1, ToLocationID: 10, Amount: 5},
+			repoErr: model.ErrInsufficientStock,
+			policy:  policyMock{canUpdate: true},
+			wantErr: model.ErrInsufficientStock,
+		},
+		{
+			name:    "Negative - DB error",
+			mv:      &model.StockMove{ItemID: 1, ToLocationID: 10, Amount: 5},
+			repoErr: errors.New("some DB error"),
+			policy:  policyMock{canUpdate: true},
+			wantErr: model.ErrCommon500,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := WHCService{
+				repo: &repoMock{
+					GetLocationFn: func(ctx context.Context, locationID int) (*model.Location, error) {
+						if loc, ok := locations[locationID]; ok {
+							return loc, nil
+						}
+						return nil, model.ErrLocationNotFound
+					},
+					GetReceivingLocationFn: func(ctx context.Context, warehouseID int) (*model.Location, error) {
+						return locations[1], nil
+					},
+					GetUserWarehousesFn: func(ctx context.Context, userID int) ([]int, error) {
+						return tt.scope, nil
+					},
+					MoveItemStockFn: func(ctx context.Context, mv *model.StockMove) error {
+						return tt.repoErr
+					},
+				},
+				policy: tt.policy,
+			}
+
+			err := svc.MoveItemStock(ctx, tt.mv, tt.userID, "manager")
+			require.ErrorIs(t, err, tt.wantErr)
+			if tt.wantErr == nil {
+				require.Equal(t, tt.wantFrom, *tt.mv.FromLocationID)
+				require.Equal(t, tt.wantAction, tt.mv.Action)
+				require.Equal(t, 1, tt.mv.WarehouseID)
+			}
+		})
+	}
+}
+
+func TestCreateMovement(t *testing.T) {
+	ctx := context.Background()
+	locations := map[int]*model.Location{
+		1:  {ID: 1, WarehouseID: 1, Kind: model.LocationBin, Code: model.ReceivingLocationCode},
+		2:  {ID: 2, WarehouseID: 2, Kind: model.LocationBin, Code: model.ReceivingLocationCode},
+		10: {ID: 10, WarehouseID: 1, Kind: model.LocationBin, Code: "A-01-1-1"},
+		12: {ID: 12, WarehouseID: 1, Kind: model.LocationShelf, Code: "A-01-1"},
+	}
+	intPtr := func(v int) *int { return &v }
+
+	cases := []struct {
+		name         string
+		m            *model.Movement
+		userID       int
+		scope        []int
+		repoErr      error
+		policy       policyMock
+		wantErr      error
+		wantLocation int
+	}{
+		{
+			name:         "Positive - receipt into default warehouse receiving bin",
+			m:            &model.Movement{ItemID: 1, Type: "Receipt", Quantity: 10, ReasonCode: "po"},
+			policy:       policyMock{canUpdate: true},
+			wantLocation: 1,
+		},
+		{
+			name:         "Positive - issue from bin, warehouse taken from bin",
+			m:            &model.Movement{ItemID: 1, Type: "issue", LocationID: intPtr(10), Quantity: -3, ReasonCode: "SALE"},
+			policy:       policyMock{canUpdate: true},
+			wantLocation: 10,
+		},
+		{
+			name:         "Positive - transfer to another warehouse",
+			m:            &model.Movement{ItemID: 1, Type: "transfer", LocationID: intPtr(10), ToWarehouseID: intPtr(2), Quantity: 3, ReasonCode: "REBALANCE"},
+			policy:       policyMock{canUpdate: true},
+			wantLocation: 10,
+		},
+		{
+			name:    "Negative - unknown type",
+			m:       &model.Movement{ItemID: 1, Type: "gift", Quantity: 3, ReasonCode: "X"},
+			policy:  policyMock{canUpdate: true},
+			wantErr: model.ErrInvalidMovementType,
+		},
+		{
+			name:    "Negative - positive write-off",
+			m:       &model.Movement{ItemID: 1, Type: "write_off", Quantity: 3, ReasonCode: "DAMAGED"},
+			policy:  policyMock{canUpdate: true},
+			wantErr: model.ErrInvalidMovementQty,
+		},
+		{
+			name:    "Negative - bad reason code",
+			m:       &model.Movement{ItemID: 1, Type: "adjustment", Quantity: -1, ReasonCode: "cycle count!"},
+			policy:  policyMock{canUpdate: true},
+			wantErr: model.ErrInvalidReasonCode,
+		},
+		{
+			name:    "Negative - transfer without destination",
+			m:       &model.Movement{ItemID: 1, Type: "transfer", Quantity: 3, ReasonCode: "REBALANCE"},
+			policy:  policyMock{canUpdate: true},
+			wantErr: model.ErrInvalidTransfer,
+		},
+		{
+			name:    "Negative - transfer into the same bin",
+			m:       &model.Movement{ItemID: 1, Type: "transfer", LocationID: intPtr(10), ToLocationID: intPtr(10), Quantity: 3, ReasonCode: "REBALANCE"},
+			policy:  policyMock{canUpdate: true},
+			wantErr: model.ErrInvalidTransfer,
+		},
+		{
+			name:    "Negative - location is not a bin",
+			m:       &model.Movement{ItemID: 1, Type: "receipt", LocationID: intPtr(12), Quantity: 3, ReasonCode: "PO"},
+			policy:  policyMock{canUpdate: true},
+			wantErr: model.ErrLocationNotBin,
+		},
+		{
+			name:    "Negative - bin from another warehouse",
+			m:       &model.Movement{ItemID: 1, Type: "receipt", WarehouseID: intPtr(2), LocationID: intPtr(10), Quantity: 3, ReasonCode: "PO"},
+			policy:  policyMock{canUpdate: true},
+			wantErr: model.ErrLocationNotBin,
+		},
+		{
+			name:    "Negative - destination warehouse out of user scope",
+			m:       &model.Movement{ItemID: 1, Type: "transfer", ToWarehouseID: intPtr(2), Quantity: 3, ReasonCode: "REBALANCE"},
+			userID:  7,
+			scope:   []int{1},
+			policy:  policyMock{canUpdate: true},
+			wantErr: model.ErrWarehouseAccessDenied,
+		},
+		{
+			name:    "Negative - no access to update items",
+			m:       &model.Movement{ItemID: 1, Type: "receipt", Quantity: 10, ReasonCode: "PO"},
+			policy:  policyMock{canUpdate: false},
+			wantErr: model.ErrAccessDenied,
+		},
+		{
+			name:    "Negative - no access is reported before validation",
+			m:       &model.Movement{ItemID: 0, Type: "unknown", Quantity: 0},
+			policy:  policyMock{canUpdate: false},
+			wantErr: model.ErrAccessDenied,
+		},
+		{
+			name:    "Negative - not enough stock",
+			m:       &model.Movement{ItemID: 1, Type: "issue", Quantity: -10, ReasonCode: "SALE"},
+			repoErr: model.ErrInsufficientStock,
+			policy:  policyMock{canUpdate: true},
+			wantErr: model.ErrInsufficientStock,
+		},
+		{
+			name:    "Negative - DB error",
+			m:       &model.Movement{ItemID: 1, Type: "issue", Quantity: -10, ReasonCode: "SALE"},
+			repoErr: errors.New("some DB error"),
+			policy:  policyMock{canUpdate: true},
+			wantErr: model.ErrCommon500,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := WHCService{
+				repo: &repoMock{
+					GetLocationFn: func(ctx context.Context, locationID int) (*model.Location, error) {
+						if loc, ok := locations[locationID]; ok {
+							return loc, nil
+						}
+						return nil, model.ErrLocationNotFound
+					},
+					GetReceivingLocationFn: func(ctx context.Context, warehouseID int) (*model.Location, error) {
+						return locations[warehouseID], nil
+					},
+					GetUserWarehousesFn: func(ctx context.Context, userID int) ([]int, error) {
+						return tt.scope, nil
+					},
+					CreateMovementFn: func(ctx context.Context, m *model.Movement) ([]*model.Movement, error) {
+						if tt.repoErr != nil {
+							return nil, tt.repoErr
+						}
+						return []*model.Movement{m}, nil
+					},
+				},
+				policy: tt.policy,
+				cfg:    Config{DefaultWarehouseID: 1},
+			}
+
+			res, err := svc.CreateMovement(ctx, tt.m, tt.userID, "manager")
+			require.ErrorIs(t, err, tt.wantErr)
+			if tt.wantErr == nil {
+				require.Len(t, res, 1)
+				require.Equal(t, tt.wantLocation, *tt.m.LocationID)
+				if tt.m.Type == model.MovementTransfer {
+					require.Equal(t, 2, *tt.m.ToLocationID)
+				}
+			}
+		})
+	}
+}
+
+func TestAdjustItemStock(t *testing.T) {
+	ctx := context.Background()
+	bins := map[int]*model.Location{
+		1:  {ID: 1, WarehouseID: 1, Kind: model.LocationBin, Code: model.ReceivingLocationCode},
+		10: {ID: 10, WarehouseID: 1, Kind: model.LocationBin, Code: "A-01-1-1"},
+	}
+	intPtr := func(v int) *int { return &v }
+
+	cases := []struct {
+		name       string
+		adj        *model.StockAdjustment
+		repoErr    error
+		policy     policyMock
+		wantErr    error
+		wantReason string
+		wantBin    *int
+	}{
+		{
+			name:       "Positive - decrement without bin is left to warehouse bins with default reason",
+			adj:        &model.StockAdjustment{ItemID: 1, Delta: -3},
+			policy:     policyMock{canUpdate: true},
+			wantReason: model.ReasonStockDelta,
+		},
+		{
+			name:       "Positive - decrement in chosen bin",
+			adj:        &model.StockAdjustment{ItemID: 1, Delta: -3, LocationID: intPtr(10), ReasonCode: "damaged"},
+			policy:     policyMock{canUpdate: true},
+			wantReason: "DAMAGED",
+			wantBin:    intPtr(10),
+		},
+		{
+			name:       "Positive - increment without bin goes to receiving bin",
+			adj:        &model.StockAdjustment{ItemID: 1, Delta: 5},
+			policy:     policyMock{canUpdate: true},
+			wantReason: model.ReasonStockDelta,
+			wantBin:    intPtr(1),
+		},
+		{
+			name:       "Positive - increment in bin with own reason",
+			adj:        &model.StockAdjustment{ItemID: 1, Delta: 5, LocationID: intPtr(10), ReasonCode: "found"},
+			policy:     policyMock{canUpdate: true},
+			wantReason: "FOUND",
+			wantBin:    intPtr(10),
+		},
+		{
+			name:    "Negative - decrement without bin exceeds warehouse total",
+			adj:     &model.StockAdjustment{ItemID: 1, Delta: -30},
+			repoErr: model.ErrInsufficientStock,
+			policy:  policyMock{canUpdate: true},
+			wantErr: model.ErrNegativeStock,
+		},
+		{
+			name:    "Negative - zero delta",
+			adj:     &model.StockAdjustment{ItemID: 1},
+			policy:  policyMock{canUpdate: true},
+			wantErr: model.ErrInvalidStockDelta,
+		},
+		{
+			name:    "Negative - incorrect item id",
+			adj:     &model.StockAdjustment{ItemID: 0, Delta: 1},
+			policy:  policyMock{canUpdate: true},
+			wantErr: model.ErrIncorrectItemID,
+		},
+		{
+			name:    "Negative - result would be negative",
+			adj:     &model.StockAdjustment{ItemID: 1, Delta: -300, LocationID: intPtr(10)},
+			repoErr: model.ErrInsufficientStock,
+			policy:  policyMock{canUpdate: true},
+			wantErr: model.ErrNegativeStock,
+		},
+		{
+			name:    "Negative - no access to update items",
+			adj:     &model.StockAdjustment{ItemID: 1, Delta: -3},
+			policy:  policyMock{canUpdate: false},
+			wantErr: model.ErrAccessDenied,
+		},
+		{
+			name:    "Negative - no access is reported before validation",
+			adj:     &model.StockAdjustment{ItemID: 0},
+			policy:  policyMock{canUpdate: false},
+			wantErr: model.ErrAccessDenied,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *model.Movement
+			svc := WHCService{
+				repo: &repoMock{
+					GetLocationFn: func(ctx context.Context, locationID int) (*model.Location, error) {
+						return bins[locationID], nil
+					},
+					GetReceivingLocationFn: func(ctx context.Context, warehouseID int) (*model.Location, error) {
+						return bins[warehouseID], nil
+					},
+					GetUserWarehousesFn: func(ctx context.Context, userID int) ([]int, error) {
+						return nil, nil
+					},
+					CreateMovementFn: func(ctx context.Context, m *model.Movement) ([]*model.Movement, error) {
+						if tt.repoErr != nil {
+							return nil, tt.repoErr
+						}
+						got = m
+						return []*model.Movement{m}, nil
+					},
+				},
+				policy: tt.policy,
+				cfg:    Config{DefaultWarehouseID: 1},
+			}
+
+			res, err := svc.AdjustItemStock(ctx, tt.adj, 3, "manager")
+			require.ErrorIs(t, err, tt.wantErr)
+			if tt.wantErr == nil {
+				require.Equal(t, []*model.Movement{got}, res)
+				require.Equal(t, 1, *got.WarehouseID)
+				require.Equal(t, tt.wantBin, got.LocationID)
+				require.Equal(t, model.MovementAdjustment, got.Type)
+				require.Equal(t, tt.adj.Delta, got.Quantity)
+				require.Equal(t, tt.wantReason, got.ReasonCode)
+			}
+		})
+	}
+}
+
+func TestSetUserDisabled(t *testing.T) {
+	ctx := context.Background()
+
+	cases := []struct {
+		name    string
+		userID  int
+		repo    *repoMock
+		policy  policyMock
+		wantErr error
+	}{
+		{
+			name:   "Positive - user disabled",
+			userID: 5,
+			repo: &repoMock{SetUserDisabledFn: func(ctx context.Context, userID int, disabled bool, updatedBy string) error {
+				return nil
+			}},
+			policy:  policyMock{canManageUser: true},
+			wantErr: nil,
+		},
+		{
+			name:    "Negative - no access to manage users",
+			userID:  5,
+			repo:    nil,
+			policy:  policyMock{canManageUser: false},
+			wantErr: model.ErrAccessDenied,
+		},
+		{
+			name:   "Negative - user not found",
+			userID: 5,
+			repo: &repoMock{SetUserDisabledFn: func(ctx context.Context, userID int, disabled bool, updatedBy string) error {
+				return model.ErrUserNotFound
+			}},
+			policy:  policyMock{canManageUser: true},
+			wantErr: model.ErrUserNotFound,
+		},
+		{
+			name:   "Negative - DB error",
+			userID: 5,
+			repo: &repoMock{SetUserDisabledFn: func(ctx context.Context, userID int, disabled bool, updatedBy string) error {
+				return errors.New("some DB error")
+			}},
+			policy:  policyMock{canManageUser: true},
+			wantErr: model.ErrCommon500,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := WHCService{
+				repo:   tt.repo,
+				policy: tt.policy,
+			}
+
+			err := svc.SetUserDisabled(ctx, tt.userID, true, "admin", "someAdmin")
+			require.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestResetUserPassword(t *testing.T) {
+	ctx := context.Background()
+
+	cases := []struct {
+		name     string
+		password string
+		repo     *repoMock
+		policy   policyMock
+		wantErr  error
+	}{
+		{
+			name:     "Positive - password reset",
+			password: "newPass",
+			repo: &repoMock{UpdateUserPasswordFn: func(ctx context.Context, userID int, passHash string, updatedBy string) error {
+				if bcrypt.CompareHashAndPassword([]byte(passHash), []byte("newPass")) != nil {
+					return errors.New("password is not hashed")
+				}
+				return nil
+			}},
+			policy:  policyMock{canManageUser: true},
+			wantErr: nil,
+		},
+		{
+			name:     "Negative - empty password",
+			password: "",
+			repo:     nil,
+			policy:   policyMock{canManageUser: true},
+			wantErr:  model.ErrEmptyPassword,
+		},
+		{
+			name:     "Negative - no access to manage users",
+			password: "newPass",
+			repo:     nil,
+			policy:   policyMock{canManageUser: false},
+			wantErr:  model.ErrAccessDenied,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := WHCService{
+				repo:   tt.repo,
+				policy: tt.policy,
+			}
+
+			err := svc.ResetUserPassword(ctx, 5, tt.password, "admin", "someAdmin")
+			require.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestCheckSession(t *testing.T) {
+	ctx := context.Background()
+
+	cases := []struct {
+		name    string
+		repo    *repoMock
+		wantErr error
+	}{
+		{
+			name: "Positive - active user with role",
+			repo: &repoMock{GetUserSessionStateFn: func(ctx context.Context, userID int, role string, jti string, issuedAt time.Time) (*model.SessionState, error) {
+				return &model.SessionState{Active: true, HasRole: true}, nil
+			}},
+			wantErr: nil,
+		},
+		{
+			name: "Negative - user disabled",
+			repo: &repoMock{GetUserSessionStateFn: func(ctx context.Context, userID int, role string, jti string, issuedAt time.Time) (*model.SessionState, error) {
+				return &model.SessionState{Active: false, HasRole: true}, nil
+			}},
+			wantErr: model.ErrUserDisabled,
+		},
+		{
+			name: "Negative - role revoked",
+			repo: &repoMock{GetUserSessionStateFn: func(ctx context.Context, userID int, role string, jti string, issuedAt time.Time) (*model.SessionState, error) {
+				return &model.SessionState{Active: true, HasRole: false}, nil
+			}},
+			wantErr: model.ErrRoleNotGranted,
+		},
+		{
+			name: "Negative - token revoked",
+			repo: &repoMock{GetUserSessionStateFn: func(ctx context.Context, userID int, role string, jti string, issuedAt time.Time) (*model.SessionState, error) {
+				if jti != "some-jti" {
+					return nil, errors.New("jti not passed to repo")
+				}
+				return &model.SessionState{Active: true, HasRole: true, Revoked: true}, nil
+			}},
+			wantErr: model.ErrSessionRevoked,
+		},
+		{
+			name: "Negative - user deleted",
+			repo: &repoMock{GetUserSessionStateFn: func(ctx context.Context, userID int, role string, jti string, issuedAt time.Time) (*model.SessionState, error) {
+				return nil, model.ErrUserNotFound
+			}},
+			wantErr: model.ErrUserNotFound,
+		},
+		{
+			name: "Negative - DB error",
+			repo: &repoMock{GetUserSessionStateFn: func(ctx context.Context, userID int, role string, jti string, issuedAt time.Time) (*model.SessionState, error) {
+				return nil, errors.New("some DB error")
+			}},
+			wantErr: model.ErrCommon500,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := WHCService{repo: tt.repo, policy: policyMock{correctRole: true}}
+
+			claims := &mwauthlog.Claims{UserID: 5, Role: "manager"}
+			claims.ID = "some-jti"
+			err := svc.CheckSession(ctx, claims)
+			require.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRefreshSession(t *testing.T) {
+	ctx := context.Background()
+
+	cases := []struct {
+		name    string
+		token   string
+		repo    *repoMock
+		jwt     *jwtMock
+		wantErr error
+	}{
+		{
+			name:  "Positive - token rotated",
+			token: "old-refresh",
+			repo: &repoMock{RotateRefreshTokenFn: func(ctx context.Context, oldHash, newHash string, expiresAt time.Time) (*model.User, error) {
+				if oldHash != hashToken("old-refresh") || newHash == oldHash {
+					return nil, errors.New("unexpected hashes")
+				}
+				return &model.User{ID: 1, UserName: "someName", Role: model.RoleManager}, nil
+			}},
+			jwt:     &jwtMock{token: "jwt-token"},
+			wantErr: nil,
+		},
+		{
+			name:    "Negative - empty token",
+			token:   "",
+			wantErr: model.ErrInvalidRefreshToken,
+		},
+		{
+			name:  "Negative - token already used or revoked",
+			token: "old-refresh",
+			repo: &repoMock{RotateRefreshTokenFn: func(ctx context.Context, oldHash, newHash string, expiresAt time.Time) (*model.User, error) {
+				return nil, model.ErrInvalidRefreshToken
+			}},
+			wantErr: model.ErrInvalidRefreshToken,
+		},
+		{
+			name:  "Negative - DB error",
+			token: "old-refresh",
+			repo: &repoMock{RotateRefreshTokenFn: func(ctx context.Context, oldHash, newHash string, expiresAt time.Time) (*model.User, error) {
+				return nil, errors.New("some DB error")
+			}},
+			wantErr: model.ErrCommon500,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := WHCService{
+				repo:       tt.repo,
+				policy:     policyMock{correctRole: true},
+				jwtManager: tt.jwt,
+				cfg:        Config{AccessTokenTTL: time.Minute, RefreshTokenTTL: time.Hour},
+			}
+
+			tokens, user, err := svc.RefreshSession(ctx, tt.token)
+
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, "jwt-token", tokens.AccessToken)
+				require.NotEqual(t, tt.token, tokens.RefreshToken)
+				require.Equal(t, model.RoleManager, user.Role)
+			}
+		})
+	}
+}
+
+func TestLogout(t *testing.T) {
+	ctx := context.Background()
+	claims := &mwauthlog.Claims{UserID: 1, Username: "john", Role: model.RoleManager}
+	claims.ID = "some-jti"
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Minute))
+
+	cases := []struct {
+		name        string
+		access      string
+		refresh     string
+		jwt         *jwtMock
+		wantRevoked []string
+		wantUser    string // пользователь в событии выхода
+		wantErr     error
+	}{
+		{
+			name:        "Positive - both tokens revoked",
+			access:      "jwt-token",
+			refresh:     "refresh",
+			jwt:         &jwtMock{claims: claims},
+			wantRevoked: []string{"some-jti", hashToken("refresh")},
+			wantUser:    "john",
+		},
+		{
+			name:        "Positive - expired access token is skipped",
+			access:      "jwt-token",
+			refresh:     "refresh",
+			jwt:         &jwtMock{err: model.ErrInvalidToken},
+			wantRevoked: []string{hashToken("refresh")},
+		},
+		{
+			name:        "Positive - nothing to revoke",
+			wantRevoked: []string{},
 		},
 	}
 
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
-			svc := WHCService{
-				repo:       tt.repo,
-				jwtManager: tt.jwt,
-				policy:     tt.policy,
+			revoked := make([]string, 0)
+			repo := &repoMock{
+				RevokeAccessTokenFn: func(ctx context.Context, jti string, expiresAt time.Time) error {
+					revoked = append(revoked, jti)
+					return nil
+				},
+				RevokeRefreshTokenFn: func(ctx context.Context, tokenHash string) error {
+					revoked = append(revoked, tokenHash)
+					return nil
+				},
+			}
+			var events []*model.AuthEvent
+			repo.CreateAuthEventFn = func(ctx context.Context, event *model.AuthEvent) error {
+				events = append(events, event)
+				return nil
 			}
+			svc := WHCService{repo: repo, jwtManager: tt.jwt}
 
-			token, _, err := svc.LoginUser(ctx, tt.userName, tt.password, tt.role)
+			err := svc.Logout(ctx, tt.access, tt.refresh)
 
-			if tt.wantErr != nil {
-				require.ErrorIs(t, err, tt.wantErr)
-			} else {
-				require.Equal(t, "jwt-token", token)
-			}
+			require.ErrorIs(t, err, tt.wantErr)
+			require.Equal(t, tt.wantRevoked, revoked)
+			require.Len(t, events, 1)
+			require.Equal(t, model.AuthEventLogout, events[0].Type)
+			require.Equal(t, tt.wantUser, events[0].Username)
 		})
 	}
 }
 
 func TestGetItemsList(t *testing.T) {
 	ctx := context.Background()
+	asOf := time.Date(2026, 12, 31, 23, 59, 59, 0, time.UTC)
 	cases := []struct {
 		name    string
 		repo    *repoMock
 		rpi     *model.RequestParam
+		filter  model.ItemFilter
 		policy  *policyMock
 		resLen  int
 		wantErr error
 	}{
 		{
 			name: "Positive - 2 items are fetched in array",
-			repo: &repoMock{GetItemsListFn: func(ctx context.Context, rp *model.RequestParam, seeDeleted bool) ([]*model.Item, error) {
+			repo: &repoMock{GetItemsListFn: func(ctx context.Context, rp *model.RequestParam, filter *model.ItemFilter, seeDeleted bool) ([]*model.Item, error) {
 				return []*model.Item{{}, {}}, nil
 			}},
 			rpi:     &model.RequestParam{},
@@ -510,7 +3018,7 @@ func TestGetItemsList(t *testing.T) {
 		},
 		{
 			name: "Negative - DB error",
-			repo: &repoMock{GetItemsListFn: func(ctx context.Context, rp *model.RequestParam, seeDeleted bool) ([]*model.Item, error) {
+			repo: &repoMock{GetItemsListFn: func(ctx context.Context, rp *model.RequestParam, filter *model.ItemFilter, seeDeleted bool) ([]*model.Item, error) {
 				return nil, errors.New("some DB error")
 			}},
 			rpi:     &model.RequestParam{},
@@ -531,6 +3039,50 @@ func TestGetItemsList(t *testing.T) {
 			policy:  &policyMock{canGetItems: true, canSeeDeleted: true},
 			wantErr: model.ErrInvalidRequestParam,
 		},
+		{
+			name: "Positive - filtered by warehouse",
+			repo: &repoMock{GetItemsListFn: func(ctx context.Context, rp *model.RequestParam, filter *model.ItemFilter, seeDeleted bool) ([]*model.Item, error) {
+				if filter.WarehouseID == nil || *filter.WarehouseID != 2 {
+					return nil, errors.New("warehouse filter is lost")
+				}
+				return []*model.Item{{}}, nil
+			}},
+			rpi:     &model.RequestParam{},
+			filter:  model.ItemFilter{WarehouseID: ptrMaker(2)},
+			resLen:  1,
+			policy:  &policyMock{canGetItems: true},
+			wantErr: nil,
+		},
+		{
+			name:    "Negative - incorrect warehouse filter",
+			repo:    nil,
+			rpi:     &model.RequestParam{},
+			filter:  model.ItemFilter{WarehouseID: ptrMaker(0)},
+			policy:  &policyMock{canGetItems: true},
+			wantErr: model.ErrIncorrectWarehouseID,
+		},
+		{
+			name: "Positive - state as of moment",
+			repo: &repoMock{GetItemsListFn: func(ctx context.Context, rp *model.RequestParam, filter *model.ItemFilter, seeDeleted bool) ([]*model.Item, error) {
+				if filter.AsOf == nil {
+					return nil, errors.New("as_of filter is lost")
+				}
+				return []*model.Item{{}}, nil
+			}},
+			rpi:     &model.RequestParam{},
+			filter:  model.ItemFilter{AsOf: &asOf},
+			resLen:  1,
+			policy:  &policyMock{canGetItems: true},
+			wantErr: nil,
+		},
+		{
+			name:    "Negative - as of moment with warehouse filter",
+			repo:    nil,
+			rpi:     &model.RequestParam{},
+			filter:  model.ItemFilter{WarehouseID: ptrMaker(2), AsOf: &asOf},
+			policy:  &policyMock{canGetItems: true},
+			wantErr: model.ErrAsOfWithWarehouse,
+		},
 	}
 
 	for _, tt := range cases {
@@ -540,7 +3092,7 @@ func TestGetItemsList(t *testing.T) {
 				policy: tt.policy,
 			}
 
-			res, err := svc.GetItemsList(ctx, tt.rpi, "role")
+			res, err := svc.GetItemsList(ctx, tt.rpi, &tt.filter, "role")
 
 			if tt.wantErr != nil {
 				require.ErrorIs(t, err, tt.wantErr)
@@ -558,13 +3110,14 @@ func TestGetItemHistoryByID(t *testing.T) {
 		repo    *repoMock
 		policy  *policyMock
 		rph     *model.RequestParam
+		filter  model.HistoryFilter
 		itemID  int
 		role    string
 		wantErr error
 	}{
 		{
 			name: "Positive - history fetched",
-			repo: &repoMock{GetItemHistoryByIDFn: func(ctx context.Context, rp *model.RequestParam, id int) ([]*model.ItemHistory, error) {
+			repo: &repoMock{GetItemHistoryByIDFn: func(ctx context.Context, rp *model.RequestParam, filter *model.HistoryFilter, id int) ([]*model.ItemHistory, error) {
 				return []*model.ItemHistory{{}, {}}, nil
 			}},
 			policy:  &policyMock{canGetHistory: true},
@@ -573,6 +3126,31 @@ func TestGetItemHistoryByID(t *testing.T) {
 			role:    "some role",
 			wantErr: nil,
 		},
+		{
+			name: "Positive - history filters normalized and passed to repo",
+			repo: &repoMock{GetItemHistoryByIDFn: func(ctx context.Context, rp *model.RequestParam, filter *model.HistoryFilter, id int) ([]*model.ItemHistory, error) {
+				if !slices.Equal(filter.Action, []string{"REVERT"}) || !slices.Equal(filter.ChangedBy, []string{"alice"}) {
+					return nil, errors.New("filters are not passed")
+				}
+				return []*model.ItemHistory{{}}, nil
+			}},
+			policy:  &policyMock{canGetHistory: true},
+			rph:     &model.RequestParam{},
+			filter:  model.HistoryFilter{Action: []string{" revert "}, ChangedBy: []string{"alice", " "}},
+			itemID:  300,
+			role:    "some role",
+			wantErr: nil,
+		},
+		{
+			name:    "Negative - unknown action in filter",
+			repo:    nil,
+			policy:  &policyMock{canGetHistory: true},
+			rph:     &model.RequestParam{},
+			filter:  model.HistoryFilter{Action: []string{"RENAME"}},
+			itemID:  300,
+			role:    "some role",
+			wantErr: model.ErrInvalidHistoryAction,
+		},
 		{
 			name:    "Negative - no access to get history",
 			repo:    nil,
@@ -584,7 +3162,7 @@ func TestGetItemHistoryByID(t *testing.T) {
 		},
 		{
 			name: "Negative - DB error",
-			repo: &repoMock{GetItemHistoryByIDFn: func(ctx context.Context, rp *model.RequestParam, id int) ([]*model.ItemHistory, error) {
+			repo: &repoMock{GetItemHistoryByIDFn: func(ctx context.Context, rp *model.RequestParam, filter *model.HistoryFilter, id int) ([]*model.ItemHistory, error) {
 				return nil, errors.New("some DB error")
 			}},
 			policy:  &policyMock{canGetHistory: true},
@@ -611,11 +3189,12 @@ func TestGetItemHistoryByID(t *testing.T) {
 				policy: tt.policy,
 			}
 
-			res, err := svc.GetItemHistoryByID(ctx, tt.rph, tt.itemID, tt.role)
+			res, err := svc.GetItemHistoryByID(ctx, tt.rph, &tt.filter, tt.itemID, tt.role)
 
 			if tt.wantErr != nil {
 				require.ErrorIs(t, err, tt.wantErr)
 			} else {
+				require.NoError(t, err)
 				require.NotEqual(t, nil, res)
 			}
 		})
@@ -634,7 +3213,7 @@ func TestGetItemHistoryAll(t *testing.T) {
 	}{
 		{
 			name: "Positive - history fetched",
-			repo: &repoMock{GetItemHistoryAllFn: func(ctx context.Context, rp *model.RequestParam) ([]*model.ItemHistory, error) {
+			repo: &repoMock{GetItemHistoryAllFn: func(ctx context.Context, rp *model.RequestParam, filter *model.HistoryFilter) ([]*model.ItemHistory, error) {
 				return []*model.ItemHistory{{}, {}}, nil
 			}},
 			policy:  &policyMock{canGetHistory: true},
@@ -652,7 +3231,7 @@ func TestGetItemHistoryAll(t *testing.T) {
 		},
 		{
 			name: "Negative - DB error",
-			repo: &repoMock{GetItemHistoryAllFn: func(ctx context.Context, rp *model.RequestParam) ([]*model.ItemHistory, error) {
+			repo: &repoMock{GetItemHistoryAllFn: func(ctx context.Context, rp *model.RequestParam, filter *model.HistoryFilter) ([]*model.ItemHistory, error) {
 				return nil, errors.New("some DB error")
 			}},
 			policy:  &policyMock{canGetHistory: true},
@@ -669,7 +3248,7 @@ func TestGetItemHistoryAll(t *testing.T) {
 				policy: tt.policy,
 			}
 
-			res, err := svc.GetItemHistoryAll(ctx, tt.rph, tt.role)
+			res, err := svc.GetItemHistoryAll(ctx, tt.rph, &model.HistoryFilter{}, tt.role)
 
 			if tt.wantErr != nil {
 				require.ErrorIs(t, err, tt.wantErr)
@@ -682,17 +3261,297 @@ func TestGetItemHistoryAll(t *testing.T) {
 
 // =============== TOOLS TESTS ================
 
+func TestGetItemHistoryDiff(t *testing.T) {
+	ctx := context.Background()
+	v3 := json.RawMessage(`{"id": 1, "title": "Bolt", "price": 100, "updated_at": "2026-01-01T10:00:00", "deleted_at": null}`)
+	v7 := json.RawMessage(`{"id": 1, "title": "Bolt M8", "price": 100, "updated_at": "2026-01-02T10:00:00", "deleted_at": null}`)
+	versions := []*model.ItemHistory{{ItemID: 1, Version: 3, NewData: &v3}, {ItemID: 1, Version: 7, NewData: &v7}}
+	move := json.RawMessage(`{"from_location_id": 1, "to_location_id": 10, "amount": 5}`)
+	noIgnore := ""
+
+	cases := []struct {
+		name        string
+		req         *model.ItemDiffRequest
+		repo        *repoMock
+		policy      policyMock
+		wantChanges []model.FieldChange
+		wantErr     error
+	}{
+		{
+			name: "Positive - noise fields hidden by default",
+			req:  &model.ItemDiffRequest{From: 3, To: 7},
+			repo: &repoMock{GetItemHistoryVersionsFn: func(ctx context.Context, itemID, from, to int) ([]*model.ItemHistory, error) {
+				return versions, nil
+			}},
+			policy: policyMock{canGetHistory: true},
+			wantChanges: []model.FieldChange{
+				{Field: "title", Old: json.RawMessage(`"Bolt"`), New: json.RawMessage(`"Bolt M8"`)},
+			},
+		},
+		{
+			name: "Positive - empty ignore list shows all fields",
+			req:  &model.ItemDiffRequest{From: 3, To: 7, Ignore: &noIgnore},
+			repo: &repoMock{GetItemHistoryVersionsFn: func(ctx context.Context, itemID, from, to int) ([]*model.ItemHistory, error) {
+				return versions, nil
+			}},
+			policy: policyMock{canGetHistory: true},
+			wantChanges: []model.FieldChange{
+				{Field: "title", Old: json.RawMessage(`"Bolt"`), New: json.RawMessage(`"Bolt M8"`)},
+				{Field: "updated_at", Old: json.RawMessage(`"2026-01-01T10:00:00"`), New: json.RawMessage(`"2026-01-02T10:00:00"`)},
+			},
+		},
+		{
+			name: "Negative - version not found",
+			req:  &model.ItemDiffRequest{From: 3, To: 70},
+			repo: &repoMock{GetItemHistoryVersionsFn: func(ctx context.Context, itemID, from, to int) ([]*model.ItemHistory, error) {
+				return versions[:1], nil
+			}},
+			policy:  policyMock{canGetHistory: true},
+			wantErr: model.ErrVersionNotFound,
+		},
+		{
+			name: "Negative - version is a stock movement",
+			req:  &model.ItemDiffRequest{From: 3, To: 8},
+			repo: &repoMock{GetItemHistoryVersionsFn: func(ctx context.Context, itemID, from, to int) ([]*model.ItemHistory, error) {
+				return []*model.ItemHistory{versions[0], {ItemID: 1, Version: 8, Action: model.HistoryActionMove, NewData: &move}}, nil
+			}},
+			policy:  policyMock{canGetHistory: true},
+			wantErr: model.ErrVersionNotSnapshot,
+		},
+		{
+			name:    "Negative - reversed range",
+			req:     &model.ItemDiffRequest{From: 7, To: 3},
+			policy:  policyMock{canGetHistory: true},
+			wantErr: model.ErrInvalidVersionRange,
+		},
+		{
+			name:    "Negative - no access to history",
+			req:     &model.ItemDiffRequest{From: 3, To: 7},
+			policy:  policyMock{canGetItems: true},
+			wantErr: model.ErrAccessDenied,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := WHCService{
+				repo:   tt.repo,
+				policy: tt.policy,
+			}
+
+			res, err := svc.GetItemHistoryDiff(ctx, tt.req, 1, "some role")
+			require.ErrorIs(t, err, tt.wantErr)
+			if tt.wantErr != nil {
+				return
+			}
+			require.Equal(t, tt.wantChanges, res.Changes)
+		})
+	}
+}
+
+func TestFillChanges(t *testing.T) {
+	oldData := json.RawMessage(`{"title": "Bolt", "price": 100}`)
+	newData := json.RawMessage(`{"title": "Bolt", "price": 120}`)
+	putaway := json.RawMessage(`{"from_location_id": 1, "to_location_id": 10, "amount": 5}`)
+	history := []*model.ItemHistory{
+		{ID: 1, Action: model.HistoryActionUpdate, OldData: &oldData, NewData: &newData},
+		{ID: 2, Action: model.HistoryActionPutaway, NewData: &putaway},
+	}
+
+	fillChanges("rid", history, nil)
+
+	require.Equal(t, []model.FieldChange{{Field: "price", Old: json.RawMessage(`100`), New: json.RawMessage(`120`)}}, history[0].Changes)
+	require.Equal(t, []model.FieldChange{}, history[1].Changes)
+}
+
+func TestIsUniqueViolation(t *testing.T) {
+	duplicate := &pq.Error{Code: "23505", Message: `duplicate key value violates unique constraint "warehouses_code_key"`}
+
+	require.True(t, isUniqueViolation(duplicate))
+	require.True(t, isUniqueViolation(fmt.Errorf("insert warehouse: %w", duplicate)))
+	require.False(t, isUniqueViolation(&pq.Error{Code: "23503", Message: "violates foreign key constraint"}))
+	require.False(t, isUniqueViolation(errors.New("unique violation")))
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	oldData := json.RawMessage(`{"title": "Bolt", "price": 100, "available_amount": 5, "deleted_at": null}`)
+	newData := json.RawMessage(`{"title":"Bolt","price":100,"available_amount":2,"deleted_at":"2026-01-02T10:00:00"}`)
+
+	t.Run("Update - only changed fields, formatting ignored", func(t *testing.T) {
+		res, err := diffSnapshots(&oldData, &newData, nil)
+		require.NoError(t, err)
+		require.Equal(t, []model.FieldChange{
+			{Field: "available_amount", Old: json.RawMessage(`5`), New: json.RawMessage(`2`)},
+			{Field: "deleted_at", Old: json.RawMessage(`null`), New: json.RawMessage(`"2026-01-02T10:00:00"`)},
+		}, res)
+	})
+
+	t.Run("Insert - every field comes from null", func(t *testing.T) {
+		res, err := diffSnapshots(nil, &newData, []string{"deleted_at"})
+		require.NoError(t, err)
+		require.Len(t, res, 3)
+		for _, c := range res {
+			require.Equal(t, json.RawMessage(`null`), c.Old)
+		}
+	})
+
+	t.Run("Broken snapshot", func(t *testing.T) {
+		broken := json.RawMessage(`[1, 2]`)
+		_, err := diffSnapshots(&broken, &newData, nil)
+		require.Error(t, err)
+	})
+}
+
+func TestRevertItemByID(t *testing.T) {
+	ctx := context.Background()
+	v3 := json.RawMessage(`{"id": 1, "title": "Bolt", "description": null, "price": 100, "visible": true, "available_amount": 5, "deleted_at": null}`)
+	current := json.RawMessage(`{"id": 1, "title": "Bolt M8", "description": "steel", "price": 120, "visible": false, "available_amount": 2, "deleted_at": null}`)
+	oldSchema := json.RawMessage(`{"id": 1, "title": "Bolt", "price": 100, "visible": true, "available_amount": 5}`)
+	putaway := json.RawMessage(`{"from_location_id": 1, "to_location_id": 10, "amount": 5}`)
+	versionsOf := func(data *json.RawMessage) func(ctx context.Context, itemID, from, to int) ([]*model.ItemHistory, error) {
+		return func(ctx context.Context, itemID, from, to int) ([]*model.ItemHistory, error) {
+			return []*model.ItemHistory{{ItemID: itemID, Version: from, NewData: data}}, nil
+		}
+	}
+	currentItem := func(ctx context.Context, itemID int) (*json.RawMessage, error) { return &current, nil }
+
+	cases := []struct {
+		name    string
+		rv      *model.ItemRevert
+		repo    *repoMock
+		policy  policyMock
+		wantErr error
+	}{
+		{
+			name: "Positive - snapshot applied as REVERT update",
+			rv:   &model.ItemRevert{Version: 3},
+			repo: &repoMock{
+				GetItemHistoryVersionsFn: versionsOf(&v3),
+				GetItemSnapshotFn:        currentItem,
+				UpdateItemFn: func(ctx context.Context, item *model.ItemUpdate, seeDeleted bool) error {
+					if *item.Title != "Bolt" || *item.Description != "" || *item.Price != 100 || !*item.Visible ||
+						item.AvailableAmount != nil || *item.RevertOf != 3 || item.UpdatedBy != "someName" || seeDeleted {
+						return errors.New("unexpected update")
+					}
+					return nil
+				},
+			},
+			policy: policyMock{canRevert: true},
+		},
+		{
+			name: "Negative - snapshot schema differs from item",
+			rv:   &model.ItemRevert{Version: 3},
+			repo: &repoMock{
+				GetItemHistoryVersionsFn: versionsOf(&oldSchema),
+				GetItemSnapshotFn:        currentItem,
+			},
+			policy:  policyMock{canRevert: true},
+			wantErr: model.ErrSnapshotSchemaMismatch,
+		},
+		{
+			name: "Negative - version without snapshot",
+			rv:   &model.ItemRevert{Version: 3},
+			repo: &repoMock{
+				GetItemHistoryVersionsFn: versionsOf(nil),
+				GetItemSnapshotFn:        currentItem,
+			},
+			policy:  policyMock{canRevert: true},
+			wantErr: model.ErrSnapshotSchemaMismatch,
+		},
+		{
+			name: "Negative - version is a stock movement",
+			rv:   &model.ItemRevert{Version: 4},
+			repo: &repoMock{GetItemHistoryVersionsFn: func(ctx context.Context, itemID, from, to int) ([]*model.ItemHistory, error) {
+				return []*model.ItemHistory{{ItemID: itemID, Version: from, Action: model.HistoryActionPutaway, NewData: &putaway}}, nil
+			}},
+			policy:  policyMock{canRevert: true},
+			wantErr: model.ErrVersionNotSnapshot,
+		},
+		{
+			name: "Negative - version not found",
+			rv:   &model.ItemRevert{Version: 30},
+			repo: &repoMock{GetItemHistoryVersionsFn: func(ctx context.Context, itemID, from, to int) ([]*model.ItemHistory, error) {
+				return []*model.ItemHistory{}, nil
+			}},
+			policy:  policyMock{canRevert: true},
+			wantErr: model.ErrVersionNotFound,
+		},
+		{
+			name: "Negative - item deleted or not found",
+			rv:   &model.ItemRevert{Version: 3},
+			repo: &repoMock{
+				GetItemHistoryVersionsFn: versionsOf(&v3),
+				GetItemSnapshotFn:        func(ctx context.Context, itemID int) (*json.RawMessage, error) { return nil, model.ErrItemNotFound },
+			},
+			policy:  policyMock{canRevert: true},
+			wantErr: model.ErrItemNotFound,
+		},
+		{
+			name: "Negative - item changed since If-Match version",
+			rv:   &model.ItemRevert{Version: 3},
+			repo: &repoMock{
+				GetItemHistoryVersionsFn: versionsOf(&v3),
+				GetItemSnapshotFn:        currentItem,
+				UpdateItemFn: func(ctx context.Context, item *model.ItemUpdate, seeDeleted bool) error {
+					return model.ErrVersionMismatch
+				},
+			},
+			policy:  policyMock{canRevert: true},
+			wantErr: model.ErrVersionMismatch,
+		},
+		{
+			name: "Negative - DB error on update",
+			rv:   &model.ItemRevert{Version: 3},
+			repo: &repoMock{
+				GetItemHistoryVersionsFn: versionsOf(&v3),
+				GetItemSnapshotFn:        currentItem,
+				UpdateItemFn: func(ctx context.Context, item *model.ItemUpdate, seeDeleted bool) error {
+					return errors.New("test DB error")
+				},
+			},
+			policy:  policyMock{canRevert: true},
+			wantErr: model.ErrCommon500,
+		},
+		{
+			name:    "Negative - incorrect version",
+			rv:      &model.ItemRevert{Version: 0},
+			policy:  policyMock{canRevert: true},
+			wantErr: model.ErrInvalidRevertVersion,
+		},
+		{
+			name:    "Negative - updating is not enough to revert",
+			rv:      &model.ItemRevert{Version: 3},
+			policy:  policyMock{canUpdate: true, canGetHistory: true},
+			wantErr: model.ErrAccessDenied,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := WHCService{
+				repo:   tt.repo,
+				policy: tt.policy,
+			}
+
+			err := svc.RevertItemByID(ctx, 1, tt.rv, nil, 0, "some role", "someName")
+			require.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
 func TestValidateReqParams(t *testing.T) {
 	testStart := time.Now()
 	testEnd := testStart.Add(5 * time.Minute)
 
 	cases := []struct {
 		name    string
+		orderBy map[string]string
 		rp      *model.RequestParam
 		wantErr error
 	}{
 		{
-			name: "Positive - full valid HISTORY reqParam",
+			name:    "Positive - full valid HISTORY reqParam",
+			orderBy: model.OrderByHistoryMap,
 			rp: &model.RequestParam{
 				OrderBy:   ptrMaker(model.HistoryOrderByID),
 				ASC:       true,
@@ -705,7 +3564,8 @@ func TestValidateReqParams(t *testing.T) {
 			wantErr: nil,
 		},
 		{
-			name: "Positive - full valid ITEM reqParam",
+			name:    "Positive - full valid ITEM reqParam",
+			orderBy: model.OrderByItemsMap,
 			rp: &model.RequestParam{
 				OrderBy:   ptrMaker(model.ItemsOrderByPrice),
 				ASC:       true,
@@ -719,11 +3579,13 @@ func TestValidateReqParams(t *testing.T) {
 		},
 		{
 			name:    "Negative - nil reqParam",
+			orderBy: model.OrderByItemsMap,
 			rp:      nil,
 			wantErr: model.ErrInvalidRequestParam,
 		},
 		{
-			name: "Negative - ASC=DESC",
+			name:    "Negative - ASC=DESC",
+			orderBy: model.OrderByItemsMap,
 			rp: &model.RequestParam{
 				OrderBy:   ptrMaker(model.ItemsOrderByPrice),
 				ASC:       true,
@@ -736,7 +3598,8 @@ func TestValidateReqParams(t *testing.T) {
 			wantErr: model.ErrInvalidAscDesc,
 		},
 		{
-			name: "Negative - incorrect OrderBy",
+			name:    "Negative - incorrect OrderBy",
+			orderBy: model.OrderByItemsMap,
 			rp: &model.RequestParam{
 				OrderBy:   ptrMaker("some order"),
 				ASC:       false,
@@ -749,7 +3612,8 @@ func TestValidateReqParams(t *testing.T) {
 			wantErr: model.ErrInvalidOrderBy,
 		},
 		{
-			name: "Positive - nil OrderBy",
+			name:    "Positive - nil OrderBy",
+			orderBy: model.OrderByItemsMap,
 			rp: &model.RequestParam{
 				OrderBy:   nil,
 				ASC:       true,
@@ -762,7 +3626,8 @@ func TestValidateReqParams(t *testing.T) {
 			wantErr: nil,
 		},
 		{
-			name: "Negative - START after END",
+			name:    "Negative - START after END",
+			orderBy: model.OrderByItemsMap,
 			rp: &model.RequestParam{
 				OrderBy:   ptrMaker(model.ItemsOrderByID),
 				ASC:       false,
@@ -775,7 +3640,8 @@ func TestValidateReqParams(t *testing.T) {
 			wantErr: model.ErrInvalidStartEndTime,
 		},
 		{
-			name: "Positive - START ok, END nil",
+			name:    "Positive - START ok, END nil",
+			orderBy: model.OrderByItemsMap,
 			rp: &model.RequestParam{
 				OrderBy:   ptrMaker(model.ItemsOrderByID),
 				ASC:       false,
@@ -788,7 +3654,8 @@ func TestValidateReqParams(t *testing.T) {
 			wantErr: nil,
 		},
 		{
-			name: "Positive - START nil, END ok",
+			name:    "Positive - START nil, END ok",
+			orderBy: model.OrderByItemsMap,
 			rp: &model.RequestParam{
 				OrderBy:   ptrMaker(model.ItemsOrderByID),
 				ASC:       false,
@@ -801,7 +3668,8 @@ func TestValidateReqParams(t *testing.T) {
 			wantErr: nil,
 		},
 		{
-			name: "Positive - PAGE nil, LIMIT nil",
+			name:    "Positive - PAGE nil, LIMIT nil",
+			orderBy: model.OrderByItemsMap,
 			rp: &model.RequestParam{
 				OrderBy:   ptrMaker(model.ItemsOrderByID),
 				ASC:       false,
@@ -814,7 +3682,8 @@ func TestValidateReqParams(t *testing.T) {
 			wantErr: nil,
 		},
 		{
-			name: "Negative - PAGE ok, LIMIT nil",
+			name:    "Negative - PAGE ok, LIMIT nil",
+			orderBy: model.OrderByItemsMap,
 			rp: &model.RequestParam{
 				OrderBy:   ptrMaker(model.ItemsOrderByID),
 				ASC:       false,
@@ -827,7 +3696,8 @@ func TestValidateReqParams(t *testing.T) {
 			wantErr: model.ErrInvalidLimit,
 		},
 		{
-			name: "Positive - PAGE nil",
+			name:    "Positive - PAGE nil",
+			orderBy: model.OrderByItemsMap,
 			rp: &model.RequestParam{
 				OrderBy:   ptrMaker(model.ItemsOrderByID),
 				ASC:       false,
@@ -840,7 +3710,8 @@ func TestValidateReqParams(t *testing.T) {
 			wantErr: nil,
 		},
 		{
-			name: "Negative - LIMIT too big",
+			name:    "Negative - LIMIT too big",
+			orderBy: model.OrderByItemsMap,
 			rp: &model.RequestParam{
 				OrderBy:   ptrMaker(model.ItemsOrderByID),
 				ASC:       false,
@@ -853,7 +3724,8 @@ func TestValidateReqParams(t *testing.T) {
 			wantErr: model.ErrInvalidLimit,
 		},
 		{
-			name: "Negative - negative LIMIT",
+			name:    "Negative - negative LIMIT",
+			orderBy: model.OrderByItemsMap,
 			rp: &model.RequestParam{
 				OrderBy:   ptrMaker(model.ItemsOrderByID),
 				ASC:       false,
@@ -866,7 +3738,8 @@ func TestValidateReqParams(t *testing.T) {
 			wantErr: model.ErrInvalidLimit,
 		},
 		{
-			name: "Negative - negative PAGE",
+			name:    "Negative - negative PAGE",
+			orderBy: model.OrderByItemsMap,
 			rp: &model.RequestParam{
 				OrderBy:   ptrMaker(model.ItemsOrderByID),
 				ASC:       false,
@@ -878,11 +3751,47 @@ func TestValidateReqParams(t *testing.T) {
 			},
 			wantErr: model.ErrInvalidPage,
 		},
+		{
+			name:    "Negative - users field on items endpoint",
+			orderBy: model.OrderByItemsMap,
+			rp: &model.RequestParam{
+				OrderBy: ptrMaker(model.UsersOrderByUserName),
+				DESC:    true,
+			},
+			wantErr: model.ErrInvalidOrderBy,
+		},
+		{
+			name:    "Negative - items field on users endpoint",
+			orderBy: model.OrderByUsersMap,
+			rp: &model.RequestParam{
+				OrderBy: ptrMaker(model.ItemsOrderByPrice),
+				DESC:    true,
+			},
+			wantErr: model.ErrInvalidOrderBy,
+		},
+		{
+			name:    "Negative - item_id on user history endpoint",
+			orderBy: model.OrderByUserHistoryMap,
+			rp: &model.RequestParam{
+				OrderBy: ptrMaker(model.HistoryOrderByItemID),
+				DESC:    true,
+			},
+			wantErr: model.ErrInvalidOrderBy,
+		},
+		{
+			name:    "Negative - any field on fixed-order endpoint",
+			orderBy: nil,
+			rp: &model.RequestParam{
+				OrderBy: ptrMaker(model.ItemsOrderByID),
+				DESC:    true,
+			},
+			wantErr: model.ErrInvalidOrderBy,
+		},
 	}
 
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
-			err := validateReqParams(tt.rp)
+			err := validateReqParams(tt.rp, tt.orderBy)
 
 			if tt.wantErr != nil {
 				require.ErrorIs(t, err, tt.wantErr)
@@ -891,6 +3800,56 @@ func TestValidateReqParams(t *testing.T) {
 	}
 }
 
+func TestValidateNormalizeHistoryFilter(t *testing.T) {
+	cases := []struct {
+		name       string
+		filter     model.HistoryFilter
+		wantFilter model.HistoryFilter
+		wantErr    error
+	}{
+		{
+			name: "Positive - values normalized, empty ones dropped",
+			filter: model.HistoryFilter{
+				ChangedBy: []string{" alice ", ""},
+				Action:    []string{"update", "soft delete"},
+				ItemID:    []int{1, 2},
+				Field:     []string{"price", " "},
+			},
+			wantFilter: model.HistoryFilter{
+				ChangedBy: []string{"alice"},
+				Action:    []string{model.HistoryActionUpdate, model.HistoryActionSoftDelete},
+				ItemID:    []int{1, 2},
+				Field:     []string{"price"},
+			},
+		},
+		{
+			name:    "Negative - unknown action",
+			filter:  model.HistoryFilter{Action: []string{"UPDATE", "DROP"}},
+			wantErr: model.ErrInvalidHistoryAction,
+		},
+		{
+			name:    "Negative - incorrect item ID",
+			filter:  model.HistoryFilter{ItemID: []int{3, 0}},
+			wantErr: model.ErrIncorrectItemID,
+		},
+		{
+			name:    "Negative - incorrect warehouse",
+			filter:  model.HistoryFilter{WarehouseID: ptrMaker(-1)},
+			wantErr: model.ErrIncorrectWarehouseID,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateNormalizeHistoryFilter(&tt.filter)
+			require.ErrorIs(t, err, tt.wantErr)
+			if tt.wantErr == nil {
+				require.Equal(t, tt.wantFilter, tt.filter)
+			}
+		})
+	}
+}
+
 func TestValidateItemUpdate(t *testing.T) {
 	cases := []struct {
 		name    string
@@ -1056,6 +4015,14 @@ func TestValidateNormalizeUser(t *testing.T) {
 			user:    nil,
 			wantErr: model.ErrEmptyUser,
 		},
+		{
+			name: "Negative - service account name",
+			user: &model.User{
+				UserName: " ApiKey:erp-sync",
+				PassHash: "somePass",
+			},
+			wantErr: model.ErrIncorrectUserName,
+		},
 	}
 
 	for _, tt := range cases {
@@ -1072,6 +4039,71 @@ func TestValidateNormalizeUser(t *testing.T) {
 }
 
 // ============== helpers ===============
+func storeRefreshTokenStub(ctx context.Context, userID int, role string, tokenHash string, expiresAt time.Time) error {
+	return nil
+}
+
 func ptrMaker[T int | string | int64 | bool](input T) *T {
 	return &input
 }
+
+func TestClaimIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	saved := &model.IdempotencyRecord{Owner: "scanner", Key: "k-1", RequestHash: "abc", StatusCode: 201, Body: []byte(`{"id":1}`)}
+
+	cases := []struct {
+		name      string
+		rec       *model.IdempotencyRecord
+		repoSaved *model.IdempotencyRecord
+		repoErr   error
+		wantSaved *model.IdempotencyRecord
+		wantErr   error
+	}{
+		{
+			name: "Positive - first request claims key",
+			rec:  &model.IdempotencyRecord{Owner: "scanner", Key: "k-2", RequestHash: "abc"},
+		},
+		{
+			name:      "Positive - replay gets saved response",
+			rec:       &model.IdempotencyRecord{Owner: "scanner", Key: "k-1", RequestHash: "abc"},
+			repoSaved: saved,
+			wantSaved: saved,
+		},
+		{
+			name:      "Negative - key reused with another payload",
+			rec:       &model.IdempotencyRecord{Owner: "scanner", Key: "k-1", RequestHash: "def"},
+			repoSaved: saved,
+			wantErr:   model.ErrIdempotencyKeyReused,
+		},
+		{
+			name:      "Negative - first request still in progress",
+			rec:       &model.IdempotencyRecord{Owner: "scanner", Key: "k-1", RequestHash: "abc"},
+			repoSaved: &model.IdempotencyRecord{Owner: "scanner", Key: "k-1", RequestHash: "abc"},
+			wantErr:   model.ErrIdempotencyInProgress,
+		},
+		{
+			name:    "Negative - DB error",
+			rec:     &model.IdempotencyRecord{Owner: "scanner", Key: "k-1", RequestHash: "abc"},
+			repoErr: errors.New("some DB error"),
+			wantErr: model.ErrCommon500,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := WHCService{
+				repo: &repoMock{
+					ClaimIdempotencyKeyFn: func(ctx context.Context, rec *model.IdempotencyRecord) (*model.IdempotencyRecord, error) {
+						return tt.repoSaved, tt.repoErr
+					},
+				},
+				cfg: Config{IdempotencyTTL: time.Hour},
+			}
+
+			res, err := svc.ClaimIdempotencyKey(ctx, tt.rec)
+			require.ErrorIs(t, err, tt.wantErr)
+			require.Equal(t, tt.wantSaved, res)
+			require.WithinDuration(t, time.Now().Add(time.Hour), tt.rec.ExpiresAt, time.Minute)
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+)
+
+// issueTokens выпускает новую пару access/refresh для пользователя в роли user.Role
+func (svc WHCService) issueTokens(ctx context.Context, user *model.User) (*model.AuthTokens, error) {
+	rid := model.RequestIDFromCtx(ctx)
+	now := time.Now().UTC()
+
+	access, err := svc.jwtManager.Generate(user.ID, user.UserName, user.Role)
+	if err != nil {
+		log.Printf("RID %q Failed to generate access token: %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+
+	refresh, err := newToken()
+	if err != nil {
+		log.Printf("RID %q Failed to generate refresh token: %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+
+	tokens := &model.AuthTokens{
+		AccessToken:      access,
+		AccessExpiresAt:  now.Add(svc.cfg.AccessTokenTTL),
+		RefreshToken:     refresh,
+		RefreshExpiresAt: now.Add(svc.cfg.RefreshTokenTTL),
+	}
+
+	if err := svc.repo.CreateRefreshToken(ctx, user.ID, user.Role, hashToken(refresh), tokens.RefreshExpiresAt); err != nil {
+		log.Printf("RID %q Failed to put refresh token to DB: %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+
+	return tokens, nil
+}
+
+// RefreshSession обменивает refresh-токен на новую пару токенов; предъявленный refresh-токен гасится
+func (svc WHCService) RefreshSession(ctx context.Context, refreshToken string) (*model.AuthTokens, *model.User, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if refreshToken == "" {
+		return nil, nil, model.ErrInvalidRefreshToken
+	}
+
+	newRefresh, err := newToken()
+	if err != nil {
+		log.Printf("RID %q Failed to generate refresh token in 'RefreshSession': %q", rid, err)
+		return nil, nil, model.ErrCommon500
+	}
+
+	now := time.Now().UTC()
+	refreshExpiresAt := now.Add(svc.cfg.RefreshTokenTTL)
+
+	user, err := svc.repo.RotateRefreshToken(ctx, hashToken(refreshToken), hashToken(newRefresh), refreshExpiresAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrInvalidRefreshToken):
+			return nil, nil, err
+		default:
+			log.Printf("RID %q Failed to rotate refresh token in DB in 'RefreshSession': %q", rid, err)
+			return nil, nil, model.ErrCommon500
+		}
+	}
+
+	access, err := svc.jwtManager.Generate(user.ID, user.UserName, user.Role)
+	if err != nil {
+		log.Printf("RID %q Failed to generate access token in 'RefreshSession': %q", rid, err)
+		return nil, nil, model.ErrCommon500
+	}
+	user.Permissions = svc.permissionsOf(user.Role)
+
+	return &model.AuthTokens{
+		AccessToken:      access,
+		AccessExpiresAt:  now.Add(svc.cfg.AccessTokenTTL),
+		RefreshToken:     newRefresh,
+		RefreshExpiresAt: refreshExpiresAt,
+	}, user, nil
+}
+
+// Logout отзывает текущий access-токен по jti и гасит refresh-токен. Пустые/невалидные токены
+// не считаются ошибкой - выйти можно и с уже истекшей сессией
+func (svc WHCService) Logout(ctx context.Context, accessToken, refreshToken string) error {
+	rid := model.RequestIDFromCtx(ctx)
+
+	event := &model.AuthEvent{Type: model.AuthEventLogout}
+	if accessToken != "" {
+		if claims, err := svc.jwtManager.Parse(accessToken); err == nil && claims.ID != "" && claims.ExpiresAt != nil {
+			if err := svc.repo.RevokeAccessToken(ctx, claims.ID, claims.ExpiresAt.Time); err != nil {
+				log.Printf("RID %q Failed to revoke access token in DB in 'Logout': %q", rid, err)
+				return model.ErrCommon500
+			}
+			event.UserID, event.Username, event.Role = claims.UserID, claims.Username, claims.Role
+		}
+	}
+
+	if refreshToken != "" {
+		if err := svc.repo.RevokeRefreshToken(ctx, hashToken(refreshToken)); err != nil {
+			log.Printf("RID %q Failed to revoke refresh token in DB in 'Logout': %q", rid, err)
+			return model.ErrCommon500
+		}
+	}
+
+	svc.recordAuthEvent(ctx, event)
+
+	return nil
+}
+
+// RevokeUserSessions - принудительный выход пользователя со всех устройств
+func (svc WHCService) RevokeUserSessions(ctx context.Context, userID int, role, username string) error {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if userID <= 0 {
+		return model.ErrIncorrectUserID
+	}
+
+	if !svc.policy.Can(role, model.PermUsersManage) {
+		return model.ErrAccessDenied
+	}
+
+	if err := svc.repo.RevokeUserSessions(ctx, userID, username); err != nil {
+		switch {
+		case errors.Is(err, model.ErrUserNotFound):
+			return err
+		default:
+			log.Printf("RID %q Failed to revoke user sessions in DB in 'RevokeUserSessions': %q", rid, err)
+			return model.ErrCommon500
+		}
+	}
+
+	return nil
+}
+
+// GetJWKS - публичные ключи проверки access-токенов для сторонних сервисов
+func (svc WHCService) GetJWKS(ctx context.Context) *model.JWKS {
+	return svc.jwtManager.JWKS()
+}
@@ -1,12 +1,28 @@
 package service
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"regexp"
 	"strings"
 
 	"github.com/UnendingLoop/WarehouseControl/internal/model"
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// pgUniqueViolation - SQLSTATE нарушения уникального ограничения
+const pgUniqueViolation = "23505"
+
+// isUniqueViolation проверяет код ошибки Postgres: текст ошибки драйвера ("pq: duplicate key value ...")
+// зависит от версии и локали сервера, а код - нет
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == pgUniqueViolation
+}
+
 func validateNormalizeNewUser(u *model.User) error {
 	if u == nil {
 		return model.ErrEmptyUser
@@ -15,6 +31,11 @@ func validateNormalizeNewUser(u *model.User) error {
 	u.UserName = strings.TrimSpace(u.UserName)
 	u.UserName = strings.ToLower(u.UserName)
 
+	// такие имена носят только сервисные аккаунты
+	if strings.HasPrefix(u.UserName, model.APIKeyActorPrefix) {
+		return model.ErrIncorrectUserName
+	}
+
 	// Генерация хэша из пароля
 	passHash, _ := bcrypt.GenerateFromPassword([]byte(u.PassHash), bcrypt.DefaultCost)
 	u.PassHash = string(passHash)
@@ -62,17 +83,96 @@ func validateItemUpdate(item *model.ItemUpdate) error {
 	return nil
 }
 
-func validateReqParams(rp *model.RequestParam) error {
+// reasonCodeRe - код причины движения: короткий машинный идентификатор вроде DAMAGED или CYCLE_COUNT
+var reasonCodeRe = regexp.MustCompile(`^[A-Z0-9_]{1,32}$`)
+
+func validateNormalizeMovement(m *model.Movement) error {
+	if m.ItemID <= 0 {
+		return model.ErrIncorrectItemID
+	}
+
+	m.Type = strings.ToLower(strings.TrimSpace(m.Type))
+	sign, ok := model.MovementTypesMap[m.Type]
+	if !ok {
+		return model.ErrInvalidMovementType
+	}
+	if m.Quantity == 0 || (sign > 0 && m.Quantity < 0) || (sign < 0 && m.Quantity > 0) {
+		return model.ErrInvalidMovementQty
+	}
+
+	m.ReasonCode = strings.ToUpper(strings.TrimSpace(m.ReasonCode))
+	if !reasonCodeRe.MatchString(m.ReasonCode) {
+		return model.ErrInvalidReasonCode
+	}
+
+	if m.Type == model.MovementTransfer {
+		if m.ToWarehouseID == nil && m.ToLocationID == nil {
+			return model.ErrInvalidTransfer
+		}
+	} else {
+		m.ToWarehouseID, m.ToLocationID = nil, nil
+	}
+	m.RelatedID = nil
+
+	return nil
+}
+
+func validateWarehouseFilter(warehouseID *int) error {
+	if warehouseID != nil && *warehouseID <= 0 {
+		return model.ErrIncorrectWarehouseID
+	}
+	return nil
+}
+
+// validateNormalizeHistoryFilter проверяет фильтры истории: действия приводятся к верхнему регистру,
+// пустые авторы и поля отбрасываются
+func validateNormalizeHistoryFilter(filter *model.HistoryFilter) error {
+	if err := validateWarehouseFilter(filter.WarehouseID); err != nil {
+		return err
+	}
+
+	for _, id := range filter.ItemID {
+		if id <= 0 {
+			return model.ErrIncorrectItemID
+		}
+	}
+
+	actions := make([]string, 0, len(filter.Action))
+	for _, a := range filter.Action {
+		a = strings.ToUpper(strings.TrimSpace(a))
+		if _, ok := model.HistoryActionsMap[a]; !ok {
+			return model.ErrInvalidHistoryAction
+		}
+		actions = append(actions, a)
+	}
+	filter.Action = actions
+
+	filter.ChangedBy = nonEmpty(filter.ChangedBy)
+	filter.Field = nonEmpty(filter.Field)
+	return nil
+}
+
+// nonEmpty возвращает значения без крайних пробелов, пропуская пустые
+func nonEmpty(values []string) []string {
+	res := make([]string, 0, len(values))
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// validateReqParams проверяет общие параметры выборки; orderBy - поля сортировки конкретного эндпоинта,
+// nil - эндпоинт отдает записи в своем порядке и order_by не принимает
+func validateReqParams(rp *model.RequestParam, orderBy map[string]string) error {
 	if rp == nil {
 		return model.ErrInvalidRequestParam
 	}
 
 	if rp.OrderBy != nil {
 		// валидация самого OrderBy
-		_, okItems := model.OrderByItemsMap[*rp.OrderBy]
-		_, okHistory := model.OrderByHistoryMap[*rp.OrderBy]
-
-		if !okHistory && !okItems {
+		if _, ok := orderBy[*rp.OrderBy]; !ok {
 			return model.ErrInvalidOrderBy
 		}
 
@@ -99,3 +199,18 @@ func validateReqParams(rp *model.RequestParam) error {
 
 	return nil
 }
+
+// newToken генерирует случайный токен для отдачи клиенту (инвайты и т.п.)
+func newToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// hashToken - в БД хранятся только хэши токенов, чтобы утечка таблицы не давала готовых токенов
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
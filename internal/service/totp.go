@@ -0,0 +1,390 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+	"github.com/UnendingLoop/WarehouseControl/internal/totp"
+)
+
+const (
+	DefaultTOTPIssuer = "WarehouseControl"
+
+	// LoginChallengeTTL - сколько ждем код второго фактора после ввода пароля
+	LoginChallengeTTL = 5 * time.Minute
+	// MaxChallengeAttempts - после стольких неверных кодов челлендж сгорает и нужно заново вводить пароль
+	MaxChallengeAttempts = 5
+
+	recoveryCodesCount = 10
+)
+
+// loginChallenge решает, нужен ли второй фактор для сессии в роли user.Role, и если нужен - выпускает челлендж.
+// Второй фактор спрашивается у всех, кто его включил, и у всех ролей из TOTPRequiredRoles
+func (svc WHCService) loginChallenge(ctx context.Context, user *model.User) (*model.LoginChallenge, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	enrolled := false
+	state, err := svc.repo.GetUserTOTP(ctx, user.ID)
+	switch {
+	case err == nil:
+		enrolled = state.ConfirmedAt != nil
+	case errors.Is(err, model.ErrTOTPNotEnrolled):
+	default:
+		log.Printf("RID %q Failed to get user totp from DB in 'LoginUser': %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+
+	if !enrolled && !slices.Contains(svc.cfg.TOTPRequiredRoles, user.Role) {
+		return nil, nil
+	}
+
+	token, err := newToken()
+	if err != nil {
+		log.Printf("RID %q Failed to generate login challenge in 'LoginUser': %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+
+	challenge := &model.LoginChallenge{
+		Token:              token,
+		UserID:             user.ID,
+		Username:           user.UserName,
+		Role:               user.Role,
+		ExpiresAt:          time.Now().UTC().Add(LoginChallengeTTL),
+		EnrollmentRequired: !enrolled,
+	}
+
+	if err := svc.repo.CreateLoginChallenge(ctx, user.ID, user.Role, hashToken(token), challenge.ExpiresAt); err != nil {
+		log.Printf("RID %q Failed to put login challenge to DB in 'LoginUser': %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+
+	return challenge, nil
+}
+
+// activeChallenge находит челлендж по открытому токену; исчерпавший попытки челлендж недействителен
+func (svc WHCService) activeChallenge(ctx context.Context, challengeToken, method string) (*model.LoginChallenge, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if challengeToken == "" {
+		return nil, model.ErrInvalidChallenge
+	}
+
+	challenge, err := svc.repo.GetLoginChallenge(ctx, hashToken(challengeToken))
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrInvalidChallenge):
+			return nil, err
+		default:
+			log.Printf("RID %q Failed to get login challenge from DB in %q: %q", rid, method, err)
+			return nil, model.ErrCommon500
+		}
+	}
+
+	if challenge.Attempts >= MaxChallengeAttempts {
+		return nil, model.ErrInvalidChallenge
+	}
+
+	return challenge, nil
+}
+
+// StartLoginTOTPEnrollment выдает секрет пользователю, которому второй фактор обязателен, но еще не настроен.
+// Настройка завершается первым кодом в CompleteLoginTOTP
+func (svc WHCService) StartLoginTOTPEnrollment(ctx context.Context, challengeToken string) (*model.TOTPEnrollment, error) {
+	challenge, err := svc.activeChallenge(ctx, challengeToken, "StartLoginTOTPEnrollment")
+	if err != nil {
+		return nil, err
+	}
+
+	return svc.newTOTPSecret(ctx, challenge.UserID, challenge.Username, "StartLoginTOTPEnrollment")
+}
+
+// CompleteLoginTOTP - второй шаг входа: код из приложения или код восстановления в обмен на токены сессии.
+// Если второй фактор настраивается при этом входе, в ответе возвращаются коды восстановления
+func (svc WHCService) CompleteLoginTOTP(ctx context.Context, challengeToken, code string) (*model.AuthTokens, *model.User, []string, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if strings.TrimSpace(code) == "" {
+		return nil, nil, nil, model.ErrEmptyOTPCode
+	}
+
+	challenge, err := svc.activeChallenge(ctx, challengeToken, "CompleteLoginTOTP")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// перебор кодов ограничивается тем же счетчиком неудач, что и перебор паролей
+	if err := svc.checkLoginThrottle(ctx, challenge.Username, challenge.Role); err != nil {
+		return nil, nil, nil, err
+	}
+
+	state, err := svc.repo.GetUserTOTP(ctx, challenge.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrTOTPNotEnrolled):
+			return nil, nil, nil, err
+		default:
+			log.Printf("RID %q Failed to get user totp from DB in 'CompleteLoginTOTP': %q", rid, err)
+			return nil, nil, nil, model.ErrCommon500
+		}
+	}
+
+	var recoveryCodes []string
+	if state.ConfirmedAt == nil {
+		recoveryCodes, err = svc.confirmTOTP(ctx, state, code, "CompleteLoginTOTP")
+	} else {
+		err = svc.checkSecondFactor(ctx, state, code, "CompleteLoginTOTP")
+	}
+	if err != nil {
+		if errors.Is(err, model.ErrInvalidOTP) {
+			if err := svc.repo.FailLoginChallenge(ctx, challenge.ID); err != nil {
+				log.Printf("RID %q Failed to count challenge attempt in DB in 'CompleteLoginTOTP': %q", rid, err)
+			}
+			svc.recordLoginAttempt(ctx, challenge.Username, challenge.Role, model.LoginFailBadOTP)
+		}
+		return nil, nil, nil, err
+	}
+
+	// челлендж одноразовый: второй запрос с тем же токеном сессию уже не получит
+	if err := svc.repo.CompleteLoginChallenge(ctx, challenge.ID); err != nil {
+		switch {
+		case errors.Is(err, model.ErrInvalidChallenge):
+			return nil, nil, nil, err
+		default:
+			log.Printf("RID %q Failed to complete login challenge in DB in 'CompleteLoginTOTP': %q", rid, err)
+			return nil, nil, nil, model.ErrCommon500
+		}
+	}
+
+	user := &model.User{
+		ID:          challenge.UserID,
+		UserName:    challenge.Username,
+		Role:        challenge.Role,
+		Permissions: svc.permissionsOf(challenge.Role),
+	}
+
+	tokens, err := svc.issueTokens(ctx, user)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	svc.recordLoginAttempt(ctx, challenge.Username, challenge.Role, "")
+
+	return tokens, user, recoveryCodes, nil
+}
+
+// EnrollTOTP начинает настройку второго фактора из активной сессии. До подтверждения кодом он не действует
+func (svc WHCService) EnrollTOTP(ctx context.Context, userID int, username string) (*model.TOTPEnrollment, error) {
+	// у сервисных аккаунтов (API-ключей) нет пользователя, которому можно включить второй фактор
+	if userID <= 0 {
+		return nil, model.ErrAccessDenied
+	}
+
+	return svc.newTOTPSecret(ctx, userID, username, "EnrollTOTP")
+}
+
+// ConfirmTOTP включает второй фактор первым кодом из приложения и возвращает коды восстановления
+func (svc WHCService) ConfirmTOTP(ctx context.Context, userID int, code string) ([]string, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if userID <= 0 {
+		return nil, model.ErrAccessDenied
+	}
+
+	if strings.TrimSpace(code) == "" {
+		return nil, model.ErrEmptyOTPCode
+	}
+
+	state, err := svc.repo.GetUserTOTP(ctx, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrTOTPNotEnrolled):
+			return nil, err
+		default:
+			log.Printf("RID %q Failed to get user totp from DB in 'ConfirmTOTP': %q", rid, err)
+			return nil, model.ErrCommon500
+		}
+	}
+
+	if state.ConfirmedAt != nil {
+		return nil, model.ErrTOTPAlreadyActive
+	}
+
+	return svc.confirmTOTP(ctx, state, code, "ConfirmTOTP")
+}
+
+// DisableTOTP выключает второй фактор по действующему коду. Для ролей, где он обязателен, выключить нельзя
+func (svc WHCService) DisableTOTP(ctx context.Context, userID int, code, role string) error {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if userID <= 0 {
+		return model.ErrAccessDenied
+	}
+
+	if slices.Contains(svc.cfg.TOTPRequiredRoles, role) {
+		return model.ErrTOTPRequired
+	}
+
+	state, err := svc.repo.GetUserTOTP(ctx, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrTOTPNotEnrolled):
+			return err
+		default:
+			log.Printf("RID %q Failed to get user totp from DB in 'DisableTOTP': %q", rid, err)
+			return model.ErrCommon500
+		}
+	}
+
+	// неподтвержденную настройку можно отменить без кода - второй фактор еще не действует
+	if state.ConfirmedAt != nil {
+		if strings.TrimSpace(code) == "" {
+			return model.ErrEmptyOTPCode
+		}
+		if err := svc.checkSecondFactor(ctx, state, code, "DisableTOTP"); err != nil {
+			return err
+		}
+	}
+
+	if err := svc.repo.DeleteUserTOTP(ctx, userID); err != nil {
+		switch {
+		case errors.Is(err, model.ErrTOTPNotEnrolled):
+			return err
+		default:
+			log.Printf("RID %q Failed to delete user totp in DB in 'DisableTOTP': %q", rid, err)
+			return model.ErrCommon500
+		}
+	}
+
+	return nil
+}
+
+// ResetUserTOTP - админ сбрасывает второй фактор пользователю, потерявшему телефон и коды восстановления
+func (svc WHCService) ResetUserTOTP(ctx context.Context, userID int, role string) error {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if userID <= 0 {
+		return model.ErrIncorrectUserID
+	}
+
+	if !svc.policy.Can(role, model.PermUsersManage) {
+		return model.ErrAccessDenied
+	}
+
+	if err := svc.repo.DeleteUserTOTP(ctx, userID); err != nil {
+		switch {
+		case errors.Is(err, model.ErrTOTPNotEnrolled):
+			return err
+		default:
+			log.Printf("RID %q Failed to delete user totp in DB in 'ResetUserTOTP': %q", rid, err)
+			return model.ErrCommon500
+		}
+	}
+
+	return nil
+}
+
+func (svc WHCService) newTOTPSecret(ctx context.Context, userID int, username, method string) (*model.TOTPEnrollment, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		log.Printf("RID %q Failed to generate totp secret in %q: %q", rid, method, err)
+		return nil, model.ErrCommon500
+	}
+
+	if err := svc.repo.SaveTOTPSecret(ctx, userID, secret); err != nil {
+		switch {
+		case errors.Is(err, model.ErrTOTPAlreadyActive):
+			return nil, err
+		default:
+			log.Printf("RID %q Failed to put totp secret to DB in %q: %q", rid, method, err)
+			return nil, model.ErrCommon500
+		}
+	}
+
+	return &model.TOTPEnrollment{Secret: secret, URI: totp.URI(svc.cfg.TOTPIssuer, username, secret)}, nil
+}
+
+// confirmTOTP принимает первый код неподтвержденного секрета и выпускает новые коды восстановления
+func (svc WHCService) confirmTOTP(ctx context.Context, state *model.TOTPState, code, method string) ([]string, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	step, ok := totp.Validate(state.Secret, code, time.Now(), state.LastStep)
+	if !ok {
+		return nil, model.ErrInvalidOTP
+	}
+
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		log.Printf("RID %q Failed to generate recovery codes in %q: %q", rid, method, err)
+		return nil, model.ErrCommon500
+	}
+	hashes := make([]string, 0, len(codes))
+	for _, c := range codes {
+		hashes = append(hashes, hashToken(normalizeRecoveryCode(c)))
+	}
+
+	if err := svc.repo.ConfirmTOTP(ctx, state.UserID, step, hashes); err != nil {
+		switch {
+		case errors.Is(err, model.ErrInvalidOTP):
+			return nil, err
+		default:
+			log.Printf("RID %q Failed to confirm totp in DB in %q: %q", rid, method, err)
+			return nil, model.ErrCommon500
+		}
+	}
+
+	// открытые коды отдаются только один раз - в БД лишь их хэши
+	return codes, nil
+}
+
+// checkSecondFactor принимает код из приложения либо неиспользованный код восстановления
+func (svc WHCService) checkSecondFactor(ctx context.Context, state *model.TOTPState, code, method string) error {
+	rid := model.RequestIDFromCtx(ctx)
+
+	var err error
+	if step, ok := totp.Validate(state.Secret, code, time.Now(), state.LastStep); ok {
+		err = svc.repo.UseTOTPStep(ctx, state.UserID, step)
+	} else {
+		err = svc.repo.UseRecoveryCode(ctx, state.UserID, hashToken(normalizeRecoveryCode(code)))
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrInvalidOTP):
+			return err
+		default:
+			log.Printf("RID %q Failed to check second factor in DB in %q: %q", rid, method, err)
+			return model.ErrCommon500
+		}
+	}
+
+	return nil
+}
+
+// newRecoveryCodes - одноразовые коды вида "abcde-fghij" (50 бит случайности каждый)
+func newRecoveryCodes() ([]string, error) {
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, 0, recoveryCodesCount)
+	for range recoveryCodesCount {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(enc.EncodeToString(buf))[:10]
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode - код принимается без учета регистра, дефиса и пробелов
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+}
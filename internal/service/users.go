@@ -0,0 +1,284 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+	"github.com/UnendingLoop/WarehouseControl/internal/mwauthlog"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func (svc WHCService) GetUsersList(ctx context.Context, rpu *model.RequestParam, role string) ([]*model.User, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if !svc.policy.Can(role, model.PermUsersManage) {
+		return nil, model.ErrAccessDenied
+	}
+
+	if err := validateReqParams(rpu, model.OrderByUsersMap); err != nil {
+		return nil, err
+	}
+
+	res, err := svc.repo.GetUsersList(ctx, rpu)
+	if err != nil {
+		log.Printf("RID %q Failed to get users list from DB in 'GetUsersList': %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+
+	return res, nil
+}
+
+func (svc WHCService) ChangeUserRole(ctx context.Context, userID int, newRole string, role, username string) error {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if userID <= 0 {
+		return model.ErrIncorrectUserID
+	}
+
+	if !svc.policy.Can(role, model.PermUsersManage) {
+		return model.ErrAccessDenied
+	}
+
+	if !svc.policy.IsCorrectRole(newRole) {
+		return model.ErrIncorrectUserRole
+	}
+
+	if err := svc.repo.UpdateUserRole(ctx, userID, newRole, username); err != nil {
+		switch {
+		case errors.Is(err, model.ErrUserNotFound):
+			return err
+		default:
+			log.Printf("RID %q Failed to update user role in DB in 'ChangeUserRole': %q", rid, err)
+			return model.ErrCommon500
+		}
+	}
+
+	return nil
+}
+
+func (svc WHCService) SetUserDisabled(ctx context.Context, userID int, disabled bool, role, username string) error {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if userID <= 0 {
+		return model.ErrIncorrectUserID
+	}
+
+	if !svc.policy.Can(role, model.PermUsersManage) {
+		return model.ErrAccessDenied
+	}
+
+	if err := svc.repo.SetUserDisabled(ctx, userID, disabled, username); err != nil {
+		switch {
+		case errors.Is(err, model.ErrUserNotFound):
+			return err
+		default:
+			log.Printf("RID %q Failed to update user status in DB in 'SetUserDisabled': %q", rid, err)
+			return model.ErrCommon500
+		}
+	}
+
+	return nil
+}
+
+func (svc WHCService) ResetUserPassword(ctx context.Context, userID int, password string, role, username string) error {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if userID <= 0 {
+		return model.ErrIncorrectUserID
+	}
+
+	if !svc.policy.Can(role, model.PermUsersManage) {
+		return model.ErrAccessDenied
+	}
+
+	if password == "" {
+		return model.ErrEmptyPassword
+	}
+
+	passHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Printf("RID %q Failed to hash password in 'ResetUserPassword': %q", rid, err)
+		return model.ErrCommon500
+	}
+
+	if err := svc.repo.UpdateUserPassword(ctx, userID, string(passHash), username); err != nil {
+		switch {
+		case errors.Is(err, model.ErrUserNotFound):
+			return err
+		default:
+			log.Printf("RID %q Failed to update user password in DB in 'ResetUserPassword': %q", rid, err)
+			return model.ErrCommon500
+		}
+	}
+
+	return nil
+}
+
+func (svc WHCService) DeleteUser(ctx context.Context, userID int, role, username string) error {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if userID <= 0 {
+		return model.ErrIncorrectUserID
+	}
+
+	if !svc.policy.Can(role, model.PermUsersManage) {
+		return model.ErrAccessDenied
+	}
+
+	if err := svc.repo.DeleteUser(ctx, userID, username); err != nil {
+		switch {
+		case errors.Is(err, model.ErrUserNotFound):
+			return err
+		default:
+			log.Printf("RID %q Failed to delete user in DB in 'DeleteUser': %q", rid, err)
+			return model.ErrCommon500
+		}
+	}
+
+	return nil
+}
+
+func (svc WHCService) GetUserHistory(ctx context.Context, rph *model.RequestParam, userID int, role string) ([]*model.UserHistory, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if userID <= 0 {
+		return nil, model.ErrIncorrectUserID
+	}
+
+	if !svc.policy.Can(role, model.PermUsersManage) {
+		return nil, model.ErrAccessDenied
+	}
+
+	if err := validateReqParams(rph, model.OrderByUserHistoryMap); err != nil {
+		return nil, err
+	}
+
+	res, err := svc.repo.GetUserHistory(ctx, rph, userID)
+	if err != nil {
+		log.Printf("RID %q Failed to get user history from DB in 'GetUserHistory': %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+
+	if len(res) == 0 {
+		return nil, model.ErrUserNotFound
+	}
+
+	return res, nil
+}
+
+// CheckSession вызывается из RequireAuth на каждый запрос: отключенный/удаленный пользователь,
+// отозванная роль или отозванный токен делают сессию недействительной даже при валидном JWT
+func (svc WHCService) CheckSession(ctx context.Context, claims *mwauthlog.Claims) error {
+	rid := model.RequestIDFromCtx(ctx)
+
+	// роль могла быть удалена из политики после выпуска токена
+	if !svc.policy.IsCorrectRole(claims.Role) {
+		return model.ErrIncorrectUserRole
+	}
+
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+
+	state, err := svc.repo.GetUserSessionState(ctx, claims.UserID, claims.Role, claims.ID, issuedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrUserNotFound):
+			return err
+		default:
+			log.Printf("RID %q Failed to get user session state from DB in 'CheckSession': %q", rid, err)
+			return model.ErrCommon500
+		}
+	}
+
+	switch {
+	case !state.Active:
+		return model.ErrUserDisabled
+	case !state.HasRole:
+		return model.ErrRoleNotGranted
+	case state.Revoked:
+		return model.ErrSessionRevoked
+	}
+
+	return nil
+}
+
+func (svc WHCService) GetUserRoles(ctx context.Context, userID int, role string) ([]string, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if userID <= 0 {
+		return nil, model.ErrIncorrectUserID
+	}
+
+	if !svc.policy.Can(role, model.PermUsersManage) {
+		return nil, model.ErrAccessDenied
+	}
+
+	res, err := svc.repo.GetUserRoles(ctx, userID)
+	if err != nil {
+		log.Printf("RID %q Failed to get user roles from DB in 'GetUserRoles': %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+
+	return res, nil
+}
+
+func (svc WHCService) GrantUserRole(ctx context.Context, userID int, grantRole string, role, username string) error {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if userID <= 0 {
+		return model.ErrIncorrectUserID
+	}
+
+	if !svc.policy.Can(role, model.PermUsersManage) {
+		return model.ErrAccessDenied
+	}
+
+	if !svc.policy.IsCorrectRole(grantRole) {
+		return model.ErrIncorrectUserRole
+	}
+
+	if err := svc.repo.GrantUserRole(ctx, userID, grantRole, username); err != nil {
+		switch {
+		case errors.Is(err, model.ErrUserNotFound):
+			return err
+		default:
+			log.Printf("RID %q Failed to grant role in DB in 'GrantUserRole': %q", rid, err)
+			return model.ErrCommon500
+		}
+	}
+
+	return nil
+}
+
+func (svc WHCService) RevokeUserRole(ctx context.Context, userID int, revokeRole string, role, username string) error {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if userID <= 0 {
+		return model.ErrIncorrectUserID
+	}
+
+	if !svc.policy.Can(role, model.PermUsersManage) {
+		return model.ErrAccessDenied
+	}
+
+	if !svc.policy.IsCorrectRole(revokeRole) {
+		return model.ErrIncorrectUserRole
+	}
+
+	if err := svc.repo.RevokeUserRole(ctx, userID, revokeRole, username); err != nil {
+		switch {
+		case errors.Is(err, model.ErrRoleNotGranted):
+			return err
+		default:
+			log.Printf("RID %q Failed to revoke role in DB in 'RevokeUserRole': %q", rid, err)
+			return model.ErrCommon500
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+)
+
+func (svc WHCService) CreateWarehouse(ctx context.Context, wh *model.Warehouse, role, username string) error {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if !svc.policy.Can(role, model.PermWarehousesManage) {
+		return model.ErrAccessDenied
+	}
+
+	wh.Code = strings.ToUpper(strings.TrimSpace(wh.Code))
+	wh.Name = strings.TrimSpace(wh.Name)
+	if wh.Code == "" || wh.Name == "" {
+		return model.ErrEmptyWarehouseInfo
+	}
+	wh.UpdatedBy = username
+
+	if err := svc.repo.CreateWarehouse(ctx, wh); err != nil {
+		switch {
+		case isUniqueViolation(err):
+			return model.ErrWarehouseAlreadyExists
+		default:
+			log.Printf("RID %q Failed to create warehouse in DB in 'CreateWarehouse': %q", rid, err)
+			return model.ErrCommon500
+		}
+	}
+
+	return nil
+}
+
+func (svc WHCService) GetWarehouses(ctx context.Context, role string) ([]*model.Warehouse, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if !svc.policy.Can(role, model.PermItemsRead) {
+		return nil, model.ErrAccessDenied
+	}
+
+	res, err := svc.repo.GetWarehouses(ctx)
+	if err != nil {
+		log.Printf("RID %q Failed to get warehouses from DB in 'GetWarehouses': %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+
+	return res, nil
+}
+
+func (svc WHCService) GetUserWarehouses(ctx context.Context, userID int, role string) ([]int, error) {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if userID <= 0 {
+		return nil, model.ErrIncorrectUserID
+	}
+
+	if !svc.policy.Can(role, model.PermUsersManage) {
+		return nil, model.ErrAccessDenied
+	}
+
+	res, err := svc.repo.GetUserWarehouses(ctx, userID)
+	if err != nil {
+		log.Printf("RID %q Failed to get user warehouses from DB in 'GetUserWarehouses': %q", rid, err)
+		return nil, model.ErrCommon500
+	}
+
+	return res, nil
+}
+
+func (svc WHCService) GrantUserWarehouse(ctx context.Context, userID, warehouseID int, role, username string) error {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if userID <= 0 {
+		return model.ErrIncorrectUserID
+	}
+	if warehouseID <= 0 {
+		return model.ErrIncorrectWarehouseID
+	}
+
+	if !svc.policy.Can(role, model.PermUsersManage) {
+		return model.ErrAccessDenied
+	}
+
+	if err := svc.repo.GrantUserWarehouse(ctx, userID, warehouseID, username); err != nil {
+		switch {
+		case errors.Is(err, model.ErrUserNotFound), errors.Is(err, model.ErrWarehouseNotFound):
+			return err
+		default:
+			log.Printf("RID %q Failed to grant warehouse in DB in 'GrantUserWarehouse': %q", rid, err)
+			return model.ErrCommon500
+		}
+	}
+
+	return nil
+}
+
+func (svc WHCService) RevokeUserWarehouse(ctx context.Context, userID, warehouseID int, role, username string) error {
+	rid := model.RequestIDFromCtx(ctx)
+
+	if userID <= 0 {
+		return model.ErrIncorrectUserID
+	}
+	if warehouseID <= 0 {
+		return model.ErrIncorrectWarehouseID
+	}
+
+	if !svc.policy.Can(role, model.PermUsersManage) {
+		return model.ErrAccessDenied
+	}
+
+	if err := svc.repo.RevokeUserWarehouse(ctx, userID, warehouseID, username); err != nil {
+		switch {
+		case errors.Is(err, model.ErrWarehouseNotGranted):
+			return err
+		default:
+			log.Printf("RID %q Failed to revoke warehouse in DB in 'RevokeUserWarehouse': %q", rid, err)
+			return model.ErrCommon500
+		}
+	}
+
+	return nil
+}
+
+// warehouseScope возвращает склады, которыми ограничен пользователь; nil - доступны все склады.
+// У сервисных аккаунтов (API-ключей) нет userID, они не ограничиваются складами
+func (svc WHCService) warehouseScope(ctx context.Context, userID int) ([]int, error) {
+	if userID <= 0 {
+		return nil, nil
+	}
+
+	scope, err := svc.repo.GetUserWarehouses(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(scope) == 0 {
+		return nil, nil
+	}
+	return scope, nil
+}
+
+// checkWarehouseScope проверяет, что пользователь может менять остатки на складе
+func (svc WHCService) checkWarehouseScope(ctx context.Context, userID, warehouseID int) error {
+	scope, err := svc.warehouseScope(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if scope != nil && !slices.Contains(scope, warehouseID) {
+		return model.ErrWarehouseAccessDenied
+	}
+	return nil
+}
+
+// resolveWarehouse подставляет склад по умолчанию, если клиент его не указал, и проверяет доступ к складу
+func (svc WHCService) resolveWarehouse(ctx context.Context, warehouseID *int, userID int) (*int, error) {
+	if warehouseID == nil {
+		def := svc.cfg.DefaultWarehouseID
+		warehouseID = &def
+	}
+	if *warehouseID <= 0 {
+		return nil, model.ErrIncorrectWarehouseID
+	}
+
+	if err := svc.checkWarehouseScope(ctx, userID, *warehouseID); err != nil {
+		return nil, err
+	}
+	return warehouseID, nil
+}
+
+// warehouseError пропускает ошибки проверки склада клиенту, остальные логирует как внутренние
+func (svc WHCService) warehouseError(rid, method string, err error) error {
+	switch {
+	case errors.Is(err, model.ErrIncorrectWarehouseID), errors.Is(err, model.ErrWarehouseAccessDenied):
+		return err
+	default:
+		log.Printf("RID %q Failed to check user warehouses in %q: %q", rid, method, err)
+		return model.ErrCommon500
+	}
+}
@@ -0,0 +1,82 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// секрет и ожидаемые значения из RFC 6238, приложение B (SHA1), усеченные до 6 цифр
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeRFCVectors(t *testing.T) {
+	cases := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+	}
+
+	for _, tt := range cases {
+		code, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		require.NoError(t, err)
+		require.Equal(t, tt.want, code)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	prev, err := Code(rfcSecret, current-1)
+	require.NoError(t, err)
+	old, err := Code(rfcSecret, current-2)
+	require.NoError(t, err)
+
+	cases := []struct {
+		name     string
+		code     string
+		lastStep int64
+		wantOK   bool
+		wantStep int64
+	}{
+		{name: "Positive - current code", code: "050471", wantOK: true, wantStep: current},
+		{name: "Positive - previous interval within skew", code: prev, wantOK: true, wantStep: current - 1},
+		{name: "Negative - interval outside skew", code: old, wantOK: false},
+		{name: "Negative - code already used", code: "050471", lastStep: current, wantOK: false},
+		{name: "Negative - wrong code", code: "000000", wantOK: false},
+		{name: "Negative - wrong length", code: "50471", wantOK: false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.code, now, tt.lastStep)
+			require.Equal(t, tt.wantOK, ok)
+			if tt.wantOK {
+				require.Equal(t, tt.wantStep, step)
+			}
+		})
+	}
+}
+
+func TestURI(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+	require.Len(t, secret, 32)
+
+	raw := URI("WarehouseControl", "jane", secret)
+	u, err := url.Parse(raw)
+	require.NoError(t, err)
+	require.Equal(t, "otpauth", u.Scheme)
+	require.Equal(t, "totp", u.Host)
+	require.Equal(t, "/WarehouseControl:jane", u.Path)
+	require.Equal(t, secret, u.Query().Get("secret"))
+	require.Equal(t, "WarehouseControl", u.Query().Get("issuer"))
+}
@@ -0,0 +1,71 @@
+package transport
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (whc *WHCHandlers) CreateAPIKey(ctx *gin.Context) {
+	// логируем role-sensitive запрос
+	rid := stringFromCtx(ctx, "request_id")
+	uid := intFromCtx(ctx, "user_id")
+	userName := stringFromCtx(ctx, "username")
+	role := stringFromCtx(ctx, "role")
+
+	// читаем сервисный аккаунт, роль и срок действия ключа
+	var req apiKeyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid api key payload"})
+		return
+	}
+	log.Printf("rid=%q userID=%d userName=%q role=%q creating api key %q for role %q", rid, uid, userName, role, req.Name, req.Role)
+
+	// передаем в сервис
+	res, err := whc.svc.CreateAPIKey(ctx.Request.Context(), req.Name, req.Role, time.Duration(req.ExpiresInHours)*time.Hour, role, userName)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, res)
+}
+
+func (whc *WHCHandlers) GetAPIKeysList(ctx *gin.Context) {
+	role := stringFromCtx(ctx, "role")
+
+	res, err := whc.svc.GetAPIKeysList(ctx.Request.Context(), role)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, res)
+}
+
+func (whc *WHCHandlers) RevokeAPIKey(ctx *gin.Context) {
+	// логируем role-sensitive запрос
+	rid := stringFromCtx(ctx, "request_id")
+	uid := intFromCtx(ctx, "user_id")
+	userName := stringFromCtx(ctx, "username")
+	role := stringFromCtx(ctx, "role")
+
+	// определяем id
+	rawID, ok := ctx.Params.Get("id")
+	if !ok {
+		ctx.JSON(400, gin.H{"error": "empty api key id"})
+		return
+	}
+	id := stringToInt(rawID)
+	log.Printf("rid=%q userID=%d userName=%q role=%q revoking api key #%d", rid, uid, userName, role, id)
+
+	// передаем в сервис
+	if err := whc.svc.RevokeAPIKey(ctx.Request.Context(), id, role); err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
@@ -0,0 +1,82 @@
+package transport
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+	"github.com/gin-gonic/gin"
+)
+
+func (whc *WHCHandlers) GetAuthEvents(ctx *gin.Context) {
+	// парсим параметры запроса и фильтры из URL
+	rp := model.RequestParam{}
+	if err := decodeQueryParams(ctx, &rp); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter := model.AuthEventFilter{}
+	if err := decodeAuthEventFilter(ctx, &filter); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role := stringFromCtx(ctx, "role")
+	res, err := whc.svc.GetAuthEvents(ctx.Request.Context(), &rp, &filter, role)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, res)
+}
+
+func (whc *WHCHandlers) ExportAuthEventsCSV(ctx *gin.Context) {
+	// парсим параметры запроса и фильтры из URL
+	rp := model.RequestParam{}
+	if err := decodeQueryParams(ctx, &rp); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter := model.AuthEventFilter{}
+	if err := decodeAuthEventFilter(ctx, &filter); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// обращаемся к сервису
+	role := stringFromCtx(ctx, "role")
+	res, err := whc.svc.GetAuthEvents(ctx.Request.Context(), &rp, &filter, role)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	// устанавливаем хедеры под CSV
+	ctx.Writer.Header().Set("Cache-Control", "no-store")
+	ctx.Writer.Header().Set("Pragma", "no-cache")
+	ctx.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Disposition")
+	ctx.Writer.Header().Set("Content-Type", "text/csv")
+	ctx.Writer.Header().Set("Content-Disposition", "attachment; filename=authEvents.csv")
+
+	// готовим и пишем данные
+	rows, err := convertAuthEventsToCSV(ctx.Request.Context(), res)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			ctx.Status(http.StatusGatewayTimeout)
+			return
+		}
+	}
+
+	writer := csv.NewWriter(ctx.Writer)
+	if err := writer.WriteAll(rows); err != nil {
+		log.Printf("failed to Flush csv-writer: %q", err.Error())
+		return
+	}
+}
@@ -4,6 +4,8 @@ package transport
 import (
 	"context"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/UnendingLoop/WarehouseControl/internal/model"
 	"github.com/gin-gonic/gin"
@@ -16,17 +18,78 @@ type WHCHandlers struct {
 }
 
 type WHCService interface {
-	CreateItem(ctx context.Context, item *model.Item, role string) error
+	CreateItem(ctx context.Context, item *model.Item, userID int, role string) error
 	GetItemByID(ctx context.Context, id int, role string) (*model.Item, error)
-	UpdateItemByID(ctx context.Context, item *model.ItemUpdate, role string) error
-	DeleteItemByID(ctx context.Context, id int, role, username string) error
+	UpdateItemByID(ctx context.Context, item *model.ItemUpdate, userID int, role string) error
+	DeleteItemByID(ctx context.Context, id int, version *int, userID int, role, username string) error
+	RestoreItemByID(ctx context.Context, id int, userID int, role, username string) error
+	RevertItemByID(ctx context.Context, id int, rv *model.ItemRevert, version *int, userID int, role, username string) error
+	PurgeItemByID(ctx context.Context, id int, userID int, role, username string) error
+	PurgeDeletedItems(ctx context.Context, req *model.PurgeRequest, userID int, role, username string) (*model.PurgeResult, error)
 
-	CreateUser(ctx context.Context, user *model.User) (string, error)
-	LoginUser(ctx context.Context, username string, password string, role string) (string, *model.User, error)
+	CreateUser(ctx context.Context, user *model.User, inviteToken string) (*model.AuthTokens, error)
+	LoginUser(ctx context.Context, username string, password string, role string) (*model.AuthTokens, *model.User, *model.LoginChallenge, error)
+	CompleteLoginTOTP(ctx context.Context, challengeToken, code string) (*model.AuthTokens, *model.User, []string, error)
+	StartLoginTOTPEnrollment(ctx context.Context, challengeToken string) (*model.TOTPEnrollment, error)
+	RefreshSession(ctx context.Context, refreshToken string) (*model.AuthTokens, *model.User, error)
+	Logout(ctx context.Context, accessToken, refreshToken string) error
+	GetJWKS(ctx context.Context) *model.JWKS
+	StartOIDCLogin(ctx context.Context) (*model.OIDCFlow, string, error)
+	CompleteOIDCLogin(ctx context.Context, flow *model.OIDCFlow, state, code string) (*model.AuthTokens, *model.User, *model.LoginChallenge, error)
 
-	GetItemsList(ctx context.Context, rpi *model.RequestParam, role string) ([]*model.Item, error)
-	GetItemHistoryByID(ctx context.Context, rph *model.RequestParam, id int, role string) ([]*model.ItemHistory, error)
-	GetItemHistoryAll(ctx context.Context, rph *model.RequestParam, role string) ([]*model.ItemHistory, error)
+	GetUsersList(ctx context.Context, rpu *model.RequestParam, role string) ([]*model.User, error)
+	ChangeUserRole(ctx context.Context, userID int, newRole string, role, username string) error
+	SetUserDisabled(ctx context.Context, userID int, disabled bool, role, username string) error
+	ResetUserPassword(ctx context.Context, userID int, password string, role, username string) error
+	DeleteUser(ctx context.Context, userID int, role, username string) error
+	GetUserHistory(ctx context.Context, rph *model.RequestParam, userID int, role string) ([]*model.UserHistory, error)
+	GetUserRoles(ctx context.Context, userID int, role string) ([]string, error)
+	GrantUserRole(ctx context.Context, userID int, grantRole string, role, username string) error
+	RevokeUserRole(ctx context.Context, userID int, revokeRole string, role, username string) error
+	RevokeUserSessions(ctx context.Context, userID int, role, username string) error
+	ResetUserTOTP(ctx context.Context, userID int, role string) error
+
+	EnrollTOTP(ctx context.Context, userID int, username string) (*model.TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, userID int, code string) ([]string, error)
+	DisableTOTP(ctx context.Context, userID int, code, role string) error
+
+	CreateInvite(ctx context.Context, inviteRole string, ttl time.Duration, role, username string) (*model.Invite, error)
+	GetInvitesList(ctx context.Context, role string) ([]*model.Invite, error)
+	RevokeInvite(ctx context.Context, inviteID int, role string) error
+
+	CreateAPIKey(ctx context.Context, name, keyRole string, ttl time.Duration, role, username string) (*model.APIKey, error)
+	GetAPIKeysList(ctx context.Context, role string) ([]*model.APIKey, error)
+	RevokeAPIKey(ctx context.Context, keyID int, role string) error
+
+	GetLoginAttempts(ctx context.Context, rp *model.RequestParam, filter *model.LoginAttemptFilter, role string) ([]*model.LoginAttempt, error)
+	GetAuthEvents(ctx context.Context, rp *model.RequestParam, filter *model.AuthEventFilter, role string) ([]*model.AuthEvent, error)
+
+	GetItemsList(ctx context.Context, rpi *model.RequestParam, filter *model.ItemFilter, role string) ([]*model.Item, error)
+	GetDeletedItems(ctx context.Context, rp *model.RequestParam, role string) ([]*model.Item, error)
+	GetItemHistoryByID(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, id int, role string) ([]*model.ItemHistory, error)
+	GetItemHistoryAll(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, role string) ([]*model.ItemHistory, error)
+	ExportItemHistoryByID(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, id int, role string) ([]*model.ItemHistory, error)
+	ExportItemHistoryAll(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, role string) ([]*model.ItemHistory, error)
+	GetItemHistoryDiff(ctx context.Context, req *model.ItemDiffRequest, id int, role string) (*model.ItemDiff, error)
+
+	CreateWarehouse(ctx context.Context, wh *model.Warehouse, role, username string) error
+	GetWarehouses(ctx context.Context, role string) ([]*model.Warehouse, error)
+	GetUserWarehouses(ctx context.Context, userID int, role string) ([]int, error)
+	GrantUserWarehouse(ctx context.Context, userID, warehouseID int, role, username string) error
+	RevokeUserWarehouse(ctx context.Context, userID, warehouseID int, role, username string) error
+
+	CreateLocation(ctx context.Context, loc *model.Location, role, username string) error
+	GetLocations(ctx context.Context, warehouseID int, role string) ([]*model.Location, error)
+	GetLocationStock(ctx context.Context, locationID int, role string) ([]*model.LocationStock, error)
+	GetItemLocations(ctx context.Context, itemID int, role string) ([]*model.LocationStock, error)
+	MoveItemStock(ctx context.Context, mv *model.StockMove, userID int, role string) error
+
+	CreateMovement(ctx context.Context, m *model.Movement, userID int, role string) ([]*model.Movement, error)
+	AdjustItemStock(ctx context.Context, adj *model.StockAdjustment, userID int, role string) ([]*model.Movement, error)
+	GetMovements(ctx context.Context, rp *model.RequestParam, filter *model.MovementFilter, role string) ([]*model.Movement, error)
+
+	GetPolicy(ctx context.Context, role string) (map[string][]string, error)
+	ReloadPolicy(ctx context.Context, role string) (map[string][]string, error)
 }
 
 func NewWHCHandlers(svc WHCService) *WHCHandlers {
@@ -34,24 +97,88 @@ func NewWHCHandlers(svc WHCService) *WHCHandlers {
 }
 
 // ---------------------------------------------------------------
+// signupRequest - роль в запросе отсутствует намеренно: ее задает режим регистрации или инвайт
+type signupRequest struct {
+	UserName    string `json:"username" binding:"required"`
+	Password    string `json:"password" binding:"required"`
+	InviteToken string `json:"invite_token"`
+}
+
 type authRequest struct {
 	UserName string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
-	Role     string `json:"role" binding:"required"`
+	Role     string `json:"role"` // необязательна: по умолчанию используется основная роль пользователя
+}
+
+// loginTOTPRequest - второй шаг входа: code - код из приложения или код восстановления
+type loginTOTPRequest struct {
+	Challenge string `json:"challenge" binding:"required"`
+	Code      string `json:"code"`
+}
+
+type challengeRequest struct {
+	Challenge string `json:"challenge" binding:"required"`
+}
+
+type otpCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type roleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+type inviteRequest struct {
+	Role           string `json:"role" binding:"required"`
+	ExpiresInHours int    `json:"expires_in_hours"` // 0 - срок по умолчанию
+}
+
+type apiKeyRequest struct {
+	Name           string `json:"name" binding:"required"` // имя сервисного аккаунта
+	Role           string `json:"role" binding:"required"`
+	ExpiresInHours int    `json:"expires_in_hours"` // 0 - бессрочный ключ
+}
+
+type warehouseRequest struct {
+	WarehouseID int `json:"warehouse_id" binding:"required"`
+}
+
+type passwordRequest struct {
+	Password string `json:"password" binding:"required"`
 }
 
 type authResponse struct {
-	User userPublic `json:"user"`
+	User          userPublic `json:"user"`
+	RecoveryCodes []string   `json:"recovery_codes,omitempty"` // только если второй фактор настроен при этом входе
 }
 
 type userPublic struct {
-	ID       int    `json:"id"`
-	UserName string `json:"username"`
-	Role     string `json:"role"`
+	ID         int        `json:"id"`
+	UserName   string     `json:"username"`
+	Role       string     `json:"role"`
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+
+	Permissions []string `json:"permissions,omitempty"`
 }
 
 func convertUserAuthToResponse(user *model.User) *authResponse {
-	return &authResponse{User: userPublic{ID: user.ID, UserName: user.UserName, Role: user.Role}}
+	return &authResponse{User: userPublic{ID: user.ID, UserName: user.UserName, Role: user.Role, Permissions: user.Permissions}}
+}
+
+// convertUsersToPublic отбрасывает хэш пароля и прочие внутренние поля перед отдачей списка пользователей
+func convertUsersToPublic(users []*model.User) []userPublic {
+	result := make([]userPublic, 0, len(users))
+	for _, u := range users {
+		result = append(result, userPublic{
+			ID:         u.ID,
+			UserName:   u.UserName,
+			Role:       u.Role,
+			CreatedAt:  u.CreatedAt,
+			DisabledAt: u.DisabledAt,
+		})
+	}
+	return result
 }
 
 // ----------------------------------------------------------
@@ -77,6 +204,27 @@ func stringToInt(input string) int {
 	return output
 }
 
+// itemETag - версия товара в виде ETag
+func itemETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// decodeIfMatch читает ожидаемую версию товара из If-Match; без заголовка или с "*" версия не проверяется
+func decodeIfMatch(c *ginext.Context) (*int, error) {
+	raw := strings.TrimSpace(c.GetHeader("If-Match"))
+	if raw == "" || raw == "*" {
+		return nil, nil
+	}
+
+	// версия одна на весь товар, поэтому слабый ETag сравнивается так же, как сильный
+	raw = strings.TrimPrefix(raw, "W/")
+	version, err := strconv.Atoi(strings.Trim(raw, `"`))
+	if err != nil || version <= 0 {
+		return nil, model.ErrInvalidIfMatch
+	}
+	return &version, nil
+}
+
 func decodeQueryParams(c *ginext.Context, input *model.RequestParam) error {
 	decoder := form.NewDecoder()
 	if err := decoder.Decode(input, c.Request.URL.Query()); err != nil {
@@ -84,3 +232,59 @@ func decodeQueryParams(c *ginext.Context, input *model.RequestParam) error {
 	}
 	return nil
 }
+
+func decodeLoginAttemptFilter(c *ginext.Context, input *model.LoginAttemptFilter) error {
+	decoder := form.NewDecoder()
+	if err := decoder.Decode(input, c.Request.URL.Query()); err != nil {
+		return err
+	}
+	return nil
+}
+
+func decodeAuthEventFilter(c *ginext.Context, input *model.AuthEventFilter) error {
+	decoder := form.NewDecoder()
+	if err := decoder.Decode(input, c.Request.URL.Query()); err != nil {
+		return err
+	}
+	return nil
+}
+
+func decodeItemFilter(c *ginext.Context, input *model.ItemFilter) error {
+	decoder := form.NewDecoder()
+	if err := decoder.Decode(input, c.Request.URL.Query()); err != nil {
+		return err
+	}
+	return nil
+}
+
+func decodeMovementFilter(c *ginext.Context, input *model.MovementFilter) error {
+	decoder := form.NewDecoder()
+	if err := decoder.Decode(input, c.Request.URL.Query()); err != nil {
+		return err
+	}
+	return nil
+}
+
+func decodeHistoryFilter(c *ginext.Context, input *model.HistoryFilter) error {
+	decoder := form.NewDecoder()
+	if err := decoder.Decode(input, c.Request.URL.Query()); err != nil {
+		return err
+	}
+	return nil
+}
+
+func decodeItemDiffRequest(c *ginext.Context, input *model.ItemDiffRequest) error {
+	decoder := form.NewDecoder()
+	if err := decoder.Decode(input, c.Request.URL.Query()); err != nil {
+		return err
+	}
+	return nil
+}
+
+func decodePurgeRequest(c *ginext.Context, input *model.PurgeRequest) error {
+	decoder := form.NewDecoder()
+	if err := decoder.Decode(input, c.Request.URL.Query()); err != nil {
+		return err
+	}
+	return nil
+}
@@ -2,56 +2,327 @@ package transport
 
 import (
 	"context"
+	"time"
 
 	"github.com/UnendingLoop/WarehouseControl/internal/model"
 )
 
 type ServiceMock struct {
-	CreateItemFn     func(ctx context.Context, item *model.Item, role string) error
-	GetItemByIDFn    func(ctx context.Context, id int, role string) (*model.Item, error)
-	UpdateItemByIDFn func(ctx context.Context, item *model.ItemUpdate, role string) error
-	DeleteItemByIDFn func(ctx context.Context, id int, role, username string) error
+	CreateItemFn        func(ctx context.Context, item *model.Item, userID int, role string) error
+	GetItemByIDFn       func(ctx context.Context, id int, role string) (*model.Item, error)
+	UpdateItemByIDFn    func(ctx context.Context, item *model.ItemUpdate, userID int, role string) error
+	DeleteItemByIDFn    func(ctx context.Context, id int, version *int, userID int, role, username string) error
+	RestoreItemByIDFn   func(ctx context.Context, id int, userID int, role, username string) error
+	RevertItemByIDFn    func(ctx context.Context, id int, rv *model.ItemRevert, version *int, userID int, role, username string) error
+	PurgeItemByIDFn     func(ctx context.Context, id int, userID int, role, username string) error
+	PurgeDeletedItemsFn func(ctx context.Context, req *model.PurgeRequest, userID int, role, username string) (*model.PurgeResult, error)
 
-	CreateUserFn func(ctx context.Context, user *model.User) (string, error)
-	LoginUserFn  func(ctx context.Context, username string, password string, role string) (string, *model.User, error)
+	CreateUserFn func(ctx context.Context, user *model.User, inviteToken string) (*model.AuthTokens, error)
+	LoginUserFn  func(ctx context.Context, username string, password string, role string) (*model.AuthTokens, *model.User, *model.LoginChallenge, error)
 
-	GetItemsListFn       func(ctx context.Context, rpi *model.RequestParam, role string) ([]*model.Item, error)
-	GetItemHistoryByIDFn func(ctx context.Context, rph *model.RequestParam, id int, role string) ([]*model.ItemHistory, error)
-	GetItemHistoryAllFn  func(ctx context.Context, rph *model.RequestParam, role string) ([]*model.ItemHistory, error)
+	CompleteLoginTOTPFn        func(ctx context.Context, challengeToken, code string) (*model.AuthTokens, *model.User, []string, error)
+	StartLoginTOTPEnrollmentFn func(ctx context.Context, challengeToken string) (*model.TOTPEnrollment, error)
+	ResetUserTOTPFn            func(ctx context.Context, userID int, role string) error
+	EnrollTOTPFn               func(ctx context.Context, userID int, username string) (*model.TOTPEnrollment, error)
+	ConfirmTOTPFn              func(ctx context.Context, userID int, code string) ([]string, error)
+	DisableTOTPFn              func(ctx context.Context, userID int, code, role string) error
+
+	RefreshSessionFn func(ctx context.Context, refreshToken string) (*model.AuthTokens, *model.User, error)
+	LogoutFn         func(ctx context.Context, accessToken, refreshToken string) error
+	GetJWKSFn        func(ctx context.Context) *model.JWKS
+
+	StartOIDCLoginFn    func(ctx context.Context) (*model.OIDCFlow, string, error)
+	CompleteOIDCLoginFn func(ctx context.Context, flow *model.OIDCFlow, state, code string) (*model.AuthTokens, *model.User, *model.LoginChallenge, error)
+
+	GetUsersListFn       func(ctx context.Context, rpu *model.RequestParam, role string) ([]*model.User, error)
+	ChangeUserRoleFn     func(ctx context.Context, userID int, newRole string, role, username string) error
+	SetUserDisabledFn    func(ctx context.Context, userID int, disabled bool, role, username string) error
+	ResetUserPasswordFn  func(ctx context.Context, userID int, password string, role, username string) error
+	DeleteUserFn         func(ctx context.Context, userID int, role, username string) error
+	GetUserHistoryFn     func(ctx context.Context, rph *model.RequestParam, userID int, role string) ([]*model.UserHistory, error)
+	GetUserRolesFn       func(ctx context.Context, userID int, role string) ([]string, error)
+	GrantUserRoleFn      func(ctx context.Context, userID int, grantRole string, role, username string) error
+	RevokeUserRoleFn     func(ctx context.Context, userID int, revokeRole string, role, username string) error
+	RevokeUserSessionsFn func(ctx context.Context, userID int, role, username string) error
+
+	CreateInviteFn   func(ctx context.Context, inviteRole string, ttl time.Duration, role, username string) (*model.Invite, error)
+	GetInvitesListFn func(ctx context.Context, role string) ([]*model.Invite, error)
+	RevokeInviteFn   func(ctx context.Context, inviteID int, role string) error
+
+	CreateAPIKeyFn   func(ctx context.Context, name, keyRole string, ttl time.Duration, role, username string) (*model.APIKey, error)
+	GetAPIKeysListFn func(ctx context.Context, role string) ([]*model.APIKey, error)
+	RevokeAPIKeyFn   func(ctx context.Context, keyID int, role string) error
+
+	GetLoginAttemptsFn func(ctx context.Context, rp *model.RequestParam, filter *model.LoginAttemptFilter, role string) ([]*model.LoginAttempt, error)
+	GetAuthEventsFn    func(ctx context.Context, rp *model.RequestParam, filter *model.AuthEventFilter, role string) ([]*model.AuthEvent, error)
+
+	GetItemsListFn       func(ctx context.Context, rpi *model.RequestParam, filter *model.ItemFilter, role string) ([]*model.Item, error)
+	GetDeletedItemsFn    func(ctx context.Context, rp *model.RequestParam, role string) ([]*model.Item, error)
+	GetItemHistoryByIDFn func(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, id int, role string) ([]*model.ItemHistory, error)
+	GetItemHistoryAllFn  func(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, role string) ([]*model.ItemHistory, error)
+
+	ExportItemHistoryByIDFn func(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, id int, role string) ([]*model.ItemHistory, error)
+	ExportItemHistoryAllFn  func(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, role string) ([]*model.ItemHistory, error)
+	GetItemHistoryDiffFn    func(ctx context.Context, req *model.ItemDiffRequest, id int, role string) (*model.ItemDiff, error)
+
+	CreateWarehouseFn     func(ctx context.Context, wh *model.Warehouse, role, username string) error
+	GetWarehousesFn       func(ctx context.Context, role string) ([]*model.Warehouse, error)
+	GetUserWarehousesFn   func(ctx context.Context, userID int, role string) ([]int, error)
+	GrantUserWarehouseFn  func(ctx context.Context, userID, warehouseID int, role, username string) error
+	RevokeUserWarehouseFn func(ctx context.Context, userID, warehouseID int, role, username string) error
+	CreateLocationFn      func(ctx context.Context, loc *model.Location, role, username string) error
+	GetLocationsFn        func(ctx context.Context, warehouseID int, role string) ([]*model.Location, error)
+	GetLocationStockFn    func(ctx context.Context, locationID int, role string) ([]*model.LocationStock, error)
+	GetItemLocationsFn    func(ctx context.Context, itemID int, role string) ([]*model.LocationStock, error)
+	MoveItemStockFn       func(ctx context.Context, mv *model.StockMove, userID int, role string) error
+	CreateMovementFn      func(ctx context.Context, m *model.Movement, userID int, role string) ([]*model.Movement, error)
+	AdjustItemStockFn     func(ctx context.Context, adj *model.StockAdjustment, userID int, role string) ([]*model.Movement, error)
+	GetMovementsFn        func(ctx context.Context, rp *model.RequestParam, filter *model.MovementFilter, role string) ([]*model.Movement, error)
+
+	GetPolicyFn    func(ctx context.Context, role string) (map[string][]string, error)
+	ReloadPolicyFn func(ctx context.Context, role string) (map[string][]string, error)
 }
 
-func (sm *ServiceMock) CreateItem(ctx context.Context, item *model.Item, role string) error {
-	return sm.CreateItemFn(ctx, item, role)
+func (sm *ServiceMock) CreateItem(ctx context.Context, item *model.Item, userID int, role string) error {
+	return sm.CreateItemFn(ctx, item, userID, role)
 }
 
 func (sm *ServiceMock) GetItemByID(ctx context.Context, id int, role string) (*model.Item, error) {
 	return sm.GetItemByIDFn(ctx, id, role)
 }
 
-func (sm *ServiceMock) UpdateItemByID(ctx context.Context, item *model.ItemUpdate, role string) error {
-	return sm.UpdateItemByIDFn(ctx, item, role)
+func (sm *ServiceMock) UpdateItemByID(ctx context.Context, item *model.ItemUpdate, userID int, role string) error {
+	return sm.UpdateItemByIDFn(ctx, item, userID, role)
+}
+
+func (sm *ServiceMock) DeleteItemByID(ctx context.Context, id int, version *int, userID int, role, username string) error {
+	return sm.DeleteItemByIDFn(ctx, id, version, userID, role, username)
 }
 
-func (sm *ServiceMock) DeleteItemByID(ctx context.Context, id int, role, username string) error {
-	return sm.DeleteItemByIDFn(ctx, id, role, username)
+func (sm *ServiceMock) RestoreItemByID(ctx context.Context, id int, userID int, role, username string) error {
+	return sm.RestoreItemByIDFn(ctx, id, userID, role, username)
 }
 
-func (sm *ServiceMock) CreateUser(ctx context.Context, user *model.User) (string, error) {
-	return sm.CreateUserFn(ctx, user)
+func (sm *ServiceMock) RevertItemByID(ctx context.Context, id int, rv *model.ItemRevert, version *int, userID int, role, username string) error {
+	return sm.RevertItemByIDFn(ctx, id, rv, version, userID, role, username)
 }
 
-func (sm *ServiceMock) LoginUser(ctx context.Context, username string, password string, role string) (string, *model.User, error) {
+func (sm *ServiceMock) PurgeItemByID(ctx context.Context, id int, userID int, role, username string) error {
+	return sm.PurgeItemByIDFn(ctx, id, userID, role, username)
+}
+
+func (sm *ServiceMock) PurgeDeletedItems(ctx context.Context, req *model.PurgeRequest, userID int, role, username string) (*model.PurgeResult, error) {
+	return sm.PurgeDeletedItemsFn(ctx, req, userID, role, username)
+}
+
+func (sm *ServiceMock) CreateUser(ctx context.Context, user *model.User, inviteToken string) (*model.AuthTokens, error) {
+	return sm.CreateUserFn(ctx, user, inviteToken)
+}
+
+func (sm *ServiceMock) LoginUser(ctx context.Context, username string, password string, role string) (*model.AuthTokens, *model.User, *model.LoginChallenge, error) {
 	return sm.LoginUserFn(ctx, username, password, role)
 }
 
-func (sm *ServiceMock) GetItemsList(ctx context.Context, rpi *model.RequestParam, role string) ([]*model.Item, error) {
-	return sm.GetItemsListFn(ctx, rpi, role)
+func (sm *ServiceMock) CompleteLoginTOTP(ctx context.Context, challengeToken, code string) (*model.AuthTokens, *model.User, []string, error) {
+	return sm.CompleteLoginTOTPFn(ctx, challengeToken, code)
+}
+
+func (sm *ServiceMock) StartLoginTOTPEnrollment(ctx context.Context, challengeToken string) (*model.TOTPEnrollment, error) {
+	return sm.StartLoginTOTPEnrollmentFn(ctx, challengeToken)
+}
+
+func (sm *ServiceMock) ResetUserTOTP(ctx context.Context, userID int, role string) error {
+	return sm.ResetUserTOTPFn(ctx, userID, role)
+}
+
+func (sm *ServiceMock) EnrollTOTP(ctx context.Context, userID int, username string) (*model.TOTPEnrollment, error) {
+	return sm.EnrollTOTPFn(ctx, userID, username)
+}
+
+func (sm *ServiceMock) ConfirmTOTP(ctx context.Context, userID int, code string) ([]string, error) {
+	return sm.ConfirmTOTPFn(ctx, userID, code)
+}
+
+func (sm *ServiceMock) DisableTOTP(ctx context.Context, userID int, code, role string) error {
+	return sm.DisableTOTPFn(ctx, userID, code, role)
+}
+
+func (sm *ServiceMock) RefreshSession(ctx context.Context, refreshToken string) (*model.AuthTokens, *model.User, error) {
+	return sm.RefreshSessionFn(ctx, refreshToken)
+}
+
+func (sm *ServiceMock) Logout(ctx context.Context, accessToken, refreshToken string) error {
+	return sm.LogoutFn(ctx, accessToken, refreshToken)
+}
+
+func (sm *ServiceMock) GetJWKS(ctx context.Context) *model.JWKS {
+	return sm.GetJWKSFn(ctx)
+}
+
+func (sm *ServiceMock) StartOIDCLogin(ctx context.Context) (*model.OIDCFlow, string, error) {
+	return sm.StartOIDCLoginFn(ctx)
+}
+
+func (sm *ServiceMock) CompleteOIDCLogin(ctx context.Context, flow *model.OIDCFlow, state, code string) (*model.AuthTokens, *model.User, *model.LoginChallenge, error) {
+	return sm.CompleteOIDCLoginFn(ctx, flow, state, code)
+}
+
+func (sm *ServiceMock) GetUsersList(ctx context.Context, rpu *model.RequestParam, role string) ([]*model.User, error) {
+	return sm.GetUsersListFn(ctx, rpu, role)
+}
+
+func (sm *ServiceMock) ChangeUserRole(ctx context.Context, userID int, newRole string, role, username string) error {
+	return sm.ChangeUserRoleFn(ctx, userID, newRole, role, username)
+}
+
+func (sm *ServiceMock) SetUserDisabled(ctx context.Context, userID int, disabled bool, role, username string) error {
+	return sm.SetUserDisabledFn(ctx, userID, disabled, role, username)
+}
+
+func (sm *ServiceMock) ResetUserPassword(ctx context.Context, userID int, password string, role, username string) error {
+	return sm.ResetUserPasswordFn(ctx, userID, password, role, username)
+}
+
+func (sm *ServiceMock) DeleteUser(ctx context.Context, userID int, role, username string) error {
+	return sm.DeleteUserFn(ctx, userID, role, username)
+}
+
+func (sm *ServiceMock) GetUserHistory(ctx context.Context, rph *model.RequestParam, userID int, role string) ([]*model.UserHistory, error) {
+	return sm.GetUserHistoryFn(ctx, rph, userID, role)
+}
+
+func (sm *ServiceMock) GetUserRoles(ctx context.Context, userID int, role string) ([]string, error) {
+	return sm.GetUserRolesFn(ctx, userID, role)
+}
+
+func (sm *ServiceMock) GrantUserRole(ctx context.Context, userID int, grantRole string, role, username string) error {
+	return sm.GrantUserRoleFn(ctx, userID, grantRole, role, username)
+}
+
+func (sm *ServiceMock) RevokeUserRole(ctx context.Context, userID int, revokeRole string, role, username string) error {
+	return sm.RevokeUserRoleFn(ctx, userID, revokeRole, role, username)
+}
+
+func (sm *ServiceMock) RevokeUserSessions(ctx context.Context, userID int, role, username string) error {
+	return sm.RevokeUserSessionsFn(ctx, userID, role, username)
+}
+
+func (sm *ServiceMock) CreateInvite(ctx context.Context, inviteRole string, ttl time.Duration, role, username string) (*model.Invite, error) {
+	return sm.CreateInviteFn(ctx, inviteRole, ttl, role, username)
+}
+
+func (sm *ServiceMock) GetInvitesList(ctx context.Context, role string) ([]*model.Invite, error) {
+	return sm.GetInvitesListFn(ctx, role)
+}
+
+func (sm *ServiceMock) RevokeInvite(ctx context.Context, inviteID int, role string) error {
+	return sm.RevokeInviteFn(ctx, inviteID, role)
+}
+
+func (sm *ServiceMock) CreateAPIKey(ctx context.Context, name, keyRole string, ttl time.Duration, role, username string) (*model.APIKey, error) {
+	return sm.CreateAPIKeyFn(ctx, name, keyRole, ttl, role, username)
+}
+
+func (sm *ServiceMock) GetAPIKeysList(ctx context.Context, role string) ([]*model.APIKey, error) {
+	return sm.GetAPIKeysListFn(ctx, role)
+}
+
+func (sm *ServiceMock) RevokeAPIKey(ctx context.Context, keyID int, role string) error {
+	return sm.RevokeAPIKeyFn(ctx, keyID, role)
+}
+
+func (sm *ServiceMock) GetLoginAttempts(ctx context.Context, rp *model.RequestParam, filter *model.LoginAttemptFilter, role string) ([]*model.LoginAttempt, error) {
+	return sm.GetLoginAttemptsFn(ctx, rp, filter, role)
+}
+
+func (sm *ServiceMock) GetAuthEvents(ctx context.Context, rp *model.RequestParam, filter *model.AuthEventFilter, role string) ([]*model.AuthEvent, error) {
+	return sm.GetAuthEventsFn(ctx, rp, filter, role)
+}
+
+func (sm *ServiceMock) GetItemsList(ctx context.Context, rpi *model.RequestParam, filter *model.ItemFilter, role string) ([]*model.Item, error) {
+	return sm.GetItemsListFn(ctx, rpi, filter, role)
+}
+
+func (sm *ServiceMock) GetItemHistoryDiff(ctx context.Context, req *model.ItemDiffRequest, id int, role string) (*model.ItemDiff, error) {
+	return sm.GetItemHistoryDiffFn(ctx, req, id, role)
+}
+
+func (sm *ServiceMock) GetDeletedItems(ctx context.Context, rp *model.RequestParam, role string) ([]*model.Item, error) {
+	return sm.GetDeletedItemsFn(ctx, rp, role)
+}
+
+func (sm *ServiceMock) GetItemHistoryByID(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, id int, role string) ([]*model.ItemHistory, error) {
+	return sm.GetItemHistoryByIDFn(ctx, rph, filter, id, role)
+}
+
+func (sm *ServiceMock) GetItemHistoryAll(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, role string) ([]*model.ItemHistory, error) {
+	return sm.GetItemHistoryAllFn(ctx, rph, filter, role)
+}
+
+func (sm *ServiceMock) ExportItemHistoryByID(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, id int, role string) ([]*model.ItemHistory, error) {
+	return sm.ExportItemHistoryByIDFn(ctx, rph, filter, id, role)
+}
+
+func (sm *ServiceMock) ExportItemHistoryAll(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, role string) ([]*model.ItemHistory, error) {
+	return sm.ExportItemHistoryAllFn(ctx, rph, filter, role)
+}
+
+func (sm *ServiceMock) CreateWarehouse(ctx context.Context, wh *model.Warehouse, role, username string) error {
+	return sm.CreateWarehouseFn(ctx, wh, role, username)
+}
+
+func (sm *ServiceMock) GetWarehouses(ctx context.Context, role string) ([]*model.Warehouse, error) {
+	return sm.GetWarehousesFn(ctx, role)
+}
+
+func (sm *ServiceMock) GetUserWarehouses(ctx context.Context, userID int, role string) ([]int, error) {
+	return sm.GetUserWarehousesFn(ctx, userID, role)
+}
+
+func (sm *ServiceMock) GrantUserWarehouse(ctx context.Context, userID, warehouseID int, role, username string) error {
+	return sm.GrantUserWarehouseFn(ctx, userID, warehouseID, role, username)
+}
+
+func (sm *ServiceMock) RevokeUserWarehouse(ctx context.Context, userID, warehouseID int, role, username string) error {
+	return sm.RevokeUserWarehouseFn(ctx, userID, warehouseID, role, username)
+}
+
+func (sm *ServiceMock) CreateLocation(ctx context.Context, loc *model.Location, role, username string) error {
+	return sm.CreateLocationFn(ctx, loc, role, username)
+}
+
+func (sm *ServiceMock) GetLocations(ctx context.Context, warehouseID int, role string) ([]*model.Location, error) {
+	return sm.GetLocationsFn(ctx, warehouseID, role)
+}
+
+func (sm *ServiceMock) GetLocationStock(ctx context.Context, locationID int, role string) ([]*model.LocationStock, error) {
+	return sm.GetLocationStockFn(ctx, locationID, role)
+}
+
+func (sm *ServiceMock) GetItemLocations(ctx context.Context, itemID int, role string) ([]*model.LocationStock, error) {
+	return sm.GetItemLocationsFn(ctx, itemID, role)
+}
+
+func (sm *ServiceMock) MoveItemStock(ctx context.Context, mv *model.StockMove, userID int, role string) error {
+	return sm.MoveItemStockFn(ctx, mv, userID, role)
+}
+
+func (sm *ServiceMock) CreateMovement(ctx context.Context, m *model.Movement, userID int, role string) ([]*model.Movement, error) {
+	return sm.CreateMovementFn(ctx, m, userID, role)
+}
+
+func (sm *ServiceMock) AdjustItemStock(ctx context.Context, adj *model.StockAdjustment, userID int, role string) ([]*model.Movement, error) {
+	return sm.AdjustItemStockFn(ctx, adj, userID, role)
+}
+
+func (sm *ServiceMock) GetMovements(ctx context.Context, rp *model.RequestParam, filter *model.MovementFilter, role string) ([]*model.Movement, error) {
+	return sm.GetMovementsFn(ctx, rp, filter, role)
 }
 
-func (sm *ServiceMock) GetItemHistoryByID(ctx context.Context, rph *model.RequestParam, id int, role string) ([]*model.ItemHistory, error) {
-	return sm.GetItemHistoryByIDFn(ctx, rph, id, role)
+func (sm *ServiceMock) GetPolicy(ctx context.Context, role string) (map[string][]string, error) {
+	return sm.GetPolicyFn(ctx, role)
 }
 
-func (sm *ServiceMock) GetItemHistoryAll(ctx context.Context, rph *model.RequestParam, role string) ([]*model.ItemHistory, error) {
-	return sm.GetItemHistoryAllFn(ctx, rph, role)
+func (sm *ServiceMock) ReloadPolicy(ctx context.Context, role string) (map[string][]string, error) {
+	return sm.ReloadPolicyFn(ctx, role)
 }
@@ -19,29 +19,22 @@ func (whc *WHCHandlers) SimplePinger(ctx *ginext.Context) {
 }
 
 func (whc *WHCHandlers) SignUpUser(ctx *gin.Context) {
-	var newUser model.User
+	var req signupRequest
 
-	if err := ctx.ShouldBindJSON(&newUser); err != nil {
+	if err := ctx.ShouldBindJSON(&req); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user payload"})
 		return
 	}
+	newUser := model.User{UserName: req.UserName, PassHash: req.Password}
 
-	token, err := whc.svc.CreateUser(ctx.Request.Context(), &newUser)
+	tokens, err := whc.svc.CreateUser(ctx.Request.Context(), &newUser, req.InviteToken)
 	if err != nil {
 		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
 		return
 	}
 	resp := convertUserAuthToResponse(&newUser)
 
-	http.SetCookie(ctx.Writer, &http.Cookie{
-		Name:     "access_token",
-		Value:    token,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   3600,
-	})
+	setAuthCookies(ctx, tokens)
 
 	ctx.JSON(http.StatusCreated, resp)
 }
@@ -54,26 +47,61 @@ func (whc *WHCHandlers) LoginUser(ctx *gin.Context) {
 		return
 	}
 
-	token, user, err := whc.svc.LoginUser(ctx.Request.Context(), req.UserName, req.Password, req.Role)
+	tokens, user, challenge, err := whc.svc.LoginUser(ctx.Request.Context(), req.UserName, req.Password, req.Role)
 	if err != nil {
 		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
 		return
 	}
+
+	// нужен второй фактор - cookies сессии выдаст POST /auth/login/totp
+	if challenge != nil {
+		ctx.JSON(http.StatusOK, gin.H{"challenge": challenge})
+		return
+	}
 	resp := convertUserAuthToResponse(user)
 
-	http.SetCookie(ctx.Writer, &http.Cookie{
-		Name:     "access_token",
-		Value:    token,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   3600,
-	})
+	setAuthCookies(ctx, tokens)
 
 	ctx.JSON(http.StatusOK, resp)
 }
 
+func (whc *WHCHandlers) RefreshSession(ctx *gin.Context) {
+	refresh, _ := ctx.Cookie(refreshCookieName)
+
+	tokens, user, err := whc.svc.RefreshSession(ctx.Request.Context(), refresh)
+	if err != nil {
+		// невалидный refresh - сессия окончена, чистим куки, чтобы клиент ушел на логин
+		clearAuthCookies(ctx)
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	setAuthCookies(ctx, tokens)
+
+	ctx.JSON(http.StatusOK, convertUserAuthToResponse(user))
+}
+
+func (whc *WHCHandlers) Logout(ctx *gin.Context) {
+	access, _ := ctx.Cookie(accessCookieName)
+	refresh, _ := ctx.Cookie(refreshCookieName)
+
+	// куки чистим в любом случае - даже если отзыв на сервере не удался
+	clearAuthCookies(ctx)
+
+	if err := whc.svc.Logout(ctx.Request.Context(), access, refresh); err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (whc *WHCHandlers) GetJWKS(ctx *gin.Context) {
+	// ключи меняются только при ротации - сторонним сервисам можно кэшировать ответ
+	ctx.Header("Cache-Control", "public, max-age=300")
+	ctx.JSON(http.StatusOK, whc.svc.GetJWKS(ctx.Request.Context()))
+}
+
 func (whc *WHCHandlers) CreateItem(ctx *gin.Context) {
 	// логируем role-sensitive запрос
 	rid := stringFromCtx(ctx, "request_id")
@@ -91,7 +119,7 @@ func (whc *WHCHandlers) CreateItem(ctx *gin.Context) {
 	}
 
 	// передаем в ервис
-	if err := whc.svc.CreateItem(ctx.Request.Context(), &item, role); err != nil {
+	if err := whc.svc.CreateItem(ctx.Request.Context(), &item, uid, role); err != nil {
 		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
 		return
 	}
@@ -116,6 +144,8 @@ func (whc *WHCHandlers) GetItemByID(ctx *gin.Context) {
 		return
 	}
 
+	// версия нужна клиенту для If-Match при изменении и удалении
+	ctx.Header("ETag", itemETag(res.Version))
 	ctx.JSON(http.StatusOK, res)
 }
 
@@ -141,11 +171,17 @@ func (whc *WHCHandlers) UpdateItem(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid item payload"})
 		return
 	}
+	version, err := decodeIfMatch(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	item.UpdatedBy = userName
 	item.ID = id
+	item.Version = version
 
 	// передаем в сервис
-	if err := whc.svc.UpdateItemByID(ctx.Request.Context(), &item, role); err != nil {
+	if err := whc.svc.UpdateItemByID(ctx.Request.Context(), &item, uid, role); err != nil {
 		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
 		return
 	}
@@ -170,8 +206,14 @@ func (whc *WHCHandlers) DeleteItem(ctx *gin.Context) {
 
 	log.Printf("rid=%q userID=%d userName=%q role=%q deleting item #%d", rid, uid, username, role, id)
 
+	version, err := decodeIfMatch(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// передаем в сервис
-	err := whc.svc.DeleteItemByID(ctx.Request.Context(), id, role, username)
+	err = whc.svc.DeleteItemByID(ctx.Request.Context(), id, version, uid, role, username)
 	if err != nil {
 		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
 		return
@@ -180,6 +222,142 @@ func (whc *WHCHandlers) DeleteItem(ctx *gin.Context) {
 	ctx.JSON(http.StatusNoContent, nil)
 }
 
+func (whc *WHCHandlers) RestoreItem(ctx *gin.Context) {
+	// логируем role-sensitive запрос
+	rid := stringFromCtx(ctx, "request_id")
+	uid := intFromCtx(ctx, "user_id")
+	username := stringFromCtx(ctx, "username")
+	role := stringFromCtx(ctx, "role")
+
+	// определяем id
+	rawID, ok := ctx.Params.Get("id")
+	if !ok {
+		ctx.JSON(400, gin.H{"error": "empty item id"})
+		return
+	}
+	id := stringToInt(rawID)
+
+	log.Printf("rid=%q userID=%d userName=%q role=%q restoring item #%d", rid, uid, username, role, id)
+
+	// передаем в сервис
+	if err := whc.svc.RestoreItemByID(ctx.Request.Context(), id, uid, role, username); err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// RevertItem откатывает товар к состоянию после указанной версии истории
+func (whc *WHCHandlers) RevertItem(ctx *gin.Context) {
+	// логируем role-sensitive запрос
+	rid := stringFromCtx(ctx, "request_id")
+	uid := intFromCtx(ctx, "user_id")
+	username := stringFromCtx(ctx, "username")
+	role := stringFromCtx(ctx, "role")
+
+	// определяем id
+	rawID, ok := ctx.Params.Get("id")
+	if !ok {
+		ctx.JSON(400, gin.H{"error": "empty item id"})
+		return
+	}
+	id := stringToInt(rawID)
+
+	var rv model.ItemRevert
+	if err := ctx.ShouldBindJSON(&rv); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": model.ErrInvalidRevertVersion.Error()})
+		return
+	}
+	version, err := decodeIfMatch(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	log.Printf("rid=%q userID=%d userName=%q role=%q reverting item #%d to version %d", rid, uid, username, role, id, rv.Version)
+
+	// передаем в сервис
+	if err := whc.svc.RevertItemByID(ctx.Request.Context(), id, &rv, version, uid, role, username); err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// PurgeItem окончательно удаляет товар, уже лежащий в корзине
+func (whc *WHCHandlers) PurgeItem(ctx *gin.Context) {
+	// логируем role-sensitive запрос
+	rid := stringFromCtx(ctx, "request_id")
+	uid := intFromCtx(ctx, "user_id")
+	username := stringFromCtx(ctx, "username")
+	role := stringFromCtx(ctx, "role")
+
+	// определяем id
+	rawID, ok := ctx.Params.Get("id")
+	if !ok {
+		ctx.JSON(400, gin.H{"error": "empty item id"})
+		return
+	}
+	id := stringToInt(rawID)
+
+	log.Printf("rid=%q userID=%d userName=%q role=%q purging item #%d", rid, uid, username, role, id)
+
+	// передаем в сервис
+	if err := whc.svc.PurgeItemByID(ctx.Request.Context(), id, uid, role, username); err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// PurgeDeletedItems очищает корзину от товаров старше срока хранения; с dry_run=true только показывает их
+func (whc *WHCHandlers) PurgeDeletedItems(ctx *gin.Context) {
+	// логируем role-sensitive запрос
+	rid := stringFromCtx(ctx, "request_id")
+	uid := intFromCtx(ctx, "user_id")
+	username := stringFromCtx(ctx, "username")
+	role := stringFromCtx(ctx, "role")
+
+	req := model.PurgeRequest{}
+	if err := decodePurgeRequest(ctx, &req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	log.Printf("rid=%q userID=%d userName=%q role=%q purging deleted items, dry run: %t", rid, uid, username, role, req.DryRun)
+
+	// передаем в сервис
+	res, err := whc.svc.PurgeDeletedItems(ctx.Request.Context(), &req, uid, role, username)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, res)
+}
+
+// GetDeletedItems - корзина: удаленные товары с автором и временем удаления
+func (whc *WHCHandlers) GetDeletedItems(ctx *gin.Context) {
+	// парсим параметры запроса из URL
+	rp := model.RequestParam{}
+	if err := decodeQueryParams(ctx, &rp); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role := stringFromCtx(ctx, "role")
+	res, err := whc.svc.GetDeletedItems(ctx.Request.Context(), &rp, role)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, res)
+}
+
 func (whc *WHCHandlers) GetItemsList(ctx *gin.Context) {
 	// парсим параметры запроса из URL
 	rpi := model.RequestParam{}
@@ -187,9 +365,14 @@ func (whc *WHCHandlers) GetItemsList(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	filter := model.ItemFilter{}
+	if err := decodeItemFilter(ctx, &filter); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	role := stringFromCtx(ctx, "role")
-	res, err := whc.svc.GetItemsList(ctx.Request.Context(), &rpi, role)
+	res, err := whc.svc.GetItemsList(ctx.Request.Context(), &rpi, &filter, role)
 	if err != nil {
 		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
 		return
@@ -205,6 +388,11 @@ func (whc *WHCHandlers) GetItemHistoryByID(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	filter := model.HistoryFilter{}
+	if err := decodeHistoryFilter(ctx, &filter); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	// определяем id и роль
 	role := stringFromCtx(ctx, "role")
@@ -216,7 +404,35 @@ func (whc *WHCHandlers) GetItemHistoryByID(ctx *gin.Context) {
 	id := stringToInt(rawID)
 
 	// обращаемся к сервису
-	res, err := whc.svc.GetItemHistoryByID(ctx.Request.Context(), &rph, id, role)
+	res, err := whc.svc.GetItemHistoryByID(ctx.Request.Context(), &rph, &filter, id, role)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, res)
+}
+
+// GetItemHistoryDiff - отличия между версиями товара from и to
+func (whc *WHCHandlers) GetItemHistoryDiff(ctx *gin.Context) {
+	// парсим параметры запроса из URL
+	req := model.ItemDiffRequest{}
+	if err := decodeItemDiffRequest(ctx, &req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": model.ErrInvalidVersionRange.Error()})
+		return
+	}
+
+	// определяем id и роль
+	role := stringFromCtx(ctx, "role")
+	rawID, ok := ctx.Params.Get("id")
+	if !ok {
+		ctx.JSON(400, gin.H{"error": "empty item id"})
+		return
+	}
+	id := stringToInt(rawID)
+
+	// обращаемся к сервису
+	res, err := whc.svc.GetItemHistoryDiff(ctx.Request.Context(), &req, id, role)
 	if err != nil {
 		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
 		return
@@ -232,12 +448,17 @@ func (whc *WHCHandlers) GetItemsHistoryList(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	filter := model.HistoryFilter{}
+	if err := decodeHistoryFilter(ctx, &filter); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	// определяем роль
 	role := stringFromCtx(ctx, "role")
 
 	// обращаемся к сервису
-	res, err := whc.svc.GetItemHistoryAll(ctx.Request.Context(), &rph, role)
+	res, err := whc.svc.GetItemHistoryAll(ctx.Request.Context(), &rph, &filter, role)
 	if err != nil {
 		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
 		return
@@ -253,12 +474,17 @@ func (whc *WHCHandlers) ExportItemsHistoryCSV(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	filter := model.HistoryFilter{}
+	if err := decodeHistoryFilter(ctx, &filter); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	// определяем роль
 	role := stringFromCtx(ctx, "role")
 
 	// обращаемся к сервису
-	res, err := whc.svc.GetItemHistoryAll(ctx.Request.Context(), &rph, role)
+	res, err := whc.svc.ExportItemHistoryAll(ctx.Request.Context(), &rph, &filter, role)
 	if err != nil {
 		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
 		return
@@ -297,11 +523,16 @@ func (whc *WHCHandlers) ExportItemsCSV(ctx *ginext.Context) {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	filter := model.ItemFilter{}
+	if err := decodeItemFilter(ctx, &filter); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	// определяем роль
 	role := stringFromCtx(ctx, "role")
 
 	// получаем массив строк
-	res, err := whc.svc.GetItemsList(ctx.Request.Context(), &rpi, role)
+	res, err := whc.svc.GetItemsList(ctx.Request.Context(), &rpi, &filter, role)
 	if err != nil {
 		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
 		return
@@ -339,6 +570,11 @@ func (whc *WHCHandlers) ExportItemIDHistoryCSV(ctx *ginext.Context) {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	filter := model.HistoryFilter{}
+	if err := decodeHistoryFilter(ctx, &filter); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	// определяем id товара и роль юзера
 	rawID, ok := ctx.Params.Get("id")
@@ -350,7 +586,7 @@ func (whc *WHCHandlers) ExportItemIDHistoryCSV(ctx *ginext.Context) {
 	role := stringFromCtx(ctx, "role")
 
 	// получаем массив History от сервиса
-	res, err := whc.svc.GetItemHistoryByID(ctx.Request.Context(), &rpa, id, role)
+	res, err := whc.svc.ExportItemHistoryByID(ctx.Request.Context(), &rpa, &filter, id, role)
 	if err != nil {
 		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
 		return
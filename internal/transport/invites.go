@@ -0,0 +1,71 @@
+package transport
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (whc *WHCHandlers) CreateInvite(ctx *gin.Context) {
+	// логируем role-sensitive запрос
+	rid := stringFromCtx(ctx, "request_id")
+	uid := intFromCtx(ctx, "user_id")
+	userName := stringFromCtx(ctx, "username")
+	role := stringFromCtx(ctx, "role")
+
+	// читаем роль и срок действия инвайта
+	var req inviteRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid invite payload"})
+		return
+	}
+	log.Printf("rid=%q userID=%d userName=%q role=%q creating invite for role %q", rid, uid, userName, role, req.Role)
+
+	// передаем в сервис
+	res, err := whc.svc.CreateInvite(ctx.Request.Context(), req.Role, time.Duration(req.ExpiresInHours)*time.Hour, role, userName)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, res)
+}
+
+func (whc *WHCHandlers) GetInvitesList(ctx *gin.Context) {
+	role := stringFromCtx(ctx, "role")
+
+	res, err := whc.svc.GetInvitesList(ctx.Request.Context(), role)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, res)
+}
+
+func (whc *WHCHandlers) RevokeInvite(ctx *gin.Context) {
+	// логируем role-sensitive запрос
+	rid := stringFromCtx(ctx, "request_id")
+	uid := intFromCtx(ctx, "user_id")
+	userName := stringFromCtx(ctx, "username")
+	role := stringFromCtx(ctx, "role")
+
+	// определяем id
+	rawID, ok := ctx.Params.Get("id")
+	if !ok {
+		ctx.JSON(400, gin.H{"error": "empty invite id"})
+		return
+	}
+	id := stringToInt(rawID)
+	log.Printf("rid=%q userID=%d userName=%q role=%q revoking invite #%d", rid, uid, userName, role, id)
+
+	// передаем в сервис
+	if err := whc.svc.RevokeInvite(ctx.Request.Context(), id, role); err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
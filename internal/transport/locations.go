@@ -0,0 +1,142 @@
+package transport
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+	"github.com/gin-gonic/gin"
+)
+
+func (whc *WHCHandlers) CreateLocation(ctx *gin.Context) {
+	// логируем role-sensitive запрос
+	rid := stringFromCtx(ctx, "request_id")
+	uid := intFromCtx(ctx, "user_id")
+	userName := stringFromCtx(ctx, "username")
+	role := stringFromCtx(ctx, "role")
+
+	// определяем склад
+	rawID, ok := ctx.Params.Get("id")
+	if !ok {
+		ctx.JSON(400, gin.H{"error": "empty warehouse id"})
+		return
+	}
+
+	var loc model.Location
+	if err := ctx.ShouldBindJSON(&loc); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid location payload"})
+		return
+	}
+	loc.WarehouseID = stringToInt(rawID)
+	log.Printf("rid=%q userID=%d userName=%q role=%q creating location %q in warehouse #%d", rid, uid, userName, role, loc.Code, loc.WarehouseID)
+
+	// передаем в сервис
+	if err := whc.svc.CreateLocation(ctx.Request.Context(), &loc, role, userName); err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, loc)
+}
+
+func (whc *WHCHandlers) GetLocations(ctx *gin.Context) {
+	role := stringFromCtx(ctx, "role")
+	rawID, ok := ctx.Params.Get("id")
+	if !ok {
+		ctx.JSON(400, gin.H{"error": "empty warehouse id"})
+		return
+	}
+
+	res, err := whc.svc.GetLocations(ctx.Request.Context(), stringToInt(rawID), role)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, res)
+}
+
+func (whc *WHCHandlers) GetLocationStock(ctx *gin.Context) {
+	role := stringFromCtx(ctx, "role")
+	rawID, ok := ctx.Params.Get("id")
+	if !ok {
+		ctx.JSON(400, gin.H{"error": "empty location id"})
+		return
+	}
+
+	res, err := whc.svc.GetLocationStock(ctx.Request.Context(), stringToInt(rawID), role)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, res)
+}
+
+func (whc *WHCHandlers) GetItemLocations(ctx *gin.Context) {
+	role := stringFromCtx(ctx, "role")
+	rawID, ok := ctx.Params.Get("id")
+	if !ok {
+		ctx.JSON(400, gin.H{"error": "empty item id"})
+		return
+	}
+
+	res, err := whc.svc.GetItemLocations(ctx.Request.Context(), stringToInt(rawID), role)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, res)
+}
+
+// PutawayItem размещает товар из ячейки приемки в ячейку хранения
+func (whc *WHCHandlers) PutawayItem(ctx *gin.Context) {
+	whc.moveItemStock(ctx, false)
+}
+
+// MoveItem переносит товар между ячейками одного склада
+func (whc *WHCHandlers) MoveItem(ctx *gin.Context) {
+	whc.moveItemStock(ctx, true)
+}
+
+func (whc *WHCHandlers) moveItemStock(ctx *gin.Context, withSource bool) {
+	// логируем role-sensitive запрос
+	rid := stringFromCtx(ctx, "request_id")
+	uid := intFromCtx(ctx, "user_id")
+	userName := stringFromCtx(ctx, "username")
+	role := stringFromCtx(ctx, "role")
+
+	// определяем id
+	rawID, ok := ctx.Params.Get("id")
+	if !ok {
+		ctx.JSON(400, gin.H{"error": "empty item id"})
+		return
+	}
+
+	var mv model.StockMove
+	if err := ctx.ShouldBindJSON(&mv); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid stock move payload"})
+		return
+	}
+
+	// при размещении источник - всегда приемка, при перемещении он обязателен
+	switch {
+	case !withSource:
+		mv.FromLocationID = nil
+	case mv.FromLocationID == nil:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": model.ErrIncorrectLocationID.Error()})
+		return
+	}
+	mv.ItemID = stringToInt(rawID)
+	mv.UpdatedBy = userName
+	log.Printf("rid=%q userID=%d userName=%q role=%q moving %d of item #%d to location #%d", rid, uid, userName, role, mv.Amount, mv.ItemID, mv.ToLocationID)
+
+	// передаем в сервис
+	if err := whc.svc.MoveItemStock(ctx.Request.Context(), &mv, uid, role); err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
@@ -0,0 +1,140 @@
+package transport
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+	"github.com/gin-gonic/gin"
+)
+
+func (whc *WHCHandlers) CreateMovement(ctx *gin.Context) {
+	// логируем role-sensitive запрос
+	rid := stringFromCtx(ctx, "request_id")
+	uid := intFromCtx(ctx, "user_id")
+	userName := stringFromCtx(ctx, "username")
+	role := stringFromCtx(ctx, "role")
+
+	var m model.Movement
+	if err := ctx.ShouldBindJSON(&m); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid movement payload"})
+		return
+	}
+	m.CreatedBy = userName
+	log.Printf("rid=%q userID=%d userName=%q role=%q creating %q movement of %d for item #%d", rid, uid, userName, role, m.Type, m.Quantity, m.ItemID)
+
+	// передаем в сервис
+	res, err := whc.svc.CreateMovement(ctx.Request.Context(), &m, uid, role)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, res)
+}
+
+func (whc *WHCHandlers) AdjustItemStock(ctx *gin.Context) {
+	// логируем role-sensitive запрос
+	rid := stringFromCtx(ctx, "request_id")
+	uid := intFromCtx(ctx, "user_id")
+	userName := stringFromCtx(ctx, "username")
+	role := stringFromCtx(ctx, "role")
+
+	// определяем id
+	rawID, ok := ctx.Params.Get("id")
+	if !ok {
+		ctx.JSON(400, gin.H{"error": "empty item id"})
+		return
+	}
+
+	var adj model.StockAdjustment
+	if err := ctx.ShouldBindJSON(&adj); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": model.ErrInvalidStockDelta.Error()})
+		return
+	}
+	adj.ItemID = stringToInt(rawID)
+	adj.UpdatedBy = userName
+	log.Printf("rid=%q userID=%d userName=%q role=%q adjusting stock of item #%d by %d", rid, uid, userName, role, adj.ItemID, adj.Delta)
+
+	// передаем в сервис
+	res, err := whc.svc.AdjustItemStock(ctx.Request.Context(), &adj, uid, role)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, res)
+}
+
+func (whc *WHCHandlers) GetMovements(ctx *gin.Context) {
+	// парсим параметры запроса и фильтры из URL
+	rp := model.RequestParam{}
+	if err := decodeQueryParams(ctx, &rp); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter := model.MovementFilter{}
+	if err := decodeMovementFilter(ctx, &filter); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role := stringFromCtx(ctx, "role")
+	res, err := whc.svc.GetMovements(ctx.Request.Context(), &rp, &filter, role)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, res)
+}
+
+func (whc *WHCHandlers) ExportMovementsCSV(ctx *gin.Context) {
+	// парсим параметры запроса и фильтры из URL
+	rp := model.RequestParam{}
+	if err := decodeQueryParams(ctx, &rp); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter := model.MovementFilter{}
+	if err := decodeMovementFilter(ctx, &filter); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// обращаемся к сервису
+	role := stringFromCtx(ctx, "role")
+	res, err := whc.svc.GetMovements(ctx.Request.Context(), &rp, &filter, role)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	// устанавливаем хедеры под CSV
+	ctx.Writer.Header().Set("Cache-Control", "no-store")
+	ctx.Writer.Header().Set("Pragma", "no-cache")
+	ctx.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Disposition")
+	ctx.Writer.Header().Set("Content-Type", "text/csv")
+	ctx.Writer.Header().Set("Content-Disposition", "attachment; filename=movements.csv")
+
+	// готовим и пишем данные
+	rows, err := convertMovementsToCSV(ctx.Request.Context(), res)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			ctx.Status(http.StatusGatewayTimeout)
+			return
+		}
+	}
+
+	writer := csv.NewWriter(ctx.Writer)
+	if err := writer.WriteAll(rows); err != nil {
+		log.Printf("failed to Flush csv-writer: %q", err.Error())
+		return
+	}
+}
@@ -0,0 +1,61 @@
+package transport
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+	"github.com/gin-gonic/gin"
+)
+
+// uiPath - куда возвращаем браузер после успешного входа через IdP
+const uiPath = "/ui/"
+
+func (whc *WHCHandlers) OIDCLogin(ctx *gin.Context) {
+	flow, redirectURL, err := whc.svc.StartOIDCLogin(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	setOIDCFlowCookie(ctx, flow)
+
+	ctx.Redirect(http.StatusFound, redirectURL)
+}
+
+func (whc *WHCHandlers) OIDCCallback(ctx *gin.Context) {
+	flow := popOIDCFlowCookie(ctx)
+
+	// пользователь отменил вход или IdP отказал
+	if ctx.Query("error") != "" {
+		ctx.JSON(errorCodeDefiner(model.ErrOIDCLoginFailed), gin.H{"error": model.ErrOIDCLoginFailed.Error()})
+		return
+	}
+
+	tokens, _, challenge, err := whc.svc.CompleteOIDCLogin(ctx.Request.Context(), flow, ctx.Query("state"), ctx.Query("code"))
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	// нужен второй фактор - сессии еще нет, UI спросит код по челленджу. Челлендж передается во фрагменте:
+	// фрагмент не уходит на сервер и не попадает в логи запросов
+	if challenge != nil {
+		ctx.Redirect(http.StatusFound, uiPath+"#"+oidcChallengeFragment(challenge))
+		return
+	}
+
+	setAuthCookies(ctx, tokens)
+
+	ctx.Redirect(http.StatusFound, uiPath)
+}
+
+// oidcChallengeFragment кодирует челлендж второго фактора для UI
+func oidcChallengeFragment(challenge *model.LoginChallenge) string {
+	v := url.Values{}
+	v.Set("challenge", challenge.Token)
+	if challenge.EnrollmentRequired {
+		v.Set("enrollment_required", "true")
+	}
+	return v.Encode()
+}
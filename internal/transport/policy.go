@@ -0,0 +1,37 @@
+package transport
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (whc *WHCHandlers) GetPolicy(ctx *gin.Context) {
+	role := stringFromCtx(ctx, "role")
+
+	res, err := whc.svc.GetPolicy(ctx.Request.Context(), role)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"roles": res})
+}
+
+func (whc *WHCHandlers) ReloadPolicy(ctx *gin.Context) {
+	// логируем role-sensitive запрос
+	rid := stringFromCtx(ctx, "request_id")
+	uid := intFromCtx(ctx, "user_id")
+	userName := stringFromCtx(ctx, "username")
+	role := stringFromCtx(ctx, "role")
+	log.Printf("rid=%q userID=%d userName=%q role=%q reloading policy", rid, uid, userName, role)
+
+	res, err := whc.svc.ReloadPolicy(ctx.Request.Context(), role)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"roles": res})
+}
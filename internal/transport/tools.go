@@ -2,15 +2,134 @@ package transport
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/UnendingLoop/WarehouseControl/internal/model"
+	"github.com/UnendingLoop/WarehouseControl/internal/mwauthlog"
+	"github.com/gin-gonic/gin"
 )
 
+const (
+	accessCookieName  = "access_token"
+	refreshCookieName = "refresh_token"
+	refreshCookiePath = "/auth" // refresh-токен нужен только эндпоинтам /auth/refresh и /auth/logout
+
+	oidcFlowCookieName = "oidc_flow"
+	oidcFlowCookiePath = "/auth/oidc"
+	oidcFlowTTL        = 10 * time.Minute // сколько можно провести на странице логина IdP
+)
+
+func setAuthCookies(ctx *gin.Context, tokens *model.AuthTokens) {
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:     accessCookieName,
+		Value:    tokens.AccessToken,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(time.Until(tokens.AccessExpiresAt).Seconds()),
+	})
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:     refreshCookieName,
+		Value:    tokens.RefreshToken,
+		Path:     refreshCookiePath,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   int(time.Until(tokens.RefreshExpiresAt).Seconds()),
+	})
+	// CSRF-токен меняется вместе с сессией и живет, пока ее можно продлить; HttpOnly нет - UI читает его из JS
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:     mwauthlog.CSRFCookie,
+		Value:    newCSRFToken(),
+		Path:     "/",
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(time.Until(tokens.RefreshExpiresAt).Seconds()),
+	})
+}
+
+func newCSRFToken() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func clearAuthCookies(ctx *gin.Context) {
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:     accessCookieName,
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:     refreshCookieName,
+		Value:    "",
+		Path:     refreshCookiePath,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   -1,
+	})
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:     mwauthlog.CSRFCookie,
+		Value:    "",
+		Path:     "/",
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
+}
+
+// setOIDCFlowCookie сохраняет state/nonce/verifier попытки логина до возврата из IdP.
+// SameSite=Lax - иначе cookie не придет в callback, куда браузер перенаправляет со страницы IdP
+func setOIDCFlowCookie(ctx *gin.Context, flow *model.OIDCFlow) {
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:     oidcFlowCookieName,
+		Value:    strings.Join([]string{flow.State, flow.Nonce, flow.Verifier}, "."),
+		Path:     oidcFlowCookiePath,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(oidcFlowTTL.Seconds()),
+	})
+}
+
+// popOIDCFlowCookie читает и сразу удаляет cookie попытки логина - повторно она не используется
+func popOIDCFlowCookie(ctx *gin.Context) *model.OIDCFlow {
+	raw, err := ctx.Cookie(oidcFlowCookieName)
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:     oidcFlowCookieName,
+		Value:    "",
+		Path:     oidcFlowCookiePath,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
+	if err != nil {
+		return nil
+	}
+
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	return &model.OIDCFlow{State: parts[0], Nonce: parts[1], Verifier: parts[2]}
+}
+
 func convertHistoryToCSV(ctx context.Context, input []*model.ItemHistory) ([][]string, error) {
 	result := make([][]string, 0, len(input)+1)
-	start := []string{"id", "item_id", "version", "action", "changed_at", "changed_by", "old_data", "new_data"}
+	start := []string{"id", "item_id", "version", "action", "changed_at", "changed_by", "warehouse_id", "stock_delta", "old_data", "new_data"}
 	result = append(result, start)
 
 	for _, v := range input {
@@ -30,6 +149,16 @@ func convertHistoryToCSV(ctx context.Context, input []*model.ItemHistory) ([][]s
 				newData = string(*v.NewData)
 			}
 
+			warehouseID := ""
+			if v.WarehouseID != nil {
+				warehouseID = strconv.Itoa(*v.WarehouseID)
+			}
+
+			stockDelta := ""
+			if v.StockDelta != nil {
+				stockDelta = strconv.Itoa(*v.StockDelta)
+			}
+
 			row = append(row,
 				strconv.Itoa(v.ID),
 				strconv.Itoa(v.ItemID),
@@ -37,6 +166,8 @@ func convertHistoryToCSV(ctx context.Context, input []*model.ItemHistory) ([][]s
 				v.Action,
 				v.ChangedAt.Format("2006-01-02 15:04:05"),
 				v.ChangedBy,
+				warehouseID,
+				stockDelta,
 				oldData,
 				newData)
 			result = append(result, row)
@@ -45,6 +176,101 @@ func convertHistoryToCSV(ctx context.Context, input []*model.ItemHistory) ([][]s
 	return result, nil
 }
 
+func convertAuthEventsToCSV(ctx context.Context, input []*model.AuthEvent) ([][]string, error) {
+	result := make([][]string, 0, len(input)+1)
+	start := []string{"id", "type", "created_at", "user_id", "username", "role", "status", "detail", "method", "path", "request_id", "ip", "user_agent"}
+	result = append(result, start)
+
+	for _, v := range input {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+			userID := ""
+			if v.UserID != 0 {
+				userID = strconv.Itoa(v.UserID)
+			}
+
+			status := ""
+			if v.Status != 0 {
+				status = strconv.Itoa(v.Status)
+			}
+
+			row := []string{
+				strconv.FormatInt(v.ID, 10),
+				v.Type,
+				v.CreatedAt.Format("2006-01-02 15:04:05"),
+				userID,
+				v.Username,
+				v.Role,
+				status,
+				v.Detail,
+				v.Method,
+				v.Path,
+				v.RequestID,
+				v.IP,
+				v.UserAgent}
+			result = append(result, row)
+		}
+	}
+	return result, nil
+}
+
+func convertMovementsToCSV(ctx context.Context, input []*model.Movement) ([][]string, error) {
+	result := make([][]string, 0, len(input)+1)
+	start := []string{"id", "created_at", "item_id", "type", "warehouse_id", "location_id", "quantity", "balance_after", "reason_code", "reference", "comment", "related_id", "created_by"}
+	result = append(result, start)
+
+	for _, v := range input {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+			warehouseID := ""
+			if v.WarehouseID != nil {
+				warehouseID = strconv.Itoa(*v.WarehouseID)
+			}
+
+			locationID := ""
+			if v.LocationID != nil {
+				locationID = strconv.Itoa(*v.LocationID)
+			}
+
+			reference := ""
+			if v.Reference != nil {
+				reference = *v.Reference
+			}
+
+			comment := ""
+			if v.Comment != nil {
+				comment = *v.Comment
+			}
+
+			relatedID := ""
+			if v.RelatedID != nil {
+				relatedID = strconv.FormatInt(*v.RelatedID, 10)
+			}
+
+			row := []string{
+				strconv.FormatInt(v.ID, 10),
+				v.CreatedAt.Format("2006-01-02 15:04:05"),
+				strconv.Itoa(v.ItemID),
+				v.Type,
+				warehouseID,
+				locationID,
+				strconv.Itoa(v.Quantity),
+				strconv.Itoa(v.BalanceAfter),
+				v.ReasonCode,
+				reference,
+				comment,
+				relatedID,
+				v.CreatedBy}
+			result = append(result, row)
+		}
+	}
+	return result, nil
+}
+
 func convertItemsToCSV(ctx context.Context, input []*model.Item) ([][]string, error) {
 	result := make([][]string, 0, len(input)+1)
 	start := []string{"item_id", "title", "description", "price", "visible", "available_amount", "created_at", "updated_at", "deleted_at"}
@@ -94,21 +320,87 @@ func errorCodeDefiner(err error) int { // потом можно сделать 
 		errors.Is(err, model.ErrInvalidRequestParam),
 		errors.Is(err, model.ErrEmptyUser),
 		errors.Is(err, model.ErrIncorrectItemID),
+		errors.Is(err, model.ErrIncorrectUserID),
 		errors.Is(err, model.ErrIncorrectUserName),
 		errors.Is(err, model.ErrIncorrectUserRole),
 		errors.Is(err, model.ErrEmptyItemInfo),
 		errors.Is(err, model.ErrEmptyTitle),
 		errors.Is(err, model.ErrInvalidPrice),
 		errors.Is(err, model.ErrInvalidAvail),
-		errors.Is(err, model.ErrNoFieldsToUpdate):
+		errors.Is(err, model.ErrNoFieldsToUpdate),
+		errors.Is(err, model.ErrEmptyPassword),
+		errors.Is(err, model.ErrInvalidInviteTTL),
+		errors.Is(err, model.ErrInvalidAPIKeyTTL),
+		errors.Is(err, model.ErrEmptyAPIKeyName),
+		errors.Is(err, model.ErrEmptyOTPCode),
+		errors.Is(err, model.ErrInvalidEventType),
+		errors.Is(err, model.ErrIncorrectWarehouseID),
+		errors.Is(err, model.ErrEmptyWarehouseInfo),
+		errors.Is(err, model.ErrIncorrectLocationID),
+		errors.Is(err, model.ErrEmptyLocationInfo),
+		errors.Is(err, model.ErrInvalidLocationKind),
+		errors.Is(err, model.ErrInvalidLocationTree),
+		errors.Is(err, model.ErrInvalidMoveAmount),
+		errors.Is(err, model.ErrInvalidStockMove),
+		errors.Is(err, model.ErrInvalidMovementType),
+		errors.Is(err, model.ErrInvalidMovementQty),
+		errors.Is(err, model.ErrInvalidStockDelta),
+		errors.Is(err, model.ErrInvalidIfMatch),
+		errors.Is(err, model.ErrInvalidRetention),
+		errors.Is(err, model.ErrInvalidVersionRange),
+		errors.Is(err, model.ErrInvalidRevertVersion),
+		errors.Is(err, model.ErrAsOfWithWarehouse),
+		errors.Is(err, model.ErrInvalidHistoryAction),
+		errors.Is(err, model.ErrInvalidReasonCode),
+		errors.Is(err, model.ErrInvalidTransfer),
+		errors.Is(err, model.ErrLocationNotBin):
 		return 400
-	case errors.Is(err, model.ErrAccessDenied):
+	case errors.Is(err, model.ErrInvalidRefreshToken),
+		errors.Is(err, model.ErrSessionRevoked),
+		errors.Is(err, model.ErrInvalidAPIKey),
+		errors.Is(err, model.ErrInvalidOIDCState),
+		errors.Is(err, model.ErrOIDCLoginFailed),
+		errors.Is(err, model.ErrInvalidChallenge),
+		errors.Is(err, model.ErrInvalidOTP):
+		return 401
+	case errors.Is(err, model.ErrAccessDenied),
+		errors.Is(err, model.ErrUserDisabled),
+		errors.Is(err, model.ErrSignupDisabled),
+		errors.Is(err, model.ErrInviteRequired),
+		errors.Is(err, model.ErrInvalidInvite),
+		errors.Is(err, model.ErrNoOIDCRole),
+		errors.Is(err, model.ErrTOTPRequired),
+		errors.Is(err, model.ErrWarehouseAccessDenied):
 		return 403
 	case errors.Is(err, model.ErrUserNotFound),
-		errors.Is(err, model.ErrItemNotFound):
+		errors.Is(err, model.ErrItemNotFound),
+		errors.Is(err, model.ErrRoleNotGranted),
+		errors.Is(err, model.ErrInviteNotFound),
+		errors.Is(err, model.ErrAPIKeyNotFound),
+		errors.Is(err, model.ErrOIDCDisabled),
+		errors.Is(err, model.ErrWarehouseNotFound),
+		errors.Is(err, model.ErrWarehouseNotGranted),
+		errors.Is(err, model.ErrLocationNotFound),
+		errors.Is(err, model.ErrVersionNotFound):
 		return 404
-	case errors.Is(err, model.ErrUserAlreadyExists):
+	case errors.Is(err, model.ErrUserAlreadyExists),
+		errors.Is(err, model.ErrTOTPAlreadyActive),
+		errors.Is(err, model.ErrTOTPNotEnrolled),
+		errors.Is(err, model.ErrWarehouseAlreadyExists),
+		errors.Is(err, model.ErrLocationAlreadyExists),
+		errors.Is(err, model.ErrInsufficientStock),
+		errors.Is(err, model.ErrStockPlacedInBins),
+		errors.Is(err, model.ErrNegativeStock),
+		errors.Is(err, model.ErrItemNotDeleted),
+		errors.Is(err, model.ErrSnapshotSchemaMismatch):
 		return 409
+	case errors.Is(err, model.ErrVersionMismatch):
+		return 412
+	case errors.Is(err, model.ErrInvalidPolicy),
+		errors.Is(err, model.ErrVersionNotSnapshot):
+		return 422
+	case errors.Is(err, model.ErrTooManyLoginAttempts):
+		return 429
 	default:
 		return 500
 	}
@@ -0,0 +1,124 @@
+package transport
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LoginTOTP - второй шаг входа: код второго фактора по токену челленджа из /auth/login
+func (whc *WHCHandlers) LoginTOTP(ctx *gin.Context) {
+	var req loginTOTPRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid second factor payload"})
+		return
+	}
+
+	tokens, user, recoveryCodes, err := whc.svc.CompleteLoginTOTP(ctx.Request.Context(), req.Challenge, req.Code)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	setAuthCookies(ctx, tokens)
+
+	resp := convertUserAuthToResponse(user)
+	resp.RecoveryCodes = recoveryCodes
+	ctx.JSON(http.StatusOK, resp)
+}
+
+// LoginTOTPEnroll - настройка второго фактора посреди входа, если для роли он обязателен, а пользователь его еще не включил
+func (whc *WHCHandlers) LoginTOTPEnroll(ctx *gin.Context) {
+	var req challengeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid challenge payload"})
+		return
+	}
+
+	res, err := whc.svc.StartLoginTOTPEnrollment(ctx.Request.Context(), req.Challenge)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, res)
+}
+
+func (whc *WHCHandlers) EnrollTOTP(ctx *gin.Context) {
+	uid := intFromCtx(ctx, "user_id")
+	userName := stringFromCtx(ctx, "username")
+
+	res, err := whc.svc.EnrollTOTP(ctx.Request.Context(), uid, userName)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, res)
+}
+
+func (whc *WHCHandlers) ConfirmTOTP(ctx *gin.Context) {
+	uid := intFromCtx(ctx, "user_id")
+
+	var req otpCodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid code payload"})
+		return
+	}
+
+	codes, err := whc.svc.ConfirmTOTP(ctx.Request.Context(), uid, req.Code)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func (whc *WHCHandlers) DisableTOTP(ctx *gin.Context) {
+	// логируем role-sensitive запрос
+	rid := stringFromCtx(ctx, "request_id")
+	uid := intFromCtx(ctx, "user_id")
+	userName := stringFromCtx(ctx, "username")
+	role := stringFromCtx(ctx, "role")
+
+	var req otpCodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid code payload"})
+		return
+	}
+	log.Printf("rid=%q userID=%d userName=%q role=%q disabling own second factor", rid, uid, userName, role)
+
+	if err := whc.svc.DisableTOTP(ctx.Request.Context(), uid, req.Code, role); err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (whc *WHCHandlers) ResetUserTOTP(ctx *gin.Context) {
+	// логируем role-sensitive запрос
+	rid := stringFromCtx(ctx, "request_id")
+	uid := intFromCtx(ctx, "user_id")
+	userName := stringFromCtx(ctx, "username")
+	role := stringFromCtx(ctx, "role")
+
+	// определяем id
+	rawID, ok := ctx.Params.Get("id")
+	if !ok {
+		ctx.JSON(400, gin.H{"error": "empty user id"})
+		return
+	}
+	id := stringToInt(rawID)
+	log.Printf("rid=%q userID=%d userName=%q role=%q resetting second factor of user #%d", rid, uid, userName, role, id)
+
+	// передаем в сервис
+	if err := whc.svc.ResetUserTOTP(ctx.Request.Context(), id, role); err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
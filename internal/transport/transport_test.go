@@ -14,6 +14,7 @@ import (
 
 	"github.com/UnendingLoop/WarehouseControl/internal/engine"
 	"github.com/UnendingLoop/WarehouseControl/internal/model"
+	"github.com/UnendingLoop/WarehouseControl/internal/mwauthlog"
 	"github.com/UnendingLoop/WarehouseControl/internal/transport"
 	"github.com/stretchr/testify/require"
 	"github.com/wb-go/wbf/config"
@@ -75,12 +76,31 @@ func TestSignUpUser(t *testing.T) {
 			},
 			method: http.MethodPost,
 			target: "/auth/signup",
-			mockSvc: &transport.ServiceMock{CreateUserFn: func(ctx context.Context, user *model.User) (string, error) {
-				return "jwt-token", nil
+			mockSvc: &transport.ServiceMock{CreateUserFn: func(ctx context.Context, user *model.User, inviteToken string) (*model.AuthTokens, error) {
+				// роль из тела запроса не должна доходить до сервиса
+				if user.Role != "" {
+					return nil, model.ErrAccessDenied
+				}
+				user.Role = model.RoleViewer
+				return testTokens(), nil
 			}},
 			wantCode:   http.StatusCreated,
 			wantCookie: ptrMaker("jwt-token"),
 		},
+		{
+			name: "Negative - signup disabled",
+			user: &model.User{
+				UserName: "someName",
+				PassHash: "somePass",
+			},
+			method: http.MethodPost,
+			target: "/auth/signup",
+			mockSvc: &transport.ServiceMock{CreateUserFn: func(ctx context.Context, user *model.User, inviteToken string) (*model.AuthTokens, error) {
+				return nil, model.ErrSignupDisabled
+			}},
+			wantCode:   http.StatusForbidden,
+			wantCookie: nil,
+		},
 		{
 			name:       "Negative - nil user info",
 			user:       nil,
@@ -99,8 +119,8 @@ func TestSignUpUser(t *testing.T) {
 			},
 			method: http.MethodPost,
 			target: "/auth/signup",
-			mockSvc: &transport.ServiceMock{CreateUserFn: func(ctx context.Context, user *model.User) (string, error) {
-				return "", model.ErrCommon500
+			mockSvc: &transport.ServiceMock{CreateUserFn: func(ctx context.Context, user *model.User, inviteToken string) (*model.AuthTokens, error) {
+				return nil, model.ErrCommon500
 			}},
 			wantCode:   http.StatusInternalServerError,
 			wantCookie: nil,
@@ -114,8 +134,8 @@ func TestSignUpUser(t *testing.T) {
 			},
 			method: http.MethodPost,
 			target: "/auth/signup",
-			mockSvc: &transport.ServiceMock{CreateUserFn: func(ctx context.Context, user *model.User) (string, error) {
-				return "", model.ErrUserAlreadyExists
+			mockSvc: &transport.ServiceMock{CreateUserFn: func(ctx context.Context, user *model.User, inviteToken string) (*model.AuthTokens, error) {
+				return nil, model.ErrUserAlreadyExists
 			}},
 			wantCode:   http.StatusConflict,
 			wantCookie: nil,
@@ -140,14 +160,21 @@ func TestSignUpUser(t *testing.T) {
 			if tt.wantCookie != nil {
 				found := false
 				resp := rec.Result()
+				csrfFound := false
 				for _, v := range resp.Cookies() {
-					if v.Name == "access_token" {
+					switch v.Name {
+					case "access_token":
 						found = true
 						require.Equal(t, "jwt-token", v.Value)
-						break
+					case mwauthlog.CSRFCookie:
+						// CSRF-токен должен быть доступен JS страницы
+						csrfFound = true
+						require.NotEmpty(t, v.Value)
+						require.False(t, v.HttpOnly)
 					}
 				}
 				require.True(t, found, "access_token cookie not found")
+				require.True(t, csrfFound, "csrf_token cookie not found")
 			}
 		})
 	}
@@ -168,16 +195,29 @@ func TestLoginUser(t *testing.T) {
 				Role:     "someRole",
 				PassHash: "somePass",
 			},
-			mockSvc: &transport.ServiceMock{LoginUserFn: func(ctx context.Context, username string, password string, role string) (string, *model.User, error) {
-				return "jwt-token", &model.User{
+			mockSvc: &transport.ServiceMock{LoginUserFn: func(ctx context.Context, username string, password string, role string) (*model.AuthTokens, *model.User, *model.LoginChallenge, error) {
+				return testTokens(), &model.User{
 					UserName: "someName",
 					Role:     "someRole",
 					PassHash: "somePass",
-				}, nil
+				}, nil, nil
 			}},
 			wantCode:   http.StatusOK,
 			wantCookie: ptrMaker("jwt-token"),
 		},
+		{
+			name: "Positive - second factor required",
+			user: &model.User{
+				UserName: "someName",
+				Role:     "admin",
+				PassHash: "somePass",
+			},
+			mockSvc: &transport.ServiceMock{LoginUserFn: func(ctx context.Context, username string, password string, role string) (*model.AuthTokens, *model.User, *model.LoginChallenge, error) {
+				return nil, nil, &model.LoginChallenge{Token: "challenge-token", ExpiresAt: time.Now().Add(time.Minute)}, nil
+			}},
+			wantCode:   http.StatusOK,
+			wantCookie: nil,
+		},
 		{
 			name: "Negative - incorrect role",
 			user: &model.User{
@@ -185,8 +225,8 @@ func TestLoginUser(t *testing.T) {
 				Role:     "someRole",
 				PassHash: "somePass",
 			},
-			mockSvc: &transport.ServiceMock{LoginUserFn: func(ctx context.Context, username string, password string, role string) (string, *model.User, error) {
-				return "", nil, model.ErrIncorrectUserRole
+			mockSvc: &transport.ServiceMock{LoginUserFn: func(ctx context.Context, username string, password string, role string) (*model.AuthTokens, *model.User, *model.LoginChallenge, error) {
+				return nil, nil, nil, model.ErrIncorrectUserRole
 			}},
 			wantCode:   http.StatusBadRequest,
 			wantCookie: nil,
@@ -198,8 +238,8 @@ func TestLoginUser(t *testing.T) {
 				Role:     "someRole",
 				PassHash: "somePass",
 			},
-			mockSvc: &transport.ServiceMock{LoginUserFn: func(ctx context.Context, username string, password string, role string) (string, *model.User, error) {
-				return "", nil, model.ErrCommon500
+			mockSvc: &transport.ServiceMock{LoginUserFn: func(ctx context.Context, username string, password string, role string) (*model.AuthTokens, *model.User, *model.LoginChallenge, error) {
+				return nil, nil, nil, model.ErrCommon500
 			}},
 			wantCode:   http.StatusInternalServerError,
 			wantCookie: nil,
@@ -215,16 +255,29 @@ func TestLoginUser(t *testing.T) {
 			wantCookie: nil,
 		},
 		{
-			name: "Negative - user not found",
+			name: "Negative - invalid credentials",
+			user: &model.User{
+				UserName: "someName",
+				Role:     "someRole",
+				PassHash: "somePass",
+			},
+			mockSvc: &transport.ServiceMock{LoginUserFn: func(ctx context.Context, username string, password string, role string) (*model.AuthTokens, *model.User, *model.LoginChallenge, error) {
+				return nil, nil, nil, model.ErrInvalidCredentials
+			}},
+			wantCode:   http.StatusBadRequest,
+			wantCookie: nil,
+		},
+		{
+			name: "Negative - too many failed attempts",
 			user: &model.User{
 				UserName: "someName",
 				Role:     "someRole",
 				PassHash: "somePass",
 			},
-			mockSvc: &transport.ServiceMock{LoginUserFn: func(ctx context.Context, username string, password string, role string) (string, *model.User, error) {
-				return "", nil, model.ErrUserNotFound
+			mockSvc: &transport.ServiceMock{LoginUserFn: func(ctx context.Context, username string, password string, role string) (*model.AuthTokens, *model.User, *model.LoginChallenge, error) {
+				return nil, nil, nil, model.ErrTooManyLoginAttempts
 			}},
-			wantCode:   http.StatusNotFound,
+			wantCode:   http.StatusTooManyRequests,
 			wantCookie: nil,
 		},
 	}
@@ -247,126 +300,343 @@ func TestLoginUser(t *testing.T) {
 			if tt.wantCookie != nil {
 				found := false
 				resp := rec.Result()
+				csrfFound := false
 				for _, v := range resp.Cookies() {
-					if v.Name == "access_token" {
+					switch v.Name {
+					case "access_token":
 						found = true
 						require.Equal(t, "jwt-token", v.Value)
-						break
+					case mwauthlog.CSRFCookie:
+						// CSRF-токен должен быть доступен JS страницы
+						csrfFound = true
+						require.NotEmpty(t, v.Value)
+						require.False(t, v.HttpOnly)
 					}
 				}
 				require.True(t, found, "access_token cookie not found")
+				require.True(t, csrfFound, "csrf_token cookie not found")
+			} else {
+				// без выданной сессии cookies не ставятся - в т.ч. когда ждем второй фактор
+				for _, v := range rec.Result().Cookies() {
+					require.NotEqual(t, "access_token", v.Name)
+				}
 			}
 		})
 	}
 }
 
-func TestCreateItem(t *testing.T) {
+func TestLoginForgedForwardedFor(t *testing.T) {
+	// счетчик неудач по IP, как в сервисе: третья неудача с одного адреса - блокировка
+	newSvc := func() *transport.ServiceMock {
+		failures := make(map[string]int)
+		return &transport.ServiceMock{LoginUserFn: func(ctx context.Context, username string, password string, role string) (*model.AuthTokens, *model.User, *model.LoginChallenge, error) {
+			ip := model.ClientIPFromCtx(ctx)
+			if failures[ip] >= 2 {
+				return nil, nil, nil, model.ErrTooManyLoginAttempts
+			}
+			failures[ip]++
+			return nil, nil, nil, model.ErrInvalidCredentials
+		}}
+	}
+	attempt := func(r http.Handler, forwardedFor string) int {
+		body := []byte(`{"username": "someName", "password": "wrong", "role": "someRole"}`)
+		req := httptest.NewRequest(http.MethodPost, "/auth/login", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	t.Run("Forged X-Forwarded-For does not reset the counter", func(t *testing.T) {
+		r := newTestServer(transport.NewWHCHandlers(newSvc()))
+
+		require.Equal(t, http.StatusBadRequest, attempt(r, "10.0.0.1"))
+		require.Equal(t, http.StatusBadRequest, attempt(r, "10.0.0.2"))
+		require.Equal(t, http.StatusTooManyRequests, attempt(r, "10.0.0.3"))
+	})
+
+	t.Run("X-Forwarded-For from trusted proxy is used", func(t *testing.T) {
+		c := config.New()
+		c.SetDefault("GIN_MODE", "testMode")
+		c.SetDefault("TRUSTED_PROXIES", "192.0.2.1") // адрес httptest-запросов
+		_, r := engine.NewServerEngine(c, transport.NewWHCHandlers(newSvc()), testJWT, sessionStub{}, nil, nil)
+
+		require.Equal(t, http.StatusBadRequest, attempt(r, "10.0.0.1"))
+		require.Equal(t, http.StatusBadRequest, attempt(r, "10.0.0.2"))
+		require.Equal(t, http.StatusBadRequest, attempt(r, "10.0.0.3"))
+	})
+}
+
+func TestLoginTOTP(t *testing.T) {
 	cases := []struct {
-		name     string
-		item     *model.Item
-		mockSvc  *transport.ServiceMock
-		wantCode int
+		name       string
+		payload    string
+		mockSvc    *transport.ServiceMock
+		wantCode   int
+		wantCookie bool
 	}{
 		{
-			name: "Positive - item create success",
-			item: &model.Item{
-				Title:           "someTitle",
-				Price:           300,
-				Visible:         true,
-				AvailableAmount: 100500,
-			},
-			mockSvc: &transport.ServiceMock{CreateItemFn: func(ctx context.Context, item *model.Item, role string) error {
-				return nil
+			name:    "Positive - second factor accepted",
+			payload: `{"challenge": "challenge-token", "code": "123456"}`,
+			mockSvc: &transport.ServiceMock{CompleteLoginTOTPFn: func(ctx context.Context, challengeToken, code string) (*model.AuthTokens, *model.User, []string, error) {
+				return testTokens(), &model.User{UserName: "someName", Role: "admin"}, nil, nil
 			}},
-			wantCode: http.StatusCreated,
+			wantCode:   http.StatusOK,
+			wantCookie: true,
 		},
 		{
-			name:     "Negative - nil item",
-			item:     nil,
-			mockSvc:  nil,
-			wantCode: http.StatusBadRequest,
+			name:    "Negative - wrong code",
+			payload: `{"challenge": "challenge-token", "code": "000000"}`,
+			mockSvc: &transport.ServiceMock{CompleteLoginTOTPFn: func(ctx context.Context, challengeToken, code string) (*model.AuthTokens, *model.User, []string, error) {
+				return nil, nil, nil, model.ErrInvalidOTP
+			}},
+			wantCode: http.StatusUnauthorized,
 		},
 		{
-			name: "Negative - DB error",
-			item: &model.Item{
-				Title:           "someTitle",
-				Price:           300,
-				Visible:         true,
-				AvailableAmount: 100500,
-			},
-			mockSvc: &transport.ServiceMock{CreateItemFn: func(ctx context.Context, item *model.Item, role string) error {
-				return model.ErrCommon500
-			}},
-			wantCode: http.StatusInternalServerError,
+			name:     "Negative - no challenge",
+			payload:  `{"code": "123456"}`,
+			mockSvc:  &transport.ServiceMock{},
+			wantCode: http.StatusBadRequest,
 		},
 	}
 
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
-			body, err := json.Marshal(tt.item)
-			require.NoError(t, err)
-			req := httptest.NewRequest(http.MethodPost, "/items", bytes.NewReader(body))
+			req := httptest.NewRequest(http.MethodPost, "/auth/login/totp", bytes.NewReader([]byte(tt.payload)))
 			req.Header.Set("Content-Type", "application/json")
-			req.AddCookie(&http.Cookie{
-				Name:  "access_token",
-				Value: "jwt-token",
-			})
-
 			rec := httptest.NewRecorder()
 
-			h := transport.NewWHCHandlers(tt.mockSvc)
-			r := newTestServer(h)
+			r := newTestServer(transport.NewWHCHandlers(tt.mockSvc))
 			r.ServeHTTP(rec, req)
 
 			require.Equal(t, tt.wantCode, rec.Code)
+			found := false
+			for _, v := range rec.Result().Cookies() {
+				if v.Name == "access_token" {
+					found = true
+				}
+			}
+			require.Equal(t, tt.wantCookie, found)
 		})
 	}
 }
 
-func TestGetItemByID(t *testing.T) {
+func TestGetUsersList(t *testing.T) {
 	cases := []struct {
 		name     string
 		mockSvc  *transport.ServiceMock
 		wantCode int
 	}{
 		{
-			name: "Positive - item found",
-			mockSvc: &transport.ServiceMock{GetItemByIDFn: func(ctx context.Context, id int, role string) (*model.Item, error) {
-				return &model.Item{}, nil
+			name: "Positive - users fetched",
+			mockSvc: &transport.ServiceMock{GetUsersListFn: func(ctx context.Context, rpu *model.RequestParam, role string) ([]*model.User, error) {
+				return []*model.User{{ID: 1, UserName: "john", Role: "admin", PassHash: "secretHash"}}, nil
 			}},
 			wantCode: http.StatusOK,
 		},
 		{
-			name: "Negative - item not found",
-			mockSvc: &transport.ServiceMock{GetItemByIDFn: func(ctx context.Context, id int, role string) (*model.Item, error) {
-				return nil, model.ErrItemNotFound
+			name: "Negative - no access",
+			mockSvc: &transport.ServiceMock{GetUsersListFn: func(ctx context.Context, rpu *model.RequestParam, role string) ([]*model.User, error) {
+				return nil, model.ErrAccessDenied
 			}},
+			wantCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/users?page=1&limit=10", nil)
+			req.Header.Set("Authorization", "Bearer "+testAccessToken(t))
+
+			rec := httptest.NewRecorder()
+
+			h := transport.NewWHCHandlers(tt.mockSvc)
+			r := newTestServer(h)
+			r.ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantCode, rec.Code)
+			require.NotContains(t, rec.Body.String(), "secretHash", "password hash must not be exposed")
+		})
+	}
+}
+
+func TestDisableUser(t *testing.T) {
+	cases := []struct {
+		name     string
+		target   string
+		wantFlag bool
+		mockErr  error
+		wantCode int
+	}{
+		{
+			name:     "Positive - user disabled",
+			target:   "/users/5/disable",
+			wantFlag: true,
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "Positive - user enabled",
+			target:   "/users/5/enable",
+			wantFlag: false,
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "Negative - user not found",
+			target:   "/users/5/disable",
+			wantFlag: true,
+			mockErr:  model.ErrUserNotFound,
 			wantCode: http.StatusNotFound,
 		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mockSvc := &transport.ServiceMock{SetUserDisabledFn: func(ctx context.Context, userID int, disabled bool, role, username string) error {
+				require.Equal(t, tt.wantFlag, disabled)
+				return tt.mockErr
+			}}
+
+			req := httptest.NewRequest(http.MethodPost, tt.target, nil)
+			addTestSession(t, req)
+
+			rec := httptest.NewRecorder()
+
+			h := transport.NewWHCHandlers(mockSvc)
+			r := newTestServer(h)
+			r.ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantCode, rec.Code)
+		})
+	}
+}
+
+func TestRefreshSession(t *testing.T) {
+	cases := []struct {
+		name        string
+		cookie      *http.Cookie
+		mockSvc     *transport.ServiceMock
+		wantCode    int
+		wantAccess  string
+		wantCleared bool
+	}{
 		{
-			name: "Negative - no access to see items",
-			mockSvc: &transport.ServiceMock{GetItemByIDFn: func(ctx context.Context, id int, role string) (*model.Item, error) {
-				return nil, model.ErrAccessDenied
+			name:   "Positive - tokens rotated",
+			cookie: &http.Cookie{Name: "refresh_token", Value: "old-refresh"},
+			mockSvc: &transport.ServiceMock{RefreshSessionFn: func(ctx context.Context, refreshToken string) (*model.AuthTokens, *model.User, error) {
+				if refreshToken != "old-refresh" {
+					return nil, nil, model.ErrInvalidRefreshToken
+				}
+				return testTokens(), &model.User{ID: 1, UserName: "someName", Role: "viewer"}, nil
 			}},
-			wantCode: http.StatusForbidden,
+			wantCode:   http.StatusOK,
+			wantAccess: "jwt-token",
 		},
 		{
-			name: "Negative - DB error",
-			mockSvc: &transport.ServiceMock{GetItemByIDFn: func(ctx context.Context, id int, role string) (*model.Item, error) {
-				return nil, errors.New("test DB error")
+			name:   "Negative - invalid refresh token clears cookies",
+			cookie: &http.Cookie{Name: "refresh_token", Value: "used-refresh"},
+			mockSvc: &transport.ServiceMock{RefreshSessionFn: func(ctx context.Context, refreshToken string) (*model.AuthTokens, *model.User, error) {
+				return nil, nil, model.ErrInvalidRefreshToken
 			}},
-			wantCode: http.StatusInternalServerError,
+			wantCode:    http.StatusUnauthorized,
+			wantCleared: true,
 		},
 	}
 
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
-			req := httptest.NewRequest(http.MethodGet, "/items/300", nil)
-			req.AddCookie(&http.Cookie{
-				Name:  "access_token",
-				Value: "jwt-token",
-			})
+			req := httptest.NewRequest(http.MethodPost, "/auth/refresh", nil)
+			req.AddCookie(tt.cookie)
+			rec := httptest.NewRecorder()
+
+			h := transport.NewWHCHandlers(tt.mockSvc)
+			r := newTestServer(h)
+			r.ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantCode, rec.Code)
+			for _, c := range rec.Result().Cookies() {
+				if c.Name != "access_token" {
+					continue
+				}
+				if tt.wantCleared {
+					require.Equal(t, "", c.Value)
+					require.Negative(t, c.MaxAge)
+				} else {
+					require.Equal(t, tt.wantAccess, c.Value)
+				}
+			}
+		})
+	}
+}
+
+func TestOIDCCallback(t *testing.T) {
+	cases := []struct {
+		name         string
+		query        string
+		cookie       *http.Cookie
+		mockSvc      *transport.ServiceMock
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:   "Positive - session cookies issued, redirect to UI",
+			query:  "?code=abc&state=s1",
+			cookie: &http.Cookie{Name: "oidc_flow", Value: "s1.n1.v1"},
+			mockSvc: &transport.ServiceMock{CompleteOIDCLoginFn: func(ctx context.Context, flow *model.OIDCFlow, state, code string) (*model.AuthTokens, *model.User, *model.LoginChallenge, error) {
+				if flow == nil || flow.State != state || flow.Nonce != "n1" || flow.Verifier != "v1" || code != "abc" {
+					return nil, nil, nil, model.ErrInvalidOIDCState
+				}
+				return testTokens(), &model.User{ID: 1, UserName: "jane", Role: "manager"}, nil, nil
+			}},
+			wantCode:     http.StatusFound,
+			wantLocation: "/ui/",
+		},
+		{
+			name:   "Positive - second factor required, challenge passed to UI without session",
+			query:  "?code=abc&state=s1",
+			cookie: &http.Cookie{Name: "oidc_flow", Value: "s1.n1.v1"},
+			mockSvc: &transport.ServiceMock{CompleteOIDCLoginFn: func(ctx context.Context, flow *model.OIDCFlow, state, code string) (*model.AuthTokens, *model.User, *model.LoginChallenge, error) {
+				return nil, nil, &model.LoginChallenge{Token: "c-1", EnrollmentRequired: true}, nil
+			}},
+			wantCode:     http.StatusFound,
+			wantLocation: "/ui/#challenge=c-1&enrollment_required=true",
+		},
+		{
+			name:  "Negative - no flow cookie",
+			query: "?code=abc&state=s1",
+			mockSvc: &transport.ServiceMock{CompleteOIDCLoginFn: func(ctx context.Context, flow *model.OIDCFlow, state, code string) (*model.AuthTokens, *model.User, *model.LoginChallenge, error) {
+				if flow == nil {
+					return nil, nil, nil, model.ErrInvalidOIDCState
+				}
+				return testTokens(), &model.User{}, nil, nil
+			}},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Negative - IdP returned error",
+			query:    "?error=access_denied&state=s1",
+			cookie:   &http.Cookie{Name: "oidc_flow", Value: "s1.n1.v1"},
+			mockSvc:  &transport.ServiceMock{},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:   "Negative - no mapped role",
+			query:  "?code=abc&state=s1",
+			cookie: &http.Cookie{Name: "oidc_flow", Value: "s1.n1.v1"},
+			mockSvc: &transport.ServiceMock{CompleteOIDCLoginFn: func(ctx context.Context, flow *model.OIDCFlow, state, code string) (*model.AuthTokens, *model.User, *model.LoginChallenge, error) {
+				return nil, nil, nil, model.ErrNoOIDCRole
+			}},
+			wantCode: http.StatusForbidden,
+		},
+	}
 
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback"+tt.query, nil)
+			if tt.cookie != nil {
+				req.AddCookie(tt.cookie)
+			}
 			rec := httptest.NewRecorder()
 
 			h := transport.NewWHCHandlers(tt.mockSvc)
@@ -374,68 +644,97 @@ func TestGetItemByID(t *testing.T) {
 			r.ServeHTTP(rec, req)
 
 			require.Equal(t, tt.wantCode, rec.Code)
+			require.Equal(t, tt.wantLocation, rec.Header().Get("Location"))
+
+			var gotAccess bool
+			for _, c := range rec.Result().Cookies() {
+				if c.Name == "oidc_flow" {
+					require.Negative(t, c.MaxAge) // cookie попытки логина одноразовая
+				}
+				if c.Name == "access_token" {
+					gotAccess = true
+				}
+			}
+			require.Equal(t, tt.wantLocation == "/ui/", gotAccess)
 		})
 	}
 }
 
-func TestUpdateItem(t *testing.T) {
+func TestLogout(t *testing.T) {
+	var gotAccess, gotRefresh string
+	mockSvc := &transport.ServiceMock{LogoutFn: func(ctx context.Context, accessToken, refreshToken string) error {
+		gotAccess, gotRefresh = accessToken, refreshToken
+		return nil
+	}}
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/logout", nil)
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: "jwt-token"})
+	req.AddCookie(&http.Cookie{Name: "refresh_token", Value: "refresh-token"})
+	rec := httptest.NewRecorder()
+
+	h := transport.NewWHCHandlers(mockSvc)
+	r := newTestServer(h)
+	r.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	require.Equal(t, "jwt-token", gotAccess)
+	require.Equal(t, "refresh-token", gotRefresh)
+
+	cleared := 0
+	for _, c := range rec.Result().Cookies() {
+		if c.Value == "" && c.MaxAge < 0 {
+			cleared++
+		}
+	}
+	require.Equal(t, 3, cleared, "auth and csrf cookies must be cleared")
+}
+
+func TestGrantUserRole(t *testing.T) {
 	cases := []struct {
 		name     string
-		item     *model.ItemUpdate
+		body     any
 		mockSvc  *transport.ServiceMock
 		wantCode int
 	}{
 		{
-			name: "Positive - item updated",
-			item: &model.ItemUpdate{},
-			mockSvc: &transport.ServiceMock{UpdateItemByIDFn: func(ctx context.Context, item *model.ItemUpdate, role string) error {
+			name: "Positive - role granted",
+			body: map[string]string{"role": "manager"},
+			mockSvc: &transport.ServiceMock{GrantUserRoleFn: func(ctx context.Context, userID int, grantRole string, role, username string) error {
 				return nil
 			}},
 			wantCode: http.StatusNoContent,
 		},
 		{
-			name: "Negative - nil item",
-			item: nil,
-			mockSvc: &transport.ServiceMock{UpdateItemByIDFn: func(ctx context.Context, item *model.ItemUpdate, role string) error {
-				return model.ErrNoFieldsToUpdate
-			}},
+			name:     "Negative - empty role",
+			body:     map[string]string{},
+			mockSvc:  &transport.ServiceMock{},
 			wantCode: http.StatusBadRequest,
 		},
 		{
-			name: "Negative - no access to update",
-			item: &model.ItemUpdate{},
-			mockSvc: &transport.ServiceMock{UpdateItemByIDFn: func(ctx context.Context, item *model.ItemUpdate, role string) error {
+			name: "Negative - no access",
+			body: map[string]string{"role": "admin"},
+			mockSvc: &transport.ServiceMock{GrantUserRoleFn: func(ctx context.Context, userID int, grantRole string, role, username string) error {
 				return model.ErrAccessDenied
 			}},
 			wantCode: http.StatusForbidden,
 		},
 		{
-			name: "Negative - item id not found",
-			item: &model.ItemUpdate{},
-			mockSvc: &transport.ServiceMock{UpdateItemByIDFn: func(ctx context.Context, item *model.ItemUpdate, role string) error {
-				return model.ErrItemNotFound
+			name: "Negative - user not found",
+			body: map[string]string{"role": "admin"},
+			mockSvc: &transport.ServiceMock{GrantUserRoleFn: func(ctx context.Context, userID int, grantRole string, role, username string) error {
+				return model.ErrUserNotFound
 			}},
 			wantCode: http.StatusNotFound,
 		},
-		{
-			name: "Negative - DB error",
-			item: &model.ItemUpdate{},
-			mockSvc: &transport.ServiceMock{UpdateItemByIDFn: func(ctx context.Context, item *model.ItemUpdate, role string) error {
-				return errors.New("test DB error")
-			}},
-			wantCode: http.StatusInternalServerError,
-		},
 	}
 
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
-			body, err := json.Marshal(tt.item)
+			body, err := json.Marshal(tt.body)
 			require.NoError(t, err)
-			req := httptest.NewRequest(http.MethodPatch, "/items/300", bytes.NewReader(body))
-			req.AddCookie(&http.Cookie{
-				Name:  "access_token",
-				Value: "jwt-token",
-			})
+			req := httptest.NewRequest(http.MethodPost, "/users/5/roles", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			addTestSession(t, req)
 
 			rec := httptest.NewRecorder()
 
@@ -448,49 +747,47 @@ func TestUpdateItem(t *testing.T) {
 	}
 }
 
-func TestDeleteItem(t *testing.T) {
+func TestGrantUserWarehouse(t *testing.T) {
 	cases := []struct {
 		name     string
+		body     any
 		mockSvc  *transport.ServiceMock
 		wantCode int
 	}{
 		{
-			name: "Positive - item updated",
-			mockSvc: &transport.ServiceMock{DeleteItemByIDFn: func(ctx context.Context, id int, role string, username string) error {
+			name: "Positive - warehouse granted",
+			body: map[string]int{"warehouse_id": 2},
+			mockSvc: &transport.ServiceMock{GrantUserWarehouseFn: func(ctx context.Context, userID, warehouseID int, role, username string) error {
+				if userID != 5 || warehouseID != 2 {
+					return model.ErrIncorrectWarehouseID
+				}
 				return nil
 			}},
 			wantCode: http.StatusNoContent,
 		},
 		{
-			name: "Negative - no access to delete",
-			mockSvc: &transport.ServiceMock{DeleteItemByIDFn: func(ctx context.Context, id int, role string, username string) error {
-				return model.ErrAccessDenied
-			}},
-			wantCode: http.StatusForbidden,
+			name:     "Negative - empty warehouse",
+			body:     map[string]int{},
+			mockSvc:  &transport.ServiceMock{},
+			wantCode: http.StatusBadRequest,
 		},
 		{
-			name: "Negative - item id not found",
-			mockSvc: &transport.ServiceMock{DeleteItemByIDFn: func(ctx context.Context, id int, role string, username string) error {
-				return model.ErrItemNotFound
+			name: "Negative - warehouse not found",
+			body: map[string]int{"warehouse_id": 9},
+			mockSvc: &transport.ServiceMock{GrantUserWarehouseFn: func(ctx context.Context, userID, warehouseID int, role, username string) error {
+				return model.ErrWarehouseNotFound
 			}},
 			wantCode: http.StatusNotFound,
 		},
-		{
-			name: "Negative - DB error",
-			mockSvc: &transport.ServiceMock{DeleteItemByIDFn: func(ctx context.Context, id int, role string, username string) error {
-				return errors.New("test DB error")
-			}},
-			wantCode: http.StatusInternalServerError,
-		},
 	}
 
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
-			req := httptest.NewRequest(http.MethodDelete, "/items/300", nil)
-			req.AddCookie(&http.Cookie{
-				Name:  "access_token",
-				Value: "jwt-token",
-			})
+			body, err := json.Marshal(tt.body)
+			require.NoError(t, err)
+			req := httptest.NewRequest(http.MethodPost, "/users/5/warehouses", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			addTestSession(t, req)
 
 			rec := httptest.NewRecorder()
 
@@ -503,49 +800,757 @@ func TestDeleteItem(t *testing.T) {
 	}
 }
 
+func TestCreateWarehouse(t *testing.T) {
+	cases := []struct {
+		name     string
+		body     any
+		mockSvc  *transport.ServiceMock
+		wantCode int
+	}{
+		{
+			name: "Positive - warehouse created",
+			body: map[string]string{"code": "north", "name": "North hub"},
+			mockSvc: &transport.ServiceMock{CreateWarehouseFn: func(ctx context.Context, wh *model.Warehouse, role, username string) error {
+				wh.ID = 2
+				return nil
+			}},
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:     "Negative - missing name",
+			body:     map[string]string{"code": "north"},
+			mockSvc:  &transport.ServiceMock{},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "Negative - code already exists",
+			body: map[string]string{"code": "north", "name": "North hub"},
+			mockSvc: &transport.ServiceMock{CreateWarehouseFn: func(ctx context.Context, wh *model.Warehouse, role, username string) error {
+				return model.ErrWarehouseAlreadyExists
+			}},
+			wantCode: http.StatusConflict,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(tt.body)
+			require.NoError(t, err)
+			req := httptest.NewRequest(http.MethodPost, "/warehouses", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			addTestSession(t, req)
+
+			rec := httptest.NewRecorder()
+
+			h := transport.NewWHCHandlers(tt.mockSvc)
+			r := newTestServer(h)
+			r.ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantCode, rec.Code)
+		})
+	}
+}
+
+func TestCreateInvite(t *testing.T) {
+	cases := []struct {
+		name     string
+		body     any
+		mockSvc  *transport.ServiceMock
+		wantCode int
+	}{
+		{
+			name: "Positive - invite created",
+			body: map[string]any{"role": "manager", "expires_in_hours": 24},
+			mockSvc: &transport.ServiceMock{CreateInviteFn: func(ctx context.Context, inviteRole string, ttl time.Duration, role, username string) (*model.Invite, error) {
+				if ttl != 24*time.Hour {
+					return nil, model.ErrInvalidInviteTTL
+				}
+				return &model.Invite{ID: 1, Token: "invite-token", Role: inviteRole}, nil
+			}},
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:     "Negative - empty role",
+			body:     map[string]any{},
+			mockSvc:  &transport.ServiceMock{},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "Negative - negative ttl",
+			body: map[string]any{"role": "manager", "expires_in_hours": -1},
+			mockSvc: &transport.ServiceMock{CreateInviteFn: func(ctx context.Context, inviteRole string, ttl time.Duration, role, username string) (*model.Invite, error) {
+				return nil, model.ErrInvalidInviteTTL
+			}},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "Negative - no access",
+			body: map[string]any{"role": "admin"},
+			mockSvc: &transport.ServiceMock{CreateInviteFn: func(ctx context.Context, inviteRole string, ttl time.Duration, role, username string) (*model.Invite, error) {
+				return nil, model.ErrAccessDenied
+			}},
+			wantCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(tt.body)
+			require.NoError(t, err)
+			req := httptest.NewRequest(http.MethodPost, "/invites", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			addTestSession(t, req)
+
+			rec := httptest.NewRecorder()
+
+			h := transport.NewWHCHandlers(tt.mockSvc)
+			r := newTestServer(h)
+			r.ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantCode, rec.Code)
+		})
+	}
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	cases := []struct {
+		name     string
+		body     any
+		mockSvc  *transport.ServiceMock
+		wantCode int
+	}{
+		{
+			name: "Positive - api key created",
+			body: map[string]any{"name": "erp-sync", "role": "manager", "expires_in_hours": 24},
+			mockSvc: &transport.ServiceMock{CreateAPIKeyFn: func(ctx context.Context, name, keyRole string, ttl time.Duration, role, username string) (*model.APIKey, error) {
+				if ttl != 24*time.Hour {
+					return nil, model.ErrInvalidAPIKeyTTL
+				}
+				return &model.APIKey{ID: 1, Key: model.APIKeyPrefix + "key", Name: name, Role: keyRole}, nil
+			}},
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:     "Negative - empty name",
+			body:     map[string]any{"role": "manager"},
+			mockSvc:  &transport.ServiceMock{},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "Negative - no access",
+			body: map[string]any{"name": "erp-sync", "role": "admin"},
+			mockSvc: &transport.ServiceMock{CreateAPIKeyFn: func(ctx context.Context, name, keyRole string, ttl time.Duration, role, username string) (*model.APIKey, error) {
+				return nil, model.ErrAccessDenied
+			}},
+			wantCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(tt.body)
+			require.NoError(t, err)
+			req := httptest.NewRequest(http.MethodPost, "/api-keys", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			addTestSession(t, req)
+
+			rec := httptest.NewRecorder()
+
+			h := transport.NewWHCHandlers(tt.mockSvc)
+			r := newTestServer(h)
+			r.ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantCode, rec.Code)
+		})
+	}
+}
+
+func TestRevokeUserRole(t *testing.T) {
+	cases := []struct {
+		name     string
+		mockSvc  *transport.ServiceMock
+		wantCode int
+	}{
+		{
+			name: "Positive - role revoked",
+			mockSvc: &transport.ServiceMock{RevokeUserRoleFn: func(ctx context.Context, userID int, revokeRole string, role, username string) error {
+				return nil
+			}},
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name: "Negative - role not granted",
+			mockSvc: &transport.ServiceMock{RevokeUserRoleFn: func(ctx context.Context, userID int, revokeRole string, role, username string) error {
+				return model.ErrRoleNotGranted
+			}},
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/users/5/roles/manager", nil)
+			addTestSession(t, req)
+
+			rec := httptest.NewRecorder()
+
+			h := transport.NewWHCHandlers(tt.mockSvc)
+			r := newTestServer(h)
+			r.ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantCode, rec.Code)
+		})
+	}
+}
+
+func TestCreateItem(t *testing.T) {
+	cases := []struct {
+		name     string
+		item     *model.Item
+		mockSvc  *transport.ServiceMock
+		wantCode int
+	}{
+		{
+			name: "Positive - item create success",
+			item: &model.Item{
+				Title:           "someTitle",
+				Price:           300,
+				Visible:         true,
+				AvailableAmount: 100500,
+			},
+			mockSvc: &transport.ServiceMock{CreateItemFn: func(ctx context.Context, item *model.Item, userID int, role string) error {
+				return nil
+			}},
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:     "Negative - nil item",
+			item:     nil,
+			mockSvc:  nil,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "Negative - DB error",
+			item: &model.Item{
+				Title:           "someTitle",
+				Price:           300,
+				Visible:         true,
+				AvailableAmount: 100500,
+			},
+			mockSvc: &transport.ServiceMock{CreateItemFn: func(ctx context.Context, item *model.Item, userID int, role string) error {
+				return model.ErrCommon500
+			}},
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(tt.item)
+			require.NoError(t, err)
+			req := httptest.NewRequest(http.MethodPost, "/items", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			addTestSession(t, req)
+
+			rec := httptest.NewRecorder()
+
+			h := transport.NewWHCHandlers(tt.mockSvc)
+			r := newTestServer(h)
+			r.ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantCode, rec.Code)
+		})
+	}
+}
+
+func TestGetItemByID(t *testing.T) {
+	cases := []struct {
+		name     string
+		mockSvc  *transport.ServiceMock
+		wantCode int
+	}{
+		{
+			name: "Positive - item found",
+			mockSvc: &transport.ServiceMock{GetItemByIDFn: func(ctx context.Context, id int, role string) (*model.Item, error) {
+				return &model.Item{}, nil
+			}},
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "Negative - item not found",
+			mockSvc: &transport.ServiceMock{GetItemByIDFn: func(ctx context.Context, id int, role string) (*model.Item, error) {
+				return nil, model.ErrItemNotFound
+			}},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name: "Negative - no access to see items",
+			mockSvc: &transport.ServiceMock{GetItemByIDFn: func(ctx context.Context, id int, role string) (*model.Item, error) {
+				return nil, model.ErrAccessDenied
+			}},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name: "Negative - DB error",
+			mockSvc: &transport.ServiceMock{GetItemByIDFn: func(ctx context.Context, id int, role string) (*model.Item, error) {
+				return nil, errors.New("test DB error")
+			}},
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/items/300", nil)
+			addTestSession(t, req)
+
+			rec := httptest.NewRecorder()
+
+			h := transport.NewWHCHandlers(tt.mockSvc)
+			r := newTestServer(h)
+			r.ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantCode, rec.Code)
+		})
+	}
+}
+
+func TestUpdateItem(t *testing.T) {
+	cases := []struct {
+		name     string
+		item     *model.ItemUpdate
+		mockSvc  *transport.ServiceMock
+		wantCode int
+	}{
+		{
+			name: "Positive - item updated",
+			item: &model.ItemUpdate{},
+			mockSvc: &transport.ServiceMock{UpdateItemByIDFn: func(ctx context.Context, item *model.ItemUpdate, userID int, role string) error {
+				return nil
+			}},
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name: "Negative - nil item",
+			item: nil,
+			mockSvc: &transport.ServiceMock{UpdateItemByIDFn: func(ctx context.Context, item *model.ItemUpdate, userID int, role string) error {
+				return model.ErrNoFieldsToUpdate
+			}},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "Negative - no access to update",
+			item: &model.ItemUpdate{},
+			mockSvc: &transport.ServiceMock{UpdateItemByIDFn: func(ctx context.Context, item *model.ItemUpdate, userID int, role string) error {
+				return model.ErrAccessDenied
+			}},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name: "Negative - item id not found",
+			item: &model.ItemUpdate{},
+			mockSvc: &transport.ServiceMock{UpdateItemByIDFn: func(ctx context.Context, item *model.ItemUpdate, userID int, role string) error {
+				return model.ErrItemNotFound
+			}},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name: "Negative - DB error",
+			item: &model.ItemUpdate{},
+			mockSvc: &transport.ServiceMock{UpdateItemByIDFn: func(ctx context.Context, item *model.ItemUpdate, userID int, role string) error {
+				return errors.New("test DB error")
+			}},
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(tt.item)
+			require.NoError(t, err)
+			req := httptest.NewRequest(http.MethodPatch, "/items/300", bytes.NewReader(body))
+			addTestSession(t, req)
+
+			rec := httptest.NewRecorder()
+
+			h := transport.NewWHCHandlers(tt.mockSvc)
+			r := newTestServer(h)
+			r.ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantCode, rec.Code)
+		})
+	}
+}
+
+func TestDeleteItem(t *testing.T) {
+	cases := []struct {
+		name     string
+		mockSvc  *transport.ServiceMock
+		wantCode int
+	}{
+		{
+			name: "Positive - item updated",
+			mockSvc: &transport.ServiceMock{DeleteItemByIDFn: func(ctx context.Context, id int, version *int, userID int, role string, username string) error {
+				return nil
+			}},
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name: "Negative - no access to delete",
+			mockSvc: &transport.ServiceMock{DeleteItemByIDFn: func(ctx context.Context, id int, version *int, userID int, role string, username string) error {
+				return model.ErrAccessDenied
+			}},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name: "Negative - item id not found",
+			mockSvc: &transport.ServiceMock{DeleteItemByIDFn: func(ctx context.Context, id int, version *int, userID int, role string, username string) error {
+				return model.ErrItemNotFound
+			}},
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name: "Negative - DB error",
+			mockSvc: &transport.ServiceMock{DeleteItemByIDFn: func(ctx context.Context, id int, version *int, userID int, role string, username string) error {
+				return errors.New("test DB error")
+			}},
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/items/300", nil)
+			addTestSession(t, req)
+
+			rec := httptest.NewRecorder()
+
+			h := transport.NewWHCHandlers(tt.mockSvc)
+			r := newTestServer(h)
+			r.ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantCode, rec.Code)
+		})
+	}
+}
+
+func TestRestoreItem(t *testing.T) {
+	cases := []struct {
+		name     string
+		mockSvc  *transport.ServiceMock
+		wantCode int
+	}{
+		{
+			name: "Positive - item restored",
+			mockSvc: &transport.ServiceMock{RestoreItemByIDFn: func(ctx context.Context, id int, userID int, role string, username string) error {
+				return nil
+			}},
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name: "Negative - item is not deleted",
+			mockSvc: &transport.ServiceMock{RestoreItemByIDFn: func(ctx context.Context, id int, userID int, role string, username string) error {
+				return model.ErrItemNotDeleted
+			}},
+			wantCode: http.StatusConflict,
+		},
+		{
+			name: "Negative - no access to restore",
+			mockSvc: &transport.ServiceMock{RestoreItemByIDFn: func(ctx context.Context, id int, userID int, role string, username string) error {
+				return model.ErrAccessDenied
+			}},
+			wantCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/items/300/restore", nil)
+			addTestSession(t, req)
+
+			rec := httptest.NewRecorder()
+
+			h := transport.NewWHCHandlers(tt.mockSvc)
+			r := newTestServer(h)
+			r.ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantCode, rec.Code)
+		})
+	}
+}
+
+func TestRevertItem(t *testing.T) {
+	cases := []struct {
+		name     string
+		body     string
+		ifMatch  string
+		mockSvc  *transport.ServiceMock
+		wantCode int
+	}{
+		{
+			name:    "Positive - item reverted with If-Match",
+			body:    `{"version": 3}`,
+			ifMatch: `"7"`,
+			mockSvc: &transport.ServiceMock{RevertItemByIDFn: func(ctx context.Context, id int, rv *model.ItemRevert, version *int, userID int, role, username string) error {
+				if id != 300 || rv.Version != 3 || version == nil || *version != 7 {
+					return model.ErrCommon500
+				}
+				return nil
+			}},
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "Negative - malformed payload",
+			body:     `{"version": "three"}`,
+			mockSvc:  &transport.ServiceMock{},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "Negative - snapshot schema mismatch",
+			body: `{"version": 3}`,
+			mockSvc: &transport.ServiceMock{RevertItemByIDFn: func(ctx context.Context, id int, rv *model.ItemRevert, version *int, userID int, role, username string) error {
+				return model.ErrSnapshotSchemaMismatch
+			}},
+			wantCode: http.StatusConflict,
+		},
+		{
+			name: "Negative - version is a stock movement",
+			body: `{"version": 4}`,
+			mockSvc: &transport.ServiceMock{RevertItemByIDFn: func(ctx context.Context, id int, rv *model.ItemRevert, version *int, userID int, role, username string) error {
+				return model.ErrVersionNotSnapshot
+			}},
+			wantCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "Negative - version not found",
+			body: `{"version": 30}`,
+			mockSvc: &transport.ServiceMock{RevertItemByIDFn: func(ctx context.Context, id int, rv *model.ItemRevert, version *int, userID int, role, username string) error {
+				return model.ErrVersionNotFound
+			}},
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/items/300/revert", bytes.NewReader([]byte(tt.body)))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			addTestSession(t, req)
+
+			rec := httptest.NewRecorder()
+
+			h := transport.NewWHCHandlers(tt.mockSvc)
+			r := newTestServer(h)
+			r.ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantCode, rec.Code)
+		})
+	}
+}
+
+func TestGetDeletedItems(t *testing.T) {
+	deletedBy := "manager"
+	mockSvc := &transport.ServiceMock{GetDeletedItemsFn: func(ctx context.Context, rp *model.RequestParam, role string) ([]*model.Item, error) {
+		return []*model.Item{{ID: 300, Title: "deleted", DeletedBy: &deletedBy}}, nil
+	}}
+
+	req := httptest.NewRequest(http.MethodGet, "/items/trash", nil)
+	addTestSession(t, req)
+
+	rec := httptest.NewRecorder()
+
+	h := transport.NewWHCHandlers(mockSvc)
+	r := newTestServer(h)
+	r.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), `"deleted_by":"manager"`)
+}
+
+func TestPurgeDeletedItems(t *testing.T) {
+	cases := []struct {
+		name     string
+		query    string
+		svcErr   error
+		wantReq  model.PurgeRequest
+		wantCode int
+	}{
+		{
+			name:     "Positive - dry run with explicit retention",
+			query:    "?dry_run=true&older_than_days=7",
+			wantReq:  model.PurgeRequest{DryRun: true, OlderThanDays: ptrMaker(7)},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Positive - purge by configured retention",
+			wantReq:  model.PurgeRequest{},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Negative - invalid query",
+			query:    "?older_than_days=week",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Negative - no access to purge",
+			svcErr:   model.ErrAccessDenied,
+			wantCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mockSvc := &transport.ServiceMock{PurgeDeletedItemsFn: func(ctx context.Context, req *model.PurgeRequest, userID int, role, username string) (*model.PurgeResult, error) {
+				if tt.svcErr != nil {
+					return nil, tt.svcErr
+				}
+				require.Equal(t, tt.wantReq, *req)
+				return &model.PurgeResult{DryRun: req.DryRun, Items: []*model.Item{}}, nil
+			}}
+
+			req := httptest.NewRequest(http.MethodPost, "/items/purge"+tt.query, nil)
+			addTestSession(t, req)
+
+			rec := httptest.NewRecorder()
+
+			h := transport.NewWHCHandlers(mockSvc)
+			r := newTestServer(h)
+			r.ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantCode, rec.Code)
+		})
+	}
+}
+
+func TestDeleteItemCSRF(t *testing.T) {
+	mockSvc := &transport.ServiceMock{DeleteItemByIDFn: func(ctx context.Context, id int, version *int, userID int, role string, username string) error {
+		return nil
+	}}
+
+	cases := []struct {
+		name     string
+		prepare  func(t *testing.T, req *http.Request)
+		wantCode int
+	}{
+		{
+			name: "Negative - cookie session without csrf header",
+			prepare: func(t *testing.T, req *http.Request) {
+				req.AddCookie(&http.Cookie{Name: "access_token", Value: testAccessToken(t)})
+				req.AddCookie(&http.Cookie{Name: mwauthlog.CSRFCookie, Value: "csrf-token"})
+			},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name: "Negative - cookie session with foreign csrf header",
+			prepare: func(t *testing.T, req *http.Request) {
+				req.AddCookie(&http.Cookie{Name: "access_token", Value: testAccessToken(t)})
+				req.AddCookie(&http.Cookie{Name: mwauthlog.CSRFCookie, Value: "csrf-token"})
+				req.Header.Set(mwauthlog.CSRFHeader, "other-token")
+			},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name: "Positive - bearer request is exempt",
+			prepare: func(t *testing.T, req *http.Request) {
+				req.Header.Set("Authorization", "Bearer "+testAccessToken(t))
+			},
+			wantCode: http.StatusNoContent,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/items/300", nil)
+			tt.prepare(t, req)
+
+			rec := httptest.NewRecorder()
+
+			h := transport.NewWHCHandlers(mockSvc)
+			newTestServer(h).ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantCode, rec.Code)
+		})
+	}
+}
+
 func TestGetItemsList(t *testing.T) {
 	cases := []struct {
 		name     string
+		query    string
 		mockSvc  *transport.ServiceMock
 		wantCode int
 	}{
 		{
 			name: "Positive - items fetched",
-			mockSvc: &transport.ServiceMock{GetItemsListFn: func(ctx context.Context, rpi *model.RequestParam, role string) ([]*model.Item, error) {
+			mockSvc: &transport.ServiceMock{GetItemsListFn: func(ctx context.Context, rpi *model.RequestParam, filter *model.ItemFilter, role string) ([]*model.Item, error) {
 				return []*model.Item{{}, {}}, nil
 			}},
 			wantCode: http.StatusOK,
 		},
 		{
 			name: "Negative - DB error",
-			mockSvc: &transport.ServiceMock{GetItemsListFn: func(ctx context.Context, rpi *model.RequestParam, role string) ([]*model.Item, error) {
+			mockSvc: &transport.ServiceMock{GetItemsListFn: func(ctx context.Context, rpi *model.RequestParam, filter *model.ItemFilter, role string) ([]*model.Item, error) {
 				return nil, errors.New("test DB error")
 			}},
 			wantCode: http.StatusInternalServerError,
 		},
 		{
 			name: "Negative - incorrect request params",
-			mockSvc: &transport.ServiceMock{GetItemsListFn: func(ctx context.Context, rpi *model.RequestParam, role string) ([]*model.Item, error) {
+			mockSvc: &transport.ServiceMock{GetItemsListFn: func(ctx context.Context, rpi *model.RequestParam, filter *model.ItemFilter, role string) ([]*model.Item, error) {
 				return nil, model.ErrInvalidAscDesc
 			}},
 			wantCode: http.StatusBadRequest,
 		},
 		{
 			name: "Negative - no access to get items",
-			mockSvc: &transport.ServiceMock{GetItemsListFn: func(ctx context.Context, rpi *model.RequestParam, role string) ([]*model.Item, error) {
+			mockSvc: &transport.ServiceMock{GetItemsListFn: func(ctx context.Context, rpi *model.RequestParam, filter *model.ItemFilter, role string) ([]*model.Item, error) {
 				return nil, model.ErrAccessDenied
 			}},
 			wantCode: http.StatusForbidden,
 		},
+		{
+			name:  "Positive - warehouse filter is passed to service",
+			query: "?warehouse_id=2",
+			mockSvc: &transport.ServiceMock{GetItemsListFn: func(ctx context.Context, rpi *model.RequestParam, filter *model.ItemFilter, role string) ([]*model.Item, error) {
+				if filter.WarehouseID == nil || *filter.WarehouseID != 2 {
+					return nil, model.ErrIncorrectWarehouseID
+				}
+				return []*model.Item{{}}, nil
+			}},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Negative - malformed warehouse filter",
+			query:    "?warehouse_id=abc",
+			mockSvc:  &transport.ServiceMock{},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:  "Positive - as_of is passed to service",
+			query: "?as_of=2026-12-31T23:59:59Z",
+			mockSvc: &transport.ServiceMock{GetItemsListFn: func(ctx context.Context, rpi *model.RequestParam, filter *model.ItemFilter, role string) ([]*model.Item, error) {
+				if filter.AsOf == nil || !filter.AsOf.Equal(time.Date(2026, 12, 31, 23, 59, 59, 0, time.UTC)) {
+					return nil, errors.New("as_of is lost")
+				}
+				return []*model.Item{{}}, nil
+			}},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Negative - malformed as_of",
+			query:    "?as_of=yesterday",
+			mockSvc:  &transport.ServiceMock{},
+			wantCode: http.StatusBadRequest,
+		},
 	}
 
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
-			req := httptest.NewRequest(http.MethodGet, "/items", nil)
-			req.AddCookie(&http.Cookie{
-				Name:  "access_token",
-				Value: "jwt-token",
-			})
+			req := httptest.NewRequest(http.MethodGet, "/items"+tt.query, nil)
+			addTestSession(t, req)
 
 			rec := httptest.NewRecorder()
 
@@ -567,7 +1572,7 @@ func TestGetItemHistoryByID(t *testing.T) {
 	}{
 		{
 			name: "Positive - history fetched",
-			mockSvc: &transport.ServiceMock{GetItemHistoryByIDFn: func(ctx context.Context, rph *model.RequestParam, id int, role string) ([]*model.ItemHistory, error) {
+			mockSvc: &transport.ServiceMock{GetItemHistoryByIDFn: func(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, id int, role string) ([]*model.ItemHistory, error) {
 				return []*model.ItemHistory{{}, {}}, nil
 			}},
 			wantCode: http.StatusOK,
@@ -575,21 +1580,21 @@ func TestGetItemHistoryByID(t *testing.T) {
 		},
 		{
 			name: "Negative - no access to see history",
-			mockSvc: &transport.ServiceMock{GetItemHistoryByIDFn: func(ctx context.Context, rph *model.RequestParam, id int, role string) ([]*model.ItemHistory, error) {
+			mockSvc: &transport.ServiceMock{GetItemHistoryByIDFn: func(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, id int, role string) ([]*model.ItemHistory, error) {
 				return nil, model.ErrAccessDenied
 			}},
 			wantCode: http.StatusForbidden,
 		},
 		{
 			name: "Negative - DB error",
-			mockSvc: &transport.ServiceMock{GetItemHistoryByIDFn: func(ctx context.Context, rph *model.RequestParam, id int, role string) ([]*model.ItemHistory, error) {
+			mockSvc: &transport.ServiceMock{GetItemHistoryByIDFn: func(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, id int, role string) ([]*model.ItemHistory, error) {
 				return nil, errors.New("test DB error")
 			}},
 			wantCode: http.StatusInternalServerError,
 		},
 		{
 			name: "Negative - item not found",
-			mockSvc: &transport.ServiceMock{GetItemHistoryByIDFn: func(ctx context.Context, rph *model.RequestParam, id int, role string) ([]*model.ItemHistory, error) {
+			mockSvc: &transport.ServiceMock{GetItemHistoryByIDFn: func(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, id int, role string) ([]*model.ItemHistory, error) {
 				return nil, model.ErrItemNotFound
 			}},
 			wantCode: http.StatusNotFound,
@@ -599,10 +1604,7 @@ func TestGetItemHistoryByID(t *testing.T) {
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
 			req := httptest.NewRequest(http.MethodGet, "/items/300/history", nil)
-			req.AddCookie(&http.Cookie{
-				Name:  "access_token",
-				Value: "jwt-token",
-			})
+			addTestSession(t, req)
 
 			rec := httptest.NewRecorder()
 
@@ -618,6 +1620,70 @@ func TestGetItemHistoryByID(t *testing.T) {
 	}
 }
 
+func TestGetItemHistoryDiff(t *testing.T) {
+	cases := []struct {
+		name     string
+		query    string
+		svcErr   error
+		wantReq  model.ItemDiffRequest
+		wantCode int
+	}{
+		{
+			name:     "Positive - default noise fields",
+			query:    "?from=3&to=7",
+			wantReq:  model.ItemDiffRequest{From: 3, To: 7},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Positive - empty ignore list is passed as is",
+			query:    "?from=3&to=7&ignore=",
+			wantReq:  model.ItemDiffRequest{From: 3, To: 7, Ignore: ptrMaker("")},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Negative - version is not a number",
+			query:    "?from=first&to=7",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Negative - version not found",
+			query:    "?from=3&to=70",
+			svcErr:   model.ErrVersionNotFound,
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Negative - version is a stock movement",
+			query:    "?from=3&to=4",
+			svcErr:   model.ErrVersionNotSnapshot,
+			wantCode: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mockSvc := &transport.ServiceMock{GetItemHistoryDiffFn: func(ctx context.Context, req *model.ItemDiffRequest, id int, role string) (*model.ItemDiff, error) {
+				if tt.svcErr != nil {
+					return nil, tt.svcErr
+				}
+				require.Equal(t, tt.wantReq, *req)
+				require.Equal(t, 300, id)
+				return &model.ItemDiff{ItemID: id, From: req.From, To: req.To, Changes: []model.FieldChange{}}, nil
+			}}
+
+			req := httptest.NewRequest(http.MethodGet, "/items/300/history/diff"+tt.query, nil)
+			addTestSession(t, req)
+
+			rec := httptest.NewRecorder()
+
+			h := transport.NewWHCHandlers(mockSvc)
+			r := newTestServer(h)
+			r.ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantCode, rec.Code)
+		})
+	}
+}
+
 func TestGetItemsHistoryList(t *testing.T) {
 	_, testHistory := generateValidItemAndHistoryArray(t)
 	cases := []struct {
@@ -628,7 +1694,7 @@ func TestGetItemsHistoryList(t *testing.T) {
 	}{
 		{
 			name: "Positive - history fetched",
-			mockSvc: &transport.ServiceMock{GetItemHistoryAllFn: func(ctx context.Context, rph *model.RequestParam, role string) ([]*model.ItemHistory, error) {
+			mockSvc: &transport.ServiceMock{GetItemHistoryAllFn: func(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, role string) ([]*model.ItemHistory, error) {
 				return testHistory, nil
 			}},
 			wantCode: http.StatusOK,
@@ -636,21 +1702,21 @@ func TestGetItemsHistoryList(t *testing.T) {
 		},
 		{
 			name: "Negative - Db error",
-			mockSvc: &transport.ServiceMock{GetItemHistoryAllFn: func(ctx context.Context, rph *model.RequestParam, role string) ([]*model.ItemHistory, error) {
+			mockSvc: &transport.ServiceMock{GetItemHistoryAllFn: func(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, role string) ([]*model.ItemHistory, error) {
 				return nil, errors.New("test DB error")
 			}},
 			wantCode: http.StatusInternalServerError,
 		},
 		{
 			name: "Negative - req params invalid",
-			mockSvc: &transport.ServiceMock{GetItemHistoryAllFn: func(ctx context.Context, rph *model.RequestParam, role string) ([]*model.ItemHistory, error) {
+			mockSvc: &transport.ServiceMock{GetItemHistoryAllFn: func(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, role string) ([]*model.ItemHistory, error) {
 				return nil, model.ErrInvalidAscDesc
 			}},
 			wantCode: http.StatusBadRequest,
 		},
 		{
 			name: "Negative -  o access to see history",
-			mockSvc: &transport.ServiceMock{GetItemHistoryAllFn: func(ctx context.Context, rph *model.RequestParam, role string) ([]*model.ItemHistory, error) {
+			mockSvc: &transport.ServiceMock{GetItemHistoryAllFn: func(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, role string) ([]*model.ItemHistory, error) {
 				return nil, model.ErrAccessDenied
 			}},
 			wantCode: http.StatusForbidden,
@@ -660,10 +1726,7 @@ func TestGetItemsHistoryList(t *testing.T) {
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
 			req := httptest.NewRequest(http.MethodGet, "/items/history", nil)
-			req.AddCookie(&http.Cookie{
-				Name:  "access_token",
-				Value: "jwt-token",
-			})
+			addTestSession(t, req)
 
 			rec := httptest.NewRecorder()
 
@@ -689,6 +1752,65 @@ func TestGetItemsHistoryList(t *testing.T) {
 	}
 }
 
+func TestItemsHistoryRepeatedFilters(t *testing.T) {
+	const query = "?changed_by=alice&changed_by=bob&action=UPDATE&action=REVERT&item_id=3&item_id=5&field=price"
+	wantFilter := model.HistoryFilter{
+		ChangedBy: []string{"alice", "bob"},
+		Action:    []string{"UPDATE", "REVERT"},
+		ItemID:    []int{3, 5},
+		Field:     []string{"price"},
+	}
+	var got *model.HistoryFilter
+	mockSvc := &transport.ServiceMock{
+		GetItemHistoryAllFn: func(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, role string) ([]*model.ItemHistory, error) {
+			got = filter
+			return []*model.ItemHistory{}, nil
+		},
+		ExportItemHistoryAllFn: func(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, role string) ([]*model.ItemHistory, error) {
+			got = filter
+			return []*model.ItemHistory{}, nil
+		},
+		GetItemHistoryByIDFn: func(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, id int, role string) ([]*model.ItemHistory, error) {
+			got = filter
+			return []*model.ItemHistory{{ItemID: id}}, nil
+		},
+		ExportItemHistoryByIDFn: func(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, id int, role string) ([]*model.ItemHistory, error) {
+			got = filter
+			return []*model.ItemHistory{{ItemID: id}}, nil
+		},
+	}
+
+	for _, path := range []string{"/items/history", "/items/history/csv", "/items/3/history", "/items/3/history/csv"} {
+		t.Run(path, func(t *testing.T) {
+			got = nil
+			req := httptest.NewRequest(http.MethodGet, path+query, nil)
+			addTestSession(t, req)
+
+			rec := httptest.NewRecorder()
+
+			h := transport.NewWHCHandlers(mockSvc)
+			r := newTestServer(h)
+			r.ServeHTTP(rec, req)
+
+			require.Equal(t, http.StatusOK, rec.Code)
+			require.Equal(t, &wantFilter, got)
+		})
+	}
+
+	t.Run("malformed item_id", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/items/history?item_id=3&item_id=abc", nil)
+		addTestSession(t, req)
+
+		rec := httptest.NewRecorder()
+
+		h := transport.NewWHCHandlers(mockSvc)
+		r := newTestServer(h)
+		r.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
 func TestExportItemsHistoryCSV(t *testing.T) {
 	_, testHistory := generateValidItemAndHistoryArray(t)
 	cases := []struct {
@@ -699,7 +1821,7 @@ func TestExportItemsHistoryCSV(t *testing.T) {
 	}{
 		{
 			name: "Positive - history fetched",
-			mockSvc: &transport.ServiceMock{GetItemHistoryAllFn: func(ctx context.Context, rph *model.RequestParam, role string) ([]*model.ItemHistory, error) {
+			mockSvc: &transport.ServiceMock{ExportItemHistoryAllFn: func(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, role string) ([]*model.ItemHistory, error) {
 				return testHistory, nil
 			}},
 			wantCode: http.StatusOK,
@@ -707,21 +1829,21 @@ func TestExportItemsHistoryCSV(t *testing.T) {
 		},
 		{
 			name: "Negative - Db error",
-			mockSvc: &transport.ServiceMock{GetItemHistoryAllFn: func(ctx context.Context, rph *model.RequestParam, role string) ([]*model.ItemHistory, error) {
+			mockSvc: &transport.ServiceMock{ExportItemHistoryAllFn: func(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, role string) ([]*model.ItemHistory, error) {
 				return nil, errors.New("test DB error")
 			}},
 			wantCode: http.StatusInternalServerError,
 		},
 		{
 			name: "Negative - req params invalid",
-			mockSvc: &transport.ServiceMock{GetItemHistoryAllFn: func(ctx context.Context, rph *model.RequestParam, role string) ([]*model.ItemHistory, error) {
+			mockSvc: &transport.ServiceMock{ExportItemHistoryAllFn: func(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, role string) ([]*model.ItemHistory, error) {
 				return nil, model.ErrInvalidAscDesc
 			}},
 			wantCode: http.StatusBadRequest,
 		},
 		{
 			name: "Negative - no access to see history",
-			mockSvc: &transport.ServiceMock{GetItemHistoryAllFn: func(ctx context.Context, rph *model.RequestParam, role string) ([]*model.ItemHistory, error) {
+			mockSvc: &transport.ServiceMock{ExportItemHistoryAllFn: func(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, role string) ([]*model.ItemHistory, error) {
 				return nil, model.ErrAccessDenied
 			}},
 			wantCode: http.StatusForbidden,
@@ -731,10 +1853,7 @@ func TestExportItemsHistoryCSV(t *testing.T) {
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
 			req := httptest.NewRequest(http.MethodGet, "/items/history/csv", nil)
-			req.AddCookie(&http.Cookie{
-				Name:  "access_token",
-				Value: "jwt-token",
-			})
+			addTestSession(t, req)
 
 			rec := httptest.NewRecorder()
 
@@ -759,6 +1878,74 @@ func TestExportItemsHistoryCSV(t *testing.T) {
 	}
 }
 
+func TestExportAuthEventsCSV(t *testing.T) {
+	events := []*model.AuthEvent{
+		{ID: 2, Type: model.AuthEventDenied, Status: http.StatusForbidden, Method: http.MethodDelete, Path: "/users/:id",
+			Username: "viewer1", RequestID: "rid-2", IP: "10.0.0.1", UserAgent: "curl/8.0", CreatedAt: time.Now()},
+		{ID: 1, Type: model.AuthEventLogin, UserID: 3, Username: "viewer1", Role: model.RoleViewer,
+			RequestID: "rid-1", IP: "10.0.0.1", UserAgent: "curl/8.0", CreatedAt: time.Now()},
+	}
+	cases := []struct {
+		name     string
+		query    string
+		mockSvc  *transport.ServiceMock
+		wantCode int
+		wantRows int
+	}{
+		{
+			name:  "Positive - filtered events exported",
+			query: "?username=viewer1&ip=10.0.0.1",
+			mockSvc: &transport.ServiceMock{GetAuthEventsFn: func(ctx context.Context, rp *model.RequestParam, filter *model.AuthEventFilter, role string) ([]*model.AuthEvent, error) {
+				if filter.Username == nil || *filter.Username != "viewer1" || filter.IP == nil || *filter.IP != "10.0.0.1" {
+					return nil, errors.New("filters not decoded")
+				}
+				return events, nil
+			}},
+			wantCode: http.StatusOK,
+			wantRows: 3,
+		},
+		{
+			name: "Negative - unknown event type",
+			mockSvc: &transport.ServiceMock{GetAuthEventsFn: func(ctx context.Context, rp *model.RequestParam, filter *model.AuthEventFilter, role string) ([]*model.AuthEvent, error) {
+				return nil, model.ErrInvalidEventType
+			}},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "Negative - no access to audit log",
+			mockSvc: &transport.ServiceMock{GetAuthEventsFn: func(ctx context.Context, rp *model.RequestParam, filter *model.AuthEventFilter, role string) ([]*model.AuthEvent, error) {
+				return nil, model.ErrAccessDenied
+			}},
+			wantCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/auth-events/csv"+tt.query, nil)
+			addTestSession(t, req)
+
+			rec := httptest.NewRecorder()
+
+			h := transport.NewWHCHandlers(tt.mockSvc)
+			newTestServer(h).ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantCode, rec.Code)
+			if tt.wantRows == 0 {
+				require.Contains(t, rec.Header().Get("Content-Type"), "application/json")
+				return
+			}
+
+			require.Contains(t, rec.Header().Get("Content-Type"), "text/csv")
+			records, err := csv.NewReader(bytes.NewReader(rec.Body.Bytes())).ReadAll()
+			require.NoError(t, err)
+			require.Len(t, records, tt.wantRows)
+			require.Equal(t, []string{"2", "denied"}, records[1][:2])
+			require.Equal(t, "403", records[1][6])
+		})
+	}
+}
+
 func TestExportItemsCSV(t *testing.T) {
 	testItem, _ := generateValidItemAndHistoryArray(t)
 	cases := []struct {
@@ -769,7 +1956,7 @@ func TestExportItemsCSV(t *testing.T) {
 	}{
 		{
 			name: "Positive - items fetched",
-			mockSvc: &transport.ServiceMock{GetItemsListFn: func(ctx context.Context, rpi *model.RequestParam, role string) ([]*model.Item, error) {
+			mockSvc: &transport.ServiceMock{GetItemsListFn: func(ctx context.Context, rpi *model.RequestParam, filter *model.ItemFilter, role string) ([]*model.Item, error) {
 				return []*model.Item{testItem, testItem}, nil
 			}},
 			wantCode: http.StatusOK,
@@ -777,21 +1964,21 @@ func TestExportItemsCSV(t *testing.T) {
 		},
 		{
 			name: "Negative - Db error",
-			mockSvc: &transport.ServiceMock{GetItemsListFn: func(ctx context.Context, rpi *model.RequestParam, role string) ([]*model.Item, error) {
+			mockSvc: &transport.ServiceMock{GetItemsListFn: func(ctx context.Context, rpi *model.RequestParam, filter *model.ItemFilter, role string) ([]*model.Item, error) {
 				return nil, errors.New("test DB error")
 			}},
 			wantCode: http.StatusInternalServerError,
 		},
 		{
 			name: "Negative - req params invalid",
-			mockSvc: &transport.ServiceMock{GetItemsListFn: func(ctx context.Context, rpi *model.RequestParam, role string) ([]*model.Item, error) {
+			mockSvc: &transport.ServiceMock{GetItemsListFn: func(ctx context.Context, rpi *model.RequestParam, filter *model.ItemFilter, role string) ([]*model.Item, error) {
 				return nil, model.ErrInvalidAscDesc
 			}},
 			wantCode: http.StatusBadRequest,
 		},
 		{
 			name: "Negative - no access to see items",
-			mockSvc: &transport.ServiceMock{GetItemsListFn: func(ctx context.Context, rpi *model.RequestParam, role string) ([]*model.Item, error) {
+			mockSvc: &transport.ServiceMock{GetItemsListFn: func(ctx context.Context, rpi *model.RequestParam, filter *model.ItemFilter, role string) ([]*model.Item, error) {
 				return nil, model.ErrAccessDenied
 			}},
 			wantCode: http.StatusForbidden,
@@ -801,10 +1988,7 @@ func TestExportItemsCSV(t *testing.T) {
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
 			req := httptest.NewRequest(http.MethodGet, "/items/csv", nil)
-			req.AddCookie(&http.Cookie{
-				Name:  "access_token",
-				Value: "jwt-token",
-			})
+			addTestSession(t, req)
 
 			rec := httptest.NewRecorder()
 
@@ -839,7 +2023,7 @@ func TestExportItemIDHistoryCSV(t *testing.T) {
 	}{
 		{
 			name: "Positive - history fetched",
-			mockSvc: &transport.ServiceMock{GetItemHistoryByIDFn: func(ctx context.Context, rph *model.RequestParam, id int, role string) ([]*model.ItemHistory, error) {
+			mockSvc: &transport.ServiceMock{ExportItemHistoryByIDFn: func(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, id int, role string) ([]*model.ItemHistory, error) {
 				return testHistory, nil
 			}},
 			wantCode: http.StatusOK,
@@ -847,28 +2031,28 @@ func TestExportItemIDHistoryCSV(t *testing.T) {
 		},
 		{
 			name: "Negative - Db error",
-			mockSvc: &transport.ServiceMock{GetItemHistoryByIDFn: func(ctx context.Context, rph *model.RequestParam, id int, role string) ([]*model.ItemHistory, error) {
+			mockSvc: &transport.ServiceMock{ExportItemHistoryByIDFn: func(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, id int, role string) ([]*model.ItemHistory, error) {
 				return nil, errors.New("test DB error")
 			}},
 			wantCode: http.StatusInternalServerError,
 		},
 		{
 			name: "Negative - req params invalid",
-			mockSvc: &transport.ServiceMock{GetItemHistoryByIDFn: func(ctx context.Context, rph *model.RequestParam, id int, role string) ([]*model.ItemHistory, error) {
+			mockSvc: &transport.ServiceMock{ExportItemHistoryByIDFn: func(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, id int, role string) ([]*model.ItemHistory, error) {
 				return nil, model.ErrInvalidAscDesc
 			}},
 			wantCode: http.StatusBadRequest,
 		},
 		{
 			name: "Negative - no access to see history",
-			mockSvc: &transport.ServiceMock{GetItemHistoryByIDFn: func(ctx context.Context, rph *model.RequestParam, id int, role string) ([]*model.ItemHistory, error) {
+			mockSvc: &transport.ServiceMock{ExportItemHistoryByIDFn: func(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, id int, role string) ([]*model.ItemHistory, error) {
 				return nil, model.ErrAccessDenied
 			}},
 			wantCode: http.StatusForbidden,
 		},
 		{
 			name: "Negative - item ID not found",
-			mockSvc: &transport.ServiceMock{GetItemHistoryByIDFn: func(ctx context.Context, rph *model.RequestParam, id int, role string) ([]*model.ItemHistory, error) {
+			mockSvc: &transport.ServiceMock{ExportItemHistoryByIDFn: func(ctx context.Context, rph *model.RequestParam, filter *model.HistoryFilter, id int, role string) ([]*model.ItemHistory, error) {
 				return nil, model.ErrItemNotFound
 			}},
 			wantCode: http.StatusNotFound,
@@ -878,10 +2062,7 @@ func TestExportItemIDHistoryCSV(t *testing.T) {
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
 			req := httptest.NewRequest(http.MethodGet, "/items/300/history/csv", nil)
-			req.AddCookie(&http.Cookie{
-				Name:  "access_token",
-				Value: "jwt-token",
-			})
+			addTestSession(t, req)
 
 			rec := httptest.NewRecorder()
 
@@ -912,11 +2093,53 @@ func ptrMaker[T int | string | int64 | bool](input T) *T {
 	return &input
 }
 
+func testTokens() *model.AuthTokens {
+	return &model.AuthTokens{
+		AccessToken:      "jwt-token",
+		AccessExpiresAt:  time.Now().Add(15 * time.Minute),
+		RefreshToken:     "refresh-token",
+		RefreshExpiresAt: time.Now().Add(time.Hour),
+	}
+}
+
+// testJWT подписывает и проверяет токены тестового сервера настоящими ключами - как в проде
+var testJWT = func() *mwauthlog.JWTManager {
+	keys, err := mwauthlog.GenerateKeySet()
+	if err != nil {
+		panic(err)
+	}
+	return mwauthlog.NewJWTManager(keys, time.Minute, "whc-test")
+}()
+
+// sessionStub - сессия из валидного токена всегда действительна, API-ключей нет
+type sessionStub struct{}
+
+func (sessionStub) CheckSession(ctx context.Context, claims *mwauthlog.Claims) error { return nil }
+
+func (sessionStub) CheckAPIKey(ctx context.Context, key string) (*mwauthlog.Claims, error) {
+	return nil, model.ErrInvalidAPIKey
+}
+
+// testAccessToken - access-токен тестового пользователя
+func testAccessToken(t *testing.T) string {
+	t.Helper()
+	token, err := testJWT.Generate(300, "testUserName", "testRole")
+	require.NoError(t, err)
+	return token
+}
+
+// addTestSession авторизует запрос как браузер: cookie сессии плюс double-submit CSRF-токен
+func addTestSession(t *testing.T, req *http.Request) {
+	t.Helper()
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: testAccessToken(t)})
+	req.AddCookie(&http.Cookie{Name: mwauthlog.CSRFCookie, Value: "csrf-token"})
+	req.Header.Set(mwauthlog.CSRFHeader, "csrf-token")
+}
+
 func newTestServer(h *transport.WHCHandlers) *ginext.Engine {
 	c := config.New()
 	c.SetDefault("GIN_MODE", "testMode")
-	c.SetDefault("SECRET", "TEST_SECRET")
-	_, r := engine.NewServerEngine(c, h, "TEST")
+	_, r := engine.NewServerEngine(c, h, testJWT, sessionStub{}, nil, nil)
 	return r
 }
 
@@ -954,3 +2177,242 @@ func generateValidItemAndHistoryArray(t *testing.T) (*model.Item, []*model.ItemH
 
 	return &testItem, testArray
 }
+
+func TestMoveItemStock(t *testing.T) {
+	cases := []struct {
+		name     string
+		path     string
+		body     any
+		mockSvc  *transport.ServiceMock
+		wantCode int
+	}{
+		{
+			name: "Positive - putaway ignores source location",
+			path: "/items/1/putaway",
+			body: map[string]int{"from_location_id": 4, "to_location_id": 10, "amount": 5},
+			mockSvc: &transport.ServiceMock{MoveItemStockFn: func(ctx context.Context, mv *model.StockMove, userID int, role string) error {
+				if mv.FromLocationID != nil || mv.ItemID != 1 || mv.ToLocationID != 10 {
+					return model.ErrInvalidStockMove
+				}
+				return nil
+			}},
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name: "Positive - move between bins",
+			path: "/items/1/move",
+			body: map[string]int{"from_location_id": 10, "to_location_id": 11, "amount": 5},
+			mockSvc: &transport.ServiceMock{MoveItemStockFn: func(ctx context.Context, mv *model.StockMove, userID int, role string) error {
+				if mv.FromLocationID == nil || *mv.FromLocationID != 10 {
+					return model.ErrInvalidStockMove
+				}
+				return nil
+			}},
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "Negative - move without source location",
+			path:     "/items/1/move",
+			body:     map[string]int{"to_location_id": 11, "amount": 5},
+			mockSvc:  &transport.ServiceMock{},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "Negative - not enough stock",
+			path: "/items/1/move",
+			body: map[string]int{"from_location_id": 10, "to_location_id": 11, "amount": 500},
+			mockSvc: &transport.ServiceMock{MoveItemStockFn: func(ctx context.Context, mv *model.StockMove, userID int, role string) error {
+				return model.ErrInsufficientStock
+			}},
+			wantCode: http.StatusConflict,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(tt.body)
+			require.NoError(t, err)
+			req := httptest.NewRequest(http.MethodPost, tt.path, bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			addTestSession(t, req)
+
+			rec := httptest.NewRecorder()
+
+			h := transport.NewWHCHandlers(tt.mockSvc)
+			r := newTestServer(h)
+			r.ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantCode, rec.Code)
+		})
+	}
+}
+
+func TestCreateMovement(t *testing.T) {
+	cases := []struct {
+		name     string
+		body     any
+		mockSvc  *transport.ServiceMock
+		wantCode int
+	}{
+		{
+			name: "Positive - movement created",
+			body: map[string]any{"item_id": 1, "type": "issue", "quantity": -3, "reason_code": "SALE", "reference": "INV-17"},
+			mockSvc: &transport.ServiceMock{CreateMovementFn: func(ctx context.Context, m *model.Movement, userID int, role string) ([]*model.Movement, error) {
+				if m.Reference == nil || *m.Reference != "INV-17" || m.CreatedBy == "" {
+					return nil, model.ErrInvalidMovementType
+				}
+				return []*model.Movement{m}, nil
+			}},
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:     "Negative - missing reason code",
+			body:     map[string]any{"item_id": 1, "type": "issue", "quantity": -3},
+			mockSvc:  &transport.ServiceMock{},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "Negative - not enough stock",
+			body: map[string]any{"item_id": 1, "type": "issue", "quantity": -300, "reason_code": "SALE"},
+			mockSvc: &transport.ServiceMock{CreateMovementFn: func(ctx context.Context, m *model.Movement, userID int, role string) ([]*model.Movement, error) {
+				return nil, model.ErrInsufficientStock
+			}},
+			wantCode: http.StatusConflict,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(tt.body)
+			require.NoError(t, err)
+			req := httptest.NewRequest(http.MethodPost, "/movements", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			addTestSession(t, req)
+
+			rec := httptest.NewRecorder()
+
+			h := transport.NewWHCHandlers(tt.mockSvc)
+			r := newTestServer(h)
+			r.ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantCode, rec.Code)
+		})
+	}
+}
+
+func TestAdjustItemStock(t *testing.T) {
+	cases := []struct {
+		name     string
+		body     any
+		mockSvc  *transport.ServiceMock
+		wantCode int
+	}{
+		{
+			name: "Positive - stock decremented",
+			body: map[string]any{"delta": -3},
+			mockSvc: &transport.ServiceMock{AdjustItemStockFn: func(ctx context.Context, adj *model.StockAdjustment, userID int, role string) ([]*model.Movement, error) {
+				if adj.ItemID != 1 || adj.Delta != -3 || adj.UpdatedBy == "" {
+					return nil, model.ErrInvalidStockDelta
+				}
+				return []*model.Movement{{ItemID: adj.ItemID, Type: model.MovementAdjustment, Quantity: adj.Delta, BalanceAfter: 7}}, nil
+			}},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Negative - zero delta",
+			body:     map[string]any{"delta": 0},
+			mockSvc:  &transport.ServiceMock{},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "Negative - result would be negative",
+			body: map[string]any{"delta": -300},
+			mockSvc: &transport.ServiceMock{AdjustItemStockFn: func(ctx context.Context, adj *model.StockAdjustment, userID int, role string) ([]*model.Movement, error) {
+				return nil, model.ErrNegativeStock
+			}},
+			wantCode: http.StatusConflict,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(tt.body)
+			require.NoError(t, err)
+			req := httptest.NewRequest(http.MethodPatch, "/items/1/stock", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			addTestSession(t, req)
+
+			rec := httptest.NewRecorder()
+
+			h := transport.NewWHCHandlers(tt.mockSvc)
+			r := newTestServer(h)
+			r.ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantCode, rec.Code)
+		})
+	}
+}
+
+func TestItemVersionPreconditions(t *testing.T) {
+	t.Run("Positive - item card carries version as ETag", func(t *testing.T) {
+		mockSvc := &transport.ServiceMock{GetItemByIDFn: func(ctx context.Context, id int, role string) (*model.Item, error) {
+			return &model.Item{ID: id, Title: "title", Version: 7}, nil
+		}}
+		req := httptest.NewRequest(http.MethodGet, "/items/3", nil)
+		addTestSession(t, req)
+		rec := httptest.NewRecorder()
+
+		newTestServer(transport.NewWHCHandlers(mockSvc)).ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, `"7"`, rec.Header().Get("ETag"))
+	})
+
+	cases := []struct {
+		name        string
+		method      string
+		ifMatch     string
+		svcErr      error
+		wantVersion *int
+		wantCode    int
+	}{
+		{name: "Positive - patch without If-Match", method: http.MethodPatch, wantCode: http.StatusNoContent},
+		{name: "Positive - patch with any version", method: http.MethodPatch, ifMatch: "*", wantCode: http.StatusNoContent},
+		{name: "Positive - patch with matching version", method: http.MethodPatch, ifMatch: `"7"`, wantVersion: ptrMaker(7), wantCode: http.StatusNoContent},
+		{name: "Negative - patch with stale version", method: http.MethodPatch, ifMatch: `"6"`, wantVersion: ptrMaker(6), svcErr: model.ErrVersionMismatch, wantCode: http.StatusPreconditionFailed},
+		{name: "Negative - patch with malformed If-Match", method: http.MethodPatch, ifMatch: `"abc"`, wantCode: http.StatusBadRequest},
+		{name: "Positive - delete with weak ETag", method: http.MethodDelete, ifMatch: `W/"7"`, wantVersion: ptrMaker(7), wantCode: http.StatusNoContent},
+		{name: "Negative - delete with stale version", method: http.MethodDelete, ifMatch: `"6"`, wantVersion: ptrMaker(6), svcErr: model.ErrVersionMismatch, wantCode: http.StatusPreconditionFailed},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotVersion *int
+			mockSvc := &transport.ServiceMock{
+				UpdateItemByIDFn: func(ctx context.Context, item *model.ItemUpdate, userID int, role string) error {
+					gotVersion = item.Version
+					return tt.svcErr
+				},
+				DeleteItemByIDFn: func(ctx context.Context, id int, version *int, userID int, role string, username string) error {
+					gotVersion = version
+					return tt.svcErr
+				},
+			}
+
+			req := httptest.NewRequest(tt.method, "/items/3", bytes.NewReader([]byte(`{"title":"new title"}`)))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			addTestSession(t, req)
+			rec := httptest.NewRecorder()
+
+			newTestServer(transport.NewWHCHandlers(mockSvc)).ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantCode, rec.Code)
+			if tt.wantCode != http.StatusBadRequest {
+				require.Equal(t, tt.wantVersion, gotVersion)
+			}
+		})
+	}
+}
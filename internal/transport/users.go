@@ -0,0 +1,302 @@
+package transport
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+	"github.com/gin-gonic/gin"
+)
+
+func (whc *WHCHandlers) GetUsersList(ctx *gin.Context) {
+	// парсим параметры запроса из URL
+	rpu := model.RequestParam{}
+	if err := decodeQueryParams(ctx, &rpu); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role := stringFromCtx(ctx, "role")
+	res, err := whc.svc.GetUsersList(ctx.Request.Context(), &rpu, role)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, convertUsersToPublic(res))
+}
+
+func (whc *WHCHandlers) ChangeUserRole(ctx *gin.Context) {
+	// логируем role-sensitive запрос
+	rid := stringFromCtx(ctx, "request_id")
+	uid := intFromCtx(ctx, "user_id")
+	userName := stringFromCtx(ctx, "username")
+	role := stringFromCtx(ctx, "role")
+
+	// определяем id
+	rawID, ok := ctx.Params.Get("id")
+	if !ok {
+		ctx.JSON(400, gin.H{"error": "empty user id"})
+		return
+	}
+	id := stringToInt(rawID)
+
+	// читаем новую роль
+	var req roleRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid role payload"})
+		return
+	}
+	log.Printf("rid=%q userID=%d userName=%q role=%q changing role of user #%d to %q", rid, uid, userName, role, id, req.Role)
+
+	// передаем в сервис
+	if err := whc.svc.ChangeUserRole(ctx.Request.Context(), id, req.Role, role, userName); err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (whc *WHCHandlers) DisableUser(ctx *gin.Context) {
+	whc.setUserDisabled(ctx, true)
+}
+
+func (whc *WHCHandlers) EnableUser(ctx *gin.Context) {
+	whc.setUserDisabled(ctx, false)
+}
+
+func (whc *WHCHandlers) setUserDisabled(ctx *gin.Context, disabled bool) {
+	// логируем role-sensitive запрос
+	rid := stringFromCtx(ctx, "request_id")
+	uid := intFromCtx(ctx, "user_id")
+	userName := stringFromCtx(ctx, "username")
+	role := stringFromCtx(ctx, "role")
+
+	// определяем id
+	rawID, ok := ctx.Params.Get("id")
+	if !ok {
+		ctx.JSON(400, gin.H{"error": "empty user id"})
+		return
+	}
+	id := stringToInt(rawID)
+	log.Printf("rid=%q userID=%d userName=%q role=%q setting disabled=%t for user #%d", rid, uid, userName, role, disabled, id)
+
+	// передаем в сервис
+	if err := whc.svc.SetUserDisabled(ctx.Request.Context(), id, disabled, role, userName); err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (whc *WHCHandlers) ResetUserPassword(ctx *gin.Context) {
+	// логируем role-sensitive запрос
+	rid := stringFromCtx(ctx, "request_id")
+	uid := intFromCtx(ctx, "user_id")
+	userName := stringFromCtx(ctx, "username")
+	role := stringFromCtx(ctx, "role")
+
+	// определяем id
+	rawID, ok := ctx.Params.Get("id")
+	if !ok {
+		ctx.JSON(400, gin.H{"error": "empty user id"})
+		return
+	}
+	id := stringToInt(rawID)
+
+	// читаем новый пароль
+	var req passwordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid password payload"})
+		return
+	}
+	log.Printf("rid=%q userID=%d userName=%q role=%q resetting password of user #%d", rid, uid, userName, role, id)
+
+	// передаем в сервис
+	if err := whc.svc.ResetUserPassword(ctx.Request.Context(), id, req.Password, role, userName); err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (whc *WHCHandlers) DeleteUser(ctx *gin.Context) {
+	// логируем role-sensitive запрос
+	rid := stringFromCtx(ctx, "request_id")
+	uid := intFromCtx(ctx, "user_id")
+	userName := stringFromCtx(ctx, "username")
+	role := stringFromCtx(ctx, "role")
+
+	// определяем id
+	rawID, ok := ctx.Params.Get("id")
+	if !ok {
+		ctx.JSON(400, gin.H{"error": "empty user id"})
+		return
+	}
+	id := stringToInt(rawID)
+	log.Printf("rid=%q userID=%d userName=%q role=%q deleting user #%d", rid, uid, userName, role, id)
+
+	// передаем в сервис
+	if err := whc.svc.DeleteUser(ctx.Request.Context(), id, role, userName); err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (whc *WHCHandlers) GetUserHistory(ctx *gin.Context) {
+	// парсим параметры запроса из URL
+	rph := model.RequestParam{}
+	if err := decodeQueryParams(ctx, &rph); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// определяем id и роль
+	role := stringFromCtx(ctx, "role")
+	rawID, ok := ctx.Params.Get("id")
+	if !ok {
+		ctx.JSON(400, gin.H{"error": "empty user id"})
+		return
+	}
+	id := stringToInt(rawID)
+
+	// обращаемся к сервису
+	res, err := whc.svc.GetUserHistory(ctx.Request.Context(), &rph, id, role)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, res)
+}
+
+func (whc *WHCHandlers) GetUserRoles(ctx *gin.Context) {
+	// определяем id и роль
+	role := stringFromCtx(ctx, "role")
+	rawID, ok := ctx.Params.Get("id")
+	if !ok {
+		ctx.JSON(400, gin.H{"error": "empty user id"})
+		return
+	}
+	id := stringToInt(rawID)
+
+	// передаем в сервис
+	res, err := whc.svc.GetUserRoles(ctx.Request.Context(), id, role)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"roles": res})
+}
+
+func (whc *WHCHandlers) GrantUserRole(ctx *gin.Context) {
+	// логируем role-sensitive запрос
+	rid := stringFromCtx(ctx, "request_id")
+	uid := intFromCtx(ctx, "user_id")
+	userName := stringFromCtx(ctx, "username")
+	role := stringFromCtx(ctx, "role")
+
+	// определяем id
+	rawID, ok := ctx.Params.Get("id")
+	if !ok {
+		ctx.JSON(400, gin.H{"error": "empty user id"})
+		return
+	}
+	id := stringToInt(rawID)
+
+	// читаем выдаваемую роль
+	var req roleRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid role payload"})
+		return
+	}
+	log.Printf("rid=%q userID=%d userName=%q role=%q granting role %q to user #%d", rid, uid, userName, role, req.Role, id)
+
+	// передаем в сервис
+	if err := whc.svc.GrantUserRole(ctx.Request.Context(), id, req.Role, role, userName); err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (whc *WHCHandlers) RevokeUserRole(ctx *gin.Context) {
+	// логируем role-sensitive запрос
+	rid := stringFromCtx(ctx, "request_id")
+	uid := intFromCtx(ctx, "user_id")
+	userName := stringFromCtx(ctx, "username")
+	role := stringFromCtx(ctx, "role")
+
+	// определяем id и отзываемую роль
+	rawID, ok := ctx.Params.Get("id")
+	if !ok {
+		ctx.JSON(400, gin.H{"error": "empty user id"})
+		return
+	}
+	id := stringToInt(rawID)
+	revokeRole := ctx.Param("role")
+	log.Printf("rid=%q userID=%d userName=%q role=%q revoking role %q from user #%d", rid, uid, userName, role, revokeRole, id)
+
+	// передаем в сервис
+	if err := whc.svc.RevokeUserRole(ctx.Request.Context(), id, revokeRole, role, userName); err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (whc *WHCHandlers) RevokeUserSessions(ctx *gin.Context) {
+	// логируем role-sensitive запрос
+	rid := stringFromCtx(ctx, "request_id")
+	uid := intFromCtx(ctx, "user_id")
+	userName := stringFromCtx(ctx, "username")
+	role := stringFromCtx(ctx, "role")
+
+	// определяем id
+	rawID, ok := ctx.Params.Get("id")
+	if !ok {
+		ctx.JSON(400, gin.H{"error": "empty user id"})
+		return
+	}
+	id := stringToInt(rawID)
+	log.Printf("rid=%q userID=%d userName=%q role=%q revoking all sessions of user #%d", rid, uid, userName, role, id)
+
+	// передаем в сервис
+	if err := whc.svc.RevokeUserSessions(ctx.Request.Context(), id, role, userName); err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (whc *WHCHandlers) GetLoginAttempts(ctx *gin.Context) {
+	// парсим параметры запроса и фильтры из URL
+	rp := model.RequestParam{}
+	if err := decodeQueryParams(ctx, &rp); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter := model.LoginAttemptFilter{}
+	if err := decodeLoginAttemptFilter(ctx, &filter); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	role := stringFromCtx(ctx, "role")
+	res, err := whc.svc.GetLoginAttempts(ctx.Request.Context(), &rp, &filter, role)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, res)
+}
@@ -0,0 +1,123 @@
+package transport
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/UnendingLoop/WarehouseControl/internal/model"
+	"github.com/gin-gonic/gin"
+)
+
+func (whc *WHCHandlers) CreateWarehouse(ctx *gin.Context) {
+	// логируем role-sensitive запрос
+	rid := stringFromCtx(ctx, "request_id")
+	uid := intFromCtx(ctx, "user_id")
+	userName := stringFromCtx(ctx, "username")
+	role := stringFromCtx(ctx, "role")
+
+	var wh model.Warehouse
+	if err := ctx.ShouldBindJSON(&wh); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid warehouse payload"})
+		return
+	}
+	log.Printf("rid=%q userID=%d userName=%q role=%q creating warehouse %q", rid, uid, userName, role, wh.Code)
+
+	// передаем в сервис
+	if err := whc.svc.CreateWarehouse(ctx.Request.Context(), &wh, role, userName); err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, wh)
+}
+
+func (whc *WHCHandlers) GetWarehouses(ctx *gin.Context) {
+	role := stringFromCtx(ctx, "role")
+
+	res, err := whc.svc.GetWarehouses(ctx.Request.Context(), role)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, res)
+}
+
+func (whc *WHCHandlers) GetUserWarehouses(ctx *gin.Context) {
+	// определяем id и роль
+	role := stringFromCtx(ctx, "role")
+	rawID, ok := ctx.Params.Get("id")
+	if !ok {
+		ctx.JSON(400, gin.H{"error": "empty user id"})
+		return
+	}
+	id := stringToInt(rawID)
+
+	// передаем в сервис
+	res, err := whc.svc.GetUserWarehouses(ctx.Request.Context(), id, role)
+	if err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	// пустой список - пользователь не ограничен складами
+	ctx.JSON(http.StatusOK, gin.H{"warehouses": res})
+}
+
+func (whc *WHCHandlers) GrantUserWarehouse(ctx *gin.Context) {
+	// логируем role-sensitive запрос
+	rid := stringFromCtx(ctx, "request_id")
+	uid := intFromCtx(ctx, "user_id")
+	userName := stringFromCtx(ctx, "username")
+	role := stringFromCtx(ctx, "role")
+
+	// определяем id
+	rawID, ok := ctx.Params.Get("id")
+	if !ok {
+		ctx.JSON(400, gin.H{"error": "empty user id"})
+		return
+	}
+	id := stringToInt(rawID)
+
+	// читаем выдаваемый склад
+	var req warehouseRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid warehouse payload"})
+		return
+	}
+	log.Printf("rid=%q userID=%d userName=%q role=%q granting warehouse #%d to user #%d", rid, uid, userName, role, req.WarehouseID, id)
+
+	// передаем в сервис
+	if err := whc.svc.GrantUserWarehouse(ctx.Request.Context(), id, req.WarehouseID, role, userName); err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (whc *WHCHandlers) RevokeUserWarehouse(ctx *gin.Context) {
+	// логируем role-sensitive запрос
+	rid := stringFromCtx(ctx, "request_id")
+	uid := intFromCtx(ctx, "user_id")
+	userName := stringFromCtx(ctx, "username")
+	role := stringFromCtx(ctx, "role")
+
+	// определяем id пользователя и отзываемый склад
+	rawID, ok := ctx.Params.Get("id")
+	if !ok {
+		ctx.JSON(400, gin.H{"error": "empty user id"})
+		return
+	}
+	id := stringToInt(rawID)
+	whID := stringToInt(ctx.Param("warehouse_id"))
+	log.Printf("rid=%q userID=%d userName=%q role=%q revoking warehouse #%d from user #%d", rid, uid, userName, role, whID, id)
+
+	// передаем в сервис
+	if err := whc.svc.RevokeUserWarehouse(ctx.Request.Context(), id, whID, role, userName); err != nil {
+		ctx.JSON(errorCodeDefiner(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}